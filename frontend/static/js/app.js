@@ -90,6 +90,10 @@ async function handleRegister(e) {
             body: JSON.stringify(user)
         });
 
+        if (response.status === 400) {
+            const body = await response.json().catch(() => null);
+            if (body && body.reasons) throw new Error(`Password ${body.reasons.join(', ')}`);
+        }
         if (!response.ok) throw new Error('Registration failed');
 
         const data = await response.json();
@@ -106,6 +110,13 @@ async function handleRegister(e) {
 }
 
 function logout() {
+    const token = localStorage.getItem('token');
+    if (token) {
+        fetch(`${API_BASE}/logout`, {
+            method: 'POST',
+            headers: { 'Authorization': `Bearer ${token}` }
+        }).catch(() => {});
+    }
     localStorage.removeItem('token');
     localStorage.removeItem('user');
     currentUser = null;
@@ -119,8 +130,9 @@ function logout() {
 // Products Functions
 async function loadProducts() {
     try {
-        const response = await fetch(`${API_BASE}/products`);
-        products = await response.json();
+        const response = await fetch(`${API_BASE}/products?fields=id,name,price,effective_price,image_url,category,stock`);
+        const data = await response.json();
+        products = data.products;
         renderProducts(products);
     } catch (error) {
         document.getElementById('products-grid').innerHTML =
@@ -160,12 +172,12 @@ function renderProducts(productsToRender) {
             <div class="product-info">
                 <div class="product-category">${product.category}</div>
                 <h3 class="product-name">${product.name}</h3>
-                <div class="product-price">$${product.price.toFixed(2)}</div>
+                <div class="product-price">$${(product.effective_price ?? product.price).toFixed(2)}</div>
                 <div class="product-stock ${product.stock <= 0 ? 'out-of-stock' : ''}">
                     ${product.stock > 0 ? `${product.stock} in stock` : 'Out of stock'}
                 </div>
                 <button class="btn btn-primary btn-block"
-                        onclick="addToCart(${product.id}, '${product.name}', ${product.price}, '${product.image_url || ''}')"
+                        onclick="addToCart(${product.id}, '${product.name}', ${product.effective_price ?? product.price}, '${product.image_url || ''}')"
                         ${product.stock <= 0 ? 'disabled' : ''}>
                     Add to Cart
                 </button>
@@ -241,7 +253,7 @@ async function addToCart(productId, name, price, imageUrl) {
     }
 }
 
-async function updateCartItem(itemId, quantity) {
+async function updateCartItem(itemId, quantity, version) {
     try {
         const response = await fetch(`${API_BASE}/cart/${currentUser.id}/items/${itemId}`, {
             method: 'PUT',
@@ -249,10 +261,14 @@ async function updateCartItem(itemId, quantity) {
                 'Content-Type': 'application/json',
                 'Authorization': `Bearer ${localStorage.getItem('token')}`
             },
-            body: JSON.stringify({ quantity })
+            body: JSON.stringify({ quantity, version })
         });
 
-        if (!response.ok) throw new Error('Failed to update cart');
+        if (response.status === 409) {
+            showToast('Your cart changed elsewhere; it has been refreshed', 'info');
+        } else if (!response.ok) {
+            throw new Error('Failed to update cart');
+        }
 
         await loadCart();
         renderCart();
@@ -301,9 +317,9 @@ function renderCart() {
                 <div class="cart-item-price">$${item.price.toFixed(2)}</div>
             </div>
             <div class="cart-item-quantity">
-                <button onclick="updateCartItem(${item.id}, ${item.quantity - 1})">-</button>
+                <button onclick="updateCartItem(${item.id}, ${item.quantity - 1}, ${item.version})">-</button>
                 <span>${item.quantity}</span>
-                <button onclick="updateCartItem(${item.id}, ${item.quantity + 1})">+</button>
+                <button onclick="updateCartItem(${item.id}, ${item.quantity + 1}, ${item.version})">+</button>
             </div>
             <button class="btn btn-danger" onclick="removeFromCart(${item.id})">Remove</button>
         </div>
@@ -346,7 +362,7 @@ function renderCheckout() {
         </div>
     `).join('');
 
-    checkoutTotal.textContent = `$${cart.total_price.toFixed(2)}`;
+    checkoutTotal.textContent = `$${(cart.total_after_discount ?? cart.total_price).toFixed(2)}`;
 }
 
 async function handleCheckout(e) {
@@ -367,9 +383,9 @@ async function handleCheckout(e) {
             },
             body: JSON.stringify({
                 user_id: currentUser.id,
-                total_amount: cart.total_price,
                 shipping_address: shippingAddress,
                 payment_method: 'card',
+                cart_version: cart.version,
                 items: cart.items.map(item => ({
                     product_id: item.product_id,
                     name: item.name,
@@ -379,9 +395,23 @@ async function handleCheckout(e) {
             })
         });
 
-        if (!orderResponse.ok) throw new Error('Failed to create order');
-
-        const order = await orderResponse.json();
+        // A 409 means this cart was already ordered, e.g. by a double submit.
+        // Only retry payment on that order if its last payment failed.
+        let order;
+        if (orderResponse.status === 409) {
+            const existing = await orderResponse.json();
+            if (existing.payment_status !== 'failed') {
+                showToast('This cart has already been ordered', 'error');
+                showSection('orders');
+                loadOrders();
+                return;
+            }
+            order = { id: existing.order_id, total_amount: existing.total_amount };
+        } else if (orderResponse.ok) {
+            order = await orderResponse.json();
+        } else {
+            throw new Error('Failed to create order');
+        }
 
         // Process payment
         const paymentResponse = await fetch(`${API_BASE}/payments`, {
@@ -393,7 +423,7 @@ async function handleCheckout(e) {
             body: JSON.stringify({
                 order_id: order.id,
                 user_id: currentUser.id,
-                amount: cart.total_price,
+                amount: order.total_amount,
                 currency: 'USD',
                 method: 'card',
                 card_info: {
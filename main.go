@@ -3,10 +3,17 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"net/mail"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
@@ -84,8 +91,10 @@ type OrderItem struct {
 }
 
 type AuthResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ExpiresIn int64     `json:"expires_in"`
+	User      User      `json:"user"`
 }
 
 type Claims struct {
@@ -157,9 +166,16 @@ func main() {
 	r.HandleFunc("/api/orders/user/{user_id}", getOrdersByUser).Methods("GET")
 	r.HandleFunc("/api/orders/{id}", getOrder).Methods("GET")
 
-	// Serve static files
+	// Unknown API paths get a JSON 404 rather than the frontend
+	r.PathPrefix("/api/").HandlerFunc(apiNotFound)
+
+	// Serve static files, falling back to the index page for client-side routes
+	frontendIndex := os.Getenv("FRONTEND_INDEX")
+	if frontendIndex == "" {
+		frontendIndex = "index.html"
+	}
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./frontend/static"))))
-	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./frontend/templates")))
+	r.PathPrefix("/").Handler(spaHandler("./frontend/templates", frontendIndex))
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -167,16 +183,30 @@ func main() {
 	}
 
 	log.Printf("Server running on :%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	log.Fatal(http.ListenAndServe(":"+port, trimTrailingSlash(r)))
 }
 
 func corsMiddleware(next http.Handler) http.Handler {
+	methods := os.Getenv("CORS_ALLOWED_METHODS")
+	if methods == "" {
+		methods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	}
+	headers := os.Getenv("CORS_ALLOWED_HEADERS")
+	if headers == "" {
+		headers = "Content-Type, Authorization, X-Client"
+	}
+	maxAge := os.Getenv("CORS_MAX_AGE")
+	if n, err := strconv.Atoi(maxAge); err != nil || n < 0 {
+		maxAge = "600"
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", methods)
+		w.Header().Set("Access-Control-Allow-Headers", headers)
 
 		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Max-Age", maxAge)
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -185,6 +215,45 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// trimTrailingSlash wraps the router so "/api/products/" and "/api/products"
+// resolve to the same route.
+func trimTrailingSlash(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Path) > 1 && strings.HasSuffix(r.URL.Path, "/") {
+			r.URL.Path = strings.TrimRight(r.URL.Path, "/")
+			if r.URL.Path == "" {
+				r.URL.Path = "/"
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// spaHandler serves files from dir and falls back to the index page for any
+// other GET so deep links like /products/5 load the frontend.
+func spaHandler(dir, index string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.NotFound(w, r)
+			return
+		}
+
+		name := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		if info, err := os.Stat(name); err == nil && !info.IsDir() {
+			http.ServeFile(w, r, name)
+			return
+		}
+
+		http.ServeFile(w, r, filepath.Join(dir, index))
+	})
+}
+
+func apiNotFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{"error": "not found"})
+}
+
 func healthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
@@ -198,7 +267,19 @@ func register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if !isValidEmail(user.Email) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid email"})
+		return
+	}
+
+	if err := validatePassword(user.Password); err != nil {
+		writePasswordError(w, err)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcryptCost)
 	if err != nil {
 		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
@@ -215,11 +296,16 @@ func register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, _ := generateToken(user.ID, user.Email)
+	token, expiresAt, _ := generateToken(user.ID, user.Email)
 	user.Password = ""
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(AuthResponse{Token: token, User: user})
+	json.NewEncoder(w).Encode(AuthResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+		ExpiresIn: int64(time.Until(expiresAt).Seconds()),
+		User:      user,
+	})
 }
 
 func login(w http.ResponseWriter, r *http.Request) {
@@ -236,7 +322,7 @@ func login(w http.ResponseWriter, r *http.Request) {
 	var user User
 	var hashedPassword string
 	err := db.QueryRow(
-		`SELECT id, email, password, first_name, last_name, phone, address, created_at
+		`SELECT id, email, password, COALESCE(first_name, ''), COALESCE(last_name, ''), COALESCE(phone, ''), COALESCE(address, ''), created_at
 		 FROM users WHERE email = $1`,
 		credentials.Email,
 	).Scan(&user.ID, &user.Email, &hashedPassword, &user.FirstName, &user.LastName, &user.Phone, &user.Address, &user.CreatedAt)
@@ -251,13 +337,133 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, _ := generateToken(user.ID, user.Email)
+	token, expiresAt, _ := generateToken(user.ID, user.Email)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(AuthResponse{Token: token, User: user})
+	json.NewEncoder(w).Encode(AuthResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+		ExpiresIn: int64(time.Until(expiresAt).Seconds()),
+		User:      user,
+	})
+}
+
+const maxEmailLength = 254
+
+// isValidEmail accepts only a bare address, matching the user service.
+func isValidEmail(email string) bool {
+	if email == "" || len(email) > maxEmailLength {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return false
+	}
+	return addr.Name == "" && addr.Address == email
 }
 
-func generateToken(userID uint, email string) (string, error) {
+const minPasswordLength = 8
+
+// bcryptCost is the work factor for new password hashes. BCRYPT_COST lets CI
+// use a low cost for speed and production raise it as hardware improves.
+var bcryptCost = bcryptCostFromEnv()
+
+func bcryptCostFromEnv() int {
+	v := os.Getenv("BCRYPT_COST")
+	if v == "" {
+		return bcrypt.DefaultCost
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < bcrypt.MinCost || n > bcrypt.MaxCost {
+		log.Printf("Invalid BCRYPT_COST %q, using %d", v, bcrypt.DefaultCost)
+		return bcrypt.DefaultCost
+	}
+	return n
+}
+
+// commonPasswords mirrors the user service denylist.
+var commonPasswords = map[string]bool{
+	"password1":   true,
+	"password12":  true,
+	"password123": true,
+	"passw0rd":    true,
+	"12345678a":   true,
+	"abc12345":    true,
+	"abcd1234":    true,
+	"qwerty123":   true,
+	"qwerty12":    true,
+	"letmein1":    true,
+	"welcome1":    true,
+	"iloveyou1":   true,
+	"admin123":    true,
+	"changeme1":   true,
+	"trustno1":    true,
+	"1q2w3e4r":    true,
+	"1qaz2wsx":    true,
+	"zaq12wsx":    true,
+	"monkey123":   true,
+	"dragon123":   true,
+}
+
+type PasswordError struct {
+	Reasons []string
+}
+
+func (e *PasswordError) Error() string {
+	return "password too weak: " + strings.Join(e.Reasons, "; ")
+}
+
+// validatePassword applies the same policy as the user service.
+func validatePassword(pw string) error {
+	var reasons []string
+
+	if len(pw) < minPasswordLength {
+		reasons = append(reasons, "must be at least 8 characters")
+	}
+
+	var hasLetter, hasDigit bool
+	for _, c := range pw {
+		switch {
+		case unicode.IsLetter(c):
+			hasLetter = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		}
+	}
+	if !hasLetter {
+		reasons = append(reasons, "must contain a letter")
+	}
+	if !hasDigit {
+		reasons = append(reasons, "must contain a digit")
+	}
+
+	if commonPasswords[strings.ToLower(pw)] {
+		reasons = append(reasons, "is too common")
+	}
+
+	if len(reasons) > 0 {
+		return &PasswordError{Reasons: reasons}
+	}
+	return nil
+}
+
+func writePasswordError(w http.ResponseWriter, err error) {
+	var pwErr *PasswordError
+	if !errors.As(err, &pwErr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   "password too weak",
+		"reasons": pwErr.Reasons,
+	})
+}
+
+// generateToken returns a signed 24h token and its expiry.
+func generateToken(userID uint, email string) (string, time.Time, error) {
 	claims := &Claims{
 		UserID: userID,
 		Email:  email,
@@ -267,21 +473,47 @@ func generateToken(userID uint, email string) (string, error) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	return token, claims.ExpiresAt.Time, err
 }
 
 // Product handlers
 func getProducts(w http.ResponseWriter, r *http.Request) {
+	limit, offset := 50, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
 	if db == nil {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode([]Product{})
+		json.NewEncoder(w).Encode(map[string]interface{}{"products": []Product{}, "total": 0, "limit": limit, "offset": offset})
+		return
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM products").Scan(&total); err != nil {
+		log.Printf("Error counting products: %v", err)
+		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
 		return
 	}
 
 	rows, err := db.Query(
-		`SELECT id, name, description, price, stock, category, image_url, created_at
-		 FROM products ORDER BY created_at DESC`,
+		`SELECT id, name, COALESCE(description, ''), price, COALESCE(stock, 0), COALESCE(category, ''), COALESCE(image_url, ''), created_at
+		 FROM products ORDER BY created_at DESC LIMIT $1 OFFSET $2`,
+		limit, offset,
 	)
 	if err != nil {
 		log.Printf("Error fetching products: %v", err)
@@ -302,7 +534,12 @@ func getProducts(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(products)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"products": products,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	})
 }
 
 func getProduct(w http.ResponseWriter, r *http.Request) {
@@ -311,7 +548,7 @@ func getProduct(w http.ResponseWriter, r *http.Request) {
 
 	var p Product
 	err := db.QueryRow(
-		"SELECT id, name, description, price, stock, category, image_url, created_at FROM products WHERE id = $1",
+		"SELECT id, name, COALESCE(description, ''), price, COALESCE(stock, 0), COALESCE(category, ''), COALESCE(image_url, ''), created_at FROM products WHERE id = $1",
 		id,
 	).Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.Category, &p.ImageURL, &p.CreatedAt)
 
@@ -349,7 +586,7 @@ func getCart(w http.ResponseWriter, r *http.Request) {
 	userID := vars["user_id"]
 
 	rows, err := db.Query(
-		`SELECT id, user_id, product_id, quantity, price, name, image_url, created_at
+		`SELECT id, user_id, product_id, quantity, price, name, COALESCE(image_url, ''), created_at
 		 FROM cart_items WHERE user_id = $1 ORDER BY created_at DESC`,
 		userID,
 	)
@@ -500,7 +737,7 @@ func getOrdersByUser(w http.ResponseWriter, r *http.Request) {
 	userID := vars["user_id"]
 
 	rows, err := db.Query(
-		`SELECT id, user_id, status, total_amount, shipping_address, payment_method, payment_status, created_at, updated_at
+		`SELECT id, user_id, COALESCE(status, 'pending'), total_amount, COALESCE(shipping_address, ''), COALESCE(payment_method, ''), COALESCE(payment_status, 'pending'), created_at, updated_at
 		 FROM orders WHERE user_id = $1 ORDER BY created_at DESC`,
 		userID,
 	)
@@ -527,7 +764,7 @@ func getOrder(w http.ResponseWriter, r *http.Request) {
 
 	var order Order
 	err := db.QueryRow(
-		`SELECT id, user_id, status, total_amount, shipping_address, payment_method, payment_status, created_at, updated_at
+		`SELECT id, user_id, COALESCE(status, 'pending'), total_amount, COALESCE(shipping_address, ''), COALESCE(payment_method, ''), COALESCE(payment_status, 'pending'), created_at, updated_at
 		 FROM orders WHERE id = $1`,
 		orderID,
 	).Scan(&order.ID, &order.UserID, &order.Status, &order.TotalAmount, &order.ShippingAddr, &order.PaymentMethod, &order.PaymentStatus, &order.CreatedAt, &order.UpdatedAt)
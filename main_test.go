@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRegisterRejectsWeakPassword(t *testing.T) {
+	for _, password := range []string{"", "ab1", "password123"} {
+		body, _ := json.Marshal(map[string]string{"email": "ann@example.com", "password": password})
+		w := httptest.NewRecorder()
+		register(w, httptest.NewRequest("POST", "/api/register", strings.NewReader(string(body))))
+
+		var resp struct {
+			Error   string   `json:"error"`
+			Reasons []string `json:"reasons"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != http.StatusBadRequest || resp.Error != "password too weak" || len(resp.Reasons) == 0 {
+			t.Errorf("password %q: status = %d, body = %+v", password, w.Code, resp)
+		}
+	}
+}
+
+func TestValidatePasswordAcceptsStrongPassword(t *testing.T) {
+	if err := validatePassword("correct horse 9"); err != nil {
+		t.Errorf("validatePassword = %v, want nil", err)
+	}
+}
+
+func TestRegisterRejectsInvalidEmail(t *testing.T) {
+	for _, email := range []string{"", "not-an-email", "Joyce <joyce@x.com>"} {
+		body, _ := json.Marshal(map[string]string{"email": email, "password": "correct horse 9"})
+		w := httptest.NewRecorder()
+		register(w, httptest.NewRequest("POST", "/api/register", strings.NewReader(string(body))))
+
+		var resp map[string]string
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != http.StatusBadRequest || resp["error"] != "invalid email" {
+			t.Errorf("email %q: status = %d, body = %v", email, w.Code, resp)
+		}
+	}
+}
+
+func TestGenerateTokenReturnsEmbeddedExpiry(t *testing.T) {
+	token, expiresAt, err := generateToken(3, "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) { return jwtSecret, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !expiresAt.Equal(claims.ExpiresAt.Time) {
+		t.Errorf("returned expiry = %v, token exp = %v", expiresAt, claims.ExpiresAt.Time)
+	}
+}
+
+func TestRegisterHashesWithConfiguredCost(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "5")
+	prev := bcryptCost
+	bcryptCost = bcryptCostFromEnv()
+	t.Cleanup(func() { bcryptCost = prev })
+
+	fake := dbtest.New(t)
+	fake.On(`INSERT INTO users`).Rows([]string{"id", "created_at"}, []interface{}{3, time.Now()})
+	prevDB := db
+	db = fake.DB
+	t.Cleanup(func() { db = prevDB })
+
+	w := httptest.NewRecorder()
+	register(w, httptest.NewRequest("POST", "/api/register", strings.NewReader(`{"email": "ann@example.com", "password": "correct horse 9"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	hash, _ := fake.Matching(`INSERT INTO users`)[0].Args[1].(string)
+	if cost, err := bcrypt.Cost([]byte(hash)); err != nil || cost != 5 {
+		t.Errorf("bcrypt.Cost = %d (%v), want 5", cost, err)
+	}
+
+	t.Setenv("BCRYPT_COST", "40")
+	if cost := bcryptCostFromEnv(); cost != bcrypt.DefaultCost {
+		t.Errorf("out of range BCRYPT_COST gave cost %d, want the default", cost)
+	}
+}
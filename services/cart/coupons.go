@@ -0,0 +1,362 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/pricing"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/promo"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/response"
+	"github.com/lib/pq"
+)
+
+const maxCouponCodeLength = 50
+
+var (
+	errCouponNotFound = errors.New("invalid coupon code")
+	errCouponExpired  = errors.New("coupon has expired")
+	errCouponUsedUp   = errors.New("coupon usage limit reached")
+)
+
+// Coupon is a discount code. Value is a percentage for percent coupons and an
+// amount for fixed ones. A nil UsageLimit means the code never runs out.
+type Coupon struct {
+	ID          uint       `json:"id"`
+	Code        string     `json:"code"`
+	Type        string     `json:"type"`
+	Value       float64    `json:"value"`
+	MinSubtotal float64    `json:"min_subtotal"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	UsageLimit  *int       `json:"usage_limit,omitempty"`
+	TimesUsed   int        `json:"times_used"`
+}
+
+// normalizeCouponCode is the form codes are stored and looked up in, so
+// "save10" and "SAVE10" are the same code.
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+const couponColumns = "id, code, type, value, min_subtotal, expires_at, usage_limit, times_used"
+
+func scanCoupon(row *sql.Row) (*Coupon, error) {
+	var c Coupon
+	err := row.Scan(&c.ID, &c.Code, &c.Type, &c.Value, &c.MinSubtotal, &c.ExpiresAt, &c.UsageLimit, &c.TimesUsed)
+	if err == sql.ErrNoRows {
+		return nil, errCouponNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// check reports why the coupon can't be used on a cart worth subtotal, or
+// nil if it can. Uses are only counted when an order redeems the coupon, so
+// a code at its limit can't be applied or redeemed.
+func (c *Coupon) check(subtotal float64, now time.Time) error {
+	if c.ExpiresAt != nil && !now.Before(*c.ExpiresAt) {
+		return errCouponExpired
+	}
+	if c.UsageLimit != nil && c.TimesUsed >= *c.UsageLimit {
+		return errCouponUsedUp
+	}
+	if subtotal < c.MinSubtotal {
+		return fmt.Errorf("coupon requires a subtotal of at least %.2f", c.MinSubtotal)
+	}
+	return nil
+}
+
+// promo returns the coupon in the form the order service accepts.
+func (c *Coupon) promo() promo.Promo {
+	return promo.Promo{Code: c.Code, Kind: c.Type, Value: c.Value}
+}
+
+// discount is how much the coupon takes off subtotal, worked out the same way
+// checkout will.
+func (c *Coupon) discount(subtotal float64) (float64, error) {
+	result, err := promo.Apply(subtotal, []promo.Promo{c.promo()}, promoRules)
+	if err != nil {
+		return 0, err
+	}
+	return pricing.Round(result.Subtotal - result.Discounted), nil
+}
+
+// applyCartCoupon fills in the cart's coupon, discount and discounted total.
+// A coupon that has stopped qualifying (it expired, or items were removed)
+// stays applied but takes nothing off, and CouponError says why.
+func applyCartCoupon(cart *Cart) error {
+	cart.TotalAfterDiscount = pricing.Round(cart.TotalPrice)
+
+	c, err := scanCoupon(db.QueryRow(
+		"SELECT "+couponColumns+" FROM coupons WHERE id = (SELECT coupon_id FROM cart_coupons WHERE user_id = $1)",
+		cart.UserID,
+	))
+	if err == errCouponNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	p := c.promo()
+	cart.Coupon = &p
+	if err := c.check(cart.TotalPrice, time.Now()); err != nil {
+		cart.CouponError = err.Error()
+		return nil
+	}
+	discount, err := c.discount(cart.TotalPrice)
+	if err != nil {
+		cart.CouponError = err.Error()
+		return nil
+	}
+	cart.Discount = discount
+	cart.TotalAfterDiscount = pricing.Round(cart.TotalPrice - discount)
+	return nil
+}
+
+// applyCoupon puts a coupon code on the user's cart, replacing any code
+// already there. Applying does not count against the usage limit; placing
+// the order does (see redeemCoupon).
+func applyCoupon(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cartOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	code := normalizeCouponCode(req.Code)
+	if code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+
+	subtotal, err := GetTotalPrice(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch cart", http.StatusInternalServerError)
+		return
+	}
+
+	c, err := scanCoupon(db.QueryRow("SELECT "+couponColumns+" FROM coupons WHERE code = $1", code))
+	if err == errCouponNotFound {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to apply coupon", http.StatusInternalServerError)
+		return
+	}
+	if err := c.check(subtotal, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO cart_coupons (user_id, coupon_id) VALUES ($1, $2)
+		 ON CONFLICT (user_id) DO UPDATE SET coupon_id = EXCLUDED.coupon_id, applied_at = CURRENT_TIMESTAMP`,
+		userID, c.ID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to apply coupon", http.StatusInternalServerError)
+		return
+	}
+
+	discount, _ := c.discount(subtotal)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":              userID,
+		"coupon":               c.promo(),
+		"total_price":          pricing.Round(subtotal),
+		"discount":             discount,
+		"total_after_discount": pricing.Round(subtotal - discount),
+	})
+}
+
+// redeemCoupon is called by the order service while it places an order. It
+// re-checks the cart's coupon against the order's subtotal, counts one use
+// and takes the coupon off the cart, all under a lock on the coupon row so
+// concurrent checkouts can't overrun the usage limit. It answers
+// {"coupon": null} when the cart has no coupon and 409 when it no longer
+// qualifies.
+func redeemCoupon(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cartOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Subtotal float64 `json:"subtotal"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	c, err := scanCoupon(tx.QueryRow(
+		"SELECT "+couponColumns+" FROM coupons WHERE id = (SELECT coupon_id FROM cart_coupons WHERE user_id = $1) FOR UPDATE",
+		userID,
+	))
+	if err == errCouponNotFound {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"coupon": nil})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to redeem coupon", http.StatusInternalServerError)
+		return
+	}
+	if err := c.check(req.Subtotal, time.Now()); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE coupons SET times_used = times_used + 1 WHERE id = $1", c.ID); err != nil {
+		http.Error(w, "Failed to redeem coupon", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM cart_coupons WHERE user_id = $1", userID); err != nil {
+		http.Error(w, "Failed to redeem coupon", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"coupon": c.promo()})
+}
+
+// releaseCoupon undoes redeemCoupon when the order it was redeemed for could
+// not be saved: the use is given back and the code goes back on the cart.
+func releaseCoupon(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cartOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var couponID uint
+	err = tx.QueryRow(
+		"UPDATE coupons SET times_used = GREATEST(times_used - 1, 0) WHERE code = $1 RETURNING id",
+		normalizeCouponCode(req.Code),
+	).Scan(&couponID)
+	if err == sql.ErrNoRows {
+		http.Error(w, errCouponNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to release coupon", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO cart_coupons (user_id, coupon_id) VALUES ($1, $2) ON CONFLICT (user_id) DO NOTHING",
+		userID, couponID,
+	); err != nil {
+		http.Error(w, "Failed to release coupon", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user_id": userID, "code": normalizeCouponCode(req.Code)})
+}
+
+// removeCoupon takes the coupon off the user's cart.
+func removeCoupon(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cartOwner(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM cart_coupons WHERE user_id = $1", userID)
+	if err != nil {
+		http.Error(w, "Failed to remove coupon", http.StatusInternalServerError)
+		return
+	}
+	n, _ := result.RowsAffected()
+	response.Deleted(w, r, int64(userID), n > 0)
+}
+
+// createCoupon adds a discount code (admin).
+func createCoupon(w http.ResponseWriter, r *http.Request) {
+	var c Coupon
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	c.Code = normalizeCouponCode(c.Code)
+
+	switch {
+	case c.Code == "" || len(c.Code) > maxCouponCodeLength:
+		http.Error(w, "code is required and must be at most 50 characters", http.StatusBadRequest)
+		return
+	case c.Type != promo.KindPercent && c.Type != promo.KindFixed:
+		http.Error(w, "type must be percent or fixed", http.StatusBadRequest)
+		return
+	case c.Value <= 0 || (c.Type == promo.KindPercent && c.Value > 100):
+		http.Error(w, "value must be positive, and at most 100 for percent coupons", http.StatusBadRequest)
+		return
+	case c.MinSubtotal < 0:
+		http.Error(w, "min_subtotal must not be negative", http.StatusBadRequest)
+		return
+	case c.UsageLimit != nil && *c.UsageLimit <= 0:
+		http.Error(w, "usage_limit must be positive", http.StatusBadRequest)
+		return
+	}
+
+	err := db.QueryRow(
+		`INSERT INTO coupons (code, type, value, min_subtotal, expires_at, usage_limit)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		c.Code, c.Type, c.Value, c.MinSubtotal, c.ExpiresAt, c.UsageLimit,
+	).Scan(&c.ID)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		http.Error(w, "Coupon code already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create coupon", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
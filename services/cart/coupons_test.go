@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizeCouponCode(t *testing.T) {
+	if got := normalizeCouponCode("  save10 "); got != "SAVE10" {
+		t.Errorf("normalizeCouponCode = %q, want SAVE10", got)
+	}
+}
+
+func TestCouponCheck(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	limit := 3
+
+	tests := []struct {
+		name     string
+		coupon   Coupon
+		subtotal float64
+		want     error
+	}{
+		{"valid", Coupon{ExpiresAt: &future, UsageLimit: &limit, TimesUsed: 2}, 50, nil},
+		{"expired", Coupon{ExpiresAt: &past}, 50, errCouponExpired},
+		{"expires now", Coupon{ExpiresAt: &now}, 50, errCouponExpired},
+		{"used up", Coupon{UsageLimit: &limit, TimesUsed: 3}, 50, errCouponUsedUp},
+		{"no limit", Coupon{TimesUsed: 1000}, 50, nil},
+	}
+	for _, tt := range tests {
+		if err := tt.coupon.check(tt.subtotal, now); err != tt.want {
+			t.Errorf("%s: check = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCouponCheckMinSubtotal(t *testing.T) {
+	c := Coupon{MinSubtotal: 40}
+	if err := c.check(39.99, time.Now()); err == nil {
+		t.Error("coupon accepted below its minimum subtotal")
+	}
+	if err := c.check(40, time.Now()); err != nil {
+		t.Errorf("coupon rejected at its minimum subtotal: %v", err)
+	}
+}
+
+func TestCouponDiscount(t *testing.T) {
+	percent := Coupon{Code: "TEN", Type: "percent", Value: 10}
+	if got, err := percent.discount(59.99); err != nil || got != 6 {
+		t.Errorf("percent discount = %v, %v; want 6", got, err)
+	}
+
+	fixed := Coupon{Code: "FIVE", Type: "fixed", Value: 5}
+	if got, err := fixed.discount(3); err != nil || got != 3 {
+		t.Errorf("fixed discount on a smaller cart = %v, %v; want 3", got, err)
+	}
+}
@@ -1,78 +1,218 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/database"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/pricing"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/promo"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/response"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/server"
 )
 
 type CartItem struct {
-	ID        uint      `json:"id"`
-	UserID    uint      `json:"user_id"`
-	ProductID uint      `json:"product_id"`
-	Quantity  int       `json:"quantity"`
-	Price     float64   `json:"price"`
-	Name      string    `json:"name"`
-	ImageURL  string    `json:"image_url"`
+	ID        uint    `json:"id"`
+	UserID    uint    `json:"user_id"`
+	ProductID uint    `json:"product_id"`
+	Quantity  int     `json:"quantity"`
+	Price     float64 `json:"price"`
+	Name      string  `json:"name"`
+	ImageURL  string  `json:"image_url"`
+	// Version goes up by one on every change to the item. Updates must send
+	// the version they last read.
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type Cart struct {
+	UserID     uint       `json:"user_id"`
 	Items      []CartItem `json:"items"`
 	TotalItems int        `json:"total_items"`
 	TotalPrice float64    `json:"total_price"`
+	// Version changes whenever an item is added, changed or removed. Checkout
+	// sends it to the order service so the same cart can't be ordered twice.
+	Version string `json:"version,omitempty"`
+	// Coupon is the code applied with POST /cart/{user_id}/coupon, in the
+	// form checkout takes as a promotion.
+	Coupon             *promo.Promo `json:"coupon,omitempty"`
+	CouponError        string       `json:"coupon_error,omitempty"`
+	Discount           float64      `json:"discount"`
+	TotalAfterDiscount float64      `json:"total_after_discount"`
+}
+
+// cartVersion fingerprints the cart's items. Item ids are never reused and
+// updated_at moves on every change, so a cleared and refilled cart gets a
+// new version even with the same products.
+func cartVersion(items []CartItem) string {
+	if len(items) == 0 {
+		return ""
+	}
+	sorted := make([]CartItem, len(items))
+	copy(sorted, items)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	h := sha256.New()
+	for _, item := range sorted {
+		fmt.Fprintf(h, "%d:%d:%d;", item.ID, item.Quantity, item.UpdatedAt.UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 var db *sql.DB
+var dbOnce database.Once
+
+// Cart items older than CART_TTL_HOURS (default 30 days; 0 keeps them
+// forever) are deleted every CART_CLEANUP_INTERVAL_MINUTES.
+var (
+	cartTTL             = time.Duration(envInt("CART_TTL_HOURS", 720)) * time.Hour
+	cartCleanupInterval = time.Duration(envInt("CART_CLEANUP_INTERVAL_MINUTES", 60)) * time.Minute
+)
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+		log.Printf("Invalid %s %q, using %d", key, v, fallback)
+	}
+	return fallback
+}
 
 func main() {
 	var err error
-	db, err = database.NewConnection("cart_db")
+	err = dbOnce.Open(&db, func() (*sql.DB, error) { return database.NewConnection("cart_db") })
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
 
+	stopKeepalive := database.StartKeepalive(db, database.KeepaliveInterval())
+	defer stopKeepalive()
+
 	initDB()
 
+	stopCleanup := startExpiredCartCleanup(cartTTL, cartCleanupInterval)
+	defer stopCleanup()
+
 	r := mux.NewRouter()
 	r.Use(middleware.CORS)
+	r.Use(middleware.LogRequestBodies)
 
 	r.HandleFunc("/health", healthCheck).Methods("GET")
-	r.HandleFunc("/cart/{user_id}", getCart).Methods("GET")
-	r.HandleFunc("/cart/{user_id}/items", addToCart).Methods("POST")
-	r.HandleFunc("/cart/{user_id}/items/{item_id}", updateCartItem).Methods("PUT")
-	r.HandleFunc("/cart/{user_id}/items/{item_id}", removeFromCart).Methods("DELETE")
-	r.HandleFunc("/cart/{user_id}", clearCart).Methods("DELETE")
+	r.Handle("/cart/stats/top-items", middleware.AuthMiddleware(middleware.RequireRole("admin")(http.HandlerFunc(getTopCartItems)))).Methods("GET")
+	r.Handle("/cart/coupons", middleware.AuthMiddleware(middleware.RequireRole("admin")(http.HandlerFunc(createCoupon)))).Methods("POST")
+	r.Handle("/cart/{user_id}", middleware.AuthMiddleware(http.HandlerFunc(getCart))).Methods("GET")
+	r.Handle("/cart/{user_id}/summary", middleware.AuthMiddleware(http.HandlerFunc(getCartSummary))).Methods("GET")
+	r.Handle("/cart/{user_id}/items", middleware.AuthMiddleware(http.HandlerFunc(addToCart))).Methods("POST")
+	r.Handle("/cart/{user_id}/items/{item_id}", middleware.AuthMiddleware(http.HandlerFunc(updateCartItem))).Methods("PUT")
+	r.Handle("/cart/{user_id}/items/{item_id}", middleware.AuthMiddleware(http.HandlerFunc(removeFromCart))).Methods("DELETE")
+	r.Handle("/cart/{user_id}", middleware.AuthMiddleware(http.HandlerFunc(clearCart))).Methods("DELETE")
+	r.Handle("/cart/{user_id}/coupon", middleware.AuthMiddleware(http.HandlerFunc(applyCoupon))).Methods("POST")
+	r.Handle("/cart/{user_id}/coupon", middleware.AuthMiddleware(http.HandlerFunc(removeCoupon))).Methods("DELETE")
+	r.Handle("/cart/{user_id}/coupon/redeem", middleware.AuthMiddleware(http.HandlerFunc(redeemCoupon))).Methods("POST")
+	r.Handle("/cart/{user_id}/coupon/release", middleware.AuthMiddleware(http.HandlerFunc(releaseCoupon))).Methods("POST")
 
 	log.Println("Cart service running on :8003")
-	log.Fatal(http.ListenAndServe(":8003", r))
+	if err := server.Run(":8003", middleware.TrimTrailingSlash(r)); err != nil {
+		log.Fatal("Server error:", err)
+	}
 }
 
 func initDB() {
-	query := `
-	CREATE TABLE IF NOT EXISTS cart_items (
-		id SERIAL PRIMARY KEY,
-		user_id INT NOT NULL,
-		product_id INT NOT NULL,
-		quantity INT NOT NULL DEFAULT 1,
-		price DECIMAL(10,2) NOT NULL,
-		name VARCHAR(255) NOT NULL,
-		image_url TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		UNIQUE(user_id, product_id)
-	)`
-	_, err := db.Exec(query)
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS cart_items (
+			id SERIAL PRIMARY KEY,
+			user_id INT NOT NULL,
+			product_id INT NOT NULL,
+			quantity INT NOT NULL DEFAULT 1,
+			price DECIMAL(10,2) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			image_url TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, product_id)
+		)`,
+		`ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP`,
+		`ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1`,
+		`CREATE INDEX IF NOT EXISTS idx_cart_items_created ON cart_items (created_at)`,
+		`CREATE TABLE IF NOT EXISTS coupons (
+			id SERIAL PRIMARY KEY,
+			code VARCHAR(50) UNIQUE NOT NULL,
+			type VARCHAR(10) NOT NULL CHECK (type IN ('percent', 'fixed')),
+			value DECIMAL(10,2) NOT NULL CHECK (value > 0),
+			min_subtotal DECIMAL(10,2) NOT NULL DEFAULT 0,
+			expires_at TIMESTAMP,
+			usage_limit INT,
+			times_used INT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS cart_coupons (
+			user_id INT PRIMARY KEY,
+			coupon_id INT NOT NULL REFERENCES coupons(id) ON DELETE CASCADE,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+	}
+
+	for _, query := range queries {
+		_, err := db.Exec(query)
+		if err != nil {
+			log.Fatal("Failed to create cart_items table:", err)
+		}
+	}
+}
+
+// deleteExpiredCartItems removes every cart item added before cutoff in one
+// statement and returns how many went.
+func deleteExpiredCartItems(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM cart_items WHERE created_at < $1", cutoff)
 	if err != nil {
-		log.Fatal("Failed to create cart_items table:", err)
+		return 0, err
 	}
+	return res.RowsAffected()
+}
+
+// startExpiredCartCleanup deletes abandoned cart items older than ttl every
+// interval. A ttl or interval of 0 disables it. Call the returned func to
+// stop.
+func startExpiredCartCleanup(ttl, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if ttl <= 0 || interval <= 0 {
+		return cancel
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := deleteExpiredCartItems(ctx, time.Now().Add(-ttl))
+				if err != nil {
+					log.Printf("Expired cart cleanup failed: %v", err)
+					continue
+				}
+				log.Printf("Expired cart cleanup removed %d items older than %s", n, ttl)
+			}
+		}
+	}()
+	return cancel
 }
 
 func healthCheck(w http.ResponseWriter, r *http.Request) {
@@ -80,11 +220,13 @@ func healthCheck(w http.ResponseWriter, r *http.Request) {
 }
 
 func getCart(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["user_id"]
+	userID, ok := cartOwner(w, r)
+	if !ok {
+		return
+	}
 
 	rows, err := db.Query(
-		`SELECT id, user_id, product_id, quantity, price, name, image_url, created_at
+		`SELECT id, user_id, product_id, quantity, price, name, COALESCE(image_url, ''), version, created_at, updated_at
 		 FROM cart_items WHERE user_id = $1 ORDER BY created_at DESC`,
 		userID,
 	)
@@ -94,10 +236,10 @@ func getCart(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	cart := Cart{Items: []CartItem{}}
+	cart := Cart{UserID: userID, Items: []CartItem{}}
 	for rows.Next() {
 		var item CartItem
-		err := rows.Scan(&item.ID, &item.UserID, &item.ProductID, &item.Quantity, &item.Price, &item.Name, &item.ImageURL, &item.CreatedAt)
+		err := rows.Scan(&item.ID, &item.UserID, &item.ProductID, &item.Quantity, &item.Price, &item.Name, &item.ImageURL, &item.Version, &item.CreatedAt, &item.UpdatedAt)
 		if err != nil {
 			continue
 		}
@@ -105,108 +247,316 @@ func getCart(w http.ResponseWriter, r *http.Request) {
 		cart.TotalItems += item.Quantity
 		cart.TotalPrice += item.Price * float64(item.Quantity)
 	}
+	cart.Version = cartVersion(cart.Items)
+	if err := applyCartCoupon(&cart); err != nil {
+		http.Error(w, "Failed to fetch cart coupon", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(cart)
 }
 
+// getCartSummary estimates tax, shipping and the grand total for the cart
+// before checkout, after any ?promo= coupons and credit, and reports how much
+// each promotion saves. Final amounts are computed when the order is placed.
+func getCartSummary(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cartOwner(w, r)
+	if !ok {
+		return
+	}
+
+	region, err := pricing.NormalizeRegion(r.URL.Query().Get("region"))
+	if err != nil {
+		http.Error(w, "Unsupported or missing region", http.StatusBadRequest)
+		return
+	}
+	promos, err := parsePromoParams(r.URL.Query()["promo"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	subtotal, err := GetTotalPrice(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch cart", http.StatusInternalServerError)
+		return
+	}
+	subtotal = pricing.Round(subtotal)
+
+	// Without dimensions the quote falls back to the flat rate.
+	parcels, err := cartParcels(userID)
+	if err != nil {
+		log.Printf("Failed to load dimensions for cart of user %d: %v", userID, err)
+	}
+	weight := pricing.BillableWeight(parcels)
+
+	// Coupons lower the taxable amount; store credit is a form of payment
+	// and only comes off the total.
+	discounts, err := promo.Apply(subtotal, promos, promoRules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tax, _ := pricing.Tax(region, discounts.Discounted)
+	shipping, _ := pricing.ShippingForWeight(region, discounts.Discounted, weight)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":               userID,
+		"region":                region,
+		"subtotal":              subtotal,
+		"discounted_subtotal":   discounts.Discounted,
+		"credit_applied":        discounts.Credit,
+		"savings":               pricing.Round(discounts.Subtotal - discounts.Due),
+		"promotions":            discounts.Adjustments,
+		"billable_weight_grams": weight,
+		"estimated_tax":         tax,
+		"estimated_shipping":    shipping,
+		"estimated_total":       pricing.Round(discounts.Discounted - discounts.Credit + tax + shipping),
+		"is_estimate":           true,
+	})
+}
+
 func addToCart(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["user_id"]
+	userID, ok := cartOwner(w, r)
+	if !ok {
+		return
+	}
 
 	var item CartItem
 	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if item.Quantity <= 0 {
+		http.Error(w, "Quantity must be positive", http.StatusBadRequest)
+		return
+	}
 
-	// Try to update existing item, if not exists then insert
-	result, err := db.Exec(
+	// Price, name and image come from the catalog, not the client.
+	product, err := fetchProduct(item.ProductID)
+	if err == errProductNotFound {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Add to cart: failed to fetch product %d: %v", item.ProductID, err)
+		http.Error(w, "Product service unavailable", http.StatusBadGateway)
+		return
+	}
+	if item.Quantity > product.Stock {
+		writeInsufficientStock(w, product.Stock)
+		return
+	}
+
+	// Try to update existing item, if not exists then insert. xmax is zero
+	// only for a freshly inserted row, which tells the two cases apart. The
+	// update only goes through if the combined quantity is still in stock.
+	var itemID uint
+	var inserted bool
+	err = db.QueryRow(
 		`INSERT INTO cart_items (user_id, product_id, quantity, price, name, image_url)
 		 VALUES ($1, $2, $3, $4, $5, $6)
-		 ON CONFLICT (user_id, product_id) DO UPDATE SET quantity = cart_items.quantity + $3`,
-		userID, item.ProductID, item.Quantity, item.Price, item.Name, item.ImageURL,
-	)
-
+		 ON CONFLICT (user_id, product_id) DO UPDATE SET quantity = cart_items.quantity + $3, price = $4, name = $5,
+		   image_url = $6, version = cart_items.version + 1, updated_at = CURRENT_TIMESTAMP
+		 WHERE cart_items.quantity + $3 <= $7
+		 RETURNING id, (xmax = 0)`,
+		userID, item.ProductID, item.Quantity, product.Price, product.Name, product.ImageURL, product.Stock,
+	).Scan(&itemID, &inserted)
+	if err == sql.ErrNoRows {
+		writeInsufficientStock(w, product.Stock)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to add item to cart", http.StatusInternalServerError)
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
 	w.Header().Set("Content-Type", "application/json")
-	if rowsAffected > 0 {
-		json.NewEncoder(w).Encode(map[string]string{"message": "Item added to cart"})
-	} else {
-		http.Error(w, "Failed to add item", http.StatusInternalServerError)
+	if inserted {
+		w.Header().Set("Location", fmt.Sprintf("/cart/%d/items/%d", userID, itemID))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "Item added to cart", "id": itemID, "user_id": userID})
+		return
 	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Cart item updated", "id": itemID, "user_id": userID})
+}
+
+func writeInsufficientStock(w http.ResponseWriter, available int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": "insufficient stock", "available": available})
 }
 
 func updateCartItem(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["user_id"]
-	itemID := vars["item_id"]
+	userID, ok := cartOwner(w, r)
+	if !ok {
+		return
+	}
+	itemID := mux.Vars(r)["item_id"]
 
 	var update struct {
 		Quantity int `json:"quantity"`
+		// Version is the item's version as last read by the client. An
+		// If-Match header may carry it instead.
+		Version int `json:"version"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	version, err := expectedVersion(r, update.Version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if version == 0 {
+		http.Error(w, "version or If-Match is required", http.StatusPreconditionRequired)
+		return
+	}
 
+	var result sql.Result
 	if update.Quantity <= 0 {
 		// Remove item if quantity is 0 or less
-		_, err := db.Exec("DELETE FROM cart_items WHERE id = $1 AND user_id = $2", itemID, userID)
-		if err != nil {
-			http.Error(w, "Failed to remove item", http.StatusInternalServerError)
-			return
-		}
+		result, err = db.Exec("DELETE FROM cart_items WHERE id = $1 AND user_id = $2 AND version = $3", itemID, userID, version)
 	} else {
-		_, err := db.Exec(
-			"UPDATE cart_items SET quantity = $1 WHERE id = $2 AND user_id = $3",
-			update.Quantity, itemID, userID,
+		result, err = db.Exec(
+			`UPDATE cart_items SET quantity = $4, version = version + 1, updated_at = CURRENT_TIMESTAMP
+			 WHERE id = $1 AND user_id = $2 AND version = $3`,
+			itemID, userID, version, update.Quantity,
 		)
-		if err != nil {
-			http.Error(w, "Failed to update item", http.StatusInternalServerError)
-			return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update item", http.StatusInternalServerError)
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		var exists bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM cart_items WHERE id = $1 AND user_id = $2)", itemID, userID).Scan(&exists)
+		if exists {
+			http.Error(w, "Cart item was modified, please refresh", http.StatusConflict)
+		} else {
+			http.Error(w, "Cart item not found", http.StatusNotFound)
 		}
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Cart updated"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Cart updated", "user_id": userID})
+}
+
+// expectedVersion is the item version an update is conditional on: the
+// If-Match header if present, else the version in the body. 0 means the
+// client sent neither.
+func expectedVersion(r *http.Request, bodyVersion int) (int, error) {
+	match := strings.Trim(r.Header.Get("If-Match"), `"`)
+	if match == "" {
+		if bodyVersion < 0 {
+			return 0, errors.New("invalid version")
+		}
+		return bodyVersion, nil
+	}
+	version, err := strconv.Atoi(match)
+	if err != nil || version <= 0 {
+		return 0, errors.New("invalid If-Match header")
+	}
+	return version, nil
+}
+
+type TopCartItem struct {
+	ProductID uint   `json:"product_id"`
+	Name      string `json:"name"`
+	CartCount int    `json:"cart_count"`
+	Quantity  int    `json:"quantity"`
+}
+
+// getTopCartItems ranks products by how many carts currently hold them, then
+// by total quantity, so merchandisers can see demand that hasn't converted.
+func getTopCartItems(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 10
+	}
+
+	rows, err := db.Query(
+		`SELECT product_id, MAX(name), COUNT(DISTINCT user_id) AS cart_count, SUM(quantity) AS quantity
+		 FROM cart_items GROUP BY product_id
+		 ORDER BY cart_count DESC, quantity DESC, product_id LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch cart stats", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := []TopCartItem{}
+	for rows.Next() {
+		var item TopCartItem
+		if err := rows.Scan(&item.ProductID, &item.Name, &item.CartCount, &item.Quantity); err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
 }
 
 func removeFromCart(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["user_id"]
-	itemID := vars["item_id"]
+	userID, ok := cartOwner(w, r)
+	if !ok {
+		return
+	}
+	itemID, err := strconv.ParseInt(mux.Vars(r)["item_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
 
-	_, err := db.Exec("DELETE FROM cart_items WHERE id = $1 AND user_id = $2", itemID, userID)
+	result, err := db.Exec("DELETE FROM cart_items WHERE id = $1 AND user_id = $2", itemID, userID)
 	if err != nil {
 		http.Error(w, "Failed to remove item", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	n, _ := result.RowsAffected()
+	response.Deleted(w, r, itemID, n > 0)
 }
 
 func clearCart(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["user_id"]
+	userID, ok := cartOwner(w, r)
+	if !ok {
+		return
+	}
 
-	_, err := db.Exec("DELETE FROM cart_items WHERE user_id = $1", userID)
+	result, err := db.Exec("DELETE FROM cart_items WHERE user_id = $1", userID)
+	if err != nil {
+		http.Error(w, "Failed to clear cart", http.StatusInternalServerError)
+		return
+	}
+	// The coupon goes with the items; the next cart starts without one.
+	if _, err := db.Exec("DELETE FROM cart_coupons WHERE user_id = $1", userID); err != nil {
+		http.Error(w, "Failed to clear cart", http.StatusInternalServerError)
+		return
+	}
+	cleared, err := result.RowsAffected()
 	if err != nil {
 		http.Error(w, "Failed to clear cart", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	// Clearing an empty cart is not an error; it just reports 0.
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"cleared": cleared, "user_id": userID})
 }
 
 func GetCartItemsByUserID(userID string) ([]CartItem, error) {
 	rows, err := db.Query(
-		`SELECT id, user_id, product_id, quantity, price, name, image_url, created_at
+		`SELECT id, user_id, product_id, quantity, price, name, COALESCE(image_url, ''), version, created_at, updated_at
 		 FROM cart_items WHERE user_id = $1`,
 		userID,
 	)
@@ -218,7 +568,7 @@ func GetCartItemsByUserID(userID string) ([]CartItem, error) {
 	var items []CartItem
 	for rows.Next() {
 		var item CartItem
-		rows.Scan(&item.ID, &item.UserID, &item.ProductID, &item.Quantity, &item.Price, &item.Name, &item.ImageURL, &item.CreatedAt)
+		rows.Scan(&item.ID, &item.UserID, &item.ProductID, &item.Quantity, &item.Price, &item.Name, &item.ImageURL, &item.Version, &item.CreatedAt, &item.UpdatedAt)
 		items = append(items, item)
 	}
 	return items, nil
@@ -229,7 +579,7 @@ func ClearCartByUserID(userID string) error {
 	return err
 }
 
-func GetTotalPrice(userID string) (float64, error) {
+func GetTotalPrice(userID uint) (float64, error) {
 	var total float64
 	err := db.QueryRow(
 		"SELECT COALESCE(SUM(price * quantity), 0) FROM cart_items WHERE user_id = $1",
@@ -238,6 +588,24 @@ func GetTotalPrice(userID string) (float64, error) {
 	return total, err
 }
 
-func GetUserIDFromContext(r *http.Request) string {
-	return strconv.Itoa(int(r.Context().Value("user_id").(uint)))
+// cartOwner resolves the cart's {user_id} against the caller's token. Only
+// the owner or an admin may touch a cart; otherwise the request is answered
+// with 400 or 403 and ok is false.
+func cartOwner(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, false
+	}
+
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Authorization header required", http.StatusUnauthorized)
+		return 0, false
+	}
+	if !claims.CanAccessUser(uint(userID)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return 0, false
+	}
+	return uint(userID), true
 }
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+// useDB points the service at a scripted database for the rest of the test.
+func useDB(t *testing.T) *dbtest.DB {
+	t.Helper()
+	fake := dbtest.New(t)
+	prev := db
+	db = fake.DB
+	t.Cleanup(func() { db = prev })
+	return fake
+}
+
+func TestCartVersion(t *testing.T) {
+	at := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	items := []CartItem{
+		{ID: 1, Quantity: 2, UpdatedAt: at},
+		{ID: 2, Quantity: 1, UpdatedAt: at},
+	}
+	v := cartVersion(items)
+
+	reversed := []CartItem{items[1], items[0]}
+	if got := cartVersion(reversed); got != v {
+		t.Error("version depends on item order")
+	}
+
+	changed := []CartItem{items[0], {ID: 2, Quantity: 3, UpdatedAt: at.Add(time.Second)}}
+	if got := cartVersion(changed); got == v {
+		t.Error("version unchanged after an item changed")
+	}
+
+	if got := cartVersion(nil); got != "" {
+		t.Errorf("empty cart version = %q, want empty", got)
+	}
+}
+
+// authorize signs a token for userID (with role, if any) and sets it on r,
+// the way the gateway forwards a logged-in user's requests.
+func authorize(t *testing.T, r *http.Request, userID uint, role string) *http.Request {
+	t.Helper()
+	claims := &middleware.Claims{
+		UserID: userID,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    middleware.GetJWTIssuer(),
+			Audience:  jwt.ClaimStrings{middleware.GetJWTAudience()},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(middleware.GetJWTSecret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// serveCart routes r through AuthMiddleware to h with the cart's mux vars.
+func serveCart(h http.HandlerFunc, r *http.Request, vars map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(h).ServeHTTP(w, mux.SetURLVars(r, vars))
+	return w
+}
+
+func TestUpdateCartItemRequiresVersion(t *testing.T) {
+	r := authorize(t, httptest.NewRequest("PUT", "/cart/1/items/5", strings.NewReader(`{"quantity": 2}`)), 1, "")
+
+	w := serveCart(updateCartItem, r, map[string]string{"user_id": "1", "item_id": "5"})
+	if w.Code != http.StatusPreconditionRequired {
+		t.Errorf("status = %d, want 428", w.Code)
+	}
+}
+
+func TestUpdateCartItemRejectsBadIfMatch(t *testing.T) {
+	r := authorize(t, httptest.NewRequest("PUT", "/cart/1/items/5", strings.NewReader(`{"quantity": 2}`)), 1, "")
+	r.Header.Set("If-Match", `"abc"`)
+
+	w := serveCart(updateCartItem, r, map[string]string{"user_id": "1", "item_id": "5"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestUpdateCartItemForbidsOtherUsersCart(t *testing.T) {
+	r := authorize(t, httptest.NewRequest("PUT", "/cart/2/items/5", strings.NewReader(`{"quantity": 2, "version": 1}`)), 1, "")
+
+	w := serveCart(updateCartItem, r, map[string]string{"user_id": "2", "item_id": "5"})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
+
+func TestUpdateCartItemIsConditionalOnVersion(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^UPDATE cart_items SET quantity`)
+
+	r := authorize(t, httptest.NewRequest("PUT", "/cart/1/items/5", strings.NewReader(`{"quantity": 3}`)), 1, "")
+	r.Header.Set("If-Match", `"4"`)
+	w := serveCart(updateCartItem, r, map[string]string{"user_id": "1", "item_id": "5"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	calls := fake.Matching(`^UPDATE cart_items`)
+	if len(calls) != 1 || !strings.Contains(calls[0].Query, "version = $3") || calls[0].Args[2] != int64(4) {
+		t.Errorf("update = %+v, want it conditional on version 4", calls)
+	}
+}
+
+func TestUpdateCartItemStaleVersionConflicts(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^UPDATE cart_items SET quantity`).Affected(0)
+	fake.On(`SELECT EXISTS`).Rows([]string{"exists"}, []interface{}{true})
+
+	r := authorize(t, httptest.NewRequest("PUT", "/cart/1/items/5", strings.NewReader(`{"quantity": 3, "version": 2}`)), 1, "")
+	w := serveCart(updateCartItem, r, map[string]string{"user_id": "1", "item_id": "5"})
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", w.Code)
+	}
+}
+
+func TestUpdateCartItemMissingItem(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^DELETE FROM cart_items`).Affected(0)
+	fake.On(`SELECT EXISTS`).Rows([]string{"exists"}, []interface{}{false})
+
+	r := authorize(t, httptest.NewRequest("PUT", "/cart/1/items/5", strings.NewReader(`{"quantity": 0, "version": 2}`)), 1, "")
+	w := serveCart(updateCartItem, r, map[string]string{"user_id": "1", "item_id": "5"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestExpectedVersion(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/", nil)
+	if v, err := expectedVersion(r, 3); err != nil || v != 3 {
+		t.Errorf("body version = %d, %v; want 3", v, err)
+	}
+
+	r.Header.Set("If-Match", `"7"`)
+	if v, err := expectedVersion(r, 3); err != nil || v != 7 {
+		t.Errorf("If-Match version = %d, %v; want 7 (header wins)", v, err)
+	}
+
+	r.Header.Set("If-Match", "0")
+	if _, err := expectedVersion(r, 0); err == nil {
+		t.Error("If-Match: 0 accepted")
+	}
+}
+
+// cartSummary fetches user 1's summary with query from a cart worth subtotal.
+func cartSummary(t *testing.T, query string, subtotal float64) (int, map[string]interface{}) {
+	t.Helper()
+	fake := useDB(t)
+	fake.On(`SELECT COALESCE\(SUM\(price \* quantity\), 0\) FROM cart_items`).Rows([]string{"sum"}, []interface{}{subtotal})
+	fake.On(`SELECT product_id, quantity FROM cart_items`).Rows([]string{"product_id", "quantity"})
+
+	r := authorize(t, httptest.NewRequest("GET", "/cart/1/summary?"+query, nil), 1, "")
+	w := serveCart(getCartSummary, r, map[string]string{"user_id": "1"})
+	var body map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&body)
+	return w.Code, body
+}
+
+func TestCartSummaryEstimatesTaxAndShipping(t *testing.T) {
+	code, body := cartSummary(t, "region=ca", 40)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	want := map[string]interface{}{
+		"region":             "CA",
+		"subtotal":           40.0,
+		"estimated_tax":      2.9,
+		"estimated_shipping": 5.99,
+		"estimated_total":    48.89,
+		"is_estimate":        true,
+	}
+	for k, v := range want {
+		if body[k] != v {
+			t.Errorf("%s = %v, want %v", k, body[k], v)
+		}
+	}
+}
+
+func TestCartSummaryZeroTaxRegion(t *testing.T) {
+	code, body := cartSummary(t, "region=OR", 60)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if body["estimated_tax"] != 0.0 || body["estimated_shipping"] != 0.0 || body["estimated_total"] != 60.0 {
+		t.Errorf("summary = %v, want no tax and free shipping over the threshold", body)
+	}
+}
+
+func TestCartSummaryReportsSavings(t *testing.T) {
+	code, body := cartSummary(t, "region=OR&promo=percent:15:SAVE15", 80)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d: %v", code, body)
+	}
+	if body["discounted_subtotal"] != 68.0 || body["savings"] != 12.0 {
+		t.Errorf("discounted = %v, savings = %v; want 68 and 12", body["discounted_subtotal"], body["savings"])
+	}
+	promotions, _ := body["promotions"].([]interface{})
+	if len(promotions) != 1 {
+		t.Fatalf("promotions = %v, want one", body["promotions"])
+	}
+	p := promotions[0].(map[string]interface{})
+	if p["code"] != "SAVE15" || p["amount"] != body["savings"] {
+		t.Errorf("promotion = %v, want SAVE15 saving the same 12", p)
+	}
+}
+
+func TestCartSummarySavingsIncludeCredit(t *testing.T) {
+	code, body := cartSummary(t, "region=OR&promo=percent:10:SAVE10&promo=credit:5", 80)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d: %v", code, body)
+	}
+	if body["savings"] != 13.0 || body["credit_applied"] != 5.0 {
+		t.Errorf("savings = %v, credit = %v; want 13 and 5", body["savings"], body["credit_applied"])
+	}
+	if promotions, _ := body["promotions"].([]interface{}); len(promotions) != 2 {
+		t.Errorf("promotions = %v, want the coupon and the credit", body["promotions"])
+	}
+}
+
+func TestCartSummaryNoPromotions(t *testing.T) {
+	_, body := cartSummary(t, "region=OR", 80)
+	if body["savings"] != 0.0 {
+		t.Errorf("savings = %v, want 0", body["savings"])
+	}
+}
+
+func TestCartSummaryRejectsBadPromo(t *testing.T) {
+	for _, query := range []string{"region=OR&promo=percent", "region=OR&promo=percent:abc", "region=OR&promo=bogus:5", "region=OR&promo=percent:10:A&promo=fixed:5:B"} {
+		if code, _ := cartSummary(t, query, 80); code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, code)
+		}
+	}
+}
+
+func TestCartSummaryRejectsUnknownRegion(t *testing.T) {
+	for _, region := range []string{"", "ZZ"} {
+		r := authorize(t, httptest.NewRequest("GET", "/cart/1/summary?region="+region, nil), 1, "")
+		if w := serveCart(getCartSummary, r, map[string]string{"user_id": "1"}); w.Code != http.StatusBadRequest {
+			t.Errorf("region %q: status = %d, want 400", region, w.Code)
+		}
+	}
+}
+
+// stubProduct serves product 4 from a catalog stub for the rest of the test.
+func stubProduct(t *testing.T, price float64, stock int) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/products/4" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 4, "name": "Lamp", "price": price, "stock": stock})
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("PRODUCT_SERVICE_URL", srv.URL)
+}
+
+func TestAddToCartStatusForNewAndExistingLines(t *testing.T) {
+	stubProduct(t, 15, 10)
+	fake := useDB(t)
+	upsert := `INSERT INTO cart_items .* ON CONFLICT \(user_id, product_id\) DO UPDATE`
+	fake.On(upsert).Rows([]string{"id", "inserted"}, []interface{}{31, true}).Times(1)
+	fake.On(upsert).Rows([]string{"id", "inserted"}, []interface{}{31, false})
+
+	add := func() *httptest.ResponseRecorder {
+		r := authorize(t, httptest.NewRequest("POST", "/cart/1/items", strings.NewReader(`{"product_id": 4, "quantity": 1, "price": 0.01}`)), 1, "")
+		return serveCart(addToCart, r, map[string]string{"user_id": "1"})
+	}
+
+	w := add()
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/cart/1/items/31" {
+		t.Errorf("first add: status = %d, Location = %q; want 201 and /cart/1/items/31", w.Code, w.Header().Get("Location"))
+	}
+	w = add()
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+		t.Errorf("second add: status = %d, Location = %q; want 200 and no Location", w.Code, w.Header().Get("Location"))
+	}
+
+	// The catalog price is stored, not the one the client sent.
+	if calls := fake.Matching(upsert); len(calls) != 2 || calls[0].Args[3] != 15.0 {
+		t.Errorf("upserts = %+v", calls)
+	}
+}
+
+func TestAddToCartOverStockConflicts(t *testing.T) {
+	stubProduct(t, 15, 3)
+	fake := useDB(t)
+	// The conflict update's stock guard matched no row.
+	fake.On(`INSERT INTO cart_items`)
+
+	r := authorize(t, httptest.NewRequest("POST", "/cart/1/items", strings.NewReader(`{"product_id": 4, "quantity": 2}`)), 1, "")
+	if w := serveCart(addToCart, r, map[string]string{"user_id": "1"}); w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", w.Code)
+	}
+}
+
+func TestTopCartItemsRanking(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`FROM cart_items GROUP BY product_id ORDER BY cart_count DESC, quantity DESC, product_id LIMIT \$1`).Rows(
+		[]string{"product_id", "name", "cart_count", "quantity"},
+		[]interface{}{4, "Lamp", 3, 5}, []interface{}{9, "Rug", 3, 3}, []interface{}{2, "Vase", 1, 8})
+
+	w := httptest.NewRecorder()
+	getTopCartItems(w, httptest.NewRequest("GET", "/cart/stats/top-items?limit=3", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Items []TopCartItem `json:"items"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	want := []TopCartItem{{4, "Lamp", 3, 5}, {9, "Rug", 3, 3}, {2, "Vase", 1, 8}}
+	if !reflect.DeepEqual(resp.Items, want) {
+		t.Errorf("items = %+v, want %+v", resp.Items, want)
+	}
+	// A cart counts once per product however many it holds.
+	if q := fake.Matching(`GROUP BY`); len(q) != 1 || !strings.Contains(q[0].Query, "COUNT(DISTINCT user_id)") || q[0].Args[0] != int64(3) {
+		t.Errorf("queries = %+v", q)
+	}
+}
+
+func TestTopCartItemsLimitBounds(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`GROUP BY product_id`).Rows([]string{"product_id", "name", "cart_count", "quantity"})
+
+	for _, limit := range []string{"", "0", "500", "x"} {
+		getTopCartItems(httptest.NewRecorder(), httptest.NewRequest("GET", "/cart/stats/top-items?limit="+limit, nil))
+	}
+	for _, c := range fake.Calls() {
+		if c.Args[0] != int64(10) {
+			t.Errorf("limit = %v, want the default 10", c.Args[0])
+		}
+	}
+}
+
+func TestClearCartReportsRemovedItems(t *testing.T) {
+	for _, removed := range []int64{3, 0} {
+		fake := useDB(t)
+		fake.On(`DELETE FROM cart_items WHERE user_id = \$1`).Affected(removed)
+		fake.On(`DELETE FROM cart_coupons WHERE user_id = \$1`)
+
+		r := authorize(t, httptest.NewRequest("DELETE", "/cart/7", nil), 7, "")
+		w := serveCart(clearCart, r, map[string]string{"user_id": "7"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("%d items: status = %d, want 200", removed, w.Code)
+		}
+		var resp struct {
+			Cleared int64 `json:"cleared"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Cleared != removed {
+			t.Errorf("cleared = %d, want %d", resp.Cleared, removed)
+		}
+	}
+}
+
+func TestClearCartForbidsOtherUsersCart(t *testing.T) {
+	fake := useDB(t)
+	r := authorize(t, httptest.NewRequest("DELETE", "/cart/7", nil), 8, "")
+	if w := serveCart(clearCart, r, map[string]string{"user_id": "7"}); w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("another user's cart was cleared")
+	}
+}
+
+func TestCartHandlersWithoutClaimsDoNotPanic(t *testing.T) {
+	fake := useDB(t)
+	r := mux.SetURLVars(httptest.NewRequest("DELETE", "/cart/7", nil), map[string]string{"user_id": "7"})
+	if _, ok := middleware.UserIDFromContext(r.Context()); ok {
+		t.Error("UserIDFromContext found a user on a request with no claims")
+	}
+
+	w := httptest.NewRecorder()
+	clearCart(w, r)
+	if w.Code != http.StatusUnauthorized || len(fake.Calls()) != 0 {
+		t.Errorf("status = %d with %d queries, want 401 and none", w.Code, len(fake.Calls()))
+	}
+}
+
+func TestCartResponsesEchoResolvedUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID uint
+		role   string
+	}{
+		{"owner", 7, ""},
+		{"admin acting for the owner", 1, "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useDB(t)
+			fake.On(`^UPDATE cart_items SET quantity`)
+
+			r := authorize(t, httptest.NewRequest("PUT", "/cart/7/items/5", strings.NewReader(`{"quantity": 2, "version": 1}`)), tt.userID, tt.role)
+			w := serveCart(updateCartItem, r, map[string]string{"user_id": "7", "item_id": "5"})
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			var resp struct {
+				UserID uint `json:"user_id"`
+			}
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.UserID != 7 {
+				t.Errorf("user_id = %d, want the cart's owner 7", resp.UserID)
+			}
+		})
+	}
+}
+
+func TestCartHandlersRejectMismatchedPathUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+		vars    map[string]string
+	}{
+		{"get", getCart, "GET", "", map[string]string{"user_id": "7"}},
+		{"summary", getCartSummary, "GET", "", map[string]string{"user_id": "7"}},
+		{"add", addToCart, "POST", `{"product_id": 4, "quantity": 1}`, map[string]string{"user_id": "7"}},
+		{"remove", removeFromCart, "DELETE", "", map[string]string{"user_id": "7", "item_id": "5"}},
+		{"apply coupon", applyCoupon, "POST", `{"code": "SAVE10"}`, map[string]string{"user_id": "7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useDB(t)
+			r := authorize(t, httptest.NewRequest(tt.method, "/cart/7", strings.NewReader(tt.body)), 8, "")
+			if w := serveCart(tt.handler, r, tt.vars); w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", w.Code)
+			}
+			if len(fake.Calls()) != 0 {
+				t.Error("another user's cart reached the database")
+			}
+		})
+	}
+}
+
+func TestAddToCartOutOfStockRejectedUpFront(t *testing.T) {
+	stubProduct(t, 15, 0)
+	fake := useDB(t)
+
+	r := authorize(t, httptest.NewRequest("POST", "/cart/1/items", strings.NewReader(`{"product_id": 4, "quantity": 9999}`)), 1, "")
+	w := serveCart(addToCart, r, map[string]string{"user_id": "1"})
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"available":0`) {
+		t.Errorf("status = %d, body = %s; want 409 with nothing available", w.Code, w.Body)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("an out-of-stock add reached the database")
+	}
+}
+
+func TestAddToCartUsesSalePrice(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 4, "name": "Lamp", "price": 40, "effective_price": 30, "stock": 5})
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("PRODUCT_SERVICE_URL", srv.URL)
+	fake := useDB(t)
+	fake.On(`INSERT INTO cart_items`).Rows([]string{"id", "inserted"}, []interface{}{31, true})
+
+	r := authorize(t, httptest.NewRequest("POST", "/cart/1/items", strings.NewReader(`{"product_id": 4, "quantity": 1, "price": 40}`)), 1, "")
+	if w := serveCart(addToCart, r, map[string]string{"user_id": "1"}); w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if calls := fake.Matching(`INSERT INTO cart_items`); calls[0].Args[3] != 30.0 {
+		t.Errorf("stored price = %v, want the sale price 30", calls[0].Args[3])
+	}
+}
+
+func TestAddToCartCatalogFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   int
+	}{
+		{"unknown product", http.StatusNotFound, http.StatusNotFound},
+		{"catalog down", http.StatusInternalServerError, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(tt.status) }))
+			t.Cleanup(srv.Close)
+			t.Setenv("PRODUCT_SERVICE_URL", srv.URL)
+			fake := useDB(t)
+
+			r := authorize(t, httptest.NewRequest("POST", "/cart/1/items", strings.NewReader(`{"product_id": 4, "quantity": 1}`)), 1, "")
+			if w := serveCart(addToCart, r, map[string]string{"user_id": "1"}); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if len(fake.Calls()) != 0 {
+				t.Error("the add reached the database")
+			}
+		})
+	}
+}
+
+func TestProductServiceURL(t *testing.T) {
+	t.Setenv("PRODUCT_SERVICE_URL", "")
+	if got := productServiceURL(); got != "http://product-service:8002" {
+		t.Errorf("default = %q", got)
+	}
+	t.Setenv("PRODUCT_SERVICE_URL", "http://catalog.internal:9000")
+	if got := productServiceURL(); got != "http://catalog.internal:9000" {
+		t.Errorf("configured = %q", got)
+	}
+}
+
+func TestDeleteExpiredCartItems(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^DELETE FROM cart_items WHERE created_at < \$1$`).Affected(2)
+
+	cutoff := time.Now().Add(-cartTTL)
+	n, err := deleteExpiredCartItems(context.Background(), cutoff)
+	if err != nil || n != 2 {
+		t.Fatalf("removed %d, %v; want 2", n, err)
+	}
+	deletes := fake.Matching(`^DELETE`)
+	if len(deletes) != 1 || deletes[0].Args[0] != cutoff {
+		t.Errorf("deletes = %+v, want one DELETE before the cutoff", deletes)
+	}
+}
+
+// syncBuffer is a bytes.Buffer the cleanup goroutine can log to while the
+// test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestExpiredCartCleanupRunsEachInterval(t *testing.T) {
+	var logs syncBuffer
+	prev := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	fake := useDB(t)
+	fake.On(`^DELETE FROM cart_items`).Affected(1).Times(1)
+	fake.On(`^DELETE FROM cart_items`).Affected(0)
+
+	stop := startExpiredCartCleanup(720*time.Hour, 5*time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for len(fake.Matching(`^DELETE`)) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stop()
+
+	deletes := fake.Matching(`^DELETE`)
+	if len(deletes) < 2 {
+		t.Fatalf("%d cleanups ran, want one per interval", len(deletes))
+	}
+	if d := time.Since(deletes[0].Args[0].(time.Time)); d < 720*time.Hour || d > 721*time.Hour {
+		t.Errorf("cutoff is %v ago, want the TTL", d)
+	}
+	if !strings.Contains(logs.String(), "removed 1 items") {
+		t.Errorf("log = %q, want the removed count", logs.String())
+	}
+
+	// Once stopped, no more cleanups run.
+	ran := len(fake.Matching(`^DELETE`))
+	time.Sleep(30 * time.Millisecond)
+	if len(fake.Matching(`^DELETE`)) > ran+1 {
+		t.Error("cleanup kept running after stop")
+	}
+}
+
+func TestExpiredCartCleanupDisabled(t *testing.T) {
+	fake := useDB(t)
+	for _, tt := range []struct{ ttl, interval time.Duration }{{0, time.Millisecond}, {time.Hour, 0}} {
+		stop := startExpiredCartCleanup(tt.ttl, tt.interval)
+		time.Sleep(20 * time.Millisecond)
+		stop()
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("a disabled cleanup deleted cart items")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/pricing"
+)
+
+var productClient = &http.Client{Timeout: 5 * time.Second}
+
+func productServiceURL() string {
+	if url := os.Getenv("PRODUCT_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://product-service:8002"
+}
+
+type productInfo struct {
+	ID             uint     `json:"id"`
+	Name           string   `json:"name"`
+	Price          float64  `json:"price"`
+	EffectivePrice *float64 `json:"effective_price"`
+	Stock          int      `json:"stock"`
+	ImageURL       string   `json:"image_url"`
+}
+
+var errProductNotFound = fmt.Errorf("product not found")
+
+// fetchProduct returns the catalog's current name, price, image and stock
+// for a product, with the sale price in Price while a sale is running.
+func fetchProduct(productID uint) (*productInfo, error) {
+	resp, err := productClient.Get(fmt.Sprintf("%s/products/%d", productServiceURL(), productID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errProductNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("product service returned %d", resp.StatusCode)
+	}
+
+	var p productInfo
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.EffectivePrice != nil {
+		p.Price = *p.EffectivePrice
+	}
+	return &p, nil
+}
+
+type productDimensions struct {
+	ID          uint    `json:"id"`
+	LengthCM    float64 `json:"length_cm"`
+	WidthCM     float64 `json:"width_cm"`
+	HeightCM    float64 `json:"height_cm"`
+	WeightGrams int     `json:"weight_grams"`
+}
+
+// cartParcels looks up the dimensions of everything in the user's cart so
+// shipping can be quoted on billable weight. Products the catalog no longer
+// has are skipped.
+func cartParcels(userID uint) ([]pricing.Parcel, error) {
+	rows, err := db.Query("SELECT product_id, quantity FROM cart_items WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quantities := map[uint]int{}
+	ids := []string{}
+	for rows.Next() {
+		var productID uint
+		var quantity int
+		if err := rows.Scan(&productID, &quantity); err != nil {
+			return nil, err
+		}
+		quantities[productID] = quantity
+		ids = append(ids, fmt.Sprint(productID))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	resp, err := productClient.Get(productServiceURL() + "/products?ids=" + strings.Join(ids, ","))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("product service returned %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Products []productDimensions `json:"products"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	parcels := make([]pricing.Parcel, 0, len(body.Products))
+	for _, p := range body.Products {
+		parcels = append(parcels, pricing.Parcel{
+			LengthCM:    p.LengthCM,
+			WidthCM:     p.WidthCM,
+			HeightCM:    p.HeightCM,
+			WeightGrams: p.WeightGrams,
+			Quantity:    quantities[p.ID],
+		})
+	}
+	return parcels, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/promo"
+)
+
+// promoRules matches the order service, so the summary refuses the same
+// combinations checkout would.
+var promoRules = promo.RulesFromEnv()
+
+// parsePromoParams reads ?promo= values of the form kind:value or
+// kind:value:code, e.g. "percent:10:SAVE10" or "credit:5". Kinds and values
+// are checked by promo.Apply.
+func parsePromoParams(values []string) ([]promo.Promo, error) {
+	promos := make([]promo.Promo, 0, len(values))
+	for _, v := range values {
+		parts := strings.SplitN(v, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("promo %q must be kind:value", v)
+		}
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("promo %q has an invalid value", v)
+		}
+		p := promo.Promo{Kind: parts[0], Value: value}
+		if len(parts) == 3 {
+			p.Code = parts[2]
+		}
+		promos = append(promos, p)
+	}
+	return promos, nil
+}
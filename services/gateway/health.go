@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const healthProbeTimeout = 2 * time.Second
+
+type ServiceHealth struct {
+	Service   string `json:"service"`
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// probeHealth calls a service's /health endpoint and times the round trip.
+func probeHealth(ctx context.Context, client *http.Client, name, serviceURL string) ServiceHealth {
+	result := ServiceHealth{Service: name, Status: "unhealthy"}
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", serviceURL+"/health", nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	resp, err := client.Do(req)
+	result.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		result.Error = fmt.Sprintf("health check returned %d", resp.StatusCode)
+		return result
+	}
+	result.Status = "healthy"
+	return result
+}
+
+// healthDetail probes every backend concurrently and reports each one, plus
+// the gateway itself, as a JSON array sorted by service name.
+func healthDetail(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthProbeTimeout)
+	defer cancel()
+
+	client := &http.Client{}
+	results := []ServiceHealth{{Service: "gateway", Status: "healthy"}}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, serviceURL := range services {
+		wg.Add(1)
+		go func(name, serviceURL string) {
+			defer wg.Done()
+			h := probeHealth(ctx, client, name, serviceURL)
+			mu.Lock()
+			results = append(results, h)
+			mu.Unlock()
+		}(name, serviceURL)
+	}
+	wg.Wait()
+
+	sort.Slice(results[1:], func(i, j int) bool {
+		return results[i+1].Service < results[j+1].Service
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthDetailReportsEachService(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(up.Close)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(failing.Close)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	prev := services
+	services = map[string]string{"user": up.URL, "order": failing.URL, "cart": down.URL}
+	t.Cleanup(func() { services = prev })
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/health/detail", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var got []ServiceHealth
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("body is not a JSON array: %v", err)
+	}
+
+	// The gateway comes first, then the services by name.
+	want := []struct{ service, status string }{
+		{"gateway", "healthy"}, {"cart", "unhealthy"}, {"order", "unhealthy"}, {"user", "healthy"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for i, h := range got {
+		if h.Service != want[i].service || h.Status != want[i].status {
+			t.Errorf("entry %d = %+v, want %s %s", i, h, want[i].service, want[i].status)
+		}
+		if (h.Status == "healthy") != (h.Error == "") {
+			t.Errorf("%s: status %s with error %q", h.Service, h.Status, h.Error)
+		}
+	}
+	if got[2].Error != "health check returned 503" {
+		t.Errorf("order error = %q", got[2].Error)
+	}
+}
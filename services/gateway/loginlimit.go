@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// windowLimiter allows up to limit hits per key in each fixed window.
+type windowLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*limitWindow
+}
+
+type limitWindow struct {
+	count int
+	start time.Time
+}
+
+func newWindowLimiter(limit int, window time.Duration) *windowLimiter {
+	return &windowLimiter{limit: limit, window: window, windows: make(map[string]*limitWindow)}
+}
+
+// allow records a hit for key and reports whether it is within the limit.
+// When it isn't, the returned duration is how long until the window resets.
+func (l *windowLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		// Drop expired windows occasionally so the map doesn't grow forever.
+		if len(l.windows) > 10000 {
+			for k, old := range l.windows {
+				if now.Sub(old.start) >= l.window {
+					delete(l.windows, k)
+				}
+			}
+		}
+		w = &limitWindow{start: now}
+		l.windows[key] = w
+	}
+
+	w.count++
+	if w.count > l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	return true, 0
+}
+
+func getEnvInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(getEnv(key, "")); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// Login attempts are limited per email and per client IP, on top of the
+// global limit, to slow down credential stuffing.
+var (
+	loginEmailLimiter = newWindowLimiter(getEnvInt("LOGIN_RATE_LIMIT_PER_EMAIL", 5), time.Minute)
+	loginIPLimiter    = newWindowLimiter(getEnvInt("LOGIN_RATE_LIMIT_PER_IP", 20), time.Minute)
+)
+
+func loginRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+
+		ip := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			ip = host
+		}
+		if ok, retry := loginIPLimiter.allow(ip, now); !ok {
+			tooManyLoginAttempts(w, retry)
+			return
+		}
+
+		// Peek at the email without consuming the body the proxy forwards.
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var credentials struct {
+			Email string `json:"email"`
+		}
+		if json.Unmarshal(body, &credentials) == nil && credentials.Email != "" {
+			email := strings.ToLower(strings.TrimSpace(credentials.Email))
+			if ok, retry := loginEmailLimiter.allow(email, now); !ok {
+				tooManyLoginAttempts(w, retry)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func tooManyLoginAttempts(w http.ResponseWriter, retry time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	http.Error(w, "Too many login attempts", http.StatusTooManyRequests)
+}
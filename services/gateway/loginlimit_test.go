@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useLoginLimits replaces the login limiters for the rest of the test.
+func useLoginLimits(t *testing.T, perEmail, perIP int) {
+	t.Helper()
+	prevEmail, prevIP := loginEmailLimiter, loginIPLimiter
+	loginEmailLimiter = newWindowLimiter(perEmail, time.Minute)
+	loginIPLimiter = newWindowLimiter(perIP, time.Minute)
+	t.Cleanup(func() { loginEmailLimiter, loginIPLimiter = prevEmail, prevIP })
+}
+
+// stubUserService points the gateway's user routes at a server that records
+// the bodies it receives.
+func stubUserService(t *testing.T) *[]string {
+	t.Helper()
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	prev := services["user"]
+	services["user"] = srv.URL
+	t.Cleanup(func() { services["user"] = prev })
+	return &bodies
+}
+
+func post(path, body, ip string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.RemoteAddr = ip + ":40000"
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+func TestLoginLimitedPerEmail(t *testing.T) {
+	useLoginLimits(t, 2, 100)
+	bodies := stubUserService(t)
+
+	login := `{"email": "Ann@Example.com", "password": "x"}`
+	for i := 0; i < 2; i++ {
+		if w := post("/api/login", login, "10.0.0.1"); w.Code != http.StatusOK {
+			t.Fatalf("attempt %d: status = %d", i+1, w.Code)
+		}
+	}
+	// The same address from another IP, differently cased, is still limited.
+	w := post("/api/login", `{"email": "ann@example.com ", "password": "x"}`, "10.0.0.2")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("third attempt: status = %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+
+	if w := post("/api/login", `{"email": "bob@example.com", "password": "x"}`, "10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("another email: status = %d, want 200", w.Code)
+	}
+	if w := post("/api/register", login, "10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("register: status = %d, want 200", w.Code)
+	}
+	if len(*bodies) != 4 || (*bodies)[0] != login {
+		t.Errorf("upstream bodies = %q, want the login body forwarded intact", *bodies)
+	}
+}
+
+func TestLoginLimitedPerIP(t *testing.T) {
+	useLoginLimits(t, 100, 2)
+	stubUserService(t)
+
+	post("/api/login", `{"email": "a@example.com"}`, "10.0.0.9")
+	post("/api/login", `{"email": "b@example.com"}`, "10.0.0.9")
+	if w := post("/api/login", `{"email": "c@example.com"}`, "10.0.0.9"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", w.Code)
+	}
+	if w := post("/api/login", `{"email": "c@example.com"}`, "10.0.0.10"); w.Code != http.StatusOK {
+		t.Errorf("another IP: status = %d, want 200", w.Code)
+	}
+}
+
+func TestWindowLimiterResets(t *testing.T) {
+	l := newWindowLimiter(1, time.Minute)
+	now := time.Now()
+	if ok, _ := l.allow("k", now); !ok {
+		t.Fatal("first hit rejected")
+	}
+	ok, retry := l.allow("k", now.Add(20*time.Second))
+	if ok || retry != 40*time.Second {
+		t.Errorf("second hit: ok = %v, retry = %s, want rejected for 40s", ok, retry)
+	}
+	if ok, _ := l.allow("k", now.Add(time.Minute)); !ok {
+		t.Error("hit in the next window rejected")
+	}
+}
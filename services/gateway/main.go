@@ -12,6 +12,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/server"
 )
 
 type ServiceConfig struct {
@@ -29,19 +30,33 @@ var services = map[string]string{
 }
 
 func main() {
+	log.Println("API Gateway running on :8080")
+	if err := server.Run(":8080", middleware.TrimTrailingSlash(newRouter())); err != nil {
+		log.Fatal("Server error:", err)
+	}
+}
+
+// newRouter registers the gateway's routes.
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.Use(middleware.CORS)
 	r.Use(loggingMiddleware)
 	r.Use(rateLimitMiddleware)
+	r.Use(sizeMetricsMiddleware)
 
 	// Health check
 	r.HandleFunc("/health", healthCheck).Methods("GET")
 	r.HandleFunc("/api/health", aggregateHealthCheck).Methods("GET")
+	r.HandleFunc("/api/health/detail", healthDetail).Methods("GET")
+	r.Handle("/metrics", gatewayMetrics).Methods("GET")
 
 	// User service routes
 	r.PathPrefix("/api/users").HandlerFunc(proxyHandler("user"))
 	r.HandleFunc("/api/register", proxyHandler("user")).Methods("POST")
-	r.HandleFunc("/api/login", proxyHandler("user")).Methods("POST")
+	r.Handle("/api/login", loginRateLimit(proxyHandler("user"))).Methods("POST")
+	r.HandleFunc("/api/refresh", proxyHandler("user")).Methods("POST")
+	r.HandleFunc("/api/logout", proxyHandler("user")).Methods("POST")
+	r.PathPrefix("/api/password-reset").HandlerFunc(proxyHandler("user"))
 
 	// Product service routes
 	r.PathPrefix("/api/products").HandlerFunc(proxyHandler("product"))
@@ -59,12 +74,18 @@ func main() {
 	// Notification service routes
 	r.PathPrefix("/api/notifications").HandlerFunc(proxyHandler("notification"))
 
-	// Serve static files for frontend
+	// Unknown API paths get a JSON 404 rather than the frontend
+	r.PathPrefix("/api/").HandlerFunc(apiNotFound)
+
+	// Serve static files for frontend, falling back to the index page for
+	// client-side routes
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./frontend/static"))))
-	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./frontend/templates")))
+	r.PathPrefix("/").Handler(spaHandler{
+		dir:   getEnv("FRONTEND_DIR", "./frontend/templates"),
+		index: getEnv("FRONTEND_INDEX", "index.html"),
+	})
 
-	log.Println("API Gateway running on :8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	return r
 }
 
 func getEnv(key, fallback string) string {
@@ -199,6 +220,12 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer so
+// streamed proxy responses can still be flushed.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Simple rate limiter
 var requestCounts = make(map[string]int)
 var lastReset = time.Now()
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Upper bounds (in bytes) of the body size histogram buckets.
+var sizeBuckets = []int64{256, 1024, 4096, 16384, 65536, 262144, 1048576}
+
+type sizeHistogram struct {
+	Count   int64            `json:"count"`
+	Sum     int64            `json:"sum"`
+	Buckets map[string]int64 `json:"buckets"`
+}
+
+func newSizeHistogram() *sizeHistogram {
+	h := &sizeHistogram{Buckets: make(map[string]int64, len(sizeBuckets)+1)}
+	for _, b := range sizeBuckets {
+		h.Buckets[strconv.FormatInt(b, 10)] = 0
+	}
+	h.Buckets["+Inf"] = 0
+	return h
+}
+
+// observe records a single value. Buckets are cumulative, matching the
+// Prometheus histogram convention.
+func (h *sizeHistogram) observe(n int64) {
+	h.Count++
+	h.Sum += n
+	for _, b := range sizeBuckets {
+		if n <= b {
+			h.Buckets[strconv.FormatInt(b, 10)]++
+		}
+	}
+	h.Buckets["+Inf"]++
+}
+
+type routeSizeMetrics struct {
+	Requests int64          `json:"requests"`
+	BytesIn  *sizeHistogram `json:"bytes_in"`
+	BytesOut *sizeHistogram `json:"bytes_out"`
+}
+
+type sizeMetrics struct {
+	mu     sync.Mutex
+	routes map[string]*routeSizeMetrics
+}
+
+var gatewayMetrics = &sizeMetrics{routes: make(map[string]*routeSizeMetrics)}
+
+func (m *sizeMetrics) record(route string, in, out int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rm, ok := m.routes[route]
+	if !ok {
+		rm = &routeSizeMetrics{BytesIn: newSizeHistogram(), BytesOut: newSizeHistogram()}
+		m.routes[route] = rm
+	}
+	rm.Requests++
+	rm.BytesIn.observe(in)
+	rm.BytesOut.observe(out)
+}
+
+func (m *sizeMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	body, err := json.Marshal(map[string]interface{}{"routes": m.routes})
+	m.mu.Unlock()
+	if err != nil {
+		http.Error(w, "Failed to encode metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// countingReader counts the bytes read from a request body as the proxy
+// streams it upstream.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written to the client without buffering,
+// so streamed responses are measured as they pass through.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingWriter) Flush() {
+	http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// sizeMetricsMiddleware records request and response body sizes per route
+// template (e.g. "/api/products").
+func sizeMetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		in := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = in
+		}
+		out := &countingWriter{ResponseWriter: w}
+
+		next.ServeHTTP(out, r)
+
+		gatewayMetrics.record(route, in.n, out.n)
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSizeHistogramIsCumulative(t *testing.T) {
+	h := newSizeHistogram()
+	h.observe(100)
+	h.observe(2000)
+	h.observe(5 << 20)
+
+	if h.Count != 3 || h.Sum != 100+2000+5<<20 {
+		t.Errorf("count = %d, sum = %d", h.Count, h.Sum)
+	}
+	want := map[string]int64{"256": 1, "1024": 1, "4096": 2, "1048576": 2, "+Inf": 3}
+	for bucket, n := range want {
+		if h.Buckets[bucket] != n {
+			t.Errorf("bucket %s = %d, want %d", bucket, h.Buckets[bucket], n)
+		}
+	}
+}
+
+func TestSizeMetricsMiddlewareRecordsRouteTemplate(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(sizeMetricsMiddleware)
+	r.HandleFunc("/api/products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("0123456789"))
+	})
+
+	body := strings.Repeat("x", 300)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/api/products/7", strings.NewReader(body)))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/api/products/8", strings.NewReader(body)))
+
+	gatewayMetrics.mu.Lock()
+	defer gatewayMetrics.mu.Unlock()
+	rm, ok := gatewayMetrics.routes["/api/products/{id}"]
+	if !ok {
+		t.Fatalf("no metrics for the route template; have %v", gatewayMetrics.routes)
+	}
+	if rm.Requests != 2 || rm.BytesIn.Sum != 600 || rm.BytesOut.Sum != 20 {
+		t.Errorf("requests = %d, bytes in = %d, bytes out = %d", rm.Requests, rm.BytesIn.Sum, rm.BytesOut.Sum)
+	}
+	if rm.BytesIn.Buckets["256"] != 0 || rm.BytesIn.Buckets["1024"] != 2 {
+		t.Errorf("bytes in buckets = %v", rm.BytesIn.Buckets)
+	}
+}
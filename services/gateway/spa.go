@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// spaHandler serves files from dir, falling back to the index page for any
+// GET that doesn't match a file so client-side routes like /products/5 work.
+type spaHandler struct {
+	dir   string
+	index string
+}
+
+func (h spaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := filepath.Join(h.dir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+	if info, err := os.Stat(name); err == nil && !info.IsDir() {
+		http.ServeFile(w, r, name)
+		return
+	}
+
+	http.ServeFile(w, r, filepath.Join(h.dir, h.index))
+}
+
+// apiNotFound answers unknown /api/* paths with JSON instead of the SPA page.
+func apiNotFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{"error": "not found"})
+}
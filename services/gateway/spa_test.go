@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFrontend(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"index.html": "index page",
+		"shop.html":  "shop page",
+		"robots.txt": "robots",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSPAHandler(t *testing.T) {
+	h := spaHandler{dir: writeFrontend(t), index: "index.html"}
+
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{http.MethodGet, "/robots.txt", http.StatusOK, "robots"},
+		{http.MethodGet, "/products/5", http.StatusOK, "index page"},
+		{http.MethodGet, "/", http.StatusOK, "index page"},
+		{http.MethodHead, "/orders", http.StatusOK, ""},
+		{http.MethodGet, "/../../etc/passwd", http.StatusBadRequest, ""},
+		{http.MethodPost, "/products/5", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.code {
+				t.Errorf("status = %d, want %d", w.Code, tt.code)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestSPAHandlerUsesConfiguredIndex(t *testing.T) {
+	t.Setenv("FRONTEND_DIR", writeFrontend(t))
+	t.Setenv("FRONTEND_INDEX", "shop.html")
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/checkout", nil))
+	if w.Body.String() != "shop page" {
+		t.Errorf("body = %q, want the configured index", w.Body.String())
+	}
+}
+
+func TestUnknownAPIPathIsJSON404(t *testing.T) {
+	t.Setenv("FRONTEND_DIR", writeFrontend(t))
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" || !strings.Contains(w.Body.String(), `"not found"`) {
+		t.Errorf("Content-Type %q, body %q", ct, w.Body.String())
+	}
+}
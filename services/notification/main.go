@@ -5,24 +5,27 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/database"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/response"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/server"
 )
 
 type Notification struct {
-	ID        uint      `json:"id"`
-	UserID    uint      `json:"user_id"`
-	Type      string    `json:"type"`
-	Channel   string    `json:"channel"`
-	Subject   string    `json:"subject"`
-	Message   string    `json:"message"`
-	Status    string    `json:"status"`
-	Metadata  string    `json:"metadata,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
-	SentAt    *time.Time `json:"sent_at,omitempty"`
+	ID        uint       `json:"id"`
+	UserID    uint       `json:"user_id"`
+	Type      string     `json:"type"`
+	Channel   string     `json:"channel"`
+	Subject   string     `json:"subject"`
+	Message   string     `json:"message"`
+	Status    string     `json:"status"`
+	Metadata  string     `json:"metadata,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at"`
 }
 
 type NotificationRequest struct {
@@ -35,25 +38,41 @@ type NotificationRequest struct {
 }
 
 var db *sql.DB
+var dbOnce database.Once
+
+// bulkRateLimit caps bulk sends per client, since one request can fan out to
+// many notifications.
+var bulkRateLimit = middleware.RateLimit(middleware.RateLimitFromEnv(
+	"NOTIFICATION_BULK_RATE_LIMIT", middleware.RateLimitConfig{PerMinute: 30, Burst: 10},
+))
 
 func main() {
 	var err error
-	db, err = database.NewConnection("notifications_db")
+	err = dbOnce.Open(&db, func() (*sql.DB, error) { return database.NewConnection("notifications_db") })
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
 
+	stopKeepalive := database.StartKeepalive(db, database.KeepaliveInterval())
+	defer stopKeepalive()
+
 	initDB()
 
+	stopPurge := startNotificationPurge(time.Duration(retentionDays)*24*time.Hour, purgeInterval)
+	defer stopPurge()
+
 	r := mux.NewRouter()
 	r.Use(middleware.CORS)
+	r.Use(middleware.LogRequestBodies)
 
 	r.HandleFunc("/health", healthCheck).Methods("GET")
 	r.HandleFunc("/notifications", sendNotification).Methods("POST")
-	r.HandleFunc("/notifications/user/{user_id}", getNotificationsByUser).Methods("GET")
+	r.Handle("/notifications/user/{user_id}", middleware.AuthMiddleware(http.HandlerFunc(getNotificationsByUser))).Methods("GET")
+	r.Handle("/notifications/user/{user_id}", middleware.AuthMiddleware(http.HandlerFunc(deleteNotificationsByUser))).Methods("DELETE")
 	r.HandleFunc("/notifications/{id}", getNotification).Methods("GET")
-	r.HandleFunc("/notifications/bulk", sendBulkNotifications).Methods("POST")
+	r.Handle("/notifications/{id}", middleware.AuthMiddleware(http.HandlerFunc(deleteNotification))).Methods("DELETE")
+	r.Handle("/notifications/bulk", bulkRateLimit(http.HandlerFunc(sendBulkNotifications))).Methods("POST")
 
 	// Template endpoints
 	r.HandleFunc("/notifications/order-confirmation", sendOrderConfirmation).Methods("POST")
@@ -61,11 +80,13 @@ func main() {
 	r.HandleFunc("/notifications/payment-receipt", sendPaymentReceipt).Methods("POST")
 
 	log.Println("Notification service running on :8006")
-	log.Fatal(http.ListenAndServe(":8006", r))
+	if err := server.Run(":8006", middleware.TrimTrailingSlash(r)); err != nil {
+		log.Fatal("Server error:", err)
+	}
 }
 
 func initDB() {
-	query := `
+	queries := []string{`
 	CREATE TABLE IF NOT EXISTS notifications (
 		id SERIAL PRIMARY KEY,
 		user_id INT NOT NULL,
@@ -77,10 +98,14 @@ func initDB() {
 		metadata JSONB,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		sent_at TIMESTAMP
-	)`
-	_, err := db.Exec(query)
-	if err != nil {
-		log.Fatal("Failed to create notifications table:", err)
+	)`,
+		`CREATE INDEX IF NOT EXISTS idx_notifications_created ON notifications (created_at)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			log.Fatal("Failed to create notifications table:", err)
+		}
 	}
 }
 
@@ -129,10 +154,20 @@ func sendNotification(w http.ResponseWriter, r *http.Request) {
 
 func getNotificationsByUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	userID := vars["user_id"]
+	userID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(uint(userID)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	rows, err := db.Query(
-		`SELECT id, user_id, type, channel, subject, message, status, metadata, created_at, sent_at
+		`SELECT id, user_id, type, channel, COALESCE(subject, ''), message, COALESCE(status, 'pending'), metadata, created_at, sent_at
 		 FROM notifications WHERE user_id = $1 ORDER BY created_at DESC LIMIT 100`,
 		userID,
 	)
@@ -169,7 +204,7 @@ func getNotification(w http.ResponseWriter, r *http.Request) {
 	var metadata sql.NullString
 	var sentAt sql.NullTime
 	err := db.QueryRow(
-		`SELECT id, user_id, type, channel, subject, message, status, metadata, created_at, sent_at
+		`SELECT id, user_id, type, channel, COALESCE(subject, ''), message, COALESCE(status, 'pending'), metadata, created_at, sent_at
 		 FROM notifications WHERE id = $1`,
 		notificationID,
 	).Scan(&n.ID, &n.UserID, &n.Type, &n.Channel, &n.Subject, &n.Message, &n.Status, &metadata, &n.CreatedAt, &sentAt)
@@ -190,6 +225,65 @@ func getNotification(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(n)
 }
 
+func deleteNotification(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+
+	// Only the notification's recipient, or an admin, may delete it.
+	var userID uint
+	err = db.QueryRow("SELECT user_id FROM notifications WHERE id = $1", id).Scan(&userID)
+	if err == sql.ErrNoRows {
+		response.Deleted(w, r, id, false)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete notification", http.StatusInternalServerError)
+		return
+	}
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM notifications WHERE id = $1", id)
+	if err != nil {
+		http.Error(w, "Failed to delete notification", http.StatusInternalServerError)
+		return
+	}
+
+	n, _ := result.RowsAffected()
+	response.Deleted(w, r, id, n > 0)
+}
+
+// deleteNotificationsByUser removes a user's notification history. It backs
+// account deletion in the user service.
+func deleteNotificationsByUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(uint(userID)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM notifications WHERE user_id = $1", userID); err != nil {
+		http.Error(w, "Failed to delete notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func sendBulkNotifications(w http.ResponseWriter, r *http.Request) {
 	var requests []NotificationRequest
 	if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
@@ -198,6 +292,7 @@ func sendBulkNotifications(w http.ResponseWriter, r *http.Request) {
 	}
 
 	results := make([]map[string]interface{}, len(requests))
+	failed := 0
 	for i, req := range requests {
 		sentAt := time.Now()
 		var id uint
@@ -209,21 +304,32 @@ func sendBulkNotifications(w http.ResponseWriter, r *http.Request) {
 
 		if err != nil {
 			results[i] = map[string]interface{}{"success": false, "error": err.Error()}
+			failed++
 		} else {
 			results[i] = map[string]interface{}{"success": true, "id": id}
 		}
 	}
 
+	// 200 when everything was sent, 500 when nothing was, 207 otherwise so
+	// clients can tell a partial failure from a total one.
+	status := http.StatusOK
+	if failed > 0 && failed == len(requests) {
+		status = http.StatusInternalServerError
+	} else if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
 }
 
 func sendOrderConfirmation(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		UserID   uint    `json:"user_id"`
-		OrderID  uint    `json:"order_id"`
-		Email    string  `json:"email"`
-		Total    float64 `json:"total"`
+		UserID  uint    `json:"user_id"`
+		OrderID uint    `json:"order_id"`
+		Email   string  `json:"email"`
+		Total   float64 `json:"total"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -260,9 +366,9 @@ func sendOrderConfirmation(w http.ResponseWriter, r *http.Request) {
 
 func sendShippingUpdate(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		UserID        uint   `json:"user_id"`
-		OrderID       uint   `json:"order_id"`
-		Status        string `json:"status"`
+		UserID         uint   `json:"user_id"`
+		OrderID        uint   `json:"order_id"`
+		Status         string `json:"status"`
 		TrackingNumber string `json:"tracking_number"`
 	}
 
@@ -335,11 +441,11 @@ func sendPaymentReceipt(w http.ResponseWriter, r *http.Request) {
 }
 
 func formatOrderConfirmation(orderID uint, total float64) string {
-	return "Thank you for your order #" + string(rune(orderID)) + "! Your order total is $" + formatFloat(total) + ". We'll notify you when it ships."
+	return "Thank you for your order #" + strconv.FormatUint(uint64(orderID), 10) + "! Your order total is $" + formatFloat(total) + ". We'll notify you when it ships."
 }
 
 func formatShippingUpdate(orderID uint, status, trackingNumber string) string {
-	msg := "Your order #" + string(rune(orderID)) + " has been " + status + "."
+	msg := "Your order #" + strconv.FormatUint(uint64(orderID), 10) + " has been " + status + "."
 	if trackingNumber != "" {
 		msg += " Tracking number: " + trackingNumber
 	}
@@ -347,9 +453,9 @@ func formatShippingUpdate(orderID uint, status, trackingNumber string) string {
 }
 
 func formatPaymentReceipt(orderID uint, amount float64, transactionID string) string {
-	return "Payment of $" + formatFloat(amount) + " received for order #" + string(rune(orderID)) + ". Transaction ID: " + transactionID
+	return "Payment of $" + formatFloat(amount) + " received for order #" + strconv.FormatUint(uint64(orderID), 10) + ". Transaction ID: " + transactionID
 }
 
 func formatFloat(f float64) string {
-	return string(rune(int(f))) + "." + string(rune(int((f-float64(int(f)))*100)))
+	return strconv.FormatFloat(f, 'f', 2, 64)
 }
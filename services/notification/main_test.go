@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+// useDB points the service at a scripted database for the rest of the test.
+func useDB(t *testing.T) *dbtest.DB {
+	t.Helper()
+	fake := dbtest.New(t)
+	prev := db
+	db = fake.DB
+	t.Cleanup(func() { db = prev })
+	return fake
+}
+
+// authorize signs a token for userID (with role, if any) and sets it on r,
+// the way the gateway forwards a logged-in user's requests.
+func authorize(t *testing.T, r *http.Request, userID uint, role string) *http.Request {
+	t.Helper()
+	claims := &middleware.Claims{
+		UserID: userID,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    middleware.GetJWTIssuer(),
+			Audience:  jwt.ClaimStrings{middleware.GetJWTAudience()},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(middleware.GetJWTSecret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+const threeNotifications = `[
+	{"user_id": 1, "type": "promo", "channel": "email", "message": "a"},
+	{"user_id": 2, "type": "promo", "channel": "email", "message": "b"},
+	{"user_id": 3, "type": "promo", "channel": "email", "message": "c"}
+]`
+
+func TestBulkNotificationStatus(t *testing.T) {
+	insert := `INSERT INTO notifications`
+	sent := []string{"id"}
+	tests := []struct {
+		name    string
+		script  func(*dbtest.DB)
+		want    int
+		success []bool
+	}{
+		{"all sent", func(d *dbtest.DB) {
+			d.On(insert).Rows(sent, []interface{}{10})
+		}, http.StatusOK, []bool{true, true, true}},
+		{"mixed", func(d *dbtest.DB) {
+			d.On(insert).Rows(sent, []interface{}{10}).Times(1)
+			d.On(insert).Err(errors.New("insert failed")).Times(1)
+			d.On(insert).Rows(sent, []interface{}{12})
+		}, http.StatusMultiStatus, []bool{true, false, true}},
+		{"all failed", func(d *dbtest.DB) {
+			d.On(insert).Err(errors.New("insert failed"))
+		}, http.StatusInternalServerError, []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.script(useDB(t))
+
+			w := httptest.NewRecorder()
+			sendBulkNotifications(w, httptest.NewRequest("POST", "/notifications/bulk", strings.NewReader(threeNotifications)))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+
+			var body struct {
+				Results []struct {
+					Success bool   `json:"success"`
+					Error   string `json:"error"`
+				} `json:"results"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Results) != len(tt.success) {
+				t.Fatalf("%d results, want %d", len(body.Results), len(tt.success))
+			}
+			for i, ok := range tt.success {
+				if got := body.Results[i]; got.Success != ok || (!ok && got.Error == "") {
+					t.Errorf("results[%d] = %+v, want success %v", i, got, ok)
+				}
+			}
+		})
+	}
+}
+
+// deleteNotificationAs sends DELETE target for notification 4 with a token
+// for userID.
+func deleteNotificationAs(t *testing.T, target string, userID uint, role string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := authorize(t, httptest.NewRequest("DELETE", target, nil), userID, role)
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(http.HandlerFunc(deleteNotification)).ServeHTTP(w, mux.SetURLVars(r, map[string]string{"id": "4"}))
+	return w
+}
+
+func TestDeleteNotificationResponseModes(t *testing.T) {
+	tests := []struct {
+		target   string
+		affected int64
+		want     int
+		body     string
+	}{
+		{"/notifications/4", 1, http.StatusNoContent, ""},
+		{"/notifications/4?confirm=true", 1, http.StatusOK, `{"deleted":true,"id":4}`},
+		{"/notifications/4?confirm=true", 0, http.StatusOK, `{"deleted":false,"id":4}`},
+	}
+	for _, tt := range tests {
+		fake := useDB(t)
+		fake.On(`SELECT user_id FROM notifications WHERE id = \$1`).Rows([]string{"user_id"}, []interface{}{7})
+		fake.On(`DELETE FROM notifications WHERE id = \$1`).Affected(tt.affected)
+
+		w := deleteNotificationAs(t, tt.target, 7, "")
+		if w.Code != tt.want || strings.TrimSpace(w.Body.String()) != tt.body {
+			t.Errorf("%s (%d affected): status = %d, body = %q; want %d %q", tt.target, tt.affected, w.Code, w.Body, tt.want, tt.body)
+		}
+	}
+}
+
+func TestDeleteNotificationChecksOwner(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID uint
+		role   string
+		want   int
+	}{
+		{"owner", 7, "", http.StatusNoContent},
+		{"admin", 1, "admin", http.StatusNoContent},
+		{"other user", 8, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useDB(t)
+			fake.On(`SELECT user_id FROM notifications WHERE id = \$1`).Rows([]string{"user_id"}, []interface{}{7})
+			fake.On(`DELETE FROM notifications WHERE id = \$1`)
+
+			if w := deleteNotificationAs(t, "/notifications/4", tt.userID, tt.role); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if deletes := fake.Matching(`^DELETE`); tt.want == http.StatusForbidden && len(deletes) != 0 {
+				t.Error("another user's notification was deleted")
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(http.HandlerFunc(deleteNotification)).ServeHTTP(w, httptest.NewRequest("DELETE", "/notifications/4", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("no token: status = %d, want 401", w.Code)
+	}
+}
+
+func TestDeleteMissingNotification(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`SELECT user_id FROM notifications WHERE id = \$1`)
+
+	w := deleteNotificationAs(t, "/notifications/4?confirm=true", 7, "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"deleted":false,"id":4}` {
+		t.Errorf("status = %d, body = %q; want deleted false", w.Code, w.Body)
+	}
+	if len(fake.Matching(`^DELETE`)) != 0 {
+		t.Error("a missing notification was deleted")
+	}
+}
+
+func TestGetNotificationRendersUnsentAsNull(t *testing.T) {
+	sent := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	for _, sentAt := range []interface{}{nil, sent} {
+		useDB(t).On(`FROM notifications WHERE id = \$1`).Rows(
+			[]string{"id", "user_id", "type", "channel", "subject", "message", "status", "metadata", "created_at", "sent_at"},
+			[]interface{}{4, 7, "order", "email", "", "Hi", "pending", nil, sent, sentAt})
+
+		w := httptest.NewRecorder()
+		getNotification(w, mux.SetURLVars(httptest.NewRequest("GET", "/notifications/4", nil), map[string]string{"id": "4"}))
+		want := `"sent_at":null`
+		if sentAt != nil {
+			want = `"sent_at":"2026-05-01T09:00:00Z"`
+		}
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("body = %s, want %s", w.Body, want)
+		}
+	}
+}
+
+func TestGetNotificationsByUserChecksToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID uint
+		role   string
+		want   int
+	}{
+		{"owner", 7, "", http.StatusOK},
+		{"admin", 1, "admin", http.StatusOK},
+		{"other user", 8, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useDB(t)
+			fake.On(`FROM notifications WHERE user_id = \$1`).Rows([]string{"id"})
+
+			r := authorize(t, httptest.NewRequest("GET", "/notifications/user/7", nil), tt.userID, tt.role)
+			w := httptest.NewRecorder()
+			middleware.AuthMiddleware(http.HandlerFunc(getNotificationsByUser)).ServeHTTP(w, mux.SetURLVars(r, map[string]string{"user_id": "7"}))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if lookups := fake.Matching(`FROM notifications`); tt.want == http.StatusOK && (len(lookups) != 1 || lookups[0].Args[0] != int64(7)) {
+				t.Errorf("lookups = %+v, want user 7's notifications", lookups)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// purgeInterval is how often notifications past retention are deleted.
+	purgeInterval = time.Hour
+	// purgeBatchSize bounds each DELETE so a large backlog doesn't hold
+	// locks on the table for long.
+	purgeBatchSize = 1000
+)
+
+// retentionDays is how long delivered notifications are kept, from
+// NOTIFICATION_RETENTION_DAYS (default 90). 0 keeps them forever.
+var retentionDays = envInt("NOTIFICATION_RETENTION_DAYS", 90)
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+		log.Printf("Invalid %s %q, using %d", key, v, fallback)
+	}
+	return fallback
+}
+
+// purgeNotifications deletes sent notifications created before cutoff and
+// returns how many were removed. Anything not yet delivered is kept so it
+// can still be retried or inspected. Running it again is harmless.
+func purgeNotifications(ctx context.Context, cutoff time.Time) (int64, error) {
+	var total int64
+	for {
+		res, err := db.ExecContext(ctx,
+			`DELETE FROM notifications WHERE id IN (
+				SELECT id FROM notifications WHERE status = 'sent' AND created_at < $1 LIMIT $2
+			)`,
+			cutoff, purgeBatchSize,
+		)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+		if n < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// startNotificationPurge deletes notifications older than the retention
+// window at startup and then every interval. Call the returned func to stop.
+func startNotificationPurge(retention, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if retention <= 0 {
+		return cancel
+	}
+
+	purge := func() {
+		n, err := purgeNotifications(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Notification purge failed: %v", err)
+			return
+		}
+		if n > 0 {
+			log.Printf("Notification purge removed %d notifications older than %s", n, retention)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		purge()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purge()
+			}
+		}
+	}()
+	return cancel
+}
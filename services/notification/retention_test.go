@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestPurgeNotificationsDeletesOldSentOnly(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^DELETE FROM notifications`).Affected(3)
+
+	cutoff := time.Now().Add(-90 * 24 * time.Hour)
+	n, err := purgeNotifications(context.Background(), cutoff)
+	if err != nil || n != 3 {
+		t.Fatalf("purged %d, %v; want 3", n, err)
+	}
+	deletes := fake.Matching(`^DELETE FROM notifications`)
+	if len(deletes) != 1 {
+		t.Fatalf("%d deletes, want 1", len(deletes))
+	}
+	// Pending and failed notifications are never candidates, however old.
+	if ok, _ := regexp.MatchString(`status = 'sent' AND created_at < \$1`, deletes[0].Query); !ok {
+		t.Errorf("delete %q is not limited to old sent notifications", deletes[0].Query)
+	}
+	if deletes[0].Args[0] != cutoff {
+		t.Errorf("cutoff = %v, want %v", deletes[0].Args[0], cutoff)
+	}
+}
+
+func TestPurgeNotificationsWorksInBatches(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^DELETE FROM notifications`).Affected(purgeBatchSize).Times(2)
+	fake.On(`^DELETE FROM notifications`).Affected(7)
+
+	n, err := purgeNotifications(context.Background(), time.Now())
+	if err != nil || n != 2*purgeBatchSize+7 {
+		t.Fatalf("purged %d, %v; want %d", n, err, 2*purgeBatchSize+7)
+	}
+	if deletes := fake.Matching(`^DELETE`); len(deletes) != 3 {
+		t.Errorf("%d deletes, want 3", len(deletes))
+	}
+}
+
+func TestPurgeNotificationsIsRepeatable(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^DELETE FROM notifications`).Affected(4).Times(1)
+	fake.On(`^DELETE FROM notifications`).Affected(0)
+
+	cutoff := time.Now()
+	if n, err := purgeNotifications(context.Background(), cutoff); err != nil || n != 4 {
+		t.Fatalf("first run purged %d, %v; want 4", n, err)
+	}
+	if n, err := purgeNotifications(context.Background(), cutoff); err != nil || n != 0 {
+		t.Errorf("second run purged %d, %v; want nothing left", n, err)
+	}
+}
+
+func TestStartNotificationPurge(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^DELETE FROM notifications`).Affected(0)
+
+	stop := startNotificationPurge(time.Hour, time.Hour)
+	deadline := time.Now().Add(time.Second)
+	for len(fake.Matching(`^DELETE`)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stop()
+	if len(fake.Matching(`^DELETE`)) == 0 {
+		t.Fatal("no purge at startup")
+	}
+	cutoff := fake.Matching(`^DELETE`)[0].Args[0].(time.Time)
+	if d := time.Since(cutoff); d < time.Hour || d > time.Hour+time.Minute {
+		t.Errorf("cutoff is %v ago, want the retention window", d)
+	}
+}
+
+func TestStartNotificationPurgeDisabled(t *testing.T) {
+	fake := useDB(t)
+
+	stop := startNotificationPurge(0, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	if len(fake.Calls()) != 0 {
+		t.Error("a zero retention purged notifications")
+	}
+}
+
+func TestEnvInt(t *testing.T) {
+	t.Setenv("NOTIFICATION_RETENTION_DAYS", "")
+	if got := envInt("NOTIFICATION_RETENTION_DAYS", 90); got != 90 {
+		t.Errorf("unset = %d, want 90", got)
+	}
+	t.Setenv("NOTIFICATION_RETENTION_DAYS", "30")
+	if got := envInt("NOTIFICATION_RETENTION_DAYS", 90); got != 30 {
+		t.Errorf("30 = %d", got)
+	}
+	for _, v := range []string{"-1", "soon"} {
+		t.Setenv("NOTIFICATION_RETENTION_DAYS", v)
+		if got := envInt("NOTIFICATION_RETENTION_DAYS", 90); got != 90 {
+			t.Errorf("%q = %d, want the default", v, got)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/promo"
+)
+
+var cartClient = &http.Client{Timeout: 5 * time.Second}
+
+func cartServiceURL() string {
+	if url := os.Getenv("CART_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://cart-service:8003"
+}
+
+// couponRejectedError is the cart service's reason for refusing to redeem
+// the cart's coupon, such as "coupon has expired".
+type couponRejectedError string
+
+func (e couponRejectedError) Error() string { return string(e) }
+
+// redeemCartCoupon redeems the coupon applied to the user's cart for an
+// order worth subtotal, counting one use of it. It returns nil when the cart
+// has no coupon and a couponRejectedError when the coupon no longer
+// qualifies. The caller's Authorization header is forwarded so the cart
+// service enforces ownership.
+func redeemCartCoupon(userID uint, subtotal float64, authorization string) (*promo.Promo, error) {
+	payload, _ := json.Marshal(map[string]float64{"subtotal": subtotal})
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/cart/%d/coupon/redeem", cartServiceURL(), userID), bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := cartClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return nil, couponRejectedError(body.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cart service returned %d", resp.StatusCode)
+	}
+
+	var redeemed struct {
+		Coupon *promo.Promo `json:"coupon"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&redeemed); err != nil {
+		return nil, err
+	}
+	return redeemed.Coupon, nil
+}
+
+// releaseCartCoupon gives back a use redeemed by redeemCartCoupon and puts
+// the code back on the user's cart.
+func releaseCartCoupon(userID uint, code, authorization string) error {
+	payload, _ := json.Marshal(map[string]string{"code": code})
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/cart/%d/coupon/release", cartServiceURL(), userID), bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := cartClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cart service returned %d", resp.StatusCode)
+	}
+	return nil
+}
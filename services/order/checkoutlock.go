@@ -0,0 +1,52 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+)
+
+// checkoutLockClass namespaces the per-user advisory lock taken while an
+// order is created, so it can't collide with other advisory locks.
+const checkoutLockClass = 4004
+
+// lockCheckout serializes order creation for a user until tx ends. A second
+// checkout submitted at the same time waits here and then sees the first
+// one's order.
+func lockCheckout(tx *sql.Tx, userID uint) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1, $2)", checkoutLockClass, int32(userID))
+	return err
+}
+
+// findCartOrder returns the id, order number, total and payment status of the
+// live order already placed from this version of the user's cart, or
+// sql.ErrNoRows. Cancelled orders don't count, so a cart can be checked out
+// again after its order is cancelled.
+func findCartOrder(tx *sql.Tx, userID uint, cartVersion string) (*Order, error) {
+	var o Order
+	err := tx.QueryRow(
+		`SELECT id, COALESCE(order_number, ''), total_amount, COALESCE(payment_status, 'pending') FROM orders
+		 WHERE user_id = $1 AND cart_version = $2 AND COALESCE(status, 'pending') <> 'cancelled'
+		 ORDER BY id LIMIT 1`,
+		userID, cartVersion,
+	).Scan(&o.ID, &o.OrderNumber, &o.TotalAmount, &o.PaymentStatus)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// writeDuplicateCheckout answers a second checkout of the same cart with 409
+// and the order that was placed, so a client whose payment failed can retry
+// it rather than order again.
+func writeDuplicateCheckout(w http.ResponseWriter, existing *Order) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":          "an order has already been placed from this cart",
+		"order_id":       existing.ID,
+		"order_number":   existing.OrderNumber,
+		"total_amount":   existing.TotalAmount,
+		"payment_status": existing.PaymentStatus,
+	})
+}
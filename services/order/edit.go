@@ -0,0 +1,337 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/promo"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/validation"
+)
+
+var (
+	errOrderNotFound   = errors.New("order not found")
+	errOrderNotPending = errors.New("order can only be edited while pending")
+)
+
+// lockPendingOrder locks the order row for the rest of tx and checks that the
+// caller may edit it.
+func lockPendingOrder(tx *sql.Tx, r *http.Request, orderID int) error {
+	var userID uint
+	var status string
+	err := tx.QueryRow("SELECT user_id, COALESCE(status, 'pending') FROM orders WHERE id = $1 FOR UPDATE", orderID).Scan(&userID, &status)
+	if err == sql.ErrNoRows {
+		return errOrderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(userID) {
+		return errOrderNotFound
+	}
+	if status != "pending" {
+		return errOrderNotPending
+	}
+	return nil
+}
+
+// repriceOrder recomputes a pending order's total and tax from its current
+// items the way createOrder does: the caps in checkOrderLimits still apply,
+// and the coupon and store credit the order was placed with are applied
+// again, rewriting its adjustments. Item prices stay as they were when each
+// item was added. If the edited order fails the caps or the promotion rules,
+// the failed validator is returned and nothing is written.
+func repriceOrder(tx *sql.Tx, orderID int) (*Order, *validation.Validator, error) {
+	order := &Order{ID: uint(orderID)}
+	err := tx.QueryRow(
+		"SELECT COALESCE(billing_address, shipping_address, '') FROM orders WHERE id = $1", orderID,
+	).Scan(&order.BillingAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := tx.Query("SELECT product_id, quantity, price FROM order_items WHERE order_id = $1", orderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var item OrderItem
+		if err := rows.Scan(&item.ProductID, &item.Quantity, &item.Price); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		order.Items = append(order.Items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = tx.Query(orderAdjustmentsQuery, orderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var adj promo.Adjustment
+		if err := rows.Scan(&adj.Code, &adj.Kind, &adj.Value, &adj.Amount, &adj.TotalAfter); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		order.Promotions = append(order.Promotions, adj.Promo())
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if v := checkOrderLimits(order); !v.Valid() {
+		return nil, v, nil
+	}
+	order.TotalAmount = orderSubtotal(order.Items)
+	if len(order.Promotions) > 0 {
+		if err := applyPromotions(order); err != nil {
+			v := validation.New()
+			v.Check(false, "promotions", err.Error())
+			return nil, v, nil
+		}
+	}
+	order.TaxAmount = orderTax(order)
+
+	_, err = tx.Exec(
+		"UPDATE orders SET total_amount = $1, tax_amount = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3",
+		order.TotalAmount, order.TaxAmount, orderID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(order.Promotions) > 0 {
+		if _, err := tx.Exec("DELETE FROM order_adjustments WHERE order_id = $1", orderID); err != nil {
+			return nil, nil, err
+		}
+		if err := insertOrderAdjustments(tx, order.ID, order.Adjustments); err != nil {
+			return nil, nil, err
+		}
+	}
+	return order, nil, nil
+}
+
+// commitOrderEdit reprices the order, reserves (or releases) stock for the
+// quantity change and commits. Stock is taken with the product service's
+// guarded reserve, so an edit can't oversell, and before the commit so a
+// shortage or product service failure leaves the order untouched.
+func commitOrderEdit(w http.ResponseWriter, tx *sql.Tx, orderID int, productID uint, stockDelta int) {
+	order, v, err := repriceOrder(tx, orderID)
+	if err != nil {
+		log.Printf("Order %d edit: failed to reprice: %v", orderID, err)
+		http.Error(w, "Failed to update order total", http.StatusInternalServerError)
+		return
+	}
+	if v != nil {
+		log.Printf("REVIEW: edit to order %d rejected: %v", orderID, v.Errors())
+		validation.WriteError(w, v.Errors())
+		return
+	}
+
+	if stockDelta > 0 {
+		err = reserveStock(productID, stockDelta, orderID)
+		if err == errInsufficientStock {
+			http.Error(w, "Insufficient stock", http.StatusConflict)
+			return
+		}
+	} else if stockDelta < 0 {
+		err = adjustStock(productID, -stockDelta, "order_edit", orderID)
+	}
+	if err != nil {
+		log.Printf("Order %d edit: failed to change stock for product %d by %d: %v", orderID, productID, -stockDelta, err)
+		http.Error(w, "Failed to reserve stock", http.StatusBadGateway)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		// Undo the stock change; reserving back what a decrease released
+		// is guarded too, so it can't oversell.
+		if stockDelta > 0 {
+			err = adjustStock(productID, stockDelta, "order_edit", orderID)
+		} else if stockDelta < 0 {
+			err = reserveStock(productID, -stockDelta, orderID)
+		}
+		if err != nil {
+			log.Printf("Order %d edit: failed to undo stock change for product %d: %v", orderID, productID, err)
+		}
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	resp := map[string]interface{}{"order_id": orderID, "total_amount": order.TotalAmount, "tax_amount": order.TaxAmount}
+	if order.AmountDue != nil {
+		resp["amount_due"] = *order.AmountDue
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeEditError(w http.ResponseWriter, err error) {
+	switch err {
+	case errOrderNotFound:
+		http.Error(w, "Order not found", http.StatusNotFound)
+	case errOrderNotPending:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Failed to edit order", http.StatusInternalServerError)
+	}
+}
+
+func addOrderItem(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		ProductID uint `json:"product_id"`
+		Quantity  int  `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Quantity <= 0 {
+		http.Error(w, "Quantity must be positive", http.StatusBadRequest)
+		return
+	}
+
+	product, err := fetchProduct(req.ProductID)
+	if err == errProductNotFound {
+		http.Error(w, "Product not found", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch product", http.StatusBadGateway)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockPendingOrder(tx, r, orderID); err != nil {
+		writeEditError(w, err)
+		return
+	}
+
+	// Adding a product already on the order increases that line instead.
+	result, err := tx.Exec(
+		"UPDATE order_items SET quantity = quantity + $1 WHERE order_id = $2 AND product_id = $3",
+		req.Quantity, orderID, req.ProductID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to add item", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		_, err = tx.Exec(
+			`INSERT INTO order_items (order_id, product_id, name, quantity, price)
+			 VALUES ($1, $2, $3, $4, $5)`,
+			orderID, req.ProductID, product.Name, req.Quantity, product.Price,
+		)
+		if err != nil {
+			http.Error(w, "Failed to add item", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	commitOrderEdit(w, tx, orderID, req.ProductID, req.Quantity)
+}
+
+func updateOrderItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+	itemID, err := strconv.Atoi(vars["item_id"])
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Quantity int `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Quantity < 0 {
+		http.Error(w, "Quantity cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	editOrderItemQuantity(w, r, orderID, itemID, req.Quantity)
+}
+
+func removeOrderItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+	itemID, err := strconv.Atoi(vars["item_id"])
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	editOrderItemQuantity(w, r, orderID, itemID, 0)
+}
+
+// editOrderItemQuantity sets an item's quantity, removing it at zero.
+func editOrderItemQuantity(w http.ResponseWriter, r *http.Request, orderID, itemID, quantity int) {
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockPendingOrder(tx, r, orderID); err != nil {
+		writeEditError(w, err)
+		return
+	}
+
+	var productID uint
+	var current int
+	err = tx.QueryRow(
+		"SELECT product_id, quantity FROM order_items WHERE id = $1 AND order_id = $2",
+		itemID, orderID,
+	).Scan(&productID, &current)
+	if err != nil {
+		http.Error(w, "Order item not found", http.StatusNotFound)
+		return
+	}
+
+	if quantity == 0 {
+		_, err = tx.Exec("DELETE FROM order_items WHERE id = $1", itemID)
+	} else {
+		_, err = tx.Exec("UPDATE order_items SET quantity = $1 WHERE id = $2", quantity, itemID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update item", http.StatusInternalServerError)
+		return
+	}
+
+	commitOrderEdit(w, tx, orderID, productID, quantity-current)
+}
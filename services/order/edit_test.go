@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+)
+
+// seedEdit scripts order 3, owned by user 7 and billed in Texas, in the
+// given status. After the edit it holds 2 items at 27.50 and no promotions.
+func seedEdit(t *testing.T, status string) *dbtest.DB {
+	t.Helper()
+	return seedEditPriced(t, status, [][]interface{}{{4, 2, 27.5}})
+}
+
+// seedEditPriced is seedEdit with the order's items after the edit and the
+// adjustments it was placed with.
+func seedEditPriced(t *testing.T, status string, items [][]interface{}, adjustments ...[]interface{}) *dbtest.DB {
+	t.Helper()
+	fake := useDB(t)
+	fake.On(`SELECT user_id, COALESCE\(status, 'pending'\) FROM orders WHERE id = \$1 FOR UPDATE`).Rows([]string{"user_id", "status"}, []interface{}{7, status})
+	fake.On(`SELECT COALESCE\(billing_address`).Rows([]string{"billing_address"}, []interface{}{"500 Main St, Austin, TX 78701"})
+	fake.On(`SELECT product_id, quantity, price FROM order_items`).Rows([]string{"product_id", "quantity", "price"}, items...)
+	fake.On(`FROM order_adjustments`).Rows([]string{"code", "kind", "value", "amount", "total_after"}, adjustments...)
+	fake.On(`^UPDATE orders SET total_amount`)
+	fake.On(`^DELETE FROM order_adjustments`)
+	fake.On(`^INSERT INTO order_adjustments`)
+	return fake
+}
+
+func editOrder(t *testing.T, method, path, body string, userID uint) *httptest.ResponseRecorder {
+	t.Helper()
+	r := authorize(t, httptest.NewRequest(method, path, strings.NewReader(body)), userID, "")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+func TestAddOrderItem(t *testing.T) {
+	catalog := stubCatalog(t, map[uint]int{4: 10})
+	fake := seedEdit(t, "pending")
+	fake.On(`UPDATE order_items SET quantity = quantity \+ \$1`).Affected(0)
+	fake.On(`INSERT INTO order_items`)
+
+	w := editOrder(t, "POST", "/orders/3/items", `{"product_id": 4, "quantity": 2}`, 7)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		TotalAmount float64 `json:"total_amount"`
+		TaxAmount   float64 `json:"tax_amount"`
+	}
+	json.NewDecoder(w.Body).Decode(&body)
+	if body.TotalAmount != 55 || body.TaxAmount != 3.44 {
+		t.Errorf("total_amount = %v, tax_amount = %v; want the recomputed 55 and 3.44", body.TotalAmount, body.TaxAmount)
+	}
+	updates := fake.Matching(`^UPDATE orders`)
+	if len(updates) != 1 || updates[0].Args[0] != 55.0 || updates[0].Args[1] != 3.44 {
+		t.Errorf("order updates = %+v, want total 55 and tax 3.44", updates)
+	}
+
+	inserts := fake.Matching(`INSERT INTO order_items`)
+	if len(inserts) != 1 || inserts[0].Args[2] != "Lamp" || inserts[0].Args[3] != int64(2) || inserts[0].Args[4] != 20.0 {
+		t.Errorf("inserts = %+v, want 2 x Lamp at the catalog price", inserts)
+	}
+	if got := strings.Join(catalog.Calls(), ","); got != "reserve 4 2 order:3" {
+		t.Errorf("stock calls = %q, want 2 reserved", got)
+	}
+	if calls := fake.Calls(); calls[len(calls)-1].Query != "COMMIT" {
+		t.Error("edit was not committed")
+	}
+}
+
+func TestRemoveOrderItemReleasesStock(t *testing.T) {
+	catalog := stubCatalog(t, map[uint]int{4: 10})
+	fake := seedEdit(t, "pending")
+	fake.On(`SELECT product_id, quantity FROM order_items`).Rows([]string{"product_id", "quantity"}, []interface{}{4, 3})
+	fake.On(`DELETE FROM order_items WHERE id = \$1`)
+
+	w := editOrder(t, "DELETE", "/orders/3/items/21", "", 7)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if deletes := fake.Matching(`DELETE FROM order_items`); len(deletes) != 1 || deletes[0].Args[0] != int64(21) {
+		t.Errorf("deletes = %+v", deletes)
+	}
+	if got := strings.Join(catalog.Calls(), ","); got != "release 4 3" {
+		t.Errorf("stock calls = %q, want 3 released", got)
+	}
+}
+
+func TestUpdateOrderItemQuantity(t *testing.T) {
+	catalog := stubCatalog(t, map[uint]int{4: 10})
+	fake := seedEdit(t, "pending")
+	fake.On(`SELECT product_id, quantity FROM order_items`).Rows([]string{"product_id", "quantity"}, []interface{}{4, 3})
+	fake.On(`UPDATE order_items SET quantity = \$1 WHERE id = \$2`)
+
+	if w := editOrder(t, "PUT", "/orders/3/items/21", `{"quantity": 5}`, 7); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if got := strings.Join(catalog.Calls(), ","); got != "reserve 4 2 order:3" {
+		t.Errorf("stock calls = %q, want the extra 2 reserved", got)
+	}
+}
+
+func TestEditRejectedOnceConfirmed(t *testing.T) {
+	catalog := stubCatalog(t, map[uint]int{4: 10})
+	fake := seedEdit(t, "confirmed")
+
+	w := editOrder(t, "POST", "/orders/3/items", `{"product_id": 4, "quantity": 1}`, 7)
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", w.Code)
+	}
+	if len(fake.Matching(`order_items`)) != 0 || len(catalog.Calls()) != 0 {
+		t.Error("a confirmed order's items or stock were changed")
+	}
+}
+
+func TestEditRejectedForAnotherCustomer(t *testing.T) {
+	stubCatalog(t, map[uint]int{4: 10})
+	seedEdit(t, "pending")
+
+	if w := editOrder(t, "DELETE", "/orders/3/items/21", "", 8); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestEditCannotOversell(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"add", "POST", "/orders/3/items", `{"product_id": 4, "quantity": 3}`},
+		{"quantity increase", "PUT", "/orders/3/items/21", `{"quantity": 6}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only 2 left, and both edits need 3 more.
+			catalog := stubCatalog(t, map[uint]int{4: 2})
+			fake := seedEdit(t, "pending")
+			fake.On(`SELECT product_id, quantity FROM order_items`).Rows([]string{"product_id", "quantity"}, []interface{}{4, 3})
+			fake.On(`^UPDATE order_items`).Affected(0)
+			fake.On(`INSERT INTO order_items`)
+
+			if w := editOrder(t, tt.method, tt.path, tt.body, 7); w.Code != http.StatusConflict {
+				t.Errorf("status = %d, want 409", w.Code)
+			}
+			if len(catalog.Calls()) != 0 || catalog.stock[4] != 2 {
+				t.Errorf("stock calls = %v, stock = %d; want nothing taken", catalog.Calls(), catalog.stock[4])
+			}
+			if len(fake.Matching(`^COMMIT`)) != 0 {
+				t.Error("an edit without stock was committed")
+			}
+		})
+	}
+}
+
+func TestEditKeepsCouponAndCredit(t *testing.T) {
+	stubCatalog(t, map[uint]int{4: 10})
+	// Placed at 2 x 50 with 10% off and 15 of store credit; now 3 x 50.
+	fake := seedEditPriced(t, "pending", [][]interface{}{{4, 3, 50.0}},
+		[]interface{}{"SAVE10", "percent", 10.0, 10.0, 90.0},
+		[]interface{}{"", "credit", 15.0, 15.0, 75.0},
+	)
+	fake.On(`SELECT product_id, quantity FROM order_items`).Rows([]string{"product_id", "quantity"}, []interface{}{4, 2})
+	fake.On(`UPDATE order_items SET quantity = \$1 WHERE id = \$2`)
+
+	w := editOrder(t, "PUT", "/orders/3/items/21", `{"quantity": 3}`, 7)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		TotalAmount float64 `json:"total_amount"`
+		TaxAmount   float64 `json:"tax_amount"`
+		AmountDue   float64 `json:"amount_due"`
+	}
+	json.NewDecoder(w.Body).Decode(&body)
+	if body.TotalAmount != 135 || body.TaxAmount != 8.44 || body.AmountDue != 120 {
+		t.Errorf("body = %+v, want 135 after the coupon, 8.44 tax and 120 due after credit", body)
+	}
+
+	if len(fake.Matching(`^DELETE FROM order_adjustments`)) != 1 {
+		t.Error("the old adjustments were not replaced")
+	}
+	inserts := fake.Matching(`^INSERT INTO order_adjustments`)
+	if len(inserts) != 2 {
+		t.Fatalf("%d adjustments recorded, want 2", len(inserts))
+	}
+	// (order_id, seq, code, kind, value, amount, total_after)
+	if a := inserts[0].Args; a[2] != "SAVE10" || a[4] != 10.0 || a[5] != 15.0 || a[6] != 135.0 {
+		t.Errorf("coupon adjustment = %v, want 10%% taking 15 off 150", a)
+	}
+	if a := inserts[1].Args; a[3] != "credit" || a[5] != 15.0 || a[6] != 120.0 {
+		t.Errorf("credit adjustment = %v, want the same 15 of credit", a)
+	}
+}
+
+func TestEditRejectedOverOrderCap(t *testing.T) {
+	catalog := stubCatalog(t, map[uint]int{4: 10})
+	fake := seedEditPriced(t, "pending", [][]interface{}{{4, 3, maxOrderTotal / 2}})
+	fake.On(`SELECT product_id, quantity FROM order_items`).Rows([]string{"product_id", "quantity"}, []interface{}{4, 1})
+	fake.On(`UPDATE order_items SET quantity = \$1 WHERE id = \$2`)
+
+	w := editOrder(t, "PUT", "/orders/3/items/21", `{"quantity": 3}`, 7)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "maximum order total") {
+		t.Errorf("body = %s, want the order cap", w.Body)
+	}
+	if len(catalog.Calls()) != 0 {
+		t.Errorf("stock calls = %v, want none", catalog.Calls())
+	}
+	if len(fake.Matching(`^UPDATE orders`)) != 0 || len(fake.Matching(`^COMMIT`)) != 0 {
+		t.Error("an edit over the cap was saved")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/response"
+)
+
+var exportCSVHeader = []string{
+	"order_id", "user_id", "status", "total_amount", "tax_amount",
+	"payment_method", "payment_status", "source", "created_at", "updated_at",
+}
+
+// exportedOrder is one order in a JSON export; it carries the same columns
+// as the CSV.
+type exportedOrder struct {
+	ID            uint       `json:"order_id"`
+	UserID        uint       `json:"user_id"`
+	Status        string     `json:"status"`
+	TotalAmount   float64    `json:"total_amount"`
+	TaxAmount     float64    `json:"tax_amount"`
+	PaymentMethod string     `json:"payment_method"`
+	PaymentStatus string     `json:"payment_status"`
+	Source        string     `json:"source"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     *time.Time `json:"updated_at"`
+}
+
+// parseExportTime accepts either a date (2006-01-02) or an RFC 3339 timestamp.
+// A bare date used as the upper bound covers the whole day.
+func parseExportTime(value string, endOfRange bool) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		if endOfRange {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// formatExportTime renders a nullable timestamp, leaving the cell empty when
+// it is unset.
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func scanExportOrder(rows *sql.Rows) (Order, error) {
+	var o Order
+	err := rows.Scan(&o.ID, &o.UserID, &o.Status, &o.TotalAmount, &o.TaxAmount, &o.PaymentMethod, &o.PaymentStatus, &o.Source, &o.CreatedAt, &o.UpdatedAt)
+	return o, err
+}
+
+// exportOrders streams all orders created in [from, to) for accounting, as
+// CSV by default or as a JSON array with ?format=json.
+func exportOrders(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, "Unsupported export format", http.StatusBadRequest)
+		return
+	}
+
+	fromParam := r.URL.Query().Get("from")
+	toParam := r.URL.Query().Get("to")
+	if fromParam == "" || toParam == "" {
+		http.Error(w, "from and to are required", http.StatusBadRequest)
+		return
+	}
+
+	from, err := parseExportTime(fromParam, false)
+	if err != nil {
+		http.Error(w, "Invalid from date", http.StatusBadRequest)
+		return
+	}
+	to, err := parseExportTime(toParam, true)
+	if err != nil {
+		http.Error(w, "Invalid to date", http.StatusBadRequest)
+		return
+	}
+	if !to.After(from) {
+		http.Error(w, "to must be after from", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(
+		`SELECT id, user_id, COALESCE(status, 'pending'), total_amount, tax_amount, COALESCE(payment_method, ''), COALESCE(payment_status, 'pending'), source, created_at, updated_at
+		 FROM orders WHERE created_at >= $1 AND created_at < $2 ORDER BY created_at, id`,
+		from, to,
+	)
+	if err != nil {
+		http.Error(w, "Failed to export orders", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	filename := fmt.Sprintf("orders_%s_%s.%s", fromParam, toParam, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == "json" {
+		count, err := response.StreamJSON(w, rows, func(rows *sql.Rows) (interface{}, error) {
+			o, err := scanExportOrder(rows)
+			if err != nil {
+				log.Printf("Order export: failed to scan row: %v", err)
+				return nil, err
+			}
+			return exportedOrder{
+				ID:            o.ID,
+				UserID:        o.UserID,
+				Status:        o.Status,
+				TotalAmount:   o.TotalAmount,
+				TaxAmount:     o.TaxAmount,
+				PaymentMethod: o.PaymentMethod,
+				PaymentStatus: o.PaymentStatus,
+				Source:        o.Source,
+				CreatedAt:     o.CreatedAt,
+				UpdatedAt:     o.UpdatedAt,
+			}, nil
+		})
+		if err != nil {
+			log.Printf("Order export: aborted after %d rows: %v", count, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+
+	flusher, _ := w.(http.Flusher)
+	cw := csv.NewWriter(w)
+	cw.Write(exportCSVHeader)
+
+	count := 0
+	for rows.Next() {
+		o, err := scanExportOrder(rows)
+		if err != nil {
+			log.Printf("Order export: failed to scan row: %v", err)
+			continue
+		}
+
+		cw.Write([]string{
+			strconv.FormatUint(uint64(o.ID), 10),
+			strconv.FormatUint(uint64(o.UserID), 10),
+			o.Status,
+			strconv.FormatFloat(o.TotalAmount, 'f', 2, 64),
+			strconv.FormatFloat(o.TaxAmount, 'f', 2, 64),
+			o.PaymentMethod,
+			o.PaymentStatus,
+			o.Source,
+			o.CreatedAt.UTC().Format(time.RFC3339),
+			formatExportTime(o.UpdatedAt),
+		})
+
+		count++
+		if count%100 == 0 {
+			cw.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+
+	cw.Flush()
+	if err := rows.Err(); err != nil {
+		log.Printf("Order export: aborted after %d rows: %v", count, err)
+	}
+}
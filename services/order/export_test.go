@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var exportColumns = []string{"id", "user_id", "status", "total_amount", "tax_amount", "payment_method", "payment_status", "source", "created_at", "updated_at"}
+
+func seedExport(t *testing.T) {
+	t.Helper()
+	created := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+	useDB(t).On(`FROM orders WHERE created_at >= \$1 AND created_at < \$2`).Rows(exportColumns,
+		[]interface{}{1, 7, "paid", 40.5, 3.24, "card", "completed", "web", created, updated},
+		[]interface{}{2, 8, "pending", 12.0, 0.0, "card", "pending", "mobile", created.Add(time.Minute), nil},
+		[]interface{}{3, 7, "shipped", 99.99, 8.0, "store_credit", "completed", "web", created.Add(2 * time.Minute), nil},
+	)
+}
+
+func TestExportOrdersCSV(t *testing.T) {
+	seedExport(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/orders/export?from=2026-03-01&to=2026-03-31", nil)
+	w := httptest.NewRecorder()
+	exportOrders(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="orders_2026-03-01_2026-03-31.csv"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(records[0], ",") != strings.Join(exportCSVHeader, ",") {
+		t.Errorf("header = %v", records[0])
+	}
+	if len(records) != 4 {
+		t.Fatalf("got %d data rows, want 3", len(records)-1)
+	}
+	if got := strings.Join(records[1], ","); got != "1,7,paid,40.50,3.24,card,completed,web,2026-03-02T10:00:00Z,2026-03-02T11:00:00Z" {
+		t.Errorf("first row = %s", got)
+	}
+	if records[2][9] != "" {
+		t.Errorf("unset updated_at = %q, want empty", records[2][9])
+	}
+}
+
+func TestExportOrdersJSON(t *testing.T) {
+	seedExport(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/orders/export?from=2026-03-01&to=2026-03-31&format=json", nil)
+	w := httptest.NewRecorder()
+	exportOrders(w, r)
+
+	var orders []exportedOrder
+	if err := json.NewDecoder(w.Body).Decode(&orders); err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 3 || orders[2].PaymentMethod != "store_credit" {
+		t.Errorf("orders = %+v", orders)
+	}
+}
+
+func TestExportOrdersRangeCoversWholeEndDay(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`FROM orders WHERE created_at`).Rows(exportColumns)
+
+	r := httptest.NewRequest(http.MethodGet, "/orders/export?from=2026-03-01&to=2026-03-01", nil)
+	exportOrders(httptest.NewRecorder(), r)
+
+	calls := fake.Matching(`FROM orders`)
+	if len(calls) != 1 {
+		t.Fatalf("got %d queries", len(calls))
+	}
+	from, to := calls[0].Args[0].(time.Time), calls[0].Args[1].(time.Time)
+	if to.Sub(from) != 24*time.Hour {
+		t.Errorf("range = %v to %v, want one whole day", from, to)
+	}
+}
+
+func TestExportOrdersRejectsBadRanges(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"from=2026-03-01",
+		"from=yesterday&to=2026-03-01",
+		"from=2026-03-02&to=2026-03-01",
+		"from=2026-03-01&to=2026-03-02&format=xlsx",
+	} {
+		t.Run(query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			exportOrders(w, httptest.NewRequest(http.MethodGet, "/orders/export?"+query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", w.Code)
+			}
+		})
+	}
+}
+
+func TestExportOrdersRequiresAdmin(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/orders/export?from=2026-03-01&to=2026-03-02", nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, authorize(t, r, 7, ""))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
+
+func TestFormatExportTime(t *testing.T) {
+	at := time.Date(2026, 5, 1, 9, 0, 0, 0, time.FixedZone("", 3600))
+	if got := formatExportTime(&at); got != "2026-05-01T08:00:00Z" {
+		t.Errorf("formatExportTime = %q", got)
+	}
+	if got := formatExportTime(nil); got != "" {
+		t.Errorf("formatExportTime(nil) = %q, want an empty cell", got)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/pagination"
+)
+
+var orderColumns = []string{"id", "user_id", "status", "total_amount", "tax_amount", "shipping_address", "billing_address", "payment_method", "payment_status", "source", "order_number", "created_at", "updated_at"}
+
+func orderRow(id int, createdAt time.Time) []interface{} {
+	return []interface{}{id, 7, "pending", 10.0, 0.0, "1 Main St", "1 Main St", "card", "pending", "web", "", createdAt, nil}
+}
+
+type orderPage struct {
+	Orders     []Order `json:"orders"`
+	NextCursor string  `json:"next_cursor"`
+}
+
+func listOrders(t *testing.T, query string) orderPage {
+	t.Helper()
+	r := authorize(t, httptest.NewRequest(http.MethodGet, "/orders/user/7?"+query, nil), 7, "")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var page orderPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestOrderCursorPagesDoNotOverlap(t *testing.T) {
+	base := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	fake := useDB(t)
+	// The first page asks for one row more than it returns, to learn whether
+	// there is a next page.
+	fake.On(`FROM orders WHERE user_id = \$1 ORDER BY`).Rows(orderColumns,
+		orderRow(5, base.Add(5*time.Minute)), orderRow(4, base.Add(4*time.Minute)), orderRow(3, base.Add(3*time.Minute)))
+	fake.On(`FROM orders WHERE user_id = \$1 AND \(created_at, id\) < \(\$2, \$3\)`).Rows(orderColumns,
+		orderRow(3, base.Add(3*time.Minute)), orderRow(2, base.Add(2*time.Minute)))
+
+	first := listOrders(t, "cursor=&limit=2")
+	if len(first.Orders) != 2 || first.Orders[0].ID != 5 || first.Orders[1].ID != 4 {
+		t.Fatalf("first page = %+v", first.Orders)
+	}
+	createdAt, id, err := pagination.DecodeCursor(first.NextCursor)
+	if err != nil || id != 4 || !createdAt.Equal(base.Add(4*time.Minute)) {
+		t.Fatalf("next_cursor = (%v, %d, %v), want the last row of the page", createdAt, id, err)
+	}
+
+	second := listOrders(t, "cursor="+url.QueryEscape(first.NextCursor)+"&limit=2")
+	if len(second.Orders) != 2 || second.Orders[0].ID != 3 || second.Orders[1].ID != 2 {
+		t.Errorf("second page = %+v", second.Orders)
+	}
+	if second.NextCursor != "" {
+		t.Errorf("next_cursor on the last page = %q", second.NextCursor)
+	}
+
+	calls := fake.Matching(`\(created_at, id\) <`)
+	if len(calls) != 1 || calls[0].Args[2] != int64(4) || calls[0].Args[3] != int64(3) {
+		t.Errorf("second page query args = %+v, want after order 4 with limit 3", calls)
+	}
+}
+
+func TestOrderCursorRejectsBadInput(t *testing.T) {
+	for _, query := range []string{"cursor=garbage", "cursor=&limit=0", "cursor=&limit=x"} {
+		r := authorize(t, httptest.NewRequest(http.MethodGet, "/orders/user/7?"+query, nil), 7, "")
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestReceiptsArePaginated(t *testing.T) {
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	fake := useDB(t)
+	fake.On(`^SELECT COUNT\(\*\) FROM orders WHERE user_id = \$1 AND payment_status = 'completed'`).Rows([]string{"count"}, []interface{}{5})
+	fake.On(`LIMIT \$2 OFFSET \$3`).Rows([]string{"id", "created_at", "total_amount", "status"},
+		[]interface{}{12, day, 40.5, "delivered"},
+		[]interface{}{11, day.Add(-time.Hour), 9.99, "shipped"},
+	)
+
+	r := authorize(t, httptest.NewRequest(http.MethodGet, "/orders/user/7/receipts?limit=2&offset=2", nil), 7, "")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var resp struct {
+		Receipts []Receipt `json:"receipts"`
+		Total    int       `json:"total"`
+		Limit    int       `json:"limit"`
+		Offset   int       `json:"offset"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 5 || resp.Limit != 2 || resp.Offset != 2 || len(resp.Receipts) != 2 {
+		t.Errorf("page = %+v", resp)
+	}
+	if resp.Receipts[0].OrderID != 12 || resp.Receipts[0].URL != "/api/orders/12" {
+		t.Errorf("first receipt = %+v", resp.Receipts[0])
+	}
+
+	page := fake.Matching(`LIMIT \$2 OFFSET \$3`)
+	if len(page) != 1 || page[0].Args[1] != int64(2) || page[0].Args[2] != int64(2) {
+		t.Errorf("page query args = %+v", page)
+	}
+}
+
+func TestReceiptsRequireOwnership(t *testing.T) {
+	useDB(t)
+
+	r := authorize(t, httptest.NewRequest(http.MethodGet, "/orders/user/7/receipts", nil), 8, "")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("another user: status = %d, want 403", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/user/7/receipts", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("no token: status = %d, want 401", w.Code)
+	}
+}
+
+func TestOrderCursorLimitIsCapped(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`FROM orders WHERE user_id = \$1 ORDER BY`).Rows(orderColumns)
+
+	listOrders(t, "cursor=&limit=100000")
+	calls := fake.Matching(`FROM orders WHERE user_id`)
+	if len(calls) != 1 || calls[0].Args[1] != int64(maxOrderPageSize+1) {
+		t.Errorf("query args = %+v, want a limit of %d plus one", calls, maxOrderPageSize)
+	}
+}
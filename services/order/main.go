@@ -3,26 +3,57 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/database"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/featureflags"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/pagination"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/pricing"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/promo"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/server"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/validation"
 )
 
 type Order struct {
-	ID            uint        `json:"id"`
-	UserID        uint        `json:"user_id"`
-	Status        string      `json:"status"`
-	TotalAmount   float64     `json:"total_amount"`
-	ShippingAddr  string      `json:"shipping_address"`
-	PaymentMethod string      `json:"payment_method"`
-	PaymentStatus string      `json:"payment_status"`
-	Items         []OrderItem `json:"items,omitempty"`
-	CreatedAt     time.Time   `json:"created_at"`
-	UpdatedAt     time.Time   `json:"updated_at"`
+	ID          uint    `json:"id"`
+	OrderNumber string  `json:"order_number"`
+	UserID      uint    `json:"user_id"`
+	Status      string  `json:"status"`
+	TotalAmount float64 `json:"total_amount"`
+	// TaxAmount is computed from the billing address when the order is
+	// placed; a value sent by the client is ignored.
+	TaxAmount     float64 `json:"tax_amount"`
+	ShippingAddr  string  `json:"shipping_address"`
+	BillingAddr   string  `json:"billing_address"`
+	PaymentMethod string  `json:"payment_method"`
+	PaymentStatus string  `json:"payment_status"`
+	Source        string  `json:"source"`
+	// CartVersion is the cart's version at checkout and is required. Only
+	// one live order may be placed from each version, so a double submit
+	// can't buy twice.
+	CartVersion string      `json:"cart_version,omitempty"`
+	Items       []OrderItem `json:"items,omitempty"`
+	// StoreCredit is how much of the user's store credit to spend; the
+	// amount used is capped at their balance.
+	StoreCredit float64 `json:"store_credit,omitempty"`
+	// Promotions are the cart coupon and store credit resolved on the server
+	// at checkout; any sent by the client are discarded. Adjustments is what
+	// the promo pipeline actually applied.
+	Promotions  []promo.Promo      `json:"promotions,omitempty"`
+	Adjustments []promo.Adjustment `json:"adjustments,omitempty"`
+	AmountDue   *float64           `json:"amount_due,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   *time.Time         `json:"updated_at"`
 }
 
 type OrderItem struct {
@@ -35,29 +66,69 @@ type OrderItem struct {
 }
 
 var db *sql.DB
+var dbOnce database.Once
+
+// Sanity caps on incoming orders; anything above them is almost certainly a
+// client bug or fraud and is rejected for manual review.
+var (
+	maxOrderTotal     = envFloat("ORDER_MAX_TOTAL", 10000)
+	maxOrderItemPrice = envFloat("ORDER_MAX_ITEM_PRICE", 5000)
+)
+
+func envFloat(key string, fallback float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+			return f
+		}
+		log.Printf("Invalid %s %q, using %v", key, v, fallback)
+	}
+	return fallback
+}
 
 func main() {
 	var err error
-	db, err = database.NewConnection("orders_db")
+	err = dbOnce.Open(&db, func() (*sql.DB, error) { return database.NewConnection("orders_db") })
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
 
+	stopKeepalive := database.StartKeepalive(db, database.KeepaliveInterval())
+	defer stopKeepalive()
+
 	initDB()
+	backfillOrderNumbers()
 
+	log.Println("Order service running on :8004")
+	if err := server.Run(":8004", middleware.TrimTrailingSlash(newRouter())); err != nil {
+		log.Fatal("Server error:", err)
+	}
+}
+
+// newRouter registers the order service's routes.
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.Use(middleware.CORS)
+	r.Use(middleware.LogRequestBodies)
 
 	r.HandleFunc("/health", healthCheck).Methods("GET")
 	r.HandleFunc("/orders", createOrder).Methods("POST")
-	r.HandleFunc("/orders/user/{user_id}", getOrdersByUser).Methods("GET")
-	r.HandleFunc("/orders/{id}", getOrder).Methods("GET")
+	r.Handle("/orders/export", middleware.AuthMiddleware(middleware.RequireRole("admin")(http.HandlerFunc(exportOrders)))).Methods("GET")
+	r.Handle("/orders/user/{user_id}", middleware.AuthMiddleware(http.HandlerFunc(getOrdersByUser))).Methods("GET")
+	r.Handle("/orders/user/{user_id}", middleware.AuthMiddleware(http.HandlerFunc(deleteOrdersByUser))).Methods("DELETE")
+	r.Handle("/orders/user/{user_id}/receipts", middleware.AuthMiddleware(http.HandlerFunc(getReceiptsByUser))).Methods("GET")
+	r.Handle("/orders/number/{order_number}", middleware.AuthMiddleware(http.HandlerFunc(getOrderByNumber))).Methods("GET")
+	r.Handle("/orders/{id}", middleware.AuthMiddleware(http.HandlerFunc(getOrder))).Methods("GET")
 	r.HandleFunc("/orders/{id}/status", updateOrderStatus).Methods("PATCH")
 	r.HandleFunc("/orders/{id}/payment", updatePaymentStatus).Methods("PATCH")
-
-	log.Println("Order service running on :8004")
-	log.Fatal(http.ListenAndServe(":8004", r))
+	r.Handle("/orders/{id}/shipments", middleware.AuthMiddleware(middleware.RequireRole("admin")(http.HandlerFunc(createShipment)))).Methods("POST")
+	r.Handle("/orders/{id}/tracking", middleware.AuthMiddleware(http.HandlerFunc(getOrderTracking))).Methods("GET")
+	r.Handle("/orders/{id}/items", middleware.AuthMiddleware(http.HandlerFunc(addOrderItem))).Methods("POST")
+	r.Handle("/orders/{id}/items/{item_id}", middleware.AuthMiddleware(http.HandlerFunc(updateOrderItem))).Methods("PUT")
+	r.Handle("/orders/{id}/items/{item_id}", middleware.AuthMiddleware(http.HandlerFunc(removeOrderItem))).Methods("DELETE")
+	r.Handle("/orders/{id}/return", featureflags.Gate("order_returns", true)(middleware.AuthMiddleware(http.HandlerFunc(returnOrderItems)))).Methods("POST")
+
+	return r
 }
 
 func initDB() {
@@ -81,6 +152,39 @@ func initDB() {
 			quantity INT NOT NULL,
 			price DECIMAL(10,2) NOT NULL
 		)`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_amount DECIMAL(10,2) NOT NULL DEFAULT 0`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS billing_address TEXT`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS source VARCHAR(20) NOT NULL DEFAULT 'web'`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS order_number VARCHAR(40) UNIQUE`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS cart_version VARCHAR(64)`,
+		`CREATE INDEX IF NOT EXISTS idx_orders_cart_version ON orders (user_id, cart_version) WHERE cart_version IS NOT NULL`,
+		`CREATE TABLE IF NOT EXISTS order_adjustments (
+			id SERIAL PRIMARY KEY,
+			order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+			seq INT NOT NULL,
+			code VARCHAR(50),
+			kind VARCHAR(20) NOT NULL,
+			amount DECIMAL(10,2) NOT NULL,
+			total_after DECIMAL(10,2) NOT NULL
+		)`,
+		`ALTER TABLE order_adjustments ADD COLUMN IF NOT EXISTS value DECIMAL(10,2)`,
+		`CREATE TABLE IF NOT EXISTS order_returns (
+			id SERIAL PRIMARY KEY,
+			order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+			order_item_id INT NOT NULL REFERENCES order_items(id) ON DELETE CASCADE,
+			quantity INT NOT NULL CHECK (quantity > 0),
+			amount DECIMAL(10,2) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS shipments (
+			id SERIAL PRIMARY KEY,
+			order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+			carrier VARCHAR(50) NOT NULL,
+			tracking_number VARCHAR(100) NOT NULL,
+			last_status VARCHAR(50),
+			last_checked_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
 	}
 
 	for _, query := range queries {
@@ -102,6 +206,34 @@ func createOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// An explicit source in the body wins over the client header.
+	if order.Source == "" {
+		order.Source = r.Header.Get("X-Client")
+	}
+
+	if v := validateOrder(&order); !v.Valid() {
+		validation.WriteError(w, v.Errors())
+		return
+	}
+
+	v, err := priceOrderItems(order.Items)
+	if err != nil {
+		log.Printf("Create order: failed to price items: %v", err)
+		http.Error(w, "Product service unavailable", http.StatusBadGateway)
+		return
+	}
+	if !v.Valid() {
+		validation.WriteError(w, v.Errors())
+		return
+	}
+	order.TotalAmount = orderSubtotal(order.Items)
+
+	if v := checkOrderLimits(&order); !v.Valid() {
+		log.Printf("REVIEW: order for user %d rejected by sanity caps: %v", order.UserID, v.Errors())
+		validation.WriteError(w, v.Errors())
+		return
+	}
+
 	tx, err := db.Begin()
 	if err != nil {
 		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
@@ -109,12 +241,53 @@ func createOrder(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	err = tx.QueryRow(
-		`INSERT INTO orders (user_id, total_amount, shipping_address, payment_method, status, payment_status)
-		 VALUES ($1, $2, $3, $4, 'pending', 'pending') RETURNING id, created_at, updated_at`,
-		order.UserID, order.TotalAmount, order.ShippingAddr, order.PaymentMethod,
-	).Scan(&order.ID, &order.CreatedAt, &order.UpdatedAt)
+	if err := lockCheckout(tx, order.UserID); err != nil {
+		http.Error(w, "Failed to create order", http.StatusInternalServerError)
+		return
+	}
+	existing, err := findCartOrder(tx, order.UserID, order.CartVersion)
+	if err == nil {
+		writeDuplicateCheckout(w, existing)
+		return
+	}
+	if err != sql.ErrNoRows {
+		http.Error(w, "Failed to create order", http.StatusInternalServerError)
+		return
+	}
+
+	// The cart coupon is redeemed only once the order is known not to be a
+	// duplicate, and handed back if the order is not saved after all.
+	authorization := r.Header.Get("Authorization")
+	committed := false
+	defer func() {
+		if !committed {
+			releaseOrderCoupon(&order, authorization)
+		}
+	}()
+	if err := resolvePromotions(&order, authorization); err != nil {
+		var rejected couponRejectedError
+		if errors.As(err, &rejected) {
+			v := validation.New()
+			v.Check(false, "coupon", rejected.Error())
+			validation.WriteError(w, v.Errors())
+			return
+		}
+		log.Printf("Create order: failed to resolve promotions for user %d: %v", order.UserID, err)
+		http.Error(w, "Failed to look up promotions", http.StatusBadGateway)
+		return
+	}
+
+	if len(order.Promotions) > 0 {
+		if err := applyPromotions(&order); err != nil {
+			v := validation.New()
+			v.Check(false, "promotions", err.Error())
+			validation.WriteError(w, v.Errors())
+			return
+		}
+	}
+	order.TaxAmount = orderTax(&order)
 
+	err = insertOrder(tx, &order)
 	if err != nil {
 		http.Error(w, "Failed to create order", http.StatusInternalServerError)
 		return
@@ -132,28 +305,317 @@ func createOrder(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if err := insertOrderAdjustments(tx, order.ID, order.Adjustments); err != nil {
+		http.Error(w, "Failed to record order adjustments", http.StatusInternalServerError)
+		return
+	}
+
+	// Stock is taken last, through the product service's guarded reserve, so
+	// two checkouts can't both buy the last unit. It is put back if the
+	// order isn't committed.
+	if item, err := reserveOrderStock(&order); err != nil {
+		if errors.Is(err, errInsufficientStock) {
+			http.Error(w, fmt.Sprintf("Insufficient stock for product %d", item.ProductID), http.StatusConflict)
+			return
+		}
+		log.Printf("Create order %d: failed to reserve stock for product %d: %v", order.ID, item.ProductID, err)
+		http.Error(w, "Failed to reserve stock", http.StatusBadGateway)
+		return
+	}
+
 	if err = tx.Commit(); err != nil {
+		releaseOrderStock(&order, order.Items)
 		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
 		return
 	}
+	committed = true
 
 	order.Status = "pending"
 	order.PaymentStatus = "pending"
+	notifyOrderConfirmed(order)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(order)
 }
 
+const maxAddressLength = 500
+
+const maxCartVersionLength = 64
+
+const defaultOrderSource = "web"
+
+var validOrderSources = map[string]bool{
+	"web":    true,
+	"mobile": true,
+	"api":    true,
+}
+
+// validateOrder normalizes the order's addresses, defaulting the billing
+// address to the shipping address when omitted, and checks each item.
+func validateOrder(order *Order) *validation.Validator {
+	v := validation.New()
+
+	order.ShippingAddr = strings.TrimSpace(order.ShippingAddr)
+	order.BillingAddr = strings.TrimSpace(order.BillingAddr)
+	if order.BillingAddr == "" {
+		order.BillingAddr = order.ShippingAddr
+	}
+	order.Source = strings.ToLower(strings.TrimSpace(order.Source))
+	if order.Source == "" {
+		order.Source = defaultOrderSource
+	}
+
+	v.Check(order.UserID > 0, "user_id", "is required")
+	v.Check(order.ShippingAddr != "", "shipping_address", "is required")
+	v.Check(len(order.ShippingAddr) <= maxAddressLength, "shipping_address", "is too long")
+	v.Check(len(order.BillingAddr) <= maxAddressLength, "billing_address", "is too long")
+	v.Check(order.CartVersion != "", "cart_version", "is required")
+	v.Check(len(order.CartVersion) <= maxCartVersionLength, "cart_version", "is too long")
+	v.Check(order.StoreCredit >= 0, "store_credit", "must not be negative")
+	v.Check(validOrderSources[order.Source], "source", "must be one of web, mobile, api")
+
+	items := v.Field("items")
+	if len(order.Items) == 0 {
+		items.Fail("must contain at least one item")
+	}
+	for i, item := range order.Items {
+		iv := items.Index(i)
+		iv.Check(item.ProductID > 0, "product_id", "is required")
+		iv.Check(item.Quantity > 0, "quantity", "must be positive")
+	}
+
+	return v
+}
+
+// promoRules decides which promotions may be combined on one order.
+var promoRules = promo.RulesFromEnv()
+
+// priceOrderItems replaces each item's name and price with the catalog's, so
+// an order costs what its products cost rather than what the client sent.
+// Unknown products are reported in the returned validator.
+func priceOrderItems(items []OrderItem) (*validation.Validator, error) {
+	v := validation.New()
+	fields := v.Field("items")
+	for i := range items {
+		product, err := fetchProduct(items[i].ProductID)
+		if err == errProductNotFound {
+			fields.Index(i).Check(false, "product_id", "does not exist")
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("product %d: %w", items[i].ProductID, err)
+		}
+		items[i].Name = product.Name
+		items[i].Price = product.Price
+	}
+	return v, nil
+}
+
+// orderSubtotal is the sum of price times quantity over the items, rounded
+// to cents. It is the order total before promotions; any total_amount in the
+// request is ignored.
+func orderSubtotal(items []OrderItem) float64 {
+	subtotal := 0.0
+	for _, item := range items {
+		subtotal += item.Price * float64(item.Quantity)
+	}
+	return pricing.Round(subtotal)
+}
+
+// resolvePromotions sets the order's promotions from server-side state: the
+// coupon on the user's cart, redeemed against the order's subtotal, and store
+// credit up to the smaller of store_credit and the user's balance. Both need
+// the caller's token, so an anonymous order gets no promotions.
+func resolvePromotions(order *Order, authorization string) error {
+	order.Promotions = nil
+	if authorization == "" {
+		return nil
+	}
+
+	coupon, err := redeemCartCoupon(order.UserID, orderSubtotal(order.Items), authorization)
+	if err != nil {
+		return fmt.Errorf("cart coupon: %w", err)
+	}
+	if coupon != nil {
+		order.Promotions = append(order.Promotions, *coupon)
+	}
+
+	if order.StoreCredit > 0 {
+		balance, err := fetchStoreCredit(order.UserID, authorization)
+		if err != nil {
+			return fmt.Errorf("store credit: %w", err)
+		}
+		if credit := math.Min(order.StoreCredit, balance); credit > 0 {
+			order.Promotions = append(order.Promotions, promo.Promo{Kind: promo.KindCredit, Value: credit})
+		}
+	}
+	return nil
+}
+
+// releaseOrderCoupon hands back the coupon resolvePromotions redeemed for an
+// order that was not saved. Failures are logged; the use stays counted.
+func releaseOrderCoupon(order *Order, authorization string) {
+	for _, p := range order.Promotions {
+		if p.Kind == promo.KindCredit || p.Code == "" {
+			continue
+		}
+		if err := releaseCartCoupon(order.UserID, p.Code, authorization); err != nil {
+			log.Printf("Create order: failed to release coupon %s for user %d: %v", p.Code, order.UserID, err)
+		}
+	}
+}
+
+// reserveOrderStock reserves each item's quantity for the order. If one
+// can't be reserved, the items before it are put back and the failing item
+// is returned with the error.
+func reserveOrderStock(order *Order) (*OrderItem, error) {
+	for i := range order.Items {
+		item := &order.Items[i]
+		if err := reserveStock(item.ProductID, item.Quantity, int(order.ID)); err != nil {
+			releaseOrderStock(order, order.Items[:i])
+			return item, err
+		}
+	}
+	return nil, nil
+}
+
+// releaseOrderStock puts back the stock reserved for items of an order that
+// was not saved. Failures are logged; that stock stays reserved.
+func releaseOrderStock(order *Order, items []OrderItem) {
+	for _, item := range items {
+		if err := adjustStock(item.ProductID, item.Quantity, "order_cancel", int(order.ID)); err != nil {
+			log.Printf("Create order %d: failed to release stock for product %d: %v", order.ID, item.ProductID, err)
+		}
+	}
+}
+
+// applyPromotions runs the order's promotions over its item subtotal. The
+// order total becomes the discounted total; store credit only lowers the
+// amount due, since it is settled as part of payment.
+func applyPromotions(order *Order) error {
+	result, err := promo.Apply(orderSubtotal(order.Items), order.Promotions, promoRules)
+	if err != nil {
+		return err
+	}
+
+	order.TotalAmount = result.Discounted
+	order.Adjustments = result.Adjustments
+	order.AmountDue = &result.Due
+	return nil
+}
+
+// insertOrderAdjustments records the adjustments applied to an order, in
+// the order they were applied.
+func insertOrderAdjustments(tx *sql.Tx, orderID uint, adjustments []promo.Adjustment) error {
+	for i, adj := range adjustments {
+		_, err := tx.Exec(
+			`INSERT INTO order_adjustments (order_id, seq, code, kind, value, amount, total_after)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			orderID, i+1, adj.Code, adj.Kind, adj.Value, adj.Amount, adj.TotalAfter,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderAdjustmentsQuery reads an order's adjustments in the order they were
+// applied. Rows recorded before the promo value was stored get it back from
+// the amounts: a percentage of what was left, or the amount itself.
+const orderAdjustmentsQuery = `SELECT COALESCE(code, ''), kind,
+	COALESCE(value, CASE WHEN kind = 'percent' AND total_after + amount > 0 THEN ROUND(amount * 100 / (total_after + amount), 2) ELSE amount END),
+	amount, total_after
+	FROM order_adjustments WHERE order_id = $1 ORDER BY seq`
+
+// orderTax is the sales tax on the order's discounted total for the region
+// in its billing address. Orders billed outside a known region record none.
+// Tax is kept for accounting and is not added to the total.
+func orderTax(order *Order) float64 {
+	region, err := pricing.RegionFromAddress(order.BillingAddr)
+	if err != nil {
+		return 0
+	}
+	tax, _ := pricing.Tax(region, order.TotalAmount)
+	return tax
+}
+
+// checkOrderLimits rejects orders whose item subtotal or item prices exceed
+// the configured caps. The subtotal is recomputed from the items, so the cap
+// holds whatever total the client claims.
+func checkOrderLimits(order *Order) *validation.Validator {
+	v := validation.New()
+
+	v.Check(orderSubtotal(order.Items) <= maxOrderTotal, "total_amount", fmt.Sprintf("exceeds the maximum order total of %.2f", maxOrderTotal))
+
+	items := v.Field("items")
+	for i, item := range order.Items {
+		items.Index(i).Check(item.Price <= maxOrderItemPrice, "price", fmt.Sprintf("exceeds the maximum item price of %.2f", maxOrderItemPrice))
+	}
+
+	return v
+}
+
+// maxOrderPageSize caps how many orders or receipts one page may return.
+const maxOrderPageSize = 100
+
 func getOrdersByUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	userID := vars["user_id"]
+	userID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
 
-	rows, err := db.Query(
-		`SELECT id, user_id, status, total_amount, shipping_address, payment_method, payment_status, created_at, updated_at
-		 FROM orders WHERE user_id = $1 ORDER BY created_at DESC`,
-		userID,
-	)
+	// Listing another user's orders is reserved for admins.
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(uint(userID)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Without a cursor param the full list is returned as before; passing one
+	// (empty for the first page) pages through it by (created_at, id).
+	useCursor := r.URL.Query().Has("cursor")
+	cursor := r.URL.Query().Get("cursor")
+
+	query := `SELECT id, user_id, COALESCE(status, 'pending'), total_amount, tax_amount, COALESCE(shipping_address, ''), COALESCE(billing_address, shipping_address, ''), COALESCE(payment_method, ''), COALESCE(payment_status, 'pending'), source, COALESCE(order_number, ''), created_at, updated_at
+		 FROM orders WHERE user_id = $1`
+	args := []interface{}{userID}
+
+	pageSize := 0
+	if useCursor {
+		pageSize = 20
+		if limit := r.URL.Query().Get("limit"); limit != "" {
+			var err error
+			pageSize, err = strconv.Atoi(limit)
+			if err != nil || pageSize <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			if pageSize > maxOrderPageSize {
+				pageSize = maxOrderPageSize
+			}
+		}
+
+		if cursor != "" {
+			createdAt, id, err := pagination.DecodeCursor(cursor)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+			query += " AND (created_at, id) < ($2, $3)"
+			args = append(args, createdAt, id)
+		}
+
+		args = append(args, pageSize+1)
+		query += " ORDER BY created_at DESC, id DESC LIMIT $" + strconv.Itoa(len(args))
+	} else {
+		query += " ORDER BY created_at DESC"
+	}
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
 		return
@@ -163,7 +625,7 @@ func getOrdersByUser(w http.ResponseWriter, r *http.Request) {
 	orders := []Order{}
 	for rows.Next() {
 		var o Order
-		err := rows.Scan(&o.ID, &o.UserID, &o.Status, &o.TotalAmount, &o.ShippingAddr, &o.PaymentMethod, &o.PaymentStatus, &o.CreatedAt, &o.UpdatedAt)
+		err := rows.Scan(&o.ID, &o.UserID, &o.Status, &o.TotalAmount, &o.TaxAmount, &o.ShippingAddr, &o.BillingAddr, &o.PaymentMethod, &o.PaymentStatus, &o.Source, &o.OrderNumber, &o.CreatedAt, &o.UpdatedAt)
 		if err != nil {
 			continue
 		}
@@ -171,39 +633,177 @@ func getOrdersByUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(orders)
+	if !useCursor {
+		json.NewEncoder(w).Encode(orders)
+		return
+	}
+
+	nextCursor := ""
+	if len(orders) > pageSize {
+		orders = orders[:pageSize]
+		last := orders[len(orders)-1]
+		nextCursor = pagination.EncodeCursor(last.CreatedAt, last.ID)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"orders": orders, "next_cursor": nextCursor})
 }
 
-func getOrder(w http.ResponseWriter, r *http.Request) {
+// deleteOrdersByUser removes all of a user's orders (items and shipments
+// cascade). It backs account deletion in the user service.
+func deleteOrdersByUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	orderID := vars["id"]
+	userID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
 
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(uint(userID)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM orders WHERE user_id = $1", userID); err != nil {
+		http.Error(w, "Failed to delete orders", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type Receipt struct {
+	OrderID     uint      `json:"order_id"`
+	Date        time.Time `json:"date"`
+	TotalAmount float64   `json:"total_amount"`
+	Status      string    `json:"status"`
+	URL         string    `json:"url"`
+}
+
+// getReceiptsByUser lists summaries of a user's paid orders, linking to the
+// full order for the receipt detail.
+func getReceiptsByUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(uint(userID)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > maxOrderPageSize {
+		limit = 20
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	var total int
+	err = db.QueryRow(
+		"SELECT COUNT(*) FROM orders WHERE user_id = $1 AND payment_status = 'completed'",
+		userID,
+	).Scan(&total)
+	if err != nil {
+		http.Error(w, "Failed to fetch receipts", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query(
+		`SELECT id, created_at, total_amount, COALESCE(status, 'pending') FROM orders
+		 WHERE user_id = $1 AND payment_status = 'completed'
+		 ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3`,
+		userID, limit, offset,
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch receipts", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	receipts := []Receipt{}
+	for rows.Next() {
+		var rc Receipt
+		if err := rows.Scan(&rc.OrderID, &rc.Date, &rc.TotalAmount, &rc.Status); err != nil {
+			continue
+		}
+		rc.URL = "/api/orders/" + strconv.FormatUint(uint64(rc.OrderID), 10)
+		receipts = append(receipts, rc)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"receipts": receipts,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+func getOrder(w http.ResponseWriter, r *http.Request) {
+	writeOrder(w, r, "id", mux.Vars(r)["id"])
+}
+
+// getOrderByNumber looks an order up by its customer-facing order number.
+func getOrderByNumber(w http.ResponseWriter, r *http.Request) {
+	writeOrder(w, r, "order_number", mux.Vars(r)["order_number"])
+}
+
+// writeOrder sends the order whose column matches value, with its items and
+// adjustments. column is always a constant from the caller, never input.
+func writeOrder(w http.ResponseWriter, r *http.Request, column, value string) {
 	var order Order
 	err := db.QueryRow(
-		`SELECT id, user_id, status, total_amount, shipping_address, payment_method, payment_status, created_at, updated_at
-		 FROM orders WHERE id = $1`,
-		orderID,
-	).Scan(&order.ID, &order.UserID, &order.Status, &order.TotalAmount, &order.ShippingAddr, &order.PaymentMethod, &order.PaymentStatus, &order.CreatedAt, &order.UpdatedAt)
+		`SELECT id, user_id, COALESCE(status, 'pending'), total_amount, tax_amount, COALESCE(shipping_address, ''), COALESCE(billing_address, shipping_address, ''), COALESCE(payment_method, ''), COALESCE(payment_status, 'pending'), source, COALESCE(order_number, ''), created_at, updated_at
+		 FROM orders WHERE `+column+` = $1`,
+		value,
+	).Scan(&order.ID, &order.UserID, &order.Status, &order.TotalAmount, &order.TaxAmount, &order.ShippingAddr, &order.BillingAddr, &order.PaymentMethod, &order.PaymentStatus, &order.Source, &order.OrderNumber, &order.CreatedAt, &order.UpdatedAt)
 
 	if err != nil {
 		http.Error(w, "Order not found", http.StatusNotFound)
 		return
 	}
 
+	// Other customers' orders are reported as missing so ids can't be probed.
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(order.UserID) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
 	// Get order items
 	rows, err := db.Query(
 		"SELECT id, order_id, product_id, name, quantity, price FROM order_items WHERE order_id = $1",
-		orderID,
+		order.ID,
 	)
 	if err == nil {
 		defer rows.Close()
 		for rows.Next() {
 			var item OrderItem
-			rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Name, &item.Quantity, &item.Price)
+			if err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Name, &item.Quantity, &item.Price); err != nil {
+				continue
+			}
 			order.Items = append(order.Items, item)
 		}
 	}
 
+	adjRows, err := db.Query(orderAdjustmentsQuery, order.ID)
+	if err == nil {
+		defer adjRows.Close()
+		for adjRows.Next() {
+			var adj promo.Adjustment
+			if err := adjRows.Scan(&adj.Code, &adj.Kind, &adj.Value, &adj.Amount, &adj.TotalAfter); err != nil {
+				continue
+			}
+			order.Adjustments = append(order.Adjustments, adj)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(order)
 }
@@ -234,15 +834,29 @@ func updateOrderStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := db.Exec(
-		"UPDATE orders SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+	// The CTE locks the row and hands back the status it replaced, so only
+	// the request that actually moves an order to shipped notifies.
+	var userID, id uint
+	var previous string
+	err := db.QueryRow(
+		`WITH prev AS (SELECT id, COALESCE(status, 'pending') AS status FROM orders WHERE id = $2 FOR UPDATE)
+		 UPDATE orders SET status = $1, updated_at = CURRENT_TIMESTAMP FROM prev
+		 WHERE orders.id = prev.id RETURNING orders.id, orders.user_id, prev.status`,
 		update.Status, orderID,
-	)
+	).Scan(&id, &userID, &previous)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to update order status", http.StatusInternalServerError)
 		return
 	}
 
+	if update.Status == "shipped" && previous != "shipped" {
+		notifyOrderShipped(id, userID)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Order status updated", "status": update.Status})
 }
@@ -260,10 +874,11 @@ func updatePaymentStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	validStatuses := map[string]bool{
-		"pending":   true,
-		"completed": true,
-		"failed":    true,
-		"refunded":  true,
+		"pending":            true,
+		"completed":          true,
+		"failed":             true,
+		"refunded":           true,
+		"partially_refunded": true,
 	}
 
 	if !validStatuses[update.PaymentStatus] {
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+// useDB points the service at a scripted database for the rest of the test.
+func useDB(t *testing.T) *dbtest.DB {
+	t.Helper()
+	fake := dbtest.New(t)
+	prev := db
+	db = fake.DB
+	t.Cleanup(func() { db = prev })
+	return fake
+}
+
+// authorize signs a token for userID (with role, if any) and sets it on r.
+func authorize(t *testing.T, r *http.Request, userID uint, role string) *http.Request {
+	t.Helper()
+	claims := &middleware.Claims{
+		UserID: userID,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    middleware.GetJWTIssuer(),
+			Audience:  jwt.ClaimStrings{middleware.GetJWTAudience()},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(middleware.GetJWTSecret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// stubProducts serves GET /products/{id} from prices and points the product
+// client at it for the rest of the test.
+func stubProducts(t *testing.T, prices map[string]float64) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/products/"), "/reserve")
+		price, ok := prices[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": "Product " + id, "price": price, "stock": 100})
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("PRODUCT_SERVICE_URL", srv.URL)
+}
+
+func TestCheckOrderLimitsRejectsOverCapOrder(t *testing.T) {
+	order := &Order{Items: []OrderItem{{ProductID: 1, Quantity: 3, Price: maxOrderTotal / 2}}}
+
+	v := checkOrderLimits(order)
+	if v.Valid() {
+		t.Fatal("order over the total cap passed")
+	}
+	if got := v.Errors()[0].Field; got != "total_amount" {
+		t.Errorf("error field = %q, want total_amount", got)
+	}
+}
+
+func TestCheckOrderLimitsRejectsOverCapItemPrice(t *testing.T) {
+	order := &Order{Items: []OrderItem{{ProductID: 1, Quantity: 1, Price: maxOrderItemPrice + 1}}}
+
+	v := checkOrderLimits(order)
+	if v.Valid() {
+		t.Fatal("item over the price cap passed")
+	}
+	if got := v.Errors()[0].Field; got != "items[0].price" {
+		t.Errorf("error field = %q, want items[0].price", got)
+	}
+}
+
+func TestCheckOrderLimitsAcceptsNormalOrder(t *testing.T) {
+	order := &Order{Items: []OrderItem{{ProductID: 1, Quantity: 2, Price: 25}}}
+
+	if v := checkOrderLimits(order); !v.Valid() {
+		t.Fatalf("normal order rejected: %v", v.Errors())
+	}
+}
+
+func TestCheckOrderLimitsIgnoresClaimedTotal(t *testing.T) {
+	order := &Order{
+		TotalAmount: 1,
+		Items:       []OrderItem{{ProductID: 1, Quantity: 5, Price: maxOrderTotal / 4}},
+	}
+
+	if checkOrderLimits(order).Valid() {
+		t.Fatal("a low claimed total_amount let an over-cap order through")
+	}
+}
+
+func TestOrderSubtotal(t *testing.T) {
+	items := []OrderItem{
+		{Quantity: 3, Price: 0.1},
+		{Quantity: 2, Price: 19.99},
+	}
+	if got := orderSubtotal(items); got != 40.28 {
+		t.Errorf("orderSubtotal = %v, want 40.28", got)
+	}
+}
+
+func TestPriceOrderItemsUsesCatalogPrices(t *testing.T) {
+	stubProducts(t, map[string]float64{"1": 12.5, "2": 3})
+
+	items := []OrderItem{
+		{ProductID: 1, Name: "cheap", Quantity: 2, Price: 0.01},
+		{ProductID: 2, Quantity: 1},
+	}
+	v, err := priceOrderItems(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Valid() {
+		t.Fatalf("unexpected validation errors: %v", v.Errors())
+	}
+	if items[0].Price != 12.5 || items[0].Name != "Product 1" {
+		t.Errorf("item 0 = %+v, want catalog name and price", items[0])
+	}
+	if got := orderSubtotal(items); got != 28 {
+		t.Errorf("subtotal = %v, want 28", got)
+	}
+}
+
+func TestPriceOrderItemsReportsUnknownProduct(t *testing.T) {
+	stubProducts(t, map[string]float64{"1": 10})
+
+	v, err := priceOrderItems([]OrderItem{{ProductID: 1, Quantity: 1}, {ProductID: 9, Quantity: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Valid() || v.Errors()[0].Field != "items[1].product_id" {
+		t.Errorf("errors = %v, want items[1].product_id", v.Errors())
+	}
+}
+
+func TestValidateOrderStoresExplicitBillingAddress(t *testing.T) {
+	order := &Order{
+		UserID:       1,
+		ShippingAddr: "1 Ship St, Austin, TX 78701",
+		BillingAddr:  "  2 Bill Ave, Albany, NY 12207 ",
+		CartVersion:  "v1",
+		Items:        []OrderItem{{ProductID: 1, Quantity: 1}},
+	}
+
+	if v := validateOrder(order); !v.Valid() {
+		t.Fatalf("unexpected errors: %v", v.Errors())
+	}
+	if order.BillingAddr != "2 Bill Ave, Albany, NY 12207" {
+		t.Errorf("billing address = %q", order.BillingAddr)
+	}
+}
+
+func TestValidateOrderDefaultsBillingToShipping(t *testing.T) {
+	order := &Order{
+		UserID:       1,
+		ShippingAddr: "1 Ship St, Austin, TX 78701",
+		CartVersion:  "v1",
+		Items:        []OrderItem{{ProductID: 1, Quantity: 1}},
+	}
+
+	if v := validateOrder(order); !v.Valid() {
+		t.Fatalf("unexpected errors: %v", v.Errors())
+	}
+	if order.BillingAddr != order.ShippingAddr {
+		t.Errorf("billing address = %q, want the shipping address", order.BillingAddr)
+	}
+}
+
+func TestValidateOrderRejectsLongBillingAddress(t *testing.T) {
+	order := &Order{
+		UserID:       1,
+		ShippingAddr: "1 Ship St",
+		BillingAddr:  strings.Repeat("x", maxAddressLength+1),
+		CartVersion:  "v1",
+		Items:        []OrderItem{{ProductID: 1, Quantity: 1}},
+	}
+
+	v := validateOrder(order)
+	if v.Valid() || v.Errors()[0].Field != "billing_address" {
+		t.Errorf("errors = %v, want billing_address", v.Errors())
+	}
+}
+
+func TestOrderTaxUsesBillingRegion(t *testing.T) {
+	order := &Order{
+		TotalAmount:  100,
+		ShippingAddr: "1 Ship St, Portland, OR 97201",
+		BillingAddr:  "2 Bill Ave, Albany, NY 12207",
+		TaxAmount:    0.01,
+	}
+	if got := orderTax(order); got != 4 {
+		t.Errorf("orderTax = %v, want 4 (NY on 100)", got)
+	}
+
+	order.BillingAddr = "10 Downing St, London"
+	if got := orderTax(order); got != 0 {
+		t.Errorf("orderTax for an unknown region = %v, want 0", got)
+	}
+}
+
+func TestValidateOrderRequiresCartVersion(t *testing.T) {
+	order := &Order{
+		UserID:       1,
+		ShippingAddr: "1 Ship St",
+		Items:        []OrderItem{{ProductID: 1, Quantity: 1}},
+	}
+
+	v := validateOrder(order)
+	if v.Valid() || v.Errors()[0].Field != "cart_version" {
+		t.Errorf("errors = %v, want cart_version", v.Errors())
+	}
+
+	order.CartVersion = strings.Repeat("a", maxCartVersionLength+1)
+	if v := validateOrder(order); v.Valid() {
+		t.Error("over-long cart_version accepted")
+	}
+}
+
+func TestValidateOrderReportsItemPaths(t *testing.T) {
+	order := &Order{
+		UserID:       1,
+		ShippingAddr: "1 Main St, Austin, TX",
+		CartVersion:  "v1",
+		Items:        []OrderItem{{ProductID: 1, Quantity: 1}, {ProductID: 2, Quantity: 1}, {ProductID: 3, Quantity: 0}},
+	}
+
+	errs := validateOrder(order).Errors()
+	if len(errs) != 1 || errs[0].Field != "items[2].quantity" {
+		t.Errorf("errors = %v, want items[2].quantity", errs)
+	}
+}
+
+// placeOrder runs createOrder against a scripted database for a one-item
+// order and returns the response along with the database.
+func placeOrder(t *testing.T, body string, header http.Header) (*httptest.ResponseRecorder, *dbtest.DB) {
+	t.Helper()
+	stubNotifications(t, http.StatusOK)
+	fake := scriptOrderCreation(t)
+	return postOrder(body, header), fake
+}
+
+// stubNotifications points the service at a notification stub answering
+// status and returns the requests it receives, as "path body".
+func stubNotifications(t *testing.T, status int) <-chan string {
+	t.Helper()
+	received := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r.URL.Path + " " + string(body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("NOTIFICATION_SERVICE_URL", srv.URL)
+	return received
+}
+
+// scriptOrderCreation scripts a successful checkout of product 1 at 20 as
+// order 12.
+func scriptOrderCreation(t *testing.T) *dbtest.DB {
+	t.Helper()
+	stubProducts(t, map[string]float64{"1": 20})
+	fake := useDB(t)
+	fake.On(`pg_advisory_xact_lock`)
+	fake.On(`WHERE user_id = \$1 AND cart_version = \$2`)
+	fake.On(`SAVEPOINT`)
+	fake.On(`INSERT INTO orders`).Rows([]string{"id", "created_at", "updated_at"}, []interface{}{12, time.Now(), time.Now()})
+	fake.On(`INSERT INTO order_items`)
+	return fake
+}
+
+func postOrder(body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	createOrder(w, r)
+	return w
+}
+
+func TestCreateOrderRecordsSource(t *testing.T) {
+	const base = `"user_id": 7, "shipping_address": "1 Main St, Austin, TX", "cart_version": "v1", "items": [{"product_id": 1, "quantity": 1}]`
+	tests := []struct {
+		name   string
+		body   string
+		client string
+		want   string
+	}{
+		{"from the X-Client header", `{` + base + `}`, "Mobile", "mobile"},
+		{"body wins over the header", `{` + base + `, "source": "api"}`, "mobile", "api"},
+		{"defaults to web", `{` + base + `}`, "", "web"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.client != "" {
+				header.Set("X-Client", tt.client)
+			}
+			w, fake := placeOrder(t, tt.body, header)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			var order Order
+			json.NewDecoder(w.Body).Decode(&order)
+			if order.Source != tt.want {
+				t.Errorf("response source = %q, want %q", order.Source, tt.want)
+			}
+			inserts := fake.Matching(`INSERT INTO orders`)
+			if len(inserts) != 1 || inserts[0].Args[6] != tt.want {
+				t.Errorf("inserted source = %v, want %q", inserts[0].Args[6], tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateOrderRejectsUnknownSource(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Client", "fax")
+	w, fake := placeOrder(t, `{"user_id": 7, "shipping_address": "1 Main St, Austin, TX", "cart_version": "v1", "items": [{"product_id": 1, "quantity": 1}]}`, header)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"source"`) {
+		t.Errorf("status = %d, body = %s; want a 400 on source", w.Code, w.Body)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("an order with an unknown source reached the database")
+	}
+}
+
+// fetchOrder requests order 5, owned by user 7, as userID with role.
+func fetchOrder(t *testing.T, path string, userID uint, role string) (*httptest.ResponseRecorder, *dbtest.DB) {
+	t.Helper()
+	fake := useDB(t)
+	fake.On(`FROM orders WHERE (id|order_number) = \$1`).Rows(orderColumns, orderRow(5, time.Now()))
+	fake.On(`FROM order_items WHERE order_id = \$1`).Rows([]string{"id", "order_id", "product_id", "name", "quantity", "price"},
+		[]interface{}{1, 5, 4, "Lamp", 1, 10.0})
+	fake.On(`FROM order_adjustments`).Rows([]string{"code", "kind", "value", "amount", "total_after"})
+
+	r := httptest.NewRequest("GET", path, nil)
+	if userID != 0 {
+		r = authorize(t, r, userID, role)
+	}
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w, fake
+}
+
+func TestGetOrderOwnOrder(t *testing.T) {
+	for _, path := range []string{"/orders/5", "/orders/number/ORD-1"} {
+		w, _ := fetchOrder(t, path, 7, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", path, w.Code, w.Body)
+		}
+		var order Order
+		json.NewDecoder(w.Body).Decode(&order)
+		if order.ID != 5 || len(order.Items) != 1 {
+			t.Errorf("%s: order = %+v", path, order)
+		}
+	}
+}
+
+func TestGetOrderHidesOtherCustomersOrders(t *testing.T) {
+	w, fake := fetchOrder(t, "/orders/5", 8, "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "Main St") {
+		t.Error("response leaked the order's address")
+	}
+	if len(fake.Matching(`order_items`)) != 0 {
+		t.Error("items were loaded for another customer's order")
+	}
+
+	if w, _ := fetchOrder(t, "/orders/5", 1, "admin"); w.Code != http.StatusOK {
+		t.Errorf("admin: status = %d, want 200", w.Code)
+	}
+	if w, _ := fetchOrder(t, "/orders/5", 0, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("no token: status = %d, want 401", w.Code)
+	}
+}
+
+func TestGetOrderNullOptionalColumns(t *testing.T) {
+	// The scripted row is what Postgres returns for an order with no
+	// addresses, payment method or number, which it only does if the query
+	// coalesces them.
+	fake := useDB(t)
+	fake.On(`COALESCE\(shipping_address, ''\), COALESCE\(billing_address, shipping_address, ''\), COALESCE\(payment_method, ''\), COALESCE\(payment_status, 'pending'\), source, COALESCE\(order_number, ''\), .* FROM orders WHERE id = \$1`).
+		Rows(orderColumns, []interface{}{5, 7, "pending", 10.0, 0.0, "", "", "", "pending", "web", "", time.Now(), nil})
+	fake.On(`FROM order_items WHERE order_id = \$1`).Rows([]string{"id", "order_id", "product_id", "name", "quantity", "price"})
+	fake.On(`FROM order_adjustments`).Rows([]string{"code", "kind", "value", "amount", "total_after"})
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, authorize(t, httptest.NewRequest("GET", "/orders/5", nil), 7, ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var order Order
+	json.NewDecoder(w.Body).Decode(&order)
+	if order.ShippingAddr != "" || order.PaymentMethod != "" || order.PaymentStatus != "pending" {
+		t.Errorf("order = %+v, want empty defaults", order)
+	}
+}
+
+func TestGetOrderRendersUnsetUpdatedAtAsNull(t *testing.T) {
+	// orderRow leaves updated_at NULL.
+	w, _ := fetchOrder(t, "/orders/5", 7, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"updated_at":null`) || strings.Contains(body, "0001-01-01") {
+		t.Errorf("body = %s, want updated_at null", body)
+	}
+}
+
+func TestCreateOrderSendsOneConfirmation(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		notifications := stubNotifications(t, status)
+		scriptOrderCreation(t)
+
+		w := postOrder(`{"user_id": 7, "shipping_address": "1 Main St, Austin, TX", "cart_version": "v1", "items": [{"product_id": 1, "quantity": 2}]}`, nil)
+		// A failing notification service never fails the order.
+		if w.Code != http.StatusCreated {
+			t.Fatalf("notifications answering %d: status = %d: %s", status, w.Code, w.Body)
+		}
+
+		select {
+		case got := <-notifications:
+			if !strings.HasPrefix(got, "/notifications/order-confirmation ") ||
+				!strings.Contains(got, `"order_id":12`) || !strings.Contains(got, `"user_id":7`) {
+				t.Errorf("notification = %s, want order 12's confirmation for user 7", got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no confirmation sent")
+		}
+		select {
+		case got := <-notifications:
+			t.Errorf("second notification %s", got)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestFailedOrderSendsNoConfirmation(t *testing.T) {
+	notifications := stubNotifications(t, http.StatusOK)
+	stubProducts(t, map[string]float64{"1": 20})
+	fake := useDB(t)
+	fake.On(`pg_advisory_xact_lock`)
+	fake.On(`WHERE user_id = \$1 AND cart_version = \$2`)
+	fake.On(`SAVEPOINT`)
+	fake.On(`INSERT INTO orders`).Rows([]string{"id", "created_at", "updated_at"}, []interface{}{12, time.Now(), time.Now()})
+	fake.On(`INSERT INTO order_items`).Err(errors.New("connection reset"))
+
+	w := postOrder(`{"user_id": 7, "shipping_address": "1 Main St, Austin, TX", "cart_version": "v1", "items": [{"product_id": 1, "quantity": 2}]}`, nil)
+	if w.Code == http.StatusCreated {
+		t.Fatal("order created despite the failed insert")
+	}
+	select {
+	case got := <-notifications:
+		t.Errorf("confirmation %s sent for an order that was rolled back", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestShippingUpdateOnlyOnShippedTransition(t *testing.T) {
+	tests := []struct {
+		previous, next string
+		notify         bool
+	}{
+		{"processing", "shipped", true},
+		{"shipped", "shipped", false},
+		{"shipped", "delivered", false},
+		{"pending", "confirmed", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.previous+" to "+tt.next, func(t *testing.T) {
+			notifications := stubNotifications(t, http.StatusOK)
+			fake := useDB(t)
+			fake.On(`^WITH prev AS .* UPDATE orders SET status`).Rows([]string{"id", "user_id", "status"}, []interface{}{5, 7, tt.previous})
+			fake.On(`SELECT tracking_number FROM shipments WHERE order_id = \$1`).Rows([]string{"tracking_number"}, []interface{}{"1Z999"})
+
+			r := httptest.NewRequest("PATCH", "/orders/5/status", strings.NewReader(`{"status": "`+tt.next+`"}`))
+			w := httptest.NewRecorder()
+			newRouter().ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			select {
+			case got := <-notifications:
+				if !tt.notify {
+					t.Errorf("unexpected notification %s", got)
+				} else if !strings.HasPrefix(got, "/notifications/shipping-update ") ||
+					!strings.Contains(got, `"tracking_number":"1Z999"`) || !strings.Contains(got, `"order_id":5`) {
+					t.Errorf("notification = %s, want order 5's shipping update with its tracking number", got)
+				}
+			case <-time.After(notifyWait(tt.notify)):
+				if tt.notify {
+					t.Error("no shipping update sent")
+				}
+			}
+		})
+	}
+}
+
+func TestShippingUpdateWithoutTracking(t *testing.T) {
+	notifications := stubNotifications(t, http.StatusOK)
+	fake := useDB(t)
+	fake.On(`^WITH prev AS`).Rows([]string{"id", "user_id", "status"}, []interface{}{5, 7, "processing"})
+	fake.On(`SELECT tracking_number FROM shipments`)
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("PATCH", "/orders/5/status", strings.NewReader(`{"status": "shipped"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	select {
+	case got := <-notifications:
+		if !strings.Contains(got, `"tracking_number":""`) {
+			t.Errorf("notification = %s, want an empty tracking number", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no shipping update sent")
+	}
+}
+
+// notifyWait is how long to wait for a notification: long when one is
+// expected, just long enough to catch a stray one otherwise.
+func notifyWait(expected bool) time.Duration {
+	if expected {
+		return 2 * time.Second
+	}
+	return 100 * time.Millisecond
+}
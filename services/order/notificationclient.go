@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+var notificationClient = &http.Client{Timeout: 5 * time.Second}
+
+func notificationServiceURL() string {
+	if url := os.Getenv("NOTIFICATION_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://notification-service:8006"
+}
+
+// postNotification sends payload to one of the notification service's
+// template endpoints, e.g. "/notifications/order-confirmation".
+func postNotification(path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notificationClient.Post(notificationServiceURL()+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The template endpoints answer 200, the generic one 201.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("notification service returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notifyOrderConfirmed sends the order confirmation in the background. It
+// runs after the order is committed; a failure is logged and never affects
+// the order.
+func notifyOrderConfirmed(order Order) {
+	go func() {
+		err := postNotification("/notifications/order-confirmation", map[string]interface{}{
+			"user_id":  order.UserID,
+			"order_id": order.ID,
+			"total":    order.TotalAmount,
+		})
+		if err != nil {
+			log.Printf("Order confirmation for order %d not sent: %v", order.ID, err)
+		}
+	}()
+}
+
+// notifyOrderShipped sends the shipping update for an order that just moved
+// to shipped, quoting its latest tracking number when one is on file.
+func notifyOrderShipped(orderID, userID uint) {
+	go func() {
+		var trackingNumber string
+		err := db.QueryRow(
+			"SELECT tracking_number FROM shipments WHERE order_id = $1 ORDER BY created_at DESC, id DESC LIMIT 1",
+			orderID,
+		).Scan(&trackingNumber)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Shipping update for order %d: tracking lookup failed: %v", orderID, err)
+		}
+
+		err = postNotification("/notifications/shipping-update", map[string]interface{}{
+			"user_id":         userID,
+			"order_id":        orderID,
+			"status":          "shipped",
+			"tracking_number": trackingNumber,
+		})
+		if err != nil {
+			log.Printf("Shipping update for order %d not sent: %v", orderID, err)
+		}
+	}()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Order numbers look like ORD-2024-483920: a configurable prefix, the year and
+// a random run of digits. They are random rather than derived from the id so
+// they don't reveal how many orders have been placed.
+var (
+	orderNumberPrefix = orderNumberPrefixFromEnv()
+	orderNumberDigits = orderNumberDigitsFromEnv()
+)
+
+const maxOrderNumberAttempts = 5
+
+func orderNumberPrefixFromEnv() string {
+	if v := os.Getenv("ORDER_NUMBER_PREFIX"); v != "" {
+		return v
+	}
+	return "ORD"
+}
+
+func orderNumberDigitsFromEnv() int {
+	if v := os.Getenv("ORDER_NUMBER_DIGITS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 4 && n <= 18 {
+			return n
+		}
+		log.Printf("Invalid ORDER_NUMBER_DIGITS %q, using 6", v)
+	}
+	return 6
+}
+
+func newOrderNumber(now time.Time) (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(orderNumberDigits)), nil)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d-%0*d", orderNumberPrefix, now.Year(), orderNumberDigits, n), nil
+}
+
+// insertOrder inserts the order with a fresh order number, drawing a new one
+// if it collides. The savepoint keeps tx usable after a failed insert.
+func insertOrder(tx *sql.Tx, order *Order) error {
+	for attempt := 1; ; attempt++ {
+		number, err := newOrderNumber(time.Now())
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("SAVEPOINT order_insert"); err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(
+			`INSERT INTO orders (user_id, total_amount, tax_amount, shipping_address, billing_address, payment_method, source, order_number, cart_version, status, payment_status)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), 'pending', 'pending') RETURNING id, created_at, updated_at`,
+			order.UserID, order.TotalAmount, order.TaxAmount, order.ShippingAddr, order.BillingAddr, order.PaymentMethod, order.Source, number, order.CartVersion,
+		).Scan(&order.ID, &order.CreatedAt, &order.UpdatedAt)
+		if err == nil {
+			order.OrderNumber = number
+			return nil
+		}
+		if !isOrderNumberConflict(err) || attempt == maxOrderNumberAttempts {
+			return err
+		}
+
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT order_insert"); err != nil {
+			return err
+		}
+	}
+}
+
+func isOrderNumberConflict(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "23505" && pqErr.Constraint == "orders_order_number_key"
+}
+
+// backfillOrderNumbers numbers orders created before order numbers existed.
+// They get the id in place of the random part, which is unique among them.
+func backfillOrderNumbers() {
+	_, err := db.Exec(
+		`UPDATE orders SET order_number = $1 || '-' || EXTRACT(YEAR FROM COALESCE(created_at, CURRENT_TIMESTAMP))::int || '-' || LPAD(id::text, $2, '0')
+		 WHERE order_number IS NULL`,
+		orderNumberPrefix, orderNumberDigits,
+	)
+	if err != nil {
+		log.Printf("Failed to backfill order numbers: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestNewOrderNumberFormat(t *testing.T) {
+	prevPrefix, prevDigits := orderNumberPrefix, orderNumberDigits
+	t.Cleanup(func() { orderNumberPrefix, orderNumberDigits = prevPrefix, prevDigits })
+
+	t.Setenv("ORDER_NUMBER_PREFIX", "SHOP")
+	t.Setenv("ORDER_NUMBER_DIGITS", "8")
+	orderNumberPrefix, orderNumberDigits = orderNumberPrefixFromEnv(), orderNumberDigitsFromEnv()
+
+	format := regexp.MustCompile(`^SHOP-2026-\d{8}$`)
+	for i := 0; i < 50; i++ {
+		number, err := newOrderNumber(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+		if err != nil || !format.MatchString(number) {
+			t.Fatalf("newOrderNumber = %q, %v", number, err)
+		}
+	}
+
+	t.Setenv("ORDER_NUMBER_DIGITS", "40")
+	if n := orderNumberDigitsFromEnv(); n != 6 {
+		t.Errorf("out of range digits gave %d, want 6", n)
+	}
+}
+
+func TestInsertOrderRetriesNumberCollision(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`SAVEPOINT order_insert`)
+	fake.On(`INSERT INTO orders`).Err(&pq.Error{Code: "23505", Constraint: "orders_order_number_key"}).Times(1)
+	fake.On(`INSERT INTO orders`).Rows([]string{"id", "created_at", "updated_at"}, []interface{}{5, time.Now(), nil})
+
+	tx, err := fake.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	order := &Order{UserID: 7}
+	if err := insertOrder(tx, order); err != nil {
+		t.Fatal(err)
+	}
+
+	inserts := fake.Matching(`INSERT INTO orders`)
+	if len(inserts) != 2 || len(fake.Matching(`ROLLBACK TO SAVEPOINT`)) != 1 {
+		t.Fatalf("inserts = %d, rollbacks to savepoint = %d; want a retry", len(inserts), len(fake.Matching(`ROLLBACK TO`)))
+	}
+	if inserts[1].Args[7] == inserts[0].Args[7] {
+		t.Error("the retry reused the colliding number")
+	}
+	if order.ID != 5 || order.OrderNumber != inserts[1].Args[7] {
+		t.Errorf("order = %d %q, want 5 with the second number", order.ID, order.OrderNumber)
+	}
+}
+
+func TestInsertOrderDoesNotRetryOtherConflicts(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`SAVEPOINT order_insert`)
+	fake.On(`INSERT INTO orders`).Err(&pq.Error{Code: "23505", Constraint: "orders_cart_version_key"})
+
+	tx, _ := fake.DB.Begin()
+	defer tx.Rollback()
+	if err := insertOrder(tx, &Order{UserID: 7}); err == nil {
+		t.Fatal("insertOrder succeeded")
+	}
+	if n := len(fake.Matching(`INSERT INTO orders`)); n != 1 {
+		t.Errorf("%d inserts, want 1", n)
+	}
+}
+
+func TestGetOrderByNumber(t *testing.T) {
+	w, fake := fetchOrder(t, "/orders/number/ORD-2026-000123", 7, "")
+	if w.Code != 200 {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	lookups := fake.Matching(`FROM orders WHERE order_number = \$1`)
+	if len(lookups) != 1 || lookups[0].Args[0] != "ORD-2026-000123" {
+		t.Errorf("lookups = %+v", lookups)
+	}
+}
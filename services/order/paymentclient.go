@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+var paymentClient = &http.Client{Timeout: 5 * time.Second}
+
+func paymentServiceURL() string {
+	if url := os.Getenv("PAYMENT_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://payment-service:8005"
+}
+
+var errNoPayment = fmt.Errorf("order has no payment")
+
+// fetchStoreCredit returns the user's store credit balance, forwarding the
+// caller's Authorization header.
+func fetchStoreCredit(userID uint, authorization string) (float64, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/payments/credit/%d", paymentServiceURL(), userID), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := paymentClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("payment service returned %d", resp.StatusCode)
+	}
+
+	var credit struct {
+		Balance float64 `json:"balance"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&credit); err != nil {
+		return 0, err
+	}
+	return credit.Balance, nil
+}
+
+// refundOrderPayment refunds amount against the order's latest payment,
+// forwarding the caller's Authorization header.
+func refundOrderPayment(orderID uint, amount float64, authorization string) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/payments/order/%d", paymentServiceURL(), orderID), nil)
+	if err != nil {
+		return err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := paymentClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNoPayment
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("payment service returned %d", resp.StatusCode)
+	}
+
+	var payment struct {
+		ID uint `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payment); err != nil {
+		return err
+	}
+
+	payload, _ := json.Marshal(map[string]float64{"amount": amount})
+	req, err = http.NewRequest("POST", fmt.Sprintf("%s/payments/%d/refund", paymentServiceURL(), payment.ID), bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	refundResp, err := paymentClient.Do(req)
+	if err != nil {
+		return err
+	}
+	refundResp.Body.Close()
+
+	if refundResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("payment service refund returned %d", refundResp.StatusCode)
+	}
+	return nil
+}
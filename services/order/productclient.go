@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+var productClient = &http.Client{Timeout: 5 * time.Second}
+
+func productServiceURL() string {
+	if url := os.Getenv("PRODUCT_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://product-service:8002"
+}
+
+type productInfo struct {
+	ID             uint     `json:"id"`
+	Name           string   `json:"name"`
+	Price          float64  `json:"price"`
+	EffectivePrice *float64 `json:"effective_price"`
+	Stock          int      `json:"stock"`
+}
+
+var (
+	errProductNotFound   = fmt.Errorf("product not found")
+	errInsufficientStock = fmt.Errorf("insufficient stock")
+)
+
+// fetchProduct returns the product service's current name, price and stock.
+func fetchProduct(productID uint) (*productInfo, error) {
+	resp, err := productClient.Get(fmt.Sprintf("%s/products/%d", productServiceURL(), productID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errProductNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("product service returned %d", resp.StatusCode)
+	}
+
+	var p productInfo
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, err
+	}
+	// Charge the sale price while a sale is running.
+	if p.EffectivePrice != nil {
+		p.Price = *p.EffectivePrice
+	}
+	return &p, nil
+}
+
+// reserveStock takes quantity units of a product for an order through the
+// product service's guarded reserve, which refuses to take stock below zero.
+// It fails with errInsufficientStock when there isn't enough.
+func reserveStock(productID uint, quantity, orderID int) error {
+	token, err := middleware.ServiceToken("order")
+	if err != nil {
+		return err
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"quantity":     quantity,
+		"reference_id": fmt.Sprintf("order:%d", orderID),
+	})
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/products/%d/reserve", productServiceURL(), productID), bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := productClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		return errInsufficientStock
+	case http.StatusNotFound:
+		return errProductNotFound
+	default:
+		return fmt.Errorf("product service returned %d", resp.StatusCode)
+	}
+}
+
+// adjustStock changes a product's stock by delta. It is used to put stock
+// back; taking it goes through reserveStock, which can't oversell. The
+// reason and order ID are recorded in the product's stock history.
+func adjustStock(productID uint, delta int, reason string, orderID int) error {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"quantity":     delta,
+		"reason":       reason,
+		"reference_id": fmt.Sprintf("order:%d", orderID),
+	})
+	req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/products/%d/stock", productServiceURL(), productID), bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := productClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("product service returned %d", resp.StatusCode)
+	}
+	return nil
+}
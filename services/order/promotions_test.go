@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/promo"
+)
+
+// stubCartAndCredit serves the cart coupon and store credit balance the
+// order service looks up at checkout, and requires the forwarded token.
+func stubCartAndCredit(t *testing.T, cart map[string]interface{}, balance float64) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/cart/1/coupon/redeem":
+			if msg, ok := cart["error"]; ok {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]interface{}{"error": msg})
+				return
+			}
+			json.NewEncoder(w).Encode(cart)
+		case "/payments/credit/1":
+			json.NewEncoder(w).Encode(map[string]interface{}{"user_id": 1, "balance": balance})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("CART_SERVICE_URL", srv.URL)
+	t.Setenv("PAYMENT_SERVICE_URL", srv.URL)
+}
+
+func TestResolvePromotionsDiscardsClientPromotions(t *testing.T) {
+	stubCartAndCredit(t, map[string]interface{}{"coupon": nil}, 0)
+
+	order := &Order{UserID: 1, Promotions: []promo.Promo{{Kind: promo.KindPercent, Value: 100}}}
+	if err := resolvePromotions(order, "Bearer tok"); err != nil {
+		t.Fatal(err)
+	}
+	if len(order.Promotions) != 0 {
+		t.Errorf("promotions = %+v, want none", order.Promotions)
+	}
+}
+
+func TestResolvePromotionsUsesCartCouponAndCappedCredit(t *testing.T) {
+	stubCartAndCredit(t, map[string]interface{}{
+		"coupon": map[string]interface{}{"code": "SAVE10", "kind": "percent", "value": 10},
+	}, 7.5)
+
+	order := &Order{UserID: 1, StoreCredit: 20}
+	if err := resolvePromotions(order, "Bearer tok"); err != nil {
+		t.Fatal(err)
+	}
+	want := []promo.Promo{
+		{Code: "SAVE10", Kind: promo.KindPercent, Value: 10},
+		{Kind: promo.KindCredit, Value: 7.5},
+	}
+	if len(order.Promotions) != 2 || order.Promotions[0] != want[0] || order.Promotions[1] != want[1] {
+		t.Errorf("promotions = %+v, want %+v", order.Promotions, want)
+	}
+}
+
+func TestResolvePromotionsRejectsCouponThatNoLongerQualifies(t *testing.T) {
+	stubCartAndCredit(t, map[string]interface{}{"error": "coupon has expired"}, 0)
+
+	order := &Order{UserID: 1}
+	err := resolvePromotions(order, "Bearer tok")
+	var rejected couponRejectedError
+	if !errors.As(err, &rejected) || rejected.Error() != "coupon has expired" {
+		t.Fatalf("err = %v, want the cart service's rejection", err)
+	}
+}
+
+func TestResolvePromotionsAnonymousOrderGetsNone(t *testing.T) {
+	order := &Order{UserID: 1, StoreCredit: 5, Promotions: []promo.Promo{{Kind: promo.KindFixed, Value: 5}}}
+	if err := resolvePromotions(order, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(order.Promotions) != 0 {
+		t.Errorf("promotions = %+v, want none", order.Promotions)
+	}
+}
+
+func TestApplyPromotionsRecordsAdjustments(t *testing.T) {
+	order := &Order{
+		Items: []OrderItem{{ProductID: 1, Quantity: 2, Price: 50}},
+		Promotions: []promo.Promo{
+			{Kind: promo.KindCredit, Value: 15},
+			{Code: "SAVE10", Kind: promo.KindPercent, Value: 10},
+		},
+	}
+	if err := applyPromotions(order); err != nil {
+		t.Fatal(err)
+	}
+	if order.TotalAmount != 90 || order.AmountDue == nil || *order.AmountDue != 75 {
+		t.Errorf("total = %v, due = %v; want 90 and 75", order.TotalAmount, order.AmountDue)
+	}
+	if len(order.Adjustments) != 2 || order.Adjustments[0].Code != "SAVE10" || order.Adjustments[1].Kind != promo.KindCredit {
+		t.Errorf("adjustments = %+v", order.Adjustments)
+	}
+}
+
+func TestRedeemCartCouponSendsOrderSubtotal(t *testing.T) {
+	var got map[string]float64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/cart/4/coupon/redeem" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(map[string]interface{}{"coupon": map[string]interface{}{"code": "TEN", "kind": "fixed", "value": 10}})
+	}))
+	defer srv.Close()
+	t.Setenv("CART_SERVICE_URL", srv.URL)
+
+	coupon, err := redeemCartCoupon(4, 64.5, "Bearer tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["subtotal"] != 64.5 {
+		t.Errorf("redeemed against subtotal %v, want 64.5", got["subtotal"])
+	}
+	if coupon == nil || coupon.Code != "TEN" {
+		t.Errorf("coupon = %+v, want TEN", coupon)
+	}
+}
+
+func TestReleaseOrderCouponReleasesOnlyCoupons(t *testing.T) {
+	var released []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path == "/cart/1/coupon/release" {
+			released = append(released, body["code"])
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	defer srv.Close()
+	t.Setenv("CART_SERVICE_URL", srv.URL)
+
+	order := &Order{UserID: 1, Promotions: []promo.Promo{
+		{Code: "SAVE10", Kind: promo.KindPercent, Value: 10},
+		{Kind: promo.KindCredit, Value: 5},
+	}}
+	releaseOrderCoupon(order, "Bearer tok")
+	if len(released) != 1 || released[0] != "SAVE10" {
+		t.Errorf("released = %v, want [SAVE10]", released)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+// returnableStatuses are the order states in which items can be sent back.
+var returnableStatuses = map[string]bool{
+	"shipped":   true,
+	"delivered": true,
+}
+
+type ReturnLine struct {
+	OrderItemID uint    `json:"order_item_id"`
+	Quantity    int     `json:"quantity"`
+	ProductID   uint    `json:"product_id"`
+	Amount      float64 `json:"amount"`
+}
+
+// returnOrderItems records returned quantities of an order's items, refunds
+// their value through the payment service and puts them back in stock. The
+// refund is issued before the return is committed so a failed refund leaves
+// nothing recorded.
+func returnOrderItems(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Items []ReturnLine `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) == 0 {
+		http.Error(w, "items is required", http.StatusBadRequest)
+		return
+	}
+
+	var userID uint
+	var status, paymentStatus string
+	err = db.QueryRow(
+		"SELECT user_id, COALESCE(status, 'pending'), COALESCE(payment_status, 'pending') FROM orders WHERE id = $1",
+		orderID,
+	).Scan(&userID, &status, &paymentStatus)
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if err == sql.ErrNoRows || (err == nil && !claims.CanAccessUser(userID)) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch order", http.StatusInternalServerError)
+		return
+	}
+	if !returnableStatuses[status] {
+		http.Error(w, "Only shipped or delivered orders can be returned", http.StatusConflict)
+		return
+	}
+	if paymentStatus != "completed" && paymentStatus != "partially_refunded" {
+		http.Error(w, "Order has no payment to refund", http.StatusConflict)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the order's items serializes concurrent returns against the
+	// same lines.
+	if _, err := tx.Exec("SELECT id FROM order_items WHERE order_id = $1 FOR UPDATE", orderID); err != nil {
+		http.Error(w, "Failed to lock order items", http.StatusInternalServerError)
+		return
+	}
+
+	requested := make(map[uint]int)
+	var refund float64
+	for i := range req.Items {
+		line := &req.Items[i]
+		if line.Quantity <= 0 {
+			http.Error(w, "Return quantity must be positive", http.StatusBadRequest)
+			return
+		}
+		requested[line.OrderItemID] += line.Quantity
+
+		var ordered, returned int
+		var price float64
+		err := tx.QueryRow(
+			`SELECT oi.product_id, oi.quantity, oi.price,
+			 (SELECT COALESCE(SUM(quantity), 0) FROM order_returns WHERE order_item_id = oi.id)
+			 FROM order_items oi WHERE oi.id = $1 AND oi.order_id = $2`,
+			line.OrderItemID, orderID,
+		).Scan(&line.ProductID, &ordered, &price, &returned)
+		if err == sql.ErrNoRows {
+			http.Error(w, fmt.Sprintf("Order item %d not found", line.OrderItemID), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch order item", http.StatusInternalServerError)
+			return
+		}
+		if returned+requested[line.OrderItemID] > ordered {
+			http.Error(w, fmt.Sprintf("Cannot return more than the %d remaining of order item %d", ordered-returned, line.OrderItemID), http.StatusBadRequest)
+			return
+		}
+
+		line.Amount = math.Round(price*float64(line.Quantity)*100) / 100
+		refund += line.Amount
+
+		_, err = tx.Exec(
+			"INSERT INTO order_returns (order_id, order_item_id, quantity, amount) VALUES ($1, $2, $3, $4)",
+			orderID, line.OrderItemID, line.Quantity, line.Amount,
+		)
+		if err != nil {
+			http.Error(w, "Failed to record return", http.StatusInternalServerError)
+			return
+		}
+	}
+	refund = math.Round(refund*100) / 100
+
+	if err := refundOrderPayment(uint(orderID), refund, r.Header.Get("Authorization")); err != nil {
+		log.Printf("Order %d return: refund of %.2f failed: %v", orderID, refund, err)
+		http.Error(w, "Failed to refund returned items", http.StatusBadGateway)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Order %d return: refund of %.2f issued but return not recorded: %v", orderID, refund, err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	// Restocking is best effort; the refund has already gone out.
+	for _, line := range req.Items {
+		if err := adjustStock(line.ProductID, line.Quantity, "order_return", orderID); err != nil {
+			log.Printf("Order %d return: failed to restock product %d: %v", orderID, line.ProductID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"order_id": orderID,
+		"items":    req.Items,
+		"refunded": refund,
+	})
+}
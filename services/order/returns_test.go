@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+)
+
+// stubRefunds serves payment 12 for every order and returns the refund
+// amounts it was asked for.
+func stubRefunds(t *testing.T) *[]float64 {
+	t.Helper()
+	var refunds []float64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/payments/order/"):
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 12})
+		case r.URL.Path == "/payments/12/refund":
+			var body struct {
+				Amount float64 `json:"amount"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			refunds = append(refunds, body.Amount)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("PAYMENT_SERVICE_URL", srv.URL)
+	return &refunds
+}
+
+// seedReturn scripts delivered order 5, owned by user 7, whose item 1 is 3
+// units of product 4 at 15.00, one of which was already returned.
+func seedReturn(t *testing.T) *dbtest.DB {
+	t.Helper()
+	fake := useDB(t)
+	fake.On(`FROM orders WHERE id = \$1`).Rows([]string{"user_id", "status", "payment_status"}, []interface{}{7, "delivered", "completed"})
+	fake.On(`SELECT id FROM order_items WHERE order_id = \$1 FOR UPDATE`)
+	fake.On(`FROM order_items oi WHERE oi.id = \$1 AND oi.order_id = \$2`).Rows(
+		[]string{"product_id", "quantity", "price", "returned"}, []interface{}{4, 3, 15.0, 1})
+	fake.On(`INSERT INTO order_returns`)
+	return fake
+}
+
+func returnItems(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := authorize(t, httptest.NewRequest("POST", "/orders/5/return", strings.NewReader(body)), 7, "")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+func TestReturnPartialRefundsAndRestocks(t *testing.T) {
+	refunds := stubRefunds(t)
+	catalog := stubCatalog(t, map[uint]int{4: 0})
+	fake := seedReturn(t)
+
+	w := returnItems(t, `{"items": [{"order_item_id": 1, "quantity": 2}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if len(*refunds) != 1 || (*refunds)[0] != 30 {
+		t.Errorf("refunds = %v, want 30.00", *refunds)
+	}
+	if got := strings.Join(catalog.Calls(), ","); got != "release 4 2" {
+		t.Errorf("stock calls = %q, want 2 restocked", got)
+	}
+	inserts := fake.Matching(`INSERT INTO order_returns`)
+	if len(inserts) != 1 || inserts[0].Args[2] != int64(2) || inserts[0].Args[3] != 30.0 {
+		t.Errorf("returns recorded = %+v", inserts)
+	}
+	if calls := fake.Calls(); calls[len(calls)-1].Query != "COMMIT" {
+		t.Error("return not committed")
+	}
+}
+
+func TestReturnRejectsOverQuantity(t *testing.T) {
+	refunds := stubRefunds(t)
+	catalog := stubCatalog(t, map[uint]int{4: 0})
+
+	// Two returnable units remain; asking for 3, or 2 across two lines plus
+	// one more, is too many.
+	for _, body := range []string{
+		`{"items": [{"order_item_id": 1, "quantity": 3}]}`,
+		`{"items": [{"order_item_id": 1, "quantity": 2}, {"order_item_id": 1, "quantity": 1}]}`,
+	} {
+		fake := seedReturn(t)
+		if w := returnItems(t, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, w.Code)
+		}
+		if calls := fake.Calls(); calls[len(calls)-1].Query != "ROLLBACK" {
+			t.Errorf("%s: return not rolled back", body)
+		}
+	}
+	if len(*refunds) != 0 || len(catalog.Calls()) != 0 {
+		t.Errorf("refunds = %v, stock calls = %v after rejected returns", *refunds, catalog.Calls())
+	}
+}
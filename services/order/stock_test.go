@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+// catalogStub is a product service holding stock for a few products. Reserve
+// refuses to go below zero, like the real one, and only accepts service
+// tokens.
+type catalogStub struct {
+	mu    sync.Mutex
+	stock map[uint]int
+	calls []string
+}
+
+func (c *catalogStub) record(call string) {
+	c.mu.Lock()
+	c.calls = append(c.calls, call)
+	c.mu.Unlock()
+}
+
+// Calls returns the stock changes made so far, as "reserve 1 2" or
+// "release 1 2" (product, quantity).
+func (c *catalogStub) Calls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+// stubCatalog serves products at 20 each with the given stock.
+func stubCatalog(t *testing.T, stock map[uint]int) *catalogStub {
+	t.Helper()
+	c := &catalogStub{stock: stock}
+	productID := func(r *http.Request) uint {
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+		return uint(id)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		stock, ok := c.stock[productID(r)]
+		c.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": productID(r), "name": "Lamp", "price": 20.0, "stock": stock})
+	}).Methods("GET")
+	r.Handle("/products/{id}/reserve", middleware.AuthMiddleware(middleware.RequireRole(middleware.RoleService)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Quantity    int    `json:"quantity"`
+			ReferenceID string `json:"reference_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		id := productID(r)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.stock[id] < body.Quantity {
+			http.Error(w, "Insufficient stock", http.StatusConflict)
+			return
+		}
+		c.stock[id] -= body.Quantity
+		c.calls = append(c.calls, "reserve "+strconv.Itoa(int(id))+" "+strconv.Itoa(body.Quantity)+" "+body.ReferenceID)
+		json.NewEncoder(w).Encode(map[string]interface{}{"stock": c.stock[id]})
+	})))).Methods("POST")
+	r.HandleFunc("/products/{id}/stock", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Quantity int `json:"quantity"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		id := productID(r)
+		c.mu.Lock()
+		c.stock[id] += body.Quantity
+		c.mu.Unlock()
+		c.record("release " + strconv.Itoa(int(id)) + " " + strconv.Itoa(body.Quantity))
+	}).Methods("PATCH")
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	t.Setenv("PRODUCT_SERVICE_URL", srv.URL)
+	return c
+}
+
+const stockOrderBody = `{"user_id": 7, "shipping_address": "1 Main St, Austin, TX", "cart_version": "v1", "items": [{"product_id": 1, "quantity": 2}, {"product_id": 2, "quantity": 3}]}`
+
+func TestCreateOrderReservesStock(t *testing.T) {
+	stubNotifications(t, http.StatusOK)
+	fake := scriptOrderCreation(t)
+	catalog := stubCatalog(t, map[uint]int{1: 5, 2: 5})
+
+	if w := postOrder(stockOrderBody, nil); w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	want := "reserve 1 2 order:12,reserve 2 3 order:12"
+	if got := strings.Join(catalog.Calls(), ","); got != want {
+		t.Errorf("stock calls = %q, want %q", got, want)
+	}
+	if calls := fake.Calls(); calls[len(calls)-1].Query != "COMMIT" {
+		t.Error("order not committed")
+	}
+}
+
+func TestCreateOrderOutOfStockReleasesReserved(t *testing.T) {
+	stubNotifications(t, http.StatusOK)
+	fake := scriptOrderCreation(t)
+	catalog := stubCatalog(t, map[uint]int{1: 5, 2: 1})
+
+	w := postOrder(stockOrderBody, nil)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "product 2") {
+		t.Fatalf("status = %d, body = %q; want 409 naming product 2", w.Code, w.Body)
+	}
+	// Product 1 was taken before product 2 came up short, so it goes back.
+	want := "reserve 1 2 order:12,release 1 2"
+	if got := strings.Join(catalog.Calls(), ","); got != want {
+		t.Errorf("stock calls = %q, want %q", got, want)
+	}
+	if len(fake.Matching(`^COMMIT`)) != 0 {
+		t.Error("an order without stock was committed")
+	}
+}
+
+func TestConcurrentCheckoutsDoNotOversell(t *testing.T) {
+	stubNotifications(t, http.StatusOK)
+	scriptOrderCreation(t)
+	catalog := stubCatalog(t, map[uint]int{1: 3})
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- postOrder(`{"user_id": 7, "shipping_address": "1 Main St", "cart_version": "v1", "items": [{"product_id": 1, "quantity": 1}]}`, nil).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusCreated] != 3 || counts[http.StatusConflict] != 5 {
+		t.Errorf("statuses = %v, want 3 created and 5 out of stock", counts)
+	}
+	if catalog.stock[1] != 0 {
+		t.Errorf("stock left = %d, want 0", catalog.stock[1])
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+type Shipment struct {
+	ID             uint       `json:"id"`
+	OrderID        uint       `json:"order_id"`
+	Carrier        string     `json:"carrier"`
+	TrackingNumber string     `json:"tracking_number"`
+	LastStatus     string     `json:"last_status"`
+	LastCheckedAt  *time.Time `json:"last_checked_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type TrackingEvent struct {
+	Status      string    `json:"status"`
+	Description string    `json:"description"`
+	Location    string    `json:"location,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// CarrierTracker looks up tracking events for a shipment. Events are
+// returned oldest first.
+type CarrierTracker interface {
+	Track(ctx context.Context, shipment Shipment) ([]TrackingEvent, error)
+}
+
+// carrierTrackers maps carrier names to their tracking integration. Only the
+// simulator is wired up until real carrier APIs are integrated.
+var carrierTrackers = map[string]CarrierTracker{
+	"simulated": simulatedCarrier{},
+	"ups":       simulatedCarrier{},
+	"fedex":     simulatedCarrier{},
+	"usps":      simulatedCarrier{},
+	"dhl":       simulatedCarrier{},
+}
+
+// simulatedCarrier derives events from the shipment's age: in transit after
+// a day, delivered after three.
+type simulatedCarrier struct{}
+
+func (simulatedCarrier) Track(ctx context.Context, shipment Shipment) ([]TrackingEvent, error) {
+	events := []TrackingEvent{{
+		Status:      "label_created",
+		Description: "Shipping label created",
+		Time:        shipment.CreatedAt,
+	}}
+
+	age := time.Since(shipment.CreatedAt)
+	if age >= 24*time.Hour {
+		events = append(events, TrackingEvent{
+			Status:      "in_transit",
+			Description: "Package in transit",
+			Location:    "Distribution center",
+			Time:        shipment.CreatedAt.Add(24 * time.Hour),
+		})
+	}
+	if age >= 72*time.Hour {
+		events = append(events, TrackingEvent{
+			Status:      "delivered",
+			Description: "Package delivered",
+			Time:        shipment.CreatedAt.Add(72 * time.Hour),
+		})
+	}
+	return events, nil
+}
+
+func createShipment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	var s Shipment
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	s.Carrier = strings.ToLower(strings.TrimSpace(s.Carrier))
+	s.TrackingNumber = strings.TrimSpace(s.TrackingNumber)
+	if _, ok := carrierTrackers[s.Carrier]; !ok {
+		http.Error(w, "Unsupported carrier", http.StatusBadRequest)
+		return
+	}
+	if s.TrackingNumber == "" {
+		http.Error(w, "tracking_number is required", http.StatusBadRequest)
+		return
+	}
+
+	s.OrderID = uint(orderID)
+	s.LastStatus = "label_created"
+	err = db.QueryRow(
+		`INSERT INTO shipments (order_id, carrier, tracking_number, last_status)
+		 SELECT id, $2, $3, $4 FROM orders WHERE id = $1
+		 RETURNING id, created_at`,
+		s.OrderID, s.Carrier, s.TrackingNumber, s.LastStatus,
+	).Scan(&s.ID, &s.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create shipment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// getOrderTracking asks the carrier for the latest events on the order's most
+// recent shipment and persists the newest status. If the carrier can't be
+// reached the last known status is returned and marked stale. Like getOrder,
+// only the order's owner or an admin may see it.
+func getOrderTracking(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderID := vars["id"]
+
+	var ownerID uint
+	err := db.QueryRow("SELECT user_id FROM orders WHERE id = $1", orderID).Scan(&ownerID)
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if err != nil || !claims.CanAccessUser(ownerID) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	var s Shipment
+	var lastChecked sql.NullTime
+	err = db.QueryRow(
+		`SELECT id, order_id, carrier, tracking_number, COALESCE(last_status, ''), last_checked_at, created_at
+		 FROM shipments WHERE order_id = $1 ORDER BY created_at DESC LIMIT 1`,
+		orderID,
+	).Scan(&s.ID, &s.OrderID, &s.Carrier, &s.TrackingNumber, &s.LastStatus, &lastChecked, &s.CreatedAt)
+
+	if err != nil {
+		http.Error(w, "No shipment found for order", http.StatusNotFound)
+		return
+	}
+	if lastChecked.Valid {
+		s.LastCheckedAt = &lastChecked.Time
+	}
+
+	response := map[string]interface{}{
+		"order_id":        s.OrderID,
+		"carrier":         s.Carrier,
+		"tracking_number": s.TrackingNumber,
+		"status":          s.LastStatus,
+		"events":          []TrackingEvent{},
+		"stale":           false,
+	}
+
+	tracker, ok := carrierTrackers[s.Carrier]
+	var events []TrackingEvent
+	if ok {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		events, err = tracker.Track(ctx, s)
+	}
+
+	if !ok || err != nil {
+		log.Printf("Tracking lookup failed for shipment %d (%s): %v", s.ID, s.Carrier, err)
+		response["stale"] = true
+		response["last_checked_at"] = s.LastCheckedAt
+	} else {
+		response["events"] = events
+		if len(events) > 0 {
+			latest := events[len(events)-1].Status
+			response["status"] = latest
+			db.Exec(
+				"UPDATE shipments SET last_status = $1, last_checked_at = CURRENT_TIMESTAMP WHERE id = $2",
+				latest, s.ID,
+			)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+)
+
+func TestTrackingRequiresToken(t *testing.T) {
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/orders/1/tracking", nil))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+}
+
+func TestCreateShipmentRequiresAdmin(t *testing.T) {
+	r := authorize(t, httptest.NewRequest("POST", "/orders/1/shipments", nil), 1, "")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
+
+func TestCreateShipmentRejectsUnknownCarrier(t *testing.T) {
+	r := authorize(t, httptest.NewRequest("POST", "/orders/1/shipments", strings.NewReader(`{"carrier": "pigeon", "tracking_number": "1Z"}`)), 1, "admin")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestSimulatedCarrierEventsFollowShipmentAge(t *testing.T) {
+	tests := []struct {
+		age  time.Duration
+		want string
+	}{
+		{time.Hour, "label_created"},
+		{30 * time.Hour, "in_transit"},
+		{80 * time.Hour, "delivered"},
+	}
+	for _, tt := range tests {
+		events, err := simulatedCarrier{}.Track(context.Background(), Shipment{CreatedAt: time.Now().Add(-tt.age)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := events[len(events)-1].Status; got != tt.want {
+			t.Errorf("after %s latest status = %q, want %q", tt.age, got, tt.want)
+		}
+	}
+}
+
+// fakeCarrier returns canned events, or err if set.
+type fakeCarrier struct {
+	events []TrackingEvent
+	err    error
+}
+
+func (f fakeCarrier) Track(ctx context.Context, shipment Shipment) ([]TrackingEvent, error) {
+	return f.events, f.err
+}
+
+// useCarrier registers tracker as the "fake" carrier for the rest of the test.
+func useCarrier(t *testing.T, tracker CarrierTracker) {
+	t.Helper()
+	carrierTrackers["fake"] = tracker
+	t.Cleanup(func() { delete(carrierTrackers, "fake") })
+}
+
+var shipmentColumns = []string{"id", "order_id", "carrier", "tracking_number", "last_status", "last_checked_at", "created_at"}
+
+// seedTracking scripts order 3, owned by user 7, with one fake-carrier shipment.
+func seedTracking(t *testing.T) *dbtest.DB {
+	t.Helper()
+	fake := useDB(t)
+	fake.On(`SELECT user_id FROM orders WHERE id = \$1`).Rows([]string{"user_id"}, []interface{}{7})
+	fake.On(`FROM shipments WHERE order_id = \$1`).Rows(shipmentColumns,
+		[]interface{}{11, 3, "fake", "1Z999", "label_created", nil, time.Now().Add(-48 * time.Hour)})
+	fake.On(`UPDATE shipments SET last_status`)
+	return fake
+}
+
+func getTracking(t *testing.T, userID uint, role string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := authorize(t, httptest.NewRequest("GET", "/orders/3/tracking", nil), userID, role)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+func TestTrackingReturnsCarrierEvents(t *testing.T) {
+	fake := seedTracking(t)
+	useCarrier(t, fakeCarrier{events: []TrackingEvent{
+		{Status: "label_created", Time: time.Now().Add(-48 * time.Hour)},
+		{Status: "out_for_delivery", Location: "Springfield", Time: time.Now()},
+	}})
+
+	w := getTracking(t, 7, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Status string          `json:"status"`
+		Events []TrackingEvent `json:"events"`
+		Stale  bool            `json:"stale"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != "out_for_delivery" || len(body.Events) != 2 || body.Stale {
+		t.Errorf("body = %+v", body)
+	}
+
+	updates := fake.Matching(`UPDATE shipments`)
+	if len(updates) != 1 || updates[0].Args[0] != "out_for_delivery" || updates[0].Args[1] != int64(11) {
+		t.Errorf("updates = %+v, want the latest status persisted on shipment 11", updates)
+	}
+}
+
+func TestTrackingFallsBackToLastKnownStatus(t *testing.T) {
+	fake := seedTracking(t)
+	useCarrier(t, fakeCarrier{err: errors.New("carrier unavailable")})
+
+	w := getTracking(t, 7, "")
+	var body struct {
+		Status string `json:"status"`
+		Stale  bool   `json:"stale"`
+	}
+	json.NewDecoder(w.Body).Decode(&body)
+	if w.Code != http.StatusOK || body.Status != "label_created" || !body.Stale {
+		t.Errorf("status = %d, body = %+v", w.Code, body)
+	}
+	if n := len(fake.Matching(`UPDATE shipments`)); n != 0 {
+		t.Errorf("%d status updates after a failed lookup", n)
+	}
+}
+
+func TestTrackingIsLimitedToOwnerAndAdmin(t *testing.T) {
+	useCarrier(t, fakeCarrier{events: []TrackingEvent{{Status: "in_transit"}}})
+
+	fake := seedTracking(t)
+	if w := getTracking(t, 8, ""); w.Code != http.StatusNotFound {
+		t.Errorf("another customer: status = %d, want 404", w.Code)
+	}
+	if n := len(fake.Matching(`FROM shipments`)); n != 0 {
+		t.Error("shipment was looked up for a customer who does not own the order")
+	}
+
+	seedTracking(t)
+	if w := getTracking(t, 1, "admin"); w.Code != http.StatusOK {
+		t.Errorf("admin: status = %d, want 200", w.Code)
+	}
+}
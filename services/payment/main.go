@@ -4,31 +4,42 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/database"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/server"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/validation"
+	"github.com/lib/pq"
 )
 
 type Payment struct {
-	ID              uint      `json:"id"`
-	OrderID         uint      `json:"order_id"`
-	UserID          uint      `json:"user_id"`
-	Amount          float64   `json:"amount"`
-	Currency        string    `json:"currency"`
-	Method          string    `json:"method"`
-	Status          string    `json:"status"`
-	TransactionID   string    `json:"transaction_id"`
-	PaymentGateway  string    `json:"payment_gateway"`
-	CardLast4       string    `json:"card_last4,omitempty"`
-	ErrorMessage    string    `json:"error_message,omitempty"`
-	CreatedAt       time.Time `json:"created_at"`
+	ID             uint      `json:"id"`
+	OrderID        uint      `json:"order_id"`
+	UserID         uint      `json:"user_id"`
+	Amount         Money     `json:"amount"`
+	Currency       string    `json:"currency"`
+	Method         string    `json:"method"`
+	Status         string    `json:"status"`
+	TransactionID  string    `json:"transaction_id"`
+	PaymentGateway string    `json:"payment_gateway"`
+	CardLast4      string    `json:"card_last4,omitempty"`
+	BillingAddress string    `json:"billing_address,omitempty"`
+	ErrorMessage   string    `json:"error_message,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	// CreditApplied is the part of the order total covered by store credit
+	// in the same checkout. It is not stored on the payment row.
+	CreditApplied Money `json:"store_credit_applied,omitempty"`
 }
 
 type PaymentRequest struct {
@@ -37,7 +48,12 @@ type PaymentRequest struct {
 	Amount   float64 `json:"amount"`
 	Currency string  `json:"currency"`
 	Method   string  `json:"method"`
-	CardInfo *struct {
+	// StoreCreditAmount, if given, must match the store credit the order
+	// applied; that amount is deducted from the user's balance and the
+	// remainder charged to Method. Method "store_credit" pays in full and
+	// needs an order whose credit covers the total.
+	StoreCreditAmount float64 `json:"store_credit_amount,omitempty"`
+	CardInfo          *struct {
 		Number   string `json:"number"`
 		ExpMonth string `json:"exp_month"`
 		ExpYear  string `json:"exp_year"`
@@ -46,50 +62,71 @@ type PaymentRequest struct {
 }
 
 var db *sql.DB
+var dbOnce database.Once
 
 func main() {
 	var err error
-	db, err = database.NewConnection("payments_db")
+	err = dbOnce.Open(&db, func() (*sql.DB, error) { return database.NewConnection("payments_db") })
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
 
+	stopKeepalive := database.StartKeepalive(db, database.KeepaliveInterval())
+	defer stopKeepalive()
+
 	initDB()
 
 	r := mux.NewRouter()
 	r.Use(middleware.CORS)
+	r.Use(middleware.LogRequestBodies)
 
 	r.HandleFunc("/health", healthCheck).Methods("GET")
 	r.HandleFunc("/payments", processPayment).Methods("POST")
 	r.HandleFunc("/payments/{id}", getPayment).Methods("GET")
 	r.HandleFunc("/payments/order/{order_id}", getPaymentByOrder).Methods("GET")
 	r.HandleFunc("/payments/{id}/refund", refundPayment).Methods("POST")
-	r.HandleFunc("/payments/user/{user_id}", getPaymentsByUser).Methods("GET")
+	r.Handle("/payments/user/{user_id}", middleware.AuthMiddleware(http.HandlerFunc(getPaymentsByUser))).Methods("GET")
+	r.HandleFunc("/payments/status/batch", getPaymentStatusBatch).Methods("POST")
+	r.Handle("/payments/credit/{user_id}", middleware.AuthMiddleware(http.HandlerFunc(getStoreCredit))).Methods("GET")
+	r.Handle("/payments/credit/{user_id}", middleware.AuthMiddleware(middleware.RequireRole("admin")(http.HandlerFunc(addStoreCredit)))).Methods("POST")
 
 	log.Println("Payment service running on :8005")
-	log.Fatal(http.ListenAndServe(":8005", r))
+	if err := server.Run(":8005", middleware.TrimTrailingSlash(r)); err != nil {
+		log.Fatal("Server error:", err)
+	}
 }
 
 func initDB() {
-	query := `
-	CREATE TABLE IF NOT EXISTS payments (
-		id SERIAL PRIMARY KEY,
-		order_id INT NOT NULL,
-		user_id INT NOT NULL,
-		amount DECIMAL(10,2) NOT NULL,
-		currency VARCHAR(3) DEFAULT 'USD',
-		method VARCHAR(50) NOT NULL,
-		status VARCHAR(50) DEFAULT 'pending',
-		transaction_id VARCHAR(100) UNIQUE,
-		payment_gateway VARCHAR(50),
-		card_last4 VARCHAR(4),
-		error_message TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	)`
-	_, err := db.Exec(query)
-	if err != nil {
-		log.Fatal("Failed to create payments table:", err)
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS payments (
+			id SERIAL PRIMARY KEY,
+			order_id INT NOT NULL,
+			user_id INT NOT NULL,
+			amount DECIMAL(10,2) NOT NULL,
+			currency VARCHAR(3) DEFAULT 'USD',
+			method VARCHAR(50) NOT NULL,
+			status VARCHAR(50) DEFAULT 'pending',
+			transaction_id VARCHAR(100) UNIQUE,
+			payment_gateway VARCHAR(50),
+			card_last4 VARCHAR(4),
+			error_message TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS billing_address TEXT`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS refunded_amount DECIMAL(10,2) NOT NULL DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS store_credits (
+			user_id INT PRIMARY KEY,
+			balance DECIMAL(10,2) NOT NULL DEFAULT 0 CHECK (balance >= 0),
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+	}
+
+	for _, query := range queries {
+		_, err := db.Exec(query)
+		if err != nil {
+			log.Fatal("Failed to create payments table:", err)
+		}
 	}
 }
 
@@ -107,41 +144,133 @@ func processPayment(w http.ResponseWriter, r *http.Request) {
 	if req.Currency == "" {
 		req.Currency = "USD"
 	}
+	if v := validatePaymentRequest(&req); !v.Valid() {
+		validation.WriteError(w, v.Errors())
+		return
+	}
 
-	// Generate transaction ID
-	transactionID := generateTransactionID()
-
-	// Simulate payment processing
-	payment := Payment{
-		OrderID:        req.OrderID,
-		UserID:         req.UserID,
-		Amount:         req.Amount,
-		Currency:       req.Currency,
-		Method:         req.Method,
-		TransactionID:  transactionID,
-		PaymentGateway: "stripe_simulator",
+	// The charge must cover exactly what the order service has on record, and
+	// is billed to the address on the order.
+	order, err := fetchOrder(req.OrderID, r.Header.Get("Authorization"))
+	if err == errOrderNotFound {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch order %d for payment: %v", req.OrderID, err)
+		http.Error(w, "Failed to verify order total", http.StatusBadGateway)
+		return
+	}
+	if order.UserID != req.UserID {
+		http.Error(w, "Order does not belong to user", http.StatusBadRequest)
+		return
+	}
+	if !amountMatches(req.Amount, order.TotalAmount) {
+		http.Error(w, fmt.Sprintf("Payment amount %.2f does not match order total %.2f", req.Amount, order.TotalAmount), http.StatusBadRequest)
+		return
 	}
 
-	// Get last 4 digits of card if provided
-	if req.CardInfo != nil && len(req.CardInfo.Number) >= 4 {
-		payment.CardLast4 = req.CardInfo.Number[len(req.CardInfo.Number)-4:]
+	// The order recorded how much store credit it applies, so that, not the
+	// request, decides what comes off the balance.
+	creditAmount := order.storeCredit()
+	if req.StoreCreditAmount != 0 && !amountMatches(req.StoreCreditAmount, creditAmount) {
+		http.Error(w, fmt.Sprintf("Store credit amount %.2f does not match the order's store credit %.2f", req.StoreCreditAmount, creditAmount), http.StatusBadRequest)
+		return
+	}
+	if req.Method == "store_credit" && !amountMatches(creditAmount, req.Amount) {
+		http.Error(w, fmt.Sprintf("Order's store credit %.2f does not cover the total", creditAmount), http.StatusBadRequest)
+		return
+	}
+	if creditAmount < 0 || creditAmount > req.Amount {
+		http.Error(w, "Invalid store credit amount", http.StatusBadRequest)
+		return
 	}
+	remainder := math.Round((req.Amount-creditAmount)*100) / 100
 
-	// Simulate payment gateway response (90% success rate)
-	if rand.Float32() < 0.9 {
-		payment.Status = "completed"
-	} else {
-		payment.Status = "failed"
-		payment.ErrorMessage = "Payment declined by issuer"
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// The credit is deducted in the same transaction as the charge, so a
+	// declined card releases it again and concurrent checkouts cannot spend
+	// the same balance twice.
+	var creditPayment *Payment
+	if creditAmount > 0 {
+		ok, err := deductStoreCredit(tx, req.UserID, moneyFromFloat(creditAmount))
+		if err != nil {
+			http.Error(w, "Failed to apply store credit", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Insufficient store credit", http.StatusPaymentRequired)
+			return
+		}
+
+		creditPayment = &Payment{
+			OrderID:        req.OrderID,
+			UserID:         req.UserID,
+			Amount:         moneyFromFloat(creditAmount),
+			Currency:       req.Currency,
+			Method:         "store_credit",
+			Status:         "completed",
+			TransactionID:  generateTransactionID(),
+			PaymentGateway: "store_credit",
+			BillingAddress: order.BillingAddr,
+		}
+		if err := savePayment(tx, creditPayment); err != nil {
+			writeSaveError(w, err)
+			return
+		}
 	}
 
-	err := db.QueryRow(
-		`INSERT INTO payments (order_id, user_id, amount, currency, method, status, transaction_id, payment_gateway, card_last4, error_message)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id, created_at`,
-		payment.OrderID, payment.UserID, payment.Amount, payment.Currency, payment.Method, payment.Status, payment.TransactionID, payment.PaymentGateway, payment.CardLast4, payment.ErrorMessage,
-	).Scan(&payment.ID, &payment.CreatedAt)
+	payment := creditPayment
+	if remainder > 0 {
+		// Simulate payment processing
+		payment = &Payment{
+			OrderID:        req.OrderID,
+			UserID:         req.UserID,
+			Amount:         moneyFromFloat(remainder),
+			Currency:       req.Currency,
+			Method:         req.Method,
+			TransactionID:  generateTransactionID(),
+			PaymentGateway: "stripe_simulator",
+			BillingAddress: order.BillingAddr,
+			CreditApplied:  moneyFromFloat(creditAmount),
+		}
+
+		// Get last 4 digits of card if provided
+		if req.CardInfo != nil && len(req.CardInfo.Number) >= 4 {
+			payment.CardLast4 = req.CardInfo.Number[len(req.CardInfo.Number)-4:]
+		}
+
+		if approveCharge() {
+			payment.Status = "completed"
+		} else {
+			payment.Status = "failed"
+			payment.ErrorMessage = "Payment declined by issuer"
+		}
+
+		if payment.Status == "failed" && creditPayment != nil {
+			// Drop the credit deduction and keep only the declined charge.
+			tx.Rollback()
+			tx, err = db.Begin()
+			if err != nil {
+				http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+				return
+			}
+			defer tx.Rollback()
+		}
+
+		if err := savePayment(tx, payment); err != nil {
+			writeSaveError(w, err)
+			return
+		}
+	}
 
-	if err != nil {
+	if err := tx.Commit(); err != nil {
 		http.Error(w, "Failed to process payment", http.StatusInternalServerError)
 		return
 	}
@@ -149,6 +278,7 @@ func processPayment(w http.ResponseWriter, r *http.Request) {
 	// Update order payment status
 	if payment.Status == "completed" {
 		updateOrderPaymentStatus(payment.OrderID, "completed")
+		sendReceipt(payment)
 	} else {
 		updateOrderPaymentStatus(payment.OrderID, "failed")
 	}
@@ -162,13 +292,76 @@ func processPayment(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(payment)
 }
 
+// approveCharge simulates the payment gateway, which approves 90% of
+// charges.
+var approveCharge = func() bool { return rand.Float32() < 0.9 }
+
+// amountMatches reports whether a payment amount equals the order total to
+// the cent. The total is computed by the order service from catalog prices
+// and server-side promotions, so the client can't lower it.
+func amountMatches(amount, total float64) bool {
+	return math.Abs(amount-total) < 0.005
+}
+
+// validatePaymentRequest checks the request, including card_info when the
+// remainder is charged to a card.
+func validatePaymentRequest(req *PaymentRequest) *validation.Validator {
+	v := validation.New()
+	v.Check(req.OrderID > 0, "order_id", "is required")
+	v.Check(req.UserID > 0, "user_id", "is required")
+	v.Check(req.Amount > 0, "amount", "must be positive")
+	v.Check(len(req.Currency) == 3, "currency", "must be a 3-letter code")
+	v.Check(req.Method != "", "method", "is required")
+
+	if req.Method == "card" && req.StoreCreditAmount < req.Amount {
+		card := v.Field("card_info")
+		if req.CardInfo == nil {
+			card.Fail("is required for card payments")
+			return v
+		}
+
+		number := strings.ReplaceAll(req.CardInfo.Number, " ", "")
+		card.Check(isDigits(number) && len(number) >= 12 && len(number) <= 19, "number", "must be 12-19 digits")
+
+		month, err := strconv.Atoi(req.CardInfo.ExpMonth)
+		card.Check(err == nil && month >= 1 && month <= 12, "exp_month", "must be between 1 and 12")
+
+		year := req.CardInfo.ExpYear
+		card.Check(isDigits(year) && (len(year) == 2 || len(year) == 4), "exp_year", "must be 2 or 4 digits")
+
+		card.Check(isDigits(req.CardInfo.CVC) && len(req.CardInfo.CVC) >= 3 && len(req.CardInfo.CVC) <= 4, "cvc", "must be 3 or 4 digits")
+	}
+
+	return v
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func writeSaveError(w http.ResponseWriter, err error) {
+	if isTransactionIDConflict(err) {
+		http.Error(w, "Duplicate transaction ID, please retry", http.StatusConflict)
+		return
+	}
+	http.Error(w, "Failed to process payment", http.StatusInternalServerError)
+}
+
 func getPayment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	paymentID := vars["id"]
 
 	var payment Payment
 	err := db.QueryRow(
-		`SELECT id, order_id, user_id, amount, currency, method, status, transaction_id, payment_gateway, card_last4, error_message, created_at
+		`SELECT id, order_id, user_id, amount, COALESCE(currency, 'USD'), method, COALESCE(status, 'pending'), COALESCE(transaction_id, ''), COALESCE(payment_gateway, ''), COALESCE(card_last4, ''), COALESCE(error_message, ''), created_at
 		 FROM payments WHERE id = $1`,
 		paymentID,
 	).Scan(&payment.ID, &payment.OrderID, &payment.UserID, &payment.Amount, &payment.Currency, &payment.Method, &payment.Status, &payment.TransactionID, &payment.PaymentGateway, &payment.CardLast4, &payment.ErrorMessage, &payment.CreatedAt)
@@ -188,7 +381,7 @@ func getPaymentByOrder(w http.ResponseWriter, r *http.Request) {
 
 	var payment Payment
 	err := db.QueryRow(
-		`SELECT id, order_id, user_id, amount, currency, method, status, transaction_id, payment_gateway, card_last4, error_message, created_at
+		`SELECT id, order_id, user_id, amount, COALESCE(currency, 'USD'), method, COALESCE(status, 'pending'), COALESCE(transaction_id, ''), COALESCE(payment_gateway, ''), COALESCE(card_last4, ''), COALESCE(error_message, ''), created_at
 		 FROM payments WHERE order_id = $1 ORDER BY created_at DESC LIMIT 1`,
 		orderID,
 	).Scan(&payment.ID, &payment.OrderID, &payment.UserID, &payment.Amount, &payment.Currency, &payment.Method, &payment.Status, &payment.TransactionID, &payment.PaymentGateway, &payment.CardLast4, &payment.ErrorMessage, &payment.CreatedAt)
@@ -204,10 +397,20 @@ func getPaymentByOrder(w http.ResponseWriter, r *http.Request) {
 
 func getPaymentsByUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	userID := vars["user_id"]
+	userID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(uint(userID)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	rows, err := db.Query(
-		`SELECT id, order_id, user_id, amount, currency, method, status, transaction_id, payment_gateway, card_last4, error_message, created_at
+		`SELECT id, order_id, user_id, amount, COALESCE(currency, 'USD'), method, COALESCE(status, 'pending'), COALESCE(transaction_id, ''), COALESCE(payment_gateway, ''), COALESCE(card_last4, ''), COALESCE(error_message, ''), created_at
 		 FROM payments WHERE user_id = $1 ORDER BY created_at DESC`,
 		userID,
 	)
@@ -220,7 +423,10 @@ func getPaymentsByUser(w http.ResponseWriter, r *http.Request) {
 	payments := []Payment{}
 	for rows.Next() {
 		var p Payment
-		rows.Scan(&p.ID, &p.OrderID, &p.UserID, &p.Amount, &p.Currency, &p.Method, &p.Status, &p.TransactionID, &p.PaymentGateway, &p.CardLast4, &p.ErrorMessage, &p.CreatedAt)
+		err := rows.Scan(&p.ID, &p.OrderID, &p.UserID, &p.Amount, &p.Currency, &p.Method, &p.Status, &p.TransactionID, &p.PaymentGateway, &p.CardLast4, &p.ErrorMessage, &p.CreatedAt)
+		if err != nil {
+			continue
+		}
 		payments = append(payments, p)
 	}
 
@@ -228,36 +434,290 @@ func getPaymentsByUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(payments)
 }
 
+const maxBatchOrderIDs = 500
+
+type OrderPaymentStatus struct {
+	OrderID   uint   `json:"order_id"`
+	PaymentID uint   `json:"payment_id,omitempty"`
+	Status    string `json:"status"`
+}
+
+// getPaymentStatusBatch returns the latest payment status for each requested
+// order in one query. Orders without a payment report status "none".
+func getPaymentStatusBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OrderIDs []int64 `json:"order_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.OrderIDs) == 0 {
+		http.Error(w, "order_ids is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.OrderIDs) > maxBatchOrderIDs {
+		http.Error(w, fmt.Sprintf("At most %d order_ids per request", maxBatchOrderIDs), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	rows, err := db.Query(
+		`SELECT DISTINCT ON (order_id) order_id, id, COALESCE(status, 'pending') FROM payments
+		 WHERE order_id = ANY($1) ORDER BY order_id, created_at DESC, id DESC`,
+		pq.Array(req.OrderIDs),
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch payment statuses", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	latest := make(map[uint]OrderPaymentStatus, len(req.OrderIDs))
+	for rows.Next() {
+		var s OrderPaymentStatus
+		if err := rows.Scan(&s.OrderID, &s.PaymentID, &s.Status); err != nil {
+			continue
+		}
+		latest[s.OrderID] = s
+	}
+
+	statuses := make([]OrderPaymentStatus, 0, len(req.OrderIDs))
+	for _, id := range req.OrderIDs {
+		s, ok := latest[uint(id)]
+		if !ok {
+			s = OrderPaymentStatus{OrderID: uint(id), Status: "none"}
+		}
+		statuses = append(statuses, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"statuses": statuses})
+}
+
+// refundPayment refunds a payment in full, or only {"amount"} of it for a
+// partial refund such as returned items. Partial refunds can be repeated
+// until the whole amount has been returned.
 func refundPayment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	paymentID := vars["id"]
 
+	var req struct {
+		Amount float64 `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Amount < 0 {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
 	var payment Payment
-	err := db.QueryRow(
-		"SELECT id, order_id, status FROM payments WHERE id = $1",
+	var refunded Money
+	err = tx.QueryRow(
+		"SELECT id, order_id, user_id, amount, refunded_amount, method, COALESCE(status, 'pending') FROM payments WHERE id = $1 FOR UPDATE",
 		paymentID,
-	).Scan(&payment.ID, &payment.OrderID, &payment.Status)
+	).Scan(&payment.ID, &payment.OrderID, &payment.UserID, &payment.Amount, &refunded, &payment.Method, &payment.Status)
 
 	if err != nil {
 		http.Error(w, "Payment not found", http.StatusNotFound)
 		return
 	}
 
-	if payment.Status != "completed" {
+	if payment.Status != "completed" && payment.Status != "partially_refunded" {
 		http.Error(w, "Only completed payments can be refunded", http.StatusBadRequest)
 		return
 	}
 
-	_, err = db.Exec("UPDATE payments SET status = 'refunded' WHERE id = $1", paymentID)
+	remaining := payment.Amount - refunded
+	amount := remaining
+	if req.Amount > 0 {
+		amount = moneyFromFloat(req.Amount)
+	}
+	if amount > remaining {
+		http.Error(w, fmt.Sprintf("Refund exceeds the %s left on this payment", remaining), http.StatusBadRequest)
+		return
+	}
+
+	status := "refunded"
+	if amount < remaining {
+		status = "partially_refunded"
+	}
+
+	_, err = tx.Exec(
+		"UPDATE payments SET status = $1, refunded_amount = refunded_amount + $2 WHERE id = $3",
+		status, amount, paymentID,
+	)
 	if err != nil {
 		http.Error(w, "Failed to refund payment", http.StatusInternalServerError)
 		return
 	}
 
-	updateOrderPaymentStatus(payment.OrderID, "refunded")
+	// Store credit goes back to the user's balance rather than a card.
+	if payment.Method == "store_credit" {
+		if err := addCredit(tx, payment.UserID, amount); err != nil {
+			http.Error(w, "Failed to refund store credit", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to refund payment", http.StatusInternalServerError)
+		return
+	}
+
+	updateOrderPaymentStatus(payment.OrderID, status)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Payment refunded successfully",
+		"status":   status,
+		"refunded": amount,
+	})
+}
+
+func getStoreCredit(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(uint(userID)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var balance Money
+	err = db.QueryRow("SELECT balance FROM store_credits WHERE user_id = $1", userID).Scan(&balance)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Failed to fetch store credit", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user_id": userID, "balance": balance})
+}
+
+func addStoreCredit(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Amount float64 `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Amount <= 0 {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := addCredit(tx, uint(userID), moneyFromFloat(req.Amount)); err != nil {
+		http.Error(w, "Failed to add store credit", http.StatusInternalServerError)
+		return
+	}
+
+	var balance Money
+	if err := tx.QueryRow("SELECT balance FROM store_credits WHERE user_id = $1", userID).Scan(&balance); err != nil {
+		http.Error(w, "Failed to add store credit", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to add store credit", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Payment refunded successfully"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"user_id": userID, "balance": balance})
+}
+
+// deductStoreCredit takes amount from the user's balance, reporting false when
+// the balance is insufficient. The guarded UPDATE makes it safe to call
+// concurrently for the same user.
+func deductStoreCredit(tx *sql.Tx, userID uint, amount Money) (bool, error) {
+	result, err := tx.Exec(
+		`UPDATE store_credits SET balance = balance - $1, updated_at = CURRENT_TIMESTAMP
+		 WHERE user_id = $2 AND balance >= $1`,
+		amount, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func addCredit(tx *sql.Tx, userID uint, amount Money) error {
+	_, err := tx.Exec(
+		`INSERT INTO store_credits (user_id, balance) VALUES ($1, $2)
+		 ON CONFLICT (user_id) DO UPDATE SET balance = store_credits.balance + $2, updated_at = CURRENT_TIMESTAMP`,
+		userID, amount,
+	)
+	return err
+}
+
+// savePayment inserts the payment, retrying once with a fresh transaction id
+// if the generated one collides. The savepoint keeps the surrounding
+// transaction usable after the failed insert.
+func savePayment(tx *sql.Tx, payment *Payment) error {
+	for attempt := 0; ; attempt++ {
+		if _, err := tx.Exec("SAVEPOINT payment_insert"); err != nil {
+			return err
+		}
+
+		err := insertPayment(tx, payment)
+		if !isTransactionIDConflict(err) || attempt == 1 {
+			return err
+		}
+
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT payment_insert"); err != nil {
+			return err
+		}
+		payment.TransactionID = generateTransactionID()
+	}
+}
+
+func insertPayment(tx *sql.Tx, payment *Payment) error {
+	return tx.QueryRow(
+		`INSERT INTO payments (order_id, user_id, amount, currency, method, status, transaction_id, payment_gateway, card_last4, error_message, billing_address)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, created_at`,
+		payment.OrderID, payment.UserID, payment.Amount, payment.Currency, payment.Method, payment.Status, payment.TransactionID, payment.PaymentGateway, payment.CardLast4, payment.ErrorMessage, payment.BillingAddress,
+	).Scan(&payment.ID, &payment.CreatedAt)
+}
+
+// isTransactionIDConflict reports whether err is a unique violation on
+// payments.transaction_id.
+func isTransactionIDConflict(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "23505" && pqErr.Constraint == "payments_transaction_id_key"
 }
 
 func generateTransactionID() string {
@@ -265,15 +725,10 @@ func generateTransactionID() string {
 }
 
 func updateOrderPaymentStatus(orderID uint, status string) {
-	orderServiceURL := os.Getenv("ORDER_SERVICE_URL")
-	if orderServiceURL == "" {
-		orderServiceURL = "http://order-service:8004"
-	}
-
 	payload := map[string]string{"payment_status": status}
 	jsonPayload, _ := json.Marshal(payload)
 
-	req, _ := http.NewRequest("PATCH", fmt.Sprintf("%s/orders/%d/payment", orderServiceURL, orderID), bytes.NewBuffer(jsonPayload))
+	req, _ := http.NewRequest("PATCH", fmt.Sprintf("%s/orders/%d/payment", orderServiceURL(), orderID), bytes.NewBuffer(jsonPayload))
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{Timeout: 5 * time.Second}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+// useDB points the service at a scripted database for the rest of the test.
+func useDB(t *testing.T) *dbtest.DB {
+	t.Helper()
+	fake := dbtest.New(t)
+	prev := db
+	db = fake.DB
+	t.Cleanup(func() { db = prev })
+	return fake
+}
+
+// authorize signs a token for userID (with role, if any) and sets it on r,
+// the way the gateway forwards a logged-in user's requests.
+func authorize(t *testing.T, r *http.Request, userID uint, role string) *http.Request {
+	t.Helper()
+	claims := &middleware.Claims{
+		UserID: userID,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    middleware.GetJWTIssuer(),
+			Audience:  jwt.ClaimStrings{middleware.GetJWTAudience()},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(middleware.GetJWTSecret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// serveAuthed routes r through AuthMiddleware to h with the given mux vars.
+func serveAuthed(h http.HandlerFunc, r *http.Request, vars map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(h).ServeHTTP(w, mux.SetURLVars(r, vars))
+	return w
+}
+
+// stubOrder serves the order the payment service checks amounts against.
+func stubOrder(t *testing.T, total float64) {
+	t.Helper()
+	stubOrderWithCredit(t, total, 0)
+}
+
+// stubOrderWithCredit serves order 1 for user 2 with a store credit
+// adjustment of credit, if any.
+func stubOrderWithCredit(t *testing.T, total, credit float64) {
+	t.Helper()
+	adjustments := []map[string]interface{}{}
+	if credit > 0 {
+		adjustments = append(adjustments, map[string]interface{}{"kind": "credit", "amount": credit, "total_after": total - credit})
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 1, "user_id": 2, "total_amount": total, "adjustments": adjustments})
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("ORDER_SERVICE_URL", srv.URL)
+}
+
+func postPayment(amount float64) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{
+		"order_id": 1,
+		"user_id":  2,
+		"amount":   amount,
+		"method":   "card",
+		"card_info": map[string]string{
+			"number": "4242424242424242", "exp_month": "12", "exp_year": "30", "cvc": "123",
+		},
+	})
+	w := httptest.NewRecorder()
+	processPayment(w, httptest.NewRequest("POST", "/payments", bytes.NewReader(body)))
+	return w
+}
+
+func TestProcessPaymentRejectsUnderpayment(t *testing.T) {
+	stubOrder(t, 100)
+
+	w := postPayment(1)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "does not match order total 100.00") {
+		t.Errorf("status = %d, body = %q; want 400 mismatch", w.Code, w.Body.String())
+	}
+}
+
+func TestProcessPaymentRejectsOverpayment(t *testing.T) {
+	stubOrder(t, 100)
+
+	if w := postPayment(100.01); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestProcessPaymentRejectsOtherUsersOrder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 1, "user_id": 99, "total_amount": 100})
+	}))
+	defer srv.Close()
+	t.Setenv("ORDER_SERVICE_URL", srv.URL)
+
+	if w := postPayment(100); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestAmountMatches(t *testing.T) {
+	tests := []struct {
+		amount, total float64
+		want          bool
+	}{
+		{100, 100, true},
+		{0.1 + 0.2, 0.3, true},
+		{99.99, 100, false},
+		{100.01, 100, false},
+	}
+	for _, tt := range tests {
+		if got := amountMatches(tt.amount, tt.total); got != tt.want {
+			t.Errorf("amountMatches(%v, %v) = %v, want %v", tt.amount, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePaymentRequestReportsCardPaths(t *testing.T) {
+	var req PaymentRequest
+	body := `{"order_id": 1, "user_id": 1, "amount": 10, "currency": "USD", "method": "card",
+		"card_info": {"number": "4242424242424242", "exp_month": "13", "exp_year": "2030", "cvc": "12"}}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := validatePaymentRequest(&req).Errors()
+	if len(errs) != 2 || errs[0].Field != "card_info.exp_month" || errs[1].Field != "card_info.cvc" {
+		t.Errorf("errors = %v, want card_info.exp_month and card_info.cvc", errs)
+	}
+}
+
+func TestPaymentStatusBatch(t *testing.T) {
+	fake := useDB(t)
+	// Orders 5 and 9 have payments; 7 has none.
+	fake.On(`SELECT DISTINCT ON \(order_id\) order_id, id, COALESCE\(status, 'pending'\) FROM payments WHERE order_id = ANY\(\$1\)`).Rows(
+		[]string{"order_id", "id", "status"},
+		[]interface{}{5, 50, "completed"},
+		[]interface{}{9, 91, "refunded"},
+	)
+
+	w := httptest.NewRecorder()
+	getPaymentStatusBatch(w, httptest.NewRequest("POST", "/payments/status/batch", strings.NewReader(`{"order_ids": [9, 7, 5]}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Statuses []OrderPaymentStatus `json:"statuses"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	want := []OrderPaymentStatus{
+		{OrderID: 9, PaymentID: 91, Status: "refunded"},
+		{OrderID: 7, Status: "none"},
+		{OrderID: 5, PaymentID: 50, Status: "completed"},
+	}
+	if len(body.Statuses) != len(want) {
+		t.Fatalf("statuses = %+v", body.Statuses)
+	}
+	for i := range want {
+		if body.Statuses[i] != want[i] {
+			t.Errorf("statuses[%d] = %+v, want %+v", i, body.Statuses[i], want[i])
+		}
+	}
+	if n := len(fake.Calls()); n != 1 {
+		t.Errorf("%d queries, want one", n)
+	}
+}
+
+func TestPaymentStatusBatchRejectsBadRequests(t *testing.T) {
+	tooMany, _ := json.Marshal(map[string]interface{}{"order_ids": make([]int64, maxBatchOrderIDs+1)})
+	for body, want := range map[string]int{
+		`{"order_ids": []}`: http.StatusBadRequest,
+		`not json`:          http.StatusBadRequest,
+		string(tooMany):     http.StatusRequestEntityTooLarge,
+	} {
+		w := httptest.NewRecorder()
+		getPaymentStatusBatch(w, httptest.NewRequest("POST", "/payments/status/batch", strings.NewReader(body)))
+		if w.Code != want {
+			t.Errorf("%.30s: status = %d, want %d", body, w.Code, want)
+		}
+	}
+}
+
+func TestUserScopedPaymentRoutesCheckToken(t *testing.T) {
+	routes := []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+	}{
+		{"payments", getPaymentsByUser, "/payments/user/7"},
+		{"store credit", getStoreCredit, "/payments/credit/7"},
+	}
+	for _, route := range routes {
+		t.Run(route.name, func(t *testing.T) {
+			fake := useDB(t)
+			r := authorize(t, httptest.NewRequest("GET", route.path, nil), 8, "")
+			if w := serveAuthed(route.handler, r, map[string]string{"user_id": "7"}); w.Code != http.StatusForbidden {
+				t.Errorf("other user: status = %d, want 403", w.Code)
+			}
+			if len(fake.Calls()) != 0 {
+				t.Error("another user's payments reached the database")
+			}
+		})
+	}
+}
+
+func TestGetStoreCreditEchoesResolvedUser(t *testing.T) {
+	for _, role := range []string{"", "admin"} {
+		fake := useDB(t)
+		fake.On(`SELECT balance FROM store_credits WHERE user_id = \$1`).Rows([]string{"balance"}, []interface{}{"12.50"})
+
+		userID := uint(7)
+		if role == "admin" {
+			userID = 1
+		}
+		r := authorize(t, httptest.NewRequest("GET", "/payments/credit/7", nil), userID, role)
+		w := serveAuthed(getStoreCredit, r, map[string]string{"user_id": "7"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("role %q: status = %d: %s", role, w.Code, w.Body)
+		}
+		var resp struct {
+			UserID  int     `json:"user_id"`
+			Balance float64 `json:"balance"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.UserID != 7 || resp.Balance != 12.5 {
+			t.Errorf("role %q: response = %+v, want user 7 with 12.50", role, resp)
+		}
+	}
+}
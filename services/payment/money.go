@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in cents. DECIMAL(10,2) columns are scanned from their
+// text form so no float rounding creeps in, and amounts are always written
+// to JSON with exactly two decimals.
+type Money int64
+
+func moneyFromFloat(f float64) Money {
+	return Money(math.Round(f * 100))
+}
+
+func (m Money) Float64() float64 {
+	return float64(m) / 100
+}
+
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	f, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount %s", data)
+	}
+	*m = moneyFromFloat(f)
+	return nil
+}
+
+// Scan implements sql.Scanner for NUMERIC columns.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return m.parse(string(v))
+	case string:
+		return m.parse(v)
+	case float64:
+		*m = moneyFromFloat(v)
+	case int64:
+		*m = Money(v * 100)
+	case nil:
+		*m = 0
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
+
+// Value implements driver.Valuer so amounts are stored from their exact
+// decimal text.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// parse reads a decimal string such as "19.99", "-5" or "3.5" into cents.
+func (m *Money) parse(s string) error {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(frac) > 2 {
+		return fmt.Errorf("amount %q has more than two decimals", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount %q", s)
+	}
+	cents, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount %q", s)
+	}
+
+	total := units*100 + cents
+	if neg {
+		total = -total
+	}
+	*m = Money(total)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMoneyScanAndMarshal(t *testing.T) {
+	tests := []struct {
+		src  interface{}
+		want string
+	}{
+		{[]byte("19.99"), "19.99"},
+		{"19.9", "19.90"},
+		{"5", "5.00"},
+		{"-3.5", "-3.50"},
+		{"0.07", "0.07"},
+		{19.989999, "19.99"},
+		{int64(12), "12.00"},
+		{nil, "0.00"},
+	}
+	for _, tt := range tests {
+		var m Money
+		if err := m.Scan(tt.src); err != nil {
+			t.Errorf("Scan(%v): %v", tt.src, err)
+			continue
+		}
+		b, _ := json.Marshal(m)
+		if string(b) != tt.want {
+			t.Errorf("Scan(%v) marshals as %s, want %s", tt.src, b, tt.want)
+		}
+	}
+}
+
+func TestMoneyScanRejectsBadDecimals(t *testing.T) {
+	for _, src := range []interface{}{"19.999", "abc", "1.x", true} {
+		var m Money
+		if err := m.Scan(src); err == nil {
+			t.Errorf("Scan(%v) = %v, want an error", src, m)
+		}
+	}
+}
+
+func TestMoneyUnmarshalRoundsToCents(t *testing.T) {
+	var m Money
+	if err := json.Unmarshal([]byte("19.989999"), &m); err != nil || m != 1999 {
+		t.Errorf("Unmarshal = %d (%v), want 1999", m, err)
+	}
+}
+
+func TestGetPaymentSerializesTwoDecimals(t *testing.T) {
+	useDB(t).On(`FROM payments WHERE id = \$1`).Rows(
+		[]string{"id", "order_id", "user_id", "amount", "currency", "method", "status", "transaction_id", "payment_gateway", "card_last4", "error_message", "created_at"},
+		[]interface{}{1, 2, 3, []byte("19.90"), "USD", "card", "completed", "tx", "stripe", "4242", "", time.Now()})
+
+	w := httptest.NewRecorder()
+	getPayment(w, mux.SetURLVars(httptest.NewRequest("GET", "/payments/1", nil), map[string]string{"id": "1"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `"amount":19.90`) {
+		t.Errorf("body = %s, want amount 19.90", w.Body)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+var notificationClient = &http.Client{Timeout: 5 * time.Second}
+
+func notificationServiceURL() string {
+	if url := os.Getenv("NOTIFICATION_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://notification-service:8006"
+}
+
+// sendReceipt emails the customer a receipt for a completed payment in the
+// background. The amount covers any store credit applied alongside the
+// charge. Failures are logged; the payment already stands.
+func sendReceipt(p *Payment) {
+	receipt := map[string]interface{}{
+		"user_id":        p.UserID,
+		"order_id":       p.OrderID,
+		"amount":         (p.Amount + p.CreditApplied).Float64(),
+		"transaction_id": p.TransactionID,
+	}
+	go func() {
+		if err := postReceipt(receipt); err != nil {
+			log.Printf("Receipt for payment %d not sent: %v", p.ID, err)
+		}
+	}()
+}
+
+func postReceipt(receipt map[string]interface{}) error {
+	body, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notificationClient.Post(notificationServiceURL()+"/notifications/payment-receipt", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("notification service returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stubReceipts points the service at a notification stub and returns the
+// receipts it receives.
+func stubReceipts(t *testing.T) <-chan map[string]interface{} {
+	t.Helper()
+	receipts := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/notifications/payment-receipt" {
+			t.Errorf("notification sent to %s", r.URL.Path)
+		}
+		var receipt map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&receipt)
+		receipts <- receipt
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("NOTIFICATION_SERVICE_URL", srv.URL)
+	return receipts
+}
+
+func cardCheckout(t *testing.T, approve bool) (<-chan map[string]interface{}, *httptest.ResponseRecorder) {
+	t.Helper()
+	stubOrder(t, 40)
+	receipts := stubReceipts(t)
+	approveCharges(t, approve)
+	fake := useDB(t)
+	fake.On(`SAVEPOINT`)
+	fake.On(`^INSERT INTO payments`).Rows([]string{"id", "created_at"}, []interface{}{1, time.Now()})
+
+	return receipts, pay(map[string]interface{}{"order_id": 1, "user_id": 2, "amount": 40, "method": "card", "card_info": testCard})
+}
+
+func TestCompletedPaymentSendsReceipt(t *testing.T) {
+	receipts, w := cardCheckout(t, true)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var payment struct {
+		TransactionID string `json:"transaction_id"`
+	}
+	json.NewDecoder(w.Body).Decode(&payment)
+
+	select {
+	case receipt := <-receipts:
+		if receipt["amount"] != 40.0 || receipt["order_id"] != 1.0 || receipt["user_id"] != 2.0 ||
+			receipt["transaction_id"] == "" || receipt["transaction_id"] != payment.TransactionID {
+			t.Errorf("receipt = %v, want 40 for order 1 with transaction %s", receipt, payment.TransactionID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no receipt sent")
+	}
+}
+
+func TestDeclinedPaymentSendsNoReceipt(t *testing.T) {
+	receipts, w := cardCheckout(t, false)
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	select {
+	case receipt := <-receipts:
+		t.Errorf("receipt %v sent for a declined payment", receipt)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
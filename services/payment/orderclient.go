@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/promo"
+)
+
+var orderClient = &http.Client{Timeout: 5 * time.Second}
+
+var errOrderNotFound = errors.New("order not found")
+
+func orderServiceURL() string {
+	if url := os.Getenv("ORDER_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://order-service:8004"
+}
+
+type orderInfo struct {
+	ID          uint               `json:"id"`
+	UserID      uint               `json:"user_id"`
+	TotalAmount float64            `json:"total_amount"`
+	BillingAddr string             `json:"billing_address"`
+	Adjustments []promo.Adjustment `json:"adjustments"`
+}
+
+// storeCredit is the store credit the order applied at checkout, which
+// payment deducts from the user's balance.
+func (o *orderInfo) storeCredit() float64 {
+	credit := 0.0
+	for _, adj := range o.Adjustments {
+		if adj.Kind == promo.KindCredit {
+			credit += adj.Amount
+		}
+	}
+	return math.Round(credit*100) / 100
+}
+
+// fetchOrder loads an order from the order service on behalf of the caller,
+// forwarding their Authorization header so ownership is enforced there too.
+func fetchOrder(orderID uint, authorization string) (*orderInfo, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/orders/%d", orderServiceURL(), orderID), nil)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := orderClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errOrderNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("order service returned %d", resp.StatusCode)
+	}
+
+	var o orderInfo
+	if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
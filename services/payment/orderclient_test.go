@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchOrderForwardsAuthAndReadsBillingAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/orders/7" || r.Header.Get("Authorization") != "Bearer tok" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": 7, "user_id": 3, "total_amount": 42.5, "billing_address": "2 Bill Ave, Albany, NY 12207",
+		})
+	}))
+	defer srv.Close()
+	t.Setenv("ORDER_SERVICE_URL", srv.URL)
+
+	order, err := fetchOrder(7, "Bearer tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.UserID != 3 || order.TotalAmount != 42.5 || order.BillingAddr != "2 Bill Ave, Albany, NY 12207" {
+		t.Errorf("order = %+v", order)
+	}
+}
+
+func TestFetchOrderNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	t.Setenv("ORDER_SERVICE_URL", srv.URL)
+
+	if _, err := fetchOrder(7, ""); err != errOrderNotFound {
+		t.Errorf("err = %v, want errOrderNotFound", err)
+	}
+}
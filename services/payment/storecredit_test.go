@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+)
+
+// creditCheckout scripts a checkout against a $40 order that applied credit
+// in store credit, and a balance that covers whatever is asked of it.
+func creditCheckout(t *testing.T, credit float64) *dbtest.DB {
+	t.Helper()
+	stubOrderWithCredit(t, 40, credit)
+	notifications := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(notifications.Close)
+	t.Setenv("NOTIFICATION_SERVICE_URL", notifications.URL)
+
+	fake := useDB(t)
+	fake.On(`SAVEPOINT`)
+	fake.On(`^INSERT INTO payments`).Rows([]string{"id", "created_at"}, []interface{}{1, time.Now()})
+	return fake
+}
+
+func approveCharges(t *testing.T, approve bool) {
+	t.Helper()
+	prev := approveCharge
+	approveCharge = func() bool { return approve }
+	t.Cleanup(func() { approveCharge = prev })
+}
+
+func pay(body map[string]interface{}) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	processPayment(w, httptest.NewRequest(http.MethodPost, "/payments", bytes.NewReader(payload)))
+	return w
+}
+
+var testCard = map[string]string{"number": "4242424242424242", "exp_month": "12", "exp_year": "30", "cvc": "123"}
+
+func TestFullStoreCreditPayment(t *testing.T) {
+	fake := creditCheckout(t, 40)
+	fake.On(`^UPDATE store_credits SET balance = balance - \$1`)
+
+	w := pay(map[string]interface{}{"order_id": 1, "user_id": 2, "amount": 40, "method": "store_credit"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	deductions := fake.Matching(`^UPDATE store_credits`)
+	if len(deductions) != 1 || deductions[0].Args[0] != "40.00" {
+		t.Errorf("deductions = %+v, want 40.00", deductions)
+	}
+	inserts := fake.Matching(`^INSERT INTO payments`)
+	if len(inserts) != 1 || inserts[0].Args[4] != "store_credit" {
+		t.Errorf("payments = %+v, want one store_credit payment", inserts)
+	}
+}
+
+func TestSplitStoreCreditPayment(t *testing.T) {
+	approveCharges(t, true)
+	fake := creditCheckout(t, 10)
+	fake.On(`^UPDATE store_credits SET balance = balance - \$1`)
+
+	w := pay(map[string]interface{}{"order_id": 1, "user_id": 2, "amount": 40, "method": "card", "store_credit_amount": 10, "card_info": testCard})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	inserts := fake.Matching(`^INSERT INTO payments`)
+	if len(inserts) != 2 {
+		t.Fatalf("got %d payments, want credit and card", len(inserts))
+	}
+	if inserts[0].Args[2] != "10.00" || inserts[0].Args[4] != "store_credit" {
+		t.Errorf("credit payment = %v", inserts[0].Args)
+	}
+	if inserts[1].Args[2] != "30.00" || inserts[1].Args[4] != "card" {
+		t.Errorf("card payment = %v", inserts[1].Args)
+	}
+}
+
+func TestDeclinedSplitPaymentReleasesCredit(t *testing.T) {
+	approveCharges(t, false)
+	fake := creditCheckout(t, 10)
+	fake.On(`^UPDATE store_credits SET balance = balance - \$1`)
+
+	w := pay(map[string]interface{}{"order_id": 1, "user_id": 2, "amount": 40, "method": "card", "store_credit_amount": 10, "card_info": testCard})
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("status = %d, want 402", w.Code)
+	}
+
+	var queries []string
+	for _, c := range fake.Calls() {
+		if c.Query == "BEGIN" || c.Query == "ROLLBACK" || c.Query == "COMMIT" || strings.HasPrefix(c.Query, "INSERT") {
+			queries = append(queries, strings.SplitN(c.Query, " (", 2)[0])
+		}
+	}
+	// The deduction and credit payment are rolled back; only the declined
+	// charge is committed.
+	want := "BEGIN INSERT INTO payments ROLLBACK BEGIN INSERT INTO payments COMMIT"
+	if got := strings.Join(queries, " "); !strings.HasPrefix(got, want) {
+		t.Errorf("statements = %q, want %q", got, want)
+	}
+}
+
+func TestInsufficientStoreCredit(t *testing.T) {
+	fake := creditCheckout(t, 40)
+	fake.On(`^UPDATE store_credits SET balance = balance - \$1`).Affected(0)
+
+	w := pay(map[string]interface{}{"order_id": 1, "user_id": 2, "amount": 40, "method": "store_credit"})
+	if w.Code != http.StatusPaymentRequired {
+		t.Errorf("status = %d, want 402", w.Code)
+	}
+	if n := len(fake.Matching(`^INSERT INTO payments|^COMMIT`)); n != 0 {
+		t.Errorf("%d payments or commits recorded without credit", n)
+	}
+}
+
+func TestConcurrentStoreCreditSpendsOnce(t *testing.T) {
+	fake := creditCheckout(t, 40)
+	// The guarded UPDATE only matches while the balance covers the charge,
+	// so the database lets exactly one of the racing deductions through.
+	fake.On(`^UPDATE store_credits SET balance = balance - \$1.* AND balance >= \$1`).Times(1)
+	fake.On(`^UPDATE store_credits`).Affected(0)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- pay(map[string]interface{}{"order_id": 1, "user_id": 2, "amount": 40, "method": "store_credit"}).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusCreated] != 1 || counts[http.StatusPaymentRequired] != 4 {
+		t.Errorf("status counts = %v, want one 201 and four 402", counts)
+	}
+}
+
+func TestStoreCreditComesFromTheOrder(t *testing.T) {
+	approveCharges(t, true)
+	fake := creditCheckout(t, 10)
+	fake.On(`^UPDATE store_credits SET balance = balance - \$1`)
+
+	// Without store_credit_amount, the order's credit is still deducted.
+	w := pay(map[string]interface{}{"order_id": 1, "user_id": 2, "amount": 40, "method": "card", "card_info": testCard})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if deductions := fake.Matching(`^UPDATE store_credits`); len(deductions) != 1 || deductions[0].Args[0] != "10.00" {
+		t.Errorf("deductions = %+v, want the order's 10.00", deductions)
+	}
+	if inserts := fake.Matching(`^INSERT INTO payments`); len(inserts) != 2 || inserts[1].Args[2] != "30.00" {
+		t.Errorf("payments = %+v, want 30.00 charged to the card", inserts)
+	}
+}
+
+func TestStoreCreditMismatchIsRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		credit float64
+		body   map[string]interface{}
+	}{
+		{"more than the order applied", 10, map[string]interface{}{"order_id": 1, "user_id": 2, "amount": 40, "method": "card", "store_credit_amount": 25, "card_info": testCard}},
+		{"credit on an order without any", 0, map[string]interface{}{"order_id": 1, "user_id": 2, "amount": 40, "method": "card", "store_credit_amount": 10, "card_info": testCard}},
+		{"full credit for a partly credited order", 10, map[string]interface{}{"order_id": 1, "user_id": 2, "amount": 40, "method": "store_credit"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := creditCheckout(t, tt.credit)
+
+			if w := pay(tt.body); w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", w.Code)
+			}
+			if len(fake.Calls()) != 0 {
+				t.Error("a mismatched payment touched the database")
+			}
+		})
+	}
+}
+
+func TestRefundingStoreCreditRestoresBalance(t *testing.T) {
+	stubOrder(t, 40)
+	fake := useDB(t)
+	fake.On(`FROM payments WHERE id = \$1 FOR UPDATE`).Rows(
+		[]string{"id", "order_id", "user_id", "amount", "refunded_amount", "method", "status"},
+		[]interface{}{3, 1, 2, "40.00", "0.00", "store_credit", "completed"})
+	fake.On(`^UPDATE payments SET status`)
+	fake.On(`^INSERT INTO store_credits`)
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/payments/3/refund", strings.NewReader(`{"amount": 15}`)), map[string]string{"id": "3"})
+	w := httptest.NewRecorder()
+	refundPayment(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	credits := fake.Matching(`^INSERT INTO store_credits`)
+	if len(credits) != 1 || credits[0].Args[0] != int64(2) || credits[0].Args[1] != "15.00" {
+		t.Errorf("credit refunds = %+v, want 15.00 back to user 2", credits)
+	}
+}
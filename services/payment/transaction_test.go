@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var transactionIDConflict = &pq.Error{Code: "23505", Constraint: "payments_transaction_id_key"}
+
+func TestSavePaymentRetriesTransactionIDCollision(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`SAVEPOINT`)
+	fake.On(`^INSERT INTO payments`).Err(transactionIDConflict).Times(1)
+	fake.On(`^INSERT INTO payments`).Rows([]string{"id", "created_at"}, []interface{}{11, time.Now()})
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	payment := &Payment{TransactionID: "txn_taken"}
+	if err := savePayment(tx, payment); err != nil {
+		t.Fatalf("savePayment: %v", err)
+	}
+	if payment.ID != 11 {
+		t.Errorf("payment id = %d, want 11", payment.ID)
+	}
+
+	inserts := fake.Matching(`^INSERT INTO payments`)
+	if len(inserts) != 2 {
+		t.Fatalf("got %d inserts, want a retry", len(inserts))
+	}
+	if inserts[1].Args[6] == "txn_taken" || payment.TransactionID == "txn_taken" {
+		t.Error("the retry reused the colliding transaction id")
+	}
+	if len(fake.Matching(`^ROLLBACK TO SAVEPOINT payment_insert$`)) != 1 {
+		t.Error("the failed insert was not rolled back to the savepoint")
+	}
+}
+
+func TestSavePaymentGivesUpAfterOneRetry(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`SAVEPOINT`)
+	fake.On(`^INSERT INTO payments`).Err(transactionIDConflict)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if err := savePayment(tx, &Payment{TransactionID: "txn_taken"}); !isTransactionIDConflict(err) {
+		t.Errorf("err = %v, want the collision", err)
+	}
+	if n := len(fake.Matching(`^INSERT INTO payments`)); n != 2 {
+		t.Errorf("got %d inserts, want 2", n)
+	}
+}
+
+func TestIsTransactionIDConflict(t *testing.T) {
+	if isTransactionIDConflict(&pq.Error{Code: "23505", Constraint: "payments_pkey"}) {
+		t.Error("a different unique violation counted as a transaction id collision")
+	}
+	if isTransactionIDConflict(nil) {
+		t.Error("nil counted as a collision")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type batchResult struct {
+	Products []Product `json:"products"`
+	Missing  []int64   `json:"missing"`
+}
+
+func fetchBatch(t *testing.T, r *http.Request) batchResult {
+	t.Helper()
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var res batchResult
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestBatchProductsKeepRequestedOrder(t *testing.T) {
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/products?ids=3,1,9,2", nil),
+		httptest.NewRequest(http.MethodPost, "/products/batch", strings.NewReader(`{"ids": [3, 1, 9, 2]}`)),
+	} {
+		t.Run(r.Method, func(t *testing.T) {
+			fake := useDB(t)
+			// The database returns rows in its own order; 9 doesn't exist.
+			fake.On(`WHERE id = ANY\(\$1\)`).Rows(productColumnNames,
+				productRow(1, "Lamp", 40, time.Now()), productRow(2, "Rug", 90, time.Now()), productRow(3, "Vase", 25, time.Now()))
+
+			res := fetchBatch(t, r)
+			var got []uint
+			for _, p := range res.Products {
+				got = append(got, p.ID)
+			}
+			if len(got) != 3 || got[0] != 3 || got[1] != 1 || got[2] != 2 {
+				t.Errorf("product ids = %v, want [3 1 2]", got)
+			}
+			if len(res.Missing) != 1 || res.Missing[0] != 9 {
+				t.Errorf("missing = %v, want [9]", res.Missing)
+			}
+			if calls := fake.Matching(`FROM products`); len(calls) != 1 {
+				t.Errorf("%d product queries, want one", len(calls))
+			}
+		})
+	}
+}
+
+func TestBatchProductsRejectBadIDs(t *testing.T) {
+	tooMany := strings.Repeat("1,", maxBatchProducts) + "1"
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/products?ids=1,abc", nil),
+		httptest.NewRequest(http.MethodGet, "/products?ids=1,-2", nil),
+		httptest.NewRequest(http.MethodGet, "/products?ids="+tooMany, nil),
+		httptest.NewRequest(http.MethodPost, "/products/batch", strings.NewReader(`{"ids": []}`)),
+		httptest.NewRequest(http.MethodPost, "/products/batch", strings.NewReader(`{"ids": [0]}`)),
+	} {
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: status = %d, want 400", r.Method, r.URL, w.Code)
+		}
+	}
+}
+
+func TestBatchProductsCap(t *testing.T) {
+	ids := make([]string, maxBatchProducts)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i + 1)
+	}
+
+	fake := useDB(t)
+	fake.On(`WHERE id = ANY\(\$1\) AND deleted_at IS NULL`).Rows(productColumnNames)
+	res := fetchBatch(t, httptest.NewRequest(http.MethodPost, "/products/batch", strings.NewReader(`{"ids": [`+strings.Join(ids, ",")+`]}`)))
+	if len(res.Missing) != maxBatchProducts {
+		t.Errorf("%d missing, want all %d reported", len(res.Missing), maxBatchProducts)
+	}
+
+	fake = useDB(t)
+	w := httptest.NewRecorder()
+	body := `{"ids": [` + strings.Join(ids, ",") + `,101]}`
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/products/batch", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("%d ids: status = %d, want 400", maxBatchProducts+1, w.Code)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("an oversize batch reached the database")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const maxBulkProducts = 1000
+
+type BulkResult struct {
+	Index int    `json:"index"`
+	ID    uint   `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// bulkCreateProducts inserts an array of products in one transaction. Any
+// invalid row rolls back the whole batch unless ?partial=true, in which case
+// the valid rows are kept and the rest reported.
+func bulkCreateProducts(w http.ResponseWriter, r *http.Request) {
+	var products []Product
+	if err := json.NewDecoder(r.Body).Decode(&products); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(products) == 0 {
+		http.Error(w, "At least one product is required", http.StatusBadRequest)
+		return
+	}
+	if len(products) > maxBulkProducts {
+		http.Error(w, fmt.Sprintf("At most %d products per request", maxBulkProducts), http.StatusRequestEntityTooLarge)
+		return
+	}
+	partial := r.URL.Query().Get("partial") == "true"
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	results := make([]BulkResult, len(products))
+	created := 0
+	for i := range products {
+		p := &products[i]
+		results[i].Index = i
+
+		normalizeProduct(p)
+		if err := validateProduct(*p); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		id, err := insertBulkProduct(tx, p)
+		if isSKUConflict(err) {
+			results[i].Error = "sku already exists"
+			continue
+		}
+		if err != nil {
+			results[i].Error = "failed to insert product"
+			continue
+		}
+		results[i].ID = id
+		created++
+	}
+
+	failed := len(products) - created
+	if failed > 0 && !partial {
+		// Nothing was kept, so don't hand out ids that no longer exist.
+		for i := range results {
+			results[i].ID = 0
+		}
+		writeBulkResults(w, http.StatusBadRequest, 0, results)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusCreated
+	if created == 0 {
+		status = http.StatusBadRequest
+	}
+	writeBulkResults(w, status, created, results)
+}
+
+// insertBulkProduct inserts one product and its images under a savepoint, so
+// a failed row leaves the rest of the transaction usable.
+func insertBulkProduct(tx *sql.Tx, p *Product) (uint, error) {
+	if _, err := tx.Exec("SAVEPOINT bulk_product"); err != nil {
+		return 0, err
+	}
+
+	normalizeProductImages(p)
+	err := tx.QueryRow(
+		`INSERT INTO products (name, description, price, stock, category, image_url, sku, length_cm, width_cm, height_cm, weight_grams, sale_price, sale_ends_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10, $11, $12, $13) RETURNING id`,
+		p.Name, p.Description, p.Price, p.Stock, p.Category, p.ImageURL, p.SKU,
+		p.LengthCM, p.WidthCM, p.HeightCM, p.WeightGrams, p.SalePrice, p.SaleEndsAt,
+	).Scan(&p.ID)
+	if err == nil {
+		err = recordStockSet(tx, int64(p.ID), 0, p.Stock, reasonInitialStock)
+	}
+	if err == nil {
+		err = insertProductImages(tx, p.ID, p.Images)
+	}
+	if err == nil {
+		err = replaceProductTags(tx, p.ID, p.Tags)
+	}
+	if err != nil {
+		tx.Exec("ROLLBACK TO SAVEPOINT bulk_product")
+		return 0, err
+	}
+
+	_, err = tx.Exec("RELEASE SAVEPOINT bulk_product")
+	return p.ID, err
+}
+
+func writeBulkResults(w http.ResponseWriter, status, created int, results []BulkResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"created": created,
+		"failed":  len(results) - created,
+		"results": results,
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+)
+
+// scriptBulkInserts answers each product insert with the next id from 20.
+func scriptBulkInserts(t *testing.T) *dbtest.DB {
+	t.Helper()
+	fake := useDB(t)
+	for id := 20; id < 25; id++ {
+		fake.On(`^INSERT INTO products`).Rows([]string{"id"}, []interface{}{id}).Times(1)
+	}
+	fake.On(`SAVEPOINT bulk_product`)
+	fake.On(`INSERT INTO stock_movements`)
+	fake.On(`product_tags`)
+	return fake
+}
+
+type bulkResponse struct {
+	Created int          `json:"created"`
+	Failed  int          `json:"failed"`
+	Results []BulkResult `json:"results"`
+}
+
+func postBulk(t *testing.T, path, body string) (int, bulkResponse) {
+	t.Helper()
+	w := sendAsAdmin(t, "POST", path, body)
+	var resp bulkResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	return w.Code, resp
+}
+
+const bulkBatch = `[
+	{"name": "Lamp", "price": 40, "stock": 3, "category": "Home"},
+	{"name": "", "price": 10, "category": "Home"},
+	{"name": "Rug", "price": -5, "category": "Home"},
+	{"name": "Vase", "price": 25, "category": "Home"}
+]`
+
+func TestBulkCreateCleanBatch(t *testing.T) {
+	fake := scriptBulkInserts(t)
+
+	code, resp := postBulk(t, "/products/bulk", `[
+		{"name": "Lamp", "price": 40, "stock": 3, "category": "Home"},
+		{"name": "Vase", "price": 25, "category": "Home"}
+	]`)
+	if code != http.StatusCreated || resp.Created != 2 || resp.Failed != 0 {
+		t.Fatalf("status = %d, response = %+v", code, resp)
+	}
+	if resp.Results[0].ID != 20 || resp.Results[1].ID != 21 {
+		t.Errorf("results = %+v, want ids 20 and 21 in order", resp.Results)
+	}
+	if calls := fake.Calls(); calls[len(calls)-1].Query != "COMMIT" {
+		t.Error("batch not committed")
+	}
+}
+
+func TestBulkCreateInvalidRowRollsBackBatch(t *testing.T) {
+	fake := scriptBulkInserts(t)
+
+	// Nothing is kept, so every row counts as failed, but only the invalid
+	// ones carry an error.
+	code, resp := postBulk(t, "/products/bulk", bulkBatch)
+	if code != http.StatusBadRequest || resp.Created != 0 || resp.Failed != 4 {
+		t.Fatalf("status = %d, response = %+v", code, resp)
+	}
+	for _, r := range resp.Results {
+		if r.ID != 0 {
+			t.Errorf("row %d reports id %d from a rolled-back batch", r.Index, r.ID)
+		}
+	}
+	if resp.Results[0].Error != "" || resp.Results[1].Error == "" || resp.Results[2].Error == "" {
+		t.Errorf("results = %+v, want rows 1 and 2 rejected", resp.Results)
+	}
+	if len(fake.Matching(`^COMMIT`)) != 0 {
+		t.Error("an invalid batch was committed")
+	}
+}
+
+func TestBulkCreatePartialKeepsValidRows(t *testing.T) {
+	fake := scriptBulkInserts(t)
+
+	code, resp := postBulk(t, "/products/bulk?partial=true", bulkBatch)
+	if code != http.StatusCreated || resp.Created != 2 || resp.Failed != 2 {
+		t.Fatalf("status = %d, response = %+v", code, resp)
+	}
+	if resp.Results[0].ID != 20 || resp.Results[3].ID != 21 || resp.Results[1].ID != 0 || resp.Results[2].ID != 0 {
+		t.Errorf("results = %+v, want rows 0 and 3 created", resp.Results)
+	}
+	if n := len(fake.Matching(`^INSERT INTO products`)); n != 2 {
+		t.Errorf("%d inserts, want only the valid rows", n)
+	}
+	if calls := fake.Calls(); calls[len(calls)-1].Query != "COMMIT" {
+		t.Error("partial batch not committed")
+	}
+}
+
+func TestBulkCreateRejectsOversizeBatch(t *testing.T) {
+	fake := useDB(t)
+
+	rows := make([]string, maxBulkProducts+1)
+	for i := range rows {
+		rows[i] = `{"name": "Lamp", "price": 1, "category": "Home"}`
+	}
+	code, _ := postBulk(t, "/products/bulk", "["+strings.Join(rows, ",")+"]")
+	if code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", code)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("an oversize batch reached the database")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// categoryCache keeps the category list in memory; it changes rarely but is
+// fetched on every catalog page load. A ttl of 0 disables caching.
+type categoryCache struct {
+	mu         sync.RWMutex
+	categories []Category
+	loadedAt   time.Time
+	ttl        time.Duration
+}
+
+var categoriesCache = &categoryCache{
+	ttl: time.Duration(envInt("PRODUCT_CATEGORY_CACHE_SECONDS", 300)) * time.Second,
+}
+
+// get returns the cached categories, reloading them once they are stale.
+func (c *categoryCache) get() ([]Category, error) {
+	c.mu.RLock()
+	categories, loadedAt := c.categories, c.loadedAt
+	c.mu.RUnlock()
+
+	if categories != nil && time.Since(loadedAt) < c.ttl {
+		return categories, nil
+	}
+	return c.load()
+}
+
+func (c *categoryCache) load() ([]Category, error) {
+	rows, err := db.Query("SELECT id, name, parent_id, COALESCE(default_sort, '') FROM categories ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var cat Category
+		if err := rows.Scan(&cat.ID, &cat.Name, &cat.ParentID, &cat.DefaultSort); err != nil {
+			return nil, err
+		}
+		categories = append(categories, cat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.categories, c.loadedAt = categories, time.Now()
+	c.mu.Unlock()
+	return categories, nil
+}
+
+// invalidate drops the cached list so the next get reads it fresh. Category
+// writes call it; other instances catch up when their ttl runs out.
+func (c *categoryCache) invalidate() {
+	c.mu.Lock()
+	c.categories = nil
+	c.mu.Unlock()
+}
+
+// warmCaches fills the in-memory caches before the service takes traffic so
+// the first requests after a deploy don't all miss at once. Failures are
+// logged and left to the normal lazy load.
+func warmCaches() {
+	start := time.Now()
+	categories, err := categoriesCache.load()
+	if err != nil {
+		log.Printf("Cache warm-up: failed to load categories: %v", err)
+		return
+	}
+	log.Printf("Cache warm-up: loaded %d categories in %s", len(categories), time.Since(start))
+}
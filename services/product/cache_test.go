@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// useCategoryCache gives the test an empty category cache.
+func useCategoryCache(t *testing.T) *categoryCache {
+	t.Helper()
+	prev := categoriesCache
+	categoriesCache = &categoryCache{ttl: time.Minute}
+	t.Cleanup(func() { categoriesCache = prev })
+	return categoriesCache
+}
+
+func TestWarmCachesLoadsCategories(t *testing.T) {
+	cache := useCategoryCache(t)
+	fake := useDB(t)
+	fake.On(`FROM categories ORDER BY name`).Rows([]string{"id", "name", "parent_id", "default_sort"},
+		[]interface{}{1, "Home", nil, ""}, []interface{}{2, "Lamps", 1, "price_asc"})
+
+	warmCaches()
+
+	if len(cache.categories) != 2 || cache.categories[1].Name != "Lamps" {
+		t.Fatalf("cached categories = %+v", cache.categories)
+	}
+	// Requests after the warm-up are served from memory.
+	if _, err := cache.get(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Matching(`FROM categories`)); n != 1 {
+		t.Errorf("categories queried %d times, want once", n)
+	}
+}
+
+func TestWarmCachesFailureLeavesLazyLoad(t *testing.T) {
+	cache := useCategoryCache(t)
+	useDB(t).On(`FROM categories`).Err(errors.New("connection refused"))
+
+	warmCaches()
+	if cache.categories != nil {
+		t.Errorf("cached categories = %+v after a failed load", cache.categories)
+	}
+}
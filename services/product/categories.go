@@ -0,0 +1,367 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	maxCategoryNameLength = 100
+	// maxCategoryDepth bounds ancestor walks, so a cycle that slipped into
+	// the table can't loop forever.
+	maxCategoryDepth = 32
+)
+
+var (
+	errCategoryNotFound = errors.New("category not found")
+	errCategoryCycle    = errors.New("category hierarchy contains a cycle")
+)
+
+type categoryRequest struct {
+	Name string `json:"name"`
+	// ParentID nests the category; 0 makes it a root. Omitted on rename, the
+	// current parent is kept.
+	ParentID *uint `json:"parent_id"`
+	// DefaultSort is one of the ?sort= values, or "" for the global default.
+	// Omitted on rename, the current default is kept.
+	DefaultSort *string `json:"default_sort"`
+}
+
+// decodeCategory reads a categoryRequest, answering 400 and returning
+// ok=false when the name is missing or too long.
+func decodeCategory(w http.ResponseWriter, r *http.Request) (categoryRequest, bool) {
+	var req categoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return req, false
+	}
+	if len(req.Name) > maxCategoryNameLength {
+		http.Error(w, "name is too long", http.StatusBadRequest)
+		return req, false
+	}
+	if req.DefaultSort != nil && *req.DefaultSort != "" {
+		if _, ok := productSortOrders[*req.DefaultSort]; !ok {
+			http.Error(w, "Invalid default_sort", http.StatusBadRequest)
+			return req, false
+		}
+	}
+	return req, true
+}
+
+// categoryDefaultSort returns the default sort configured for the named
+// category, or "" when it has none. Lookups go through the category cache,
+// so a failure to load it just means the global default.
+func categoryDefaultSort(name string) string {
+	categories, err := categoriesCache.get()
+	if err != nil {
+		log.Printf("Default sort for category %q: %v", name, err)
+		return ""
+	}
+	for _, cat := range categories {
+		if cat.Name == name {
+			return cat.DefaultSort
+		}
+	}
+	return ""
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// categoryAncestry returns the category followed by each of its ancestors up
+// to the root.
+func categoryAncestry(q queryRower, id uint) ([]Category, error) {
+	path := []Category{}
+	seen := map[uint]bool{}
+	for next := &id; next != nil; next = path[len(path)-1].ParentID {
+		if seen[*next] || len(path) >= maxCategoryDepth {
+			return nil, errCategoryCycle
+		}
+		seen[*next] = true
+
+		var cat Category
+		err := q.QueryRow("SELECT id, name, parent_id FROM categories WHERE id = $1", *next).Scan(&cat.ID, &cat.Name, &cat.ParentID)
+		if err == sql.ErrNoRows {
+			return nil, errCategoryNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, cat)
+	}
+	return path, nil
+}
+
+// checkParent verifies parentID can become the parent of id (0 for a new
+// category): it must exist and must not be id or one of id's descendants.
+func checkParent(q queryRower, id, parentID uint) error {
+	ancestors, err := categoryAncestry(q, parentID)
+	if err != nil {
+		return err
+	}
+	for _, cat := range ancestors {
+		if cat.ID == id {
+			return errCategoryCycle
+		}
+	}
+	return nil
+}
+
+// writeParentError reports a rejected parent_id.
+func writeParentError(w http.ResponseWriter, err error) {
+	switch err {
+	case errCategoryNotFound:
+		http.Error(w, "Parent category not found", http.StatusBadRequest)
+	case errCategoryCycle:
+		http.Error(w, "parent_id would create a cycle", http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to check parent category", http.StatusInternalServerError)
+	}
+}
+
+// getCategoryBreadcrumb returns the path from the root category down to the
+// requested one.
+func getCategoryBreadcrumb(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	path, err := categoryAncestry(db, uint(id))
+	if err == errCategoryNotFound {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Breadcrumb for category %d: %v", id, err)
+		http.Error(w, "Failed to build breadcrumb", http.StatusInternalServerError)
+		return
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(path)
+}
+
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+func createCategory(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeCategory(w, r)
+	if !ok {
+		return
+	}
+
+	cat := Category{Name: req.Name}
+	if req.DefaultSort != nil {
+		cat.DefaultSort = *req.DefaultSort
+	}
+	if req.ParentID != nil && *req.ParentID != 0 {
+		if err := checkParent(db, 0, *req.ParentID); err != nil {
+			writeParentError(w, err)
+			return
+		}
+		cat.ParentID = req.ParentID
+	}
+
+	err := db.QueryRow(
+		"INSERT INTO categories (name, parent_id, default_sort) VALUES ($1, $2, NULLIF($3, '')) RETURNING id",
+		cat.Name, cat.ParentID, cat.DefaultSort,
+	).Scan(&cat.ID)
+	if isUniqueViolation(err) {
+		http.Error(w, "Category already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create category", http.StatusInternalServerError)
+		return
+	}
+	categoriesCache.invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(cat)
+}
+
+// renameCategory renames a category along with every product filed under it,
+// since products reference categories by name, and optionally moves it under
+// another parent or changes its default sort.
+func renameCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	req, ok := decodeCategory(w, r)
+	if !ok {
+		return
+	}
+	name := req.Name
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var oldName, defaultSort string
+	var parentID *uint
+	err = tx.QueryRow(
+		"SELECT name, parent_id, COALESCE(default_sort, '') FROM categories WHERE id = $1 FOR UPDATE", id,
+	).Scan(&oldName, &parentID, &defaultSort)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update category", http.StatusInternalServerError)
+		return
+	}
+
+	if req.ParentID != nil {
+		parentID = nil
+		if *req.ParentID != 0 {
+			if err := checkParent(tx, uint(id), *req.ParentID); err != nil {
+				writeParentError(w, err)
+				return
+			}
+			parentID = req.ParentID
+		}
+	}
+
+	if req.DefaultSort != nil {
+		defaultSort = *req.DefaultSort
+	}
+
+	_, err = tx.Exec(
+		"UPDATE categories SET name = $1, parent_id = $2, default_sort = NULLIF($3, '') WHERE id = $4",
+		name, parentID, defaultSort, id,
+	)
+	if isUniqueViolation(err) {
+		http.Error(w, "Category already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update category", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec("UPDATE products SET category = $1, version = version + 1 WHERE category = $2", name, oldName); err != nil {
+		http.Error(w, "Failed to update products", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	categoriesCache.invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Category{ID: uint(id), Name: name, ParentID: parentID, DefaultSort: defaultSort})
+}
+
+// deleteCategory removes an unused category. If products still use it the
+// request is refused unless ?reassign= names a category to move them to,
+// which is created if needed.
+func deleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	reassign := strings.TrimSpace(r.URL.Query().Get("reassign"))
+	if len(reassign) > maxCategoryNameLength {
+		http.Error(w, "reassign is too long", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var name string
+	err = tx.QueryRow("SELECT name FROM categories WHERE id = $1 FOR UPDATE", id).Scan(&name)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete category", http.StatusInternalServerError)
+		return
+	}
+	if reassign == name {
+		http.Error(w, "Cannot reassign products to the category being deleted", http.StatusBadRequest)
+		return
+	}
+
+	var inUse int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM products WHERE category = $1", name).Scan(&inUse); err != nil {
+		http.Error(w, "Failed to delete category", http.StatusInternalServerError)
+		return
+	}
+
+	var moved int64
+	if inUse > 0 {
+		if reassign == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":    "category still has products; pass ?reassign= to move them",
+				"products": inUse,
+			})
+			return
+		}
+		if _, err := tx.Exec("INSERT INTO categories (name) VALUES ($1) ON CONFLICT DO NOTHING", reassign); err != nil {
+			http.Error(w, "Failed to create reassign category", http.StatusInternalServerError)
+			return
+		}
+		result, err := tx.Exec("UPDATE products SET category = $1, version = version + 1 WHERE category = $2", reassign, name)
+		if err != nil {
+			http.Error(w, "Failed to reassign products", http.StatusInternalServerError)
+			return
+		}
+		moved, _ = result.RowsAffected()
+	}
+
+	if _, err := tx.Exec("DELETE FROM categories WHERE id = $1", id); err != nil {
+		http.Error(w, "Failed to delete category", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	categoriesCache.invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted":  true,
+		"id":       id,
+		"reassign": reassign,
+		"moved":    moved,
+	})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+	"github.com/lib/pq"
+)
+
+func TestCreateCategory(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^INSERT INTO categories`).Rows([]string{"id"}, []interface{}{12})
+
+	w := sendAsAdmin(t, "POST", "/categories", `{"name": " Garden "}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var cat Category
+	json.NewDecoder(w.Body).Decode(&cat)
+	if cat.ID != 12 || cat.Name != "Garden" {
+		t.Errorf("category = %+v, want 12 Garden", cat)
+	}
+}
+
+func TestCreateCategoryDuplicateConflicts(t *testing.T) {
+	useDB(t).On(`^INSERT INTO categories`).Err(&pq.Error{Code: "23505", Constraint: "categories_name_key"})
+
+	if w := sendAsAdmin(t, "POST", "/categories", `{"name": "Home"}`); w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", w.Code)
+	}
+}
+
+func TestCategoryWritesRequireAdmin(t *testing.T) {
+	fake := useDB(t)
+	for _, req := range []struct{ method, path string }{
+		{"POST", "/categories"}, {"PUT", "/categories/3"}, {"DELETE", "/categories/3"},
+	} {
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, authorize(t, httptest.NewRequest(req.method, req.path, strings.NewReader(`{"name": "Garden"}`)), 5, "customer"))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status = %d, want 403", req.method, req.path, w.Code)
+		}
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("a customer's category write reached the database")
+	}
+}
+
+func TestRenameCategoryMovesProducts(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`SELECT name, parent_id, COALESCE\(default_sort, ''\) FROM categories WHERE id = \$1 FOR UPDATE`).
+		Rows([]string{"name", "parent_id", "default_sort"}, []interface{}{"Home", nil, ""})
+	fake.On(`^UPDATE categories SET name`)
+	fake.On(`^UPDATE products SET category`)
+
+	if w := sendAsAdmin(t, "PUT", "/categories/3", `{"name": "Home & Living"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	moves := fake.Matching(`^UPDATE products SET category`)
+	if len(moves) != 1 || moves[0].Args[0] != "Home & Living" || moves[0].Args[1] != "Home" {
+		t.Errorf("product updates = %+v, want Home renamed", moves)
+	}
+	if calls := fake.Calls(); calls[len(calls)-1].Query != "COMMIT" {
+		t.Error("rename not committed")
+	}
+}
+
+func TestRenameCategoryDuplicateConflicts(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`FROM categories WHERE id = \$1 FOR UPDATE`).Rows([]string{"name", "parent_id", "default_sort"}, []interface{}{"Home", nil, ""})
+	fake.On(`^UPDATE categories SET name`).Err(&pq.Error{Code: "23505", Constraint: "categories_name_key"})
+
+	if w := sendAsAdmin(t, "PUT", "/categories/3", `{"name": "Garden"}`); w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", w.Code)
+	}
+	if len(fake.Matching(`^UPDATE products`)) != 0 {
+		t.Error("products were renamed into a conflicting category")
+	}
+}
+
+// scriptCategoryDelete scripts category 3, Home, with inUse products.
+func scriptCategoryDelete(t *testing.T, inUse int) *dbtest.DB {
+	t.Helper()
+	fake := useDB(t)
+	fake.On(`SELECT name FROM categories WHERE id = \$1 FOR UPDATE`).Rows([]string{"name"}, []interface{}{"Home"})
+	fake.On(`SELECT COUNT\(\*\) FROM products WHERE category = \$1`).Rows([]string{"count"}, []interface{}{inUse})
+	fake.On(`^INSERT INTO categories`)
+	fake.On(`^UPDATE products SET category`).Affected(int64(inUse))
+	fake.On(`^DELETE FROM categories`)
+	return fake
+}
+
+func TestDeleteCategoryWithProductsConflicts(t *testing.T) {
+	fake := scriptCategoryDelete(t, 4)
+
+	w := sendAsAdmin(t, "DELETE", "/categories/3", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409", w.Code)
+	}
+	var resp struct {
+		Products int `json:"products"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Products != 4 {
+		t.Errorf("products = %d, want 4", resp.Products)
+	}
+	if len(fake.Matching(`^(DELETE|UPDATE)`)) != 0 {
+		t.Error("a category in use was deleted")
+	}
+}
+
+func TestDeleteCategoryReassignsProducts(t *testing.T) {
+	fake := scriptCategoryDelete(t, 4)
+
+	w := sendAsAdmin(t, "DELETE", "/categories/3?reassign=Uncategorized", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Moved int `json:"moved"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Moved != 4 {
+		t.Errorf("moved = %d, want 4", resp.Moved)
+	}
+	if created := fake.Matching(`^INSERT INTO categories .* ON CONFLICT DO NOTHING`); len(created) != 1 || created[0].Args[0] != "Uncategorized" {
+		t.Errorf("inserts = %+v, want Uncategorized ensured", created)
+	}
+	moves := fake.Matching(`^UPDATE products SET category`)
+	if len(moves) != 1 || moves[0].Args[0] != "Uncategorized" || moves[0].Args[1] != "Home" {
+		t.Errorf("product updates = %+v", moves)
+	}
+	if deletes := fake.Matching(`^DELETE FROM categories`); len(deletes) != 1 || deletes[0].Args[0] != int64(3) {
+		t.Errorf("deletes = %+v", deletes)
+	}
+	if calls := fake.Calls(); calls[len(calls)-1].Query != "COMMIT" {
+		t.Error("delete not committed")
+	}
+}
+
+func TestDeleteUnusedCategory(t *testing.T) {
+	fake := scriptCategoryDelete(t, 0)
+
+	if w := sendAsAdmin(t, "DELETE", "/categories/3", ""); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if len(fake.Matching(`^DELETE FROM categories`)) != 1 || len(fake.Matching(`^UPDATE`)) != 0 {
+		t.Errorf("calls = %+v, want only the category deleted", fake.Calls())
+	}
+}
+
+// scriptAncestry answers the ancestor walk with each (id, name, parent_id)
+// row in turn.
+func scriptAncestry(fake *dbtest.DB, rows ...[]interface{}) {
+	for _, row := range rows {
+		fake.On(`SELECT id, name, parent_id FROM categories WHERE id = \$1`).Rows([]string{"id", "name", "parent_id"}, row).Times(1)
+	}
+}
+
+func TestCategoryBreadcrumb(t *testing.T) {
+	fake := useDB(t)
+	scriptAncestry(fake, []interface{}{3, "Desk lamps", 2}, []interface{}{2, "Lighting", 1}, []interface{}{1, "Home", nil})
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/categories/3/breadcrumb", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var path []Category
+	json.NewDecoder(w.Body).Decode(&path)
+	var names []string
+	for _, c := range path {
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, " > ") != "Home > Lighting > Desk lamps" {
+		t.Errorf("breadcrumb = %v, want root first", names)
+	}
+	lookups := fake.Matching(`FROM categories WHERE id`)
+	if len(lookups) != 3 || lookups[1].Args[0] != int64(2) || lookups[2].Args[0] != int64(1) {
+		t.Errorf("lookups = %+v, want a walk from 3 up through 2 and 1", lookups)
+	}
+}
+
+func TestCategoryBreadcrumbDetectsCycle(t *testing.T) {
+	fake := useDB(t)
+	scriptAncestry(fake, []interface{}{3, "Desk lamps", 2}, []interface{}{2, "Lighting", 3}, []interface{}{3, "Desk lamps", 2})
+
+	if _, err := categoryAncestry(fake.DB, 3); err != errCategoryCycle {
+		t.Errorf("categoryAncestry = %v, want errCategoryCycle", err)
+	}
+	if n := len(fake.Matching(`FROM categories`)); n != 2 {
+		t.Errorf("walked %d categories, want to stop on revisiting 3", n)
+	}
+}
+
+func TestCategoryBreadcrumbNotFound(t *testing.T) {
+	useDB(t).On(`FROM categories WHERE id = \$1`)
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/categories/9/breadcrumb", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestRenameCategoryRejectsDescendantParent(t *testing.T) {
+	// Moving Lighting (2) under Desk lamps (3), its own child, would close
+	// a loop.
+	fake := useDB(t)
+	fake.On(`FROM categories WHERE id = \$1 FOR UPDATE`).Rows([]string{"name", "parent_id", "default_sort"}, []interface{}{"Lighting", 1, ""})
+	scriptAncestry(fake, []interface{}{3, "Desk lamps", 2}, []interface{}{2, "Lighting", 1}, []interface{}{1, "Home", nil})
+
+	if w := sendAsAdmin(t, "PUT", "/categories/2", `{"name": "Lighting", "parent_id": 3}`); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+	if len(fake.Matching(`^UPDATE`)) != 0 {
+		t.Error("a cyclic parent was saved")
+	}
+}
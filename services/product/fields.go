@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// productFields are the keys ?fields= may select from a product listing.
+// Gallery images and ratings are never part of listings, so they aren't
+// offered here.
+var productFields = map[string]bool{
+	"id": true, "name": true, "description": true, "price": true, "stock": true,
+	"category": true, "image_url": true, "sku": true, "length_cm": true,
+	"width_cm": true, "height_cm": true, "weight_grams": true, "created_at": true,
+	"version": true, "sale_price": true, "sale_ends_at": true,
+	"effective_price": true, "tags": true,
+}
+
+// parseFields reads a comma-separated ?fields= list. An empty list means
+// every field; an unknown name is an error.
+func parseFields(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	fields := []string{}
+	seen := map[string]bool{}
+	for _, f := range strings.Split(value, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" || seen[f] {
+			continue
+		}
+		if !productFields[f] {
+			return nil, fmt.Errorf("unknown field: %s", f)
+		}
+		seen[f] = true
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// projectProducts trims each product down to fields, or returns products
+// untouched when no fields were asked for. Projection works on the encoded
+// product so computed values such as effective_price come out the same as
+// in a full response. Fields a product leaves out (an empty sku) stay out.
+func projectProducts(products []Product, fields []string) (interface{}, error) {
+	if fields == nil {
+		return products, nil
+	}
+
+	projected := make([]map[string]json.RawMessage, 0, len(products))
+	for _, p := range products {
+		encoded, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(encoded, &all); err != nil {
+			return nil, err
+		}
+
+		out := make(map[string]json.RawMessage, len(fields))
+		for _, f := range fields {
+			if v, ok := all[f]; ok {
+				out[f] = v
+			}
+		}
+		projected = append(projected, out)
+	}
+	return projected, nil
+}
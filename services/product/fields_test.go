@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"id,name", []string{"id", "name"}},
+		{" id , price ,id,", []string{"id", "price"}},
+	}
+	for _, tt := range tests {
+		got, err := parseFields(tt.value)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFields(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+
+	for _, value := range []string{"id,password", "reviews", "images"} {
+		if _, err := parseFields(value); err == nil {
+			t.Errorf("parseFields(%q) accepted an unknown field", value)
+		}
+	}
+}
+
+// projectedKeys fetches target and returns the sorted keys of each product
+// in the response.
+func projectedKeys(t *testing.T, target string) [][]string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d: %s", target, w.Code, w.Body)
+	}
+	var body struct {
+		Products []map[string]json.RawMessage `json:"products"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	keys := make([][]string, len(body.Products))
+	for i, p := range body.Products {
+		for k := range p {
+			keys[i] = append(keys[i], k)
+		}
+		sort.Strings(keys[i])
+	}
+	return keys
+}
+
+func TestProductListingProjectsFields(t *testing.T) {
+	useExcludedCategories(t)
+	fake := useDB(t)
+	fake.On(`^SELECT COUNT`).Rows([]string{"count"}, []interface{}{2})
+	fake.On(`FROM products WHERE`).Rows(productColumnNames,
+		productRow(1, "Lamp", 40, time.Now()), productRow(2, "Rug", 90, time.Now()))
+
+	want := []string{"id", "image_url", "name", "price"}
+	for i, keys := range projectedKeys(t, "/products?fields=id,name,price,image_url") {
+		if !reflect.DeepEqual(keys, want) {
+			t.Errorf("product %d has %v, want %v", i, keys, want)
+		}
+	}
+}
+
+func TestProductBatchProjectsFields(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`WHERE id = ANY\(\$1\)`).Rows(productColumnNames, productRow(1, "Lamp", 40, time.Now()))
+
+	keys := projectedKeys(t, "/products?ids=1&fields=id,effective_price")
+	if len(keys) != 1 || !reflect.DeepEqual(keys[0], []string{"effective_price", "id"}) {
+		t.Errorf("keys = %v, want id and effective_price", keys)
+	}
+}
+
+func TestProductListingWithoutFieldsIsComplete(t *testing.T) {
+	useExcludedCategories(t)
+	fake := useDB(t)
+	fake.On(`^SELECT COUNT`).Rows([]string{"count"}, []interface{}{1})
+	fake.On(`FROM products WHERE`).Rows(productColumnNames, productRow(1, "Lamp", 40, time.Now()))
+
+	keys := projectedKeys(t, "/products")
+	if len(keys) != 1 {
+		t.Fatalf("got %d products, want 1", len(keys))
+	}
+	have := map[string]bool{}
+	for _, k := range keys[0] {
+		have[k] = true
+	}
+	for _, k := range []string{"id", "name", "description", "stock", "category", "created_at", "version", "effective_price"} {
+		if !have[k] {
+			t.Errorf("unprojected product is missing %s", k)
+		}
+	}
+}
+
+func TestProductListingRejectsUnknownFields(t *testing.T) {
+	fake := useDB(t)
+
+	for _, target := range []string{"/products?fields=id,secret", "/products?ids=1&fields=bogus"} {
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", target, w.Code)
+		}
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("an invalid projection reached the database")
+	}
+}
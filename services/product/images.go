@@ -0,0 +1,254 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/response"
+)
+
+type ProductImage struct {
+	ID       uint   `json:"id"`
+	URL      string `json:"url"`
+	Position int    `json:"position"`
+}
+
+// A product's gallery lives in product_images ordered by position. The
+// first image is mirrored into products.image_url, which older clients and
+// the listing still read.
+
+// loadProductImages returns the gallery URLs in display order.
+func loadProductImages(q rowsQuerier, productID uint) ([]string, error) {
+	rows, err := q.Query("SELECT url FROM product_images WHERE product_id = $1 ORDER BY position, id", productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []string{}
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		images = append(images, url)
+	}
+	return images, rows.Err()
+}
+
+type rowsQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// normalizeProductImages makes the primary image lead the gallery, whichever
+// of image_url and images it was given in.
+func normalizeProductImages(p *Product) {
+	if p.ImageURL == "" && len(p.Images) > 0 {
+		p.ImageURL = p.Images[0]
+	} else if p.ImageURL != "" && (len(p.Images) == 0 || p.Images[0] != p.ImageURL) {
+		p.Images = append([]string{p.ImageURL}, p.Images...)
+	}
+}
+
+// insertProductImages stores images for a new product in the order given.
+func insertProductImages(tx *sql.Tx, productID uint, images []string) error {
+	for i, url := range images {
+		if _, err := tx.Exec(
+			"INSERT INTO product_images (product_id, url, position) VALUES ($1, $2, $3)",
+			productID, url, i,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncPrimaryImage copies the first gallery image into products.image_url.
+func syncPrimaryImage(tx *sql.Tx, productID int) error {
+	_, err := tx.Exec(
+		`UPDATE products SET image_url = COALESCE(
+			(SELECT url FROM product_images WHERE product_id = $1 ORDER BY position, id LIMIT 1), ''
+		 ), version = version + 1 WHERE id = $1`,
+		productID,
+	)
+	return err
+}
+
+// replacePrimaryImage points the product's first gallery image at url, as
+// part of an update that has already written products.image_url. An empty url
+// removes the primary image instead, and the next one takes its place.
+func replacePrimaryImage(tx *sql.Tx, productID int64, url string) error {
+	var imageID int64
+	err := tx.QueryRow(
+		"SELECT id FROM product_images WHERE product_id = $1 ORDER BY position, id LIMIT 1 FOR UPDATE",
+		productID,
+	).Scan(&imageID)
+	switch {
+	case err == sql.ErrNoRows:
+		if url == "" {
+			return nil
+		}
+		_, err = tx.Exec("INSERT INTO product_images (product_id, url, position) VALUES ($1, $2, 0)", productID, url)
+		return err
+	case err != nil:
+		return err
+	case url != "":
+		_, err = tx.Exec("UPDATE product_images SET url = $1 WHERE id = $2", url, imageID)
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM product_images WHERE id = $1", imageID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`UPDATE products SET image_url = COALESCE(
+			(SELECT url FROM product_images WHERE product_id = $1 ORDER BY position, id LIMIT 1), ''
+		 ) WHERE id = $1`,
+		productID,
+	)
+	return err
+}
+
+// addProductImage appends an image to a product's gallery, or inserts it at
+// position when one is given.
+func addProductImage(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		URL      string `json:"url"`
+		Position *int   `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.URL = strings.TrimSpace(req.URL)
+	if req.URL == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+	if req.Position != nil && *req.Position < 0 {
+		http.Error(w, "position cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var primary string
+	err = tx.QueryRow("SELECT COALESCE(image_url, '') FROM products WHERE id = $1 FOR UPDATE", productID).Scan(&primary)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to add image", http.StatusInternalServerError)
+		return
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM product_images WHERE product_id = $1", productID).Scan(&count); err != nil {
+		http.Error(w, "Failed to add image", http.StatusInternalServerError)
+		return
+	}
+
+	// Products created before galleries existed only have image_url; keep it
+	// as the first image rather than letting the new one replace it.
+	if count == 0 && primary != "" {
+		if err := insertProductImages(tx, uint(productID), []string{primary}); err != nil {
+			http.Error(w, "Failed to add image", http.StatusInternalServerError)
+			return
+		}
+		count = 1
+	}
+
+	position := count
+	if req.Position != nil && *req.Position < count {
+		position = *req.Position
+		if _, err := tx.Exec(
+			"UPDATE product_images SET position = position + 1 WHERE product_id = $1 AND position >= $2",
+			productID, position,
+		); err != nil {
+			http.Error(w, "Failed to add image", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	image := ProductImage{URL: req.URL, Position: position}
+	err = tx.QueryRow(
+		"INSERT INTO product_images (product_id, url, position) VALUES ($1, $2, $3) RETURNING id",
+		productID, image.URL, image.Position,
+	).Scan(&image.ID)
+	if err != nil {
+		http.Error(w, "Failed to add image", http.StatusInternalServerError)
+		return
+	}
+
+	if err := syncPrimaryImage(tx, productID); err != nil {
+		http.Error(w, "Failed to add image", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(image)
+}
+
+// deleteProductImage removes an image from the gallery; if it was the
+// primary image the next one takes its place.
+func deleteProductImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	imageID, err := strconv.ParseInt(vars["image_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid image ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM product_images WHERE id = $1 AND product_id = $2", imageID, productID)
+	if err != nil {
+		http.Error(w, "Failed to delete image", http.StatusInternalServerError)
+		return
+	}
+	n, _ := result.RowsAffected()
+
+	if n > 0 {
+		if err := syncPrimaryImage(tx, productID); err != nil {
+			http.Error(w, "Failed to delete image", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	response.Deleted(w, r, imageID, n > 0)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeProductImages(t *testing.T) {
+	tests := []struct {
+		name      string
+		imageURL  string
+		images    []string
+		wantURL   string
+		wantImage []string
+	}{
+		{"image_url only", "a.png", nil, "a.png", []string{"a.png"}},
+		{"images only", "", []string{"a.png", "b.png"}, "a.png", []string{"a.png", "b.png"}},
+		{"image_url leads", "a.png", []string{"a.png", "b.png"}, "a.png", []string{"a.png", "b.png"}},
+		{"image_url prepended", "c.png", []string{"a.png", "b.png"}, "c.png", []string{"c.png", "a.png", "b.png"}},
+		{"neither", "", nil, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Product{ImageURL: tt.imageURL, Images: tt.images}
+			normalizeProductImages(&p)
+			if p.ImageURL != tt.wantURL {
+				t.Errorf("ImageURL = %q, want %q", p.ImageURL, tt.wantURL)
+			}
+			if !reflect.DeepEqual(p.Images, tt.wantImage) {
+				t.Errorf("Images = %v, want %v", p.Images, tt.wantImage)
+			}
+			if len(p.Images) > 0 && p.Images[0] != p.ImageURL {
+				t.Errorf("primary image %q does not lead gallery %v", p.ImageURL, p.Images)
+			}
+		})
+	}
+}
+
+// sendAsAdmin routes method path with body as an admin.
+func sendAsAdmin(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, authorize(t, httptest.NewRequest(method, path, strings.NewReader(body)), 1, "admin"))
+	return w
+}
+
+func TestGetProductImagesInPositionOrder(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`FROM products WHERE id = \$1`).Rows(productColumnNames, productRow(3, "Lamp", 40, time.Now()))
+	fake.On(`SELECT url FROM product_images WHERE product_id = \$1 ORDER BY position, id`).
+		Rows([]string{"url"}, []interface{}{"front.png"}, []interface{}{"side.png"}, []interface{}{"back.png"})
+	fake.On(`FROM reviews`).Rows([]string{"count", "avg"}, []interface{}{0, nil})
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/products/3", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var p Product
+	json.NewDecoder(w.Body).Decode(&p)
+	if want := []string{"front.png", "side.png", "back.png"}; !reflect.DeepEqual(p.Images, want) {
+		t.Errorf("images = %v, want %v", p.Images, want)
+	}
+}
+
+func TestAddProductImageAtFrontBecomesPrimary(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`SELECT COALESCE\(image_url, ''\) FROM products WHERE id = \$1 FOR UPDATE`).Rows([]string{"image_url"}, []interface{}{"front.png"})
+	fake.On(`SELECT COUNT\(\*\) FROM product_images`).Rows([]string{"count"}, []interface{}{2})
+	fake.On(`^UPDATE product_images SET position = position \+ 1`)
+	fake.On(`^INSERT INTO product_images`).Rows([]string{"id"}, []interface{}{11})
+	fake.On(`^UPDATE products SET image_url = COALESCE`)
+
+	w := sendAsAdmin(t, "POST", "/products/3/images", `{"url": "hero.png", "position": 0}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if shifts := fake.Matching(`position = position \+ 1`); len(shifts) != 1 || shifts[0].Args[1] != int64(0) {
+		t.Errorf("shifts = %+v, want images from position 0 moved down", shifts)
+	}
+	inserts := fake.Matching(`^INSERT INTO product_images`)
+	if len(inserts) != 1 || inserts[0].Args[1] != "hero.png" || inserts[0].Args[2] != int64(0) {
+		t.Errorf("inserts = %+v, want hero.png at position 0", inserts)
+	}
+	calls := fake.Calls()
+	if len(calls) < 2 || calls[len(calls)-1].Query != "COMMIT" || !strings.HasPrefix(calls[len(calls)-2].Query, "UPDATE products SET image_url") {
+		t.Errorf("calls = %+v, want image_url resynced before commit", calls)
+	}
+}
+
+func TestAddProductImageKeepsLegacyPrimary(t *testing.T) {
+	// A product from before galleries has image_url but no product_images
+	// rows; its image stays first.
+	fake := useDB(t)
+	fake.On(`SELECT COALESCE\(image_url, ''\) FROM products`).Rows([]string{"image_url"}, []interface{}{"old.png"})
+	fake.On(`SELECT COUNT\(\*\) FROM product_images`).Rows([]string{"count"}, []interface{}{0})
+	fake.On(`^INSERT INTO product_images .* RETURNING id`).Rows([]string{"id"}, []interface{}{12})
+	fake.On(`^INSERT INTO product_images`)
+	fake.On(`^UPDATE products SET image_url`)
+
+	if w := sendAsAdmin(t, "POST", "/products/3/images", `{"url": "new.png"}`); w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	inserts := fake.Matching(`^INSERT INTO product_images`)
+	if len(inserts) != 2 || inserts[0].Args[1] != "old.png" || inserts[0].Args[2] != int64(0) ||
+		inserts[1].Args[1] != "new.png" || inserts[1].Args[2] != int64(1) {
+		t.Errorf("inserts = %+v, want old.png at 0 then new.png at 1", inserts)
+	}
+}
+
+func TestDeleteProductImageResyncsPrimary(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^DELETE FROM product_images WHERE id = \$1 AND product_id = \$2`)
+	fake.On(`^UPDATE products SET image_url`)
+
+	if w := sendAsAdmin(t, "DELETE", "/products/3/images/11", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if syncs := fake.Matching(`^UPDATE products SET image_url`); len(syncs) != 1 || syncs[0].Args[0] != int64(3) {
+		t.Errorf("syncs = %+v, want product 3's image_url recomputed", syncs)
+	}
+
+	fake = useDB(t)
+	fake.On(`^DELETE FROM product_images`).Affected(0)
+	sendAsAdmin(t, "DELETE", "/products/3/images/99", "")
+	if len(fake.Matching(`^UPDATE`)) != 0 {
+		t.Error("deleting a missing image touched the product")
+	}
+}
+
+func TestUpdateProductImageURLSyncsGallery(t *testing.T) {
+	tests := []struct {
+		name     string
+		imageURL string
+		primary  []interface{}
+		want     string
+	}{
+		{"replaces primary", "hero.png", []interface{}{5}, `^UPDATE product_images SET url`},
+		{"creates primary", "hero.png", nil, `^INSERT INTO product_images`},
+		{"clearing drops primary", "", []interface{}{5}, `^DELETE FROM product_images WHERE id = \$1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useDB(t)
+			fake.On(`SELECT COALESCE\(stock, 0\) FROM products WHERE id = \$1 FOR UPDATE`).Rows([]string{"stock"}, []interface{}{10})
+			fake.On(`^UPDATE products SET name`).Rows([]string{"version", "low_stock_threshold"}, []interface{}{2, 0})
+			primary := fake.On(`SELECT id FROM product_images WHERE product_id = \$1 ORDER BY position, id LIMIT 1 FOR UPDATE`)
+			if tt.primary != nil {
+				primary.Rows([]string{"id"}, tt.primary)
+			}
+			fake.On(`^UPDATE product_images SET url`)
+			fake.On(`^INSERT INTO product_images`)
+			fake.On(`^DELETE FROM product_images`)
+			fake.On(`^UPDATE products SET image_url`)
+
+			body := `{"name": "Lamp", "price": 40, "stock": 10, "category": "Home", "image_url": "` + tt.imageURL + `"}`
+			if w := sendAsAdmin(t, "PUT", "/products/3", body); w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			writes := fake.Matching(`^(UPDATE|INSERT INTO|DELETE FROM) product_images`)
+			if len(writes) != 1 || !regexp.MustCompile(tt.want).MatchString(writes[0].Query) {
+				t.Fatalf("gallery writes = %+v, want one matching %s", writes, tt.want)
+			}
+			if tt.imageURL != "" && writes[0].Args[0] != tt.imageURL && writes[0].Args[1] != tt.imageURL {
+				t.Errorf("gallery write %+v does not carry %s", writes[0], tt.imageURL)
+			}
+			if synced := len(fake.Matching(`^UPDATE products SET image_url`)) == 1; synced != (tt.imageURL == "") {
+				t.Errorf("image_url resynced = %v, want only when the primary was dropped", synced)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	importFetchTimeout = 10 * time.Second
+	importMaxBytes     = 10 << 20
+)
+
+var importClient = &http.Client{Timeout: importFetchTimeout}
+
+// defaultImportMapping maps product fields to feed keys when the request
+// doesn't override them.
+var defaultImportMapping = map[string]string{
+	"sku":         "sku",
+	"name":        "name",
+	"description": "description",
+	"price":       "price",
+	"stock":       "stock",
+	"category":    "category",
+	"image_url":   "image_url",
+}
+
+type ImportResult struct {
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Failed  int           `json:"failed"`
+	Errors  []ImportError `json:"errors,omitempty"`
+}
+
+type ImportError struct {
+	Index int    `json:"index"`
+	SKU   string `json:"sku,omitempty"`
+	Error string `json:"error"`
+}
+
+// importProductsFromURL fetches a supplier's JSON feed (an array, or an
+// object with a "products" array) and upserts each entry by SKU.
+func importProductsFromURL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL     string            `json:"url"`
+		Mapping map[string]string `json:"mapping"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	feedURL, err := url.Parse(req.URL)
+	if err != nil || (feedURL.Scheme != "http" && feedURL.Scheme != "https") || feedURL.Host == "" {
+		http.Error(w, "url must be an http(s) URL", http.StatusBadRequest)
+		return
+	}
+
+	mapping := make(map[string]string, len(defaultImportMapping))
+	for field, key := range defaultImportMapping {
+		mapping[field] = key
+	}
+	for field, key := range req.Mapping {
+		if _, ok := defaultImportMapping[field]; !ok {
+			http.Error(w, "Unknown mapping field: "+field, http.StatusBadRequest)
+			return
+		}
+		mapping[field] = key
+	}
+
+	entries, err := fetchFeed(feedURL.String())
+	if err != nil {
+		http.Error(w, "Failed to fetch feed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	result := ImportResult{}
+	for i, entry := range entries {
+		p, err := mapFeedEntry(entry, mapping)
+		if err == nil {
+			var inserted bool
+			inserted, err = upsertProductBySKU(&p)
+			if err == nil && inserted {
+				result.Created++
+			} else if err == nil {
+				result.Updated++
+			}
+		}
+		if err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, ImportError{Index: i, SKU: p.SKU, Error: err.Error()})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func fetchFeed(feedURL string) ([]map[string]interface{}, error) {
+	resp, err := importClient.Get(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, importMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > importMaxBytes {
+		return nil, fmt.Errorf("feed exceeds %d bytes", importMaxBytes)
+	}
+
+	var entries []map[string]interface{}
+	if err := json.Unmarshal(body, &entries); err == nil {
+		return entries, nil
+	}
+
+	var wrapped struct {
+		Products []map[string]interface{} `json:"products"`
+	}
+	if err := json.Unmarshal(body, &wrapped); err != nil {
+		return nil, fmt.Errorf("feed is not a JSON product list")
+	}
+	return wrapped.Products, nil
+}
+
+// importedProduct is one feed row: the values it carried, and which mapped
+// fields were present so an update only touches those columns.
+type importedProduct struct {
+	Product
+	present map[string]bool
+}
+
+// importFields are the product fields a feed can set besides sku, in the
+// order their columns are written. Each is also the column's name.
+var importFields = []string{"name", "description", "price", "stock", "category", "image_url"}
+
+func mapFeedEntry(entry map[string]interface{}, mapping map[string]string) (importedProduct, error) {
+	p := importedProduct{present: map[string]bool{}}
+	for field, key := range mapping {
+		if v, ok := entry[key]; ok && v != nil {
+			p.present[field] = true
+		}
+	}
+
+	p.SKU = strings.TrimSpace(feedString(entry[mapping["sku"]]))
+	p.Name = feedString(entry[mapping["name"]])
+	p.Description = feedString(entry[mapping["description"]])
+	p.Category = feedString(entry[mapping["category"]])
+	p.ImageURL = feedString(entry[mapping["image_url"]])
+
+	if p.SKU == "" {
+		return p, fmt.Errorf("missing sku")
+	}
+	if p.present["price"] {
+		price, err := feedFloat(entry[mapping["price"]])
+		if err != nil {
+			return p, fmt.Errorf("invalid price")
+		}
+		p.Price = price
+	}
+	if p.present["stock"] {
+		stock, err := feedFloat(entry[mapping["stock"]])
+		if err != nil || stock != float64(int(stock)) {
+			return p, fmt.Errorf("invalid stock")
+		}
+		p.Stock = int(stock)
+	}
+	return p, nil
+}
+
+// mergeImportedProduct overlays the fields present in a feed row onto the
+// stored product.
+func mergeImportedProduct(existing Product, p importedProduct) Product {
+	if p.present["name"] {
+		existing.Name = p.Name
+	}
+	if p.present["description"] {
+		existing.Description = p.Description
+	}
+	if p.present["price"] {
+		existing.Price = p.Price
+	}
+	if p.present["stock"] {
+		existing.Stock = p.Stock
+	}
+	if p.present["category"] {
+		existing.Category = p.Category
+	}
+	if p.present["image_url"] {
+		existing.ImageURL = p.ImageURL
+	}
+	return existing
+}
+
+func feedString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+func feedFloat(v interface{}) (float64, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(t), 64)
+	default:
+		return 0, fmt.Errorf("not a number")
+	}
+}
+
+// upsertProductBySKU creates the product with p.SKU, or updates the columns
+// the feed row carried on the existing one. Either way the result must pass
+// validateProduct. It reports whether a new row was created.
+func upsertProductBySKU(p *importedProduct) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var existing Product
+	err = scanProduct(tx.QueryRow("SELECT "+productColumns+" FROM products WHERE sku = $1 FOR UPDATE", p.SKU), &existing)
+	if err == sql.ErrNoRows {
+		if err := insertImportedProduct(tx, p); err != nil {
+			return false, err
+		}
+		return true, tx.Commit()
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := updateImportedProduct(tx, existing, p); err != nil {
+		return false, err
+	}
+	return false, tx.Commit()
+}
+
+func insertImportedProduct(tx *sql.Tx, p *importedProduct) error {
+	if !p.present["price"] {
+		return fmt.Errorf("missing price")
+	}
+	normalizeProduct(&p.Product)
+	normalizeProductImages(&p.Product)
+	if err := validateProduct(p.Product); err != nil {
+		return err
+	}
+
+	err := tx.QueryRow(
+		`INSERT INTO products (sku, name, description, price, stock, category, image_url)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		p.SKU, p.Name, p.Description, p.Price, p.Stock, p.Category, p.ImageURL,
+	).Scan(&p.ID)
+	if err != nil {
+		return err
+	}
+	if err := recordStockSet(tx, int64(p.ID), 0, p.Stock, reasonImport); err != nil {
+		return err
+	}
+	return insertProductImages(tx, p.ID, p.Images)
+}
+
+func updateImportedProduct(tx *sql.Tx, existing Product, p *importedProduct) error {
+	merged := mergeImportedProduct(existing, *p)
+	normalizeProduct(&merged)
+	if err := validateProduct(merged); err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{
+		"name": merged.Name, "description": merged.Description, "price": merged.Price,
+		"stock": merged.Stock, "category": merged.Category, "image_url": merged.ImageURL,
+	}
+	set := []string{}
+	args := []interface{}{existing.ID}
+	for _, field := range importFields {
+		if p.present[field] {
+			args = append(args, values[field])
+			set = append(set, fmt.Sprintf("%s = $%d", field, len(args)))
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	if _, err := tx.Exec("UPDATE products SET "+strings.Join(set, ", ")+", version = version + 1 WHERE id = $1", args...); err != nil {
+		return err
+	}
+	if err := recordStockSet(tx, int64(existing.ID), existing.Stock, merged.Stock, reasonImport); err != nil {
+		return err
+	}
+	if p.present["image_url"] {
+		return replacePrimaryImage(tx, int64(existing.ID), merged.ImageURL)
+	}
+	return nil
+}
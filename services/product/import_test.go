@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMapFeedEntry(t *testing.T) {
+	entry := map[string]interface{}{"sku": " AB-1 ", "price": "12.50", "stock": 3.0, "description": nil}
+	p, err := mapFeedEntry(entry, defaultImportMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SKU != "AB-1" || p.Price != 12.5 || p.Stock != 3 {
+		t.Errorf("mapped %+v", p.Product)
+	}
+	for _, field := range []string{"sku", "price", "stock"} {
+		if !p.present[field] {
+			t.Errorf("%s should be present", field)
+		}
+	}
+	for _, field := range []string{"name", "description", "category", "image_url"} {
+		if p.present[field] {
+			t.Errorf("%s should not be present", field)
+		}
+	}
+}
+
+func TestMapFeedEntryRejectsMalformedRows(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry map[string]interface{}
+		want  string
+	}{
+		{"missing sku", map[string]interface{}{"name": "Lamp"}, "missing sku"},
+		{"price not a number", map[string]interface{}{"sku": "A", "price": "cheap"}, "invalid price"},
+		{"fractional stock", map[string]interface{}{"sku": "A", "stock": 1.5}, "invalid stock"},
+		{"stock not a number", map[string]interface{}{"sku": "A", "stock": true}, "invalid stock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := mapFeedEntry(tt.entry, defaultImportMapping)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeImportedProductKeepsAbsentFields(t *testing.T) {
+	existing := Product{Name: "Lamp", Description: "Brass", Price: 40, Stock: 7, Category: "Home", ImageURL: "lamp.png"}
+	p, err := mapFeedEntry(map[string]interface{}{"sku": "LAMP", "price": 35.0}, defaultImportMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged := mergeImportedProduct(existing, p)
+	want := existing
+	want.Price = 35
+	if merged.Name != want.Name || merged.Description != want.Description || merged.Price != want.Price ||
+		merged.Stock != want.Stock || merged.Category != want.Category || merged.ImageURL != want.ImageURL {
+		t.Errorf("merged = %+v, want %+v", merged, want)
+	}
+}
+
+func TestMergedImportIsValidated(t *testing.T) {
+	salePrice := 30.0
+	existing := Product{Name: "Lamp", Price: 40, Category: "Home", SalePrice: &salePrice}
+
+	tests := []struct {
+		name  string
+		entry map[string]interface{}
+		field string
+	}{
+		{"negative stock", map[string]interface{}{"sku": "LAMP", "stock": -2.0}, "stock"},
+		{"blank name", map[string]interface{}{"sku": "LAMP", "name": "  "}, "name"},
+		{"price below sale price", map[string]interface{}{"sku": "LAMP", "price": 25.0}, "sale_price"},
+		{"sub-cent price", map[string]interface{}{"sku": "LAMP", "price": 9.999}, "price"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := mapFeedEntry(tt.entry, defaultImportMapping)
+			if err != nil {
+				t.Fatal(err)
+			}
+			merged := mergeImportedProduct(existing, p)
+			normalizeProduct(&merged)
+			err = validateProduct(merged)
+			if err == nil || !strings.Contains(err.Error(), tt.field+":") {
+				t.Errorf("err = %v, want a %s error", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestFetchFeed(t *testing.T) {
+	feeds := map[string]string{
+		"/list":    `[{"sku": "A"}, {"sku": "B"}]`,
+		"/wrapped": `{"products": [{"sku": "A"}]}`,
+		"/broken":  `{"products": [`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := feeds[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	if entries, err := fetchFeed(srv.URL + "/list"); err != nil || len(entries) != 2 {
+		t.Errorf("list feed: %d entries, err %v", len(entries), err)
+	}
+	if entries, err := fetchFeed(srv.URL + "/wrapped"); err != nil || len(entries) != 1 {
+		t.Errorf("wrapped feed: %d entries, err %v", len(entries), err)
+	}
+	if _, err := fetchFeed(srv.URL + "/broken"); err == nil {
+		t.Error("malformed feed should fail")
+	}
+	if _, err := fetchFeed(srv.URL + "/missing"); err == nil {
+		t.Error("404 feed should fail")
+	}
+}
+
+func TestImportProductsFromURLRejectsBadRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not http", `{"url": "file:///etc/passwd"}`},
+		{"unknown mapping field", `{"url": "http://feed.test/", "mapping": {"colour": "color"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/products/import/url", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			importProductsFromURL(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", w.Code)
+			}
+		})
+	}
+}
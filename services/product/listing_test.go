@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/pagination"
+)
+
+type productPage struct {
+	Products   []Product `json:"products"`
+	NextCursor string    `json:"next_cursor"`
+	Total      int       `json:"total"`
+	Limit      int       `json:"limit"`
+	Offset     int       `json:"offset"`
+}
+
+func listProducts(t *testing.T, query string) productPage {
+	t.Helper()
+	w := httptest.NewRecorder()
+	getProducts(w, httptest.NewRequest(http.MethodGet, "/products?"+query, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /products?%s = %d: %s", query, w.Code, w.Body)
+	}
+	var page productPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestProductCursorPagesDoNotOverlap(t *testing.T) {
+	base := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	fake := useDB(t)
+	fake.On(`FROM products WHERE deleted_at IS NULL ORDER BY created_at DESC, id DESC LIMIT \$1`).Rows(productColumnNames,
+		productRow(9, "Lamp", 40, base.Add(3*time.Minute)), productRow(8, "Rug", 90, base.Add(2*time.Minute)), productRow(7, "Vase", 25, base.Add(time.Minute)))
+	fake.On(`FROM products WHERE deleted_at IS NULL AND \(created_at, id\) < \(\$1, \$2\)`).Rows(productColumnNames,
+		productRow(7, "Vase", 25, base.Add(time.Minute)))
+
+	first := listProducts(t, "cursor=&limit=2")
+	if len(first.Products) != 2 || first.Products[0].ID != 9 || first.Products[1].ID != 8 {
+		t.Fatalf("first page = %+v", first.Products)
+	}
+	if _, id, err := pagination.DecodeCursor(first.NextCursor); err != nil || id != 8 {
+		t.Fatalf("next_cursor points at %d (%v), want 8", id, err)
+	}
+
+	second := listProducts(t, "cursor="+url.QueryEscape(first.NextCursor)+"&limit=2")
+	if len(second.Products) != 1 || second.Products[0].ID != 7 || second.NextCursor != "" {
+		t.Errorf("second page = %+v, next_cursor %q", second.Products, second.NextCursor)
+	}
+
+	calls := fake.Matching(`\(created_at, id\) <`)
+	if len(calls) != 1 || calls[0].Args[1] != int64(8) || calls[0].Args[2] != int64(3) {
+		t.Errorf("second page args = %+v", calls)
+	}
+}
+
+func TestProductOffsetPagingStillWorks(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^SELECT COUNT\(\*\) FROM products`).Rows([]string{"count"}, []interface{}{3})
+	fake.On(`LIMIT \$1 OFFSET \$2`).Rows(productColumnNames, productRow(8, "Rug", 90, time.Now()))
+
+	page := listProducts(t, "limit=1&offset=1")
+	if len(page.Products) != 1 || page.Total != 3 || page.Limit != 1 || page.Offset != 1 {
+		t.Errorf("page = %+v", page)
+	}
+}
+
+// useExcludedCategories sets the hidden categories for the rest of the test.
+func useExcludedCategories(t *testing.T, categories ...string) {
+	t.Helper()
+	prev := excludedCategories
+	excludedCategories = categories
+	t.Cleanup(func() { excludedCategories = prev })
+}
+
+func TestExcludedCategoriesHiddenByDefault(t *testing.T) {
+	useExcludedCategories(t, "Archived", "Internal")
+	fake := useDB(t)
+	hidden := `WHERE deleted_at IS NULL AND \(category IS NULL OR NOT category = ANY\(\$1\)\)`
+	fake.On(`^SELECT COUNT\(\*\) FROM products `+hidden).Rows([]string{"count"}, []interface{}{1})
+	fake.On(`FROM products `+hidden+` ORDER BY`).Rows(productColumnNames, productRow(8, "Rug", 90, time.Now()))
+
+	page := listProducts(t, "")
+	if len(page.Products) != 1 || page.Products[0].ID != 8 || page.Total != 1 {
+		t.Errorf("page = %+v", page)
+	}
+	for _, c := range fake.Matching(`FROM products`) {
+		if c.Args[0] != `{"Archived","Internal"}` {
+			t.Errorf("excluded categories arg = %v", c.Args[0])
+		}
+	}
+}
+
+func TestExcludedCategoryVisibleWhenRequested(t *testing.T) {
+	useExcludedCategories(t, "Archived")
+	fake := useDB(t)
+	fake.On(`categories`).Rows([]string{"default_sort"})
+	fake.On(`^SELECT COUNT\(\*\) FROM products WHERE deleted_at IS NULL AND category = \$1$`).Rows([]string{"count"}, []interface{}{1})
+	fake.On(`FROM products WHERE deleted_at IS NULL AND category = \$1 ORDER BY`).Rows(productColumnNames, productRow(3, "Old lamp", 10, time.Now()))
+
+	page := listProducts(t, "category=Archived")
+	if len(page.Products) != 1 || page.Products[0].ID != 3 {
+		t.Errorf("page = %+v", page)
+	}
+	if calls := fake.Matching(`ANY`); len(calls) != 0 {
+		t.Errorf("explicit category still excluded: %+v", calls)
+	}
+}
+
+func TestProductSearchMinimumLength(t *testing.T) {
+	prev := searchMinLength
+	searchMinLength = 3
+	t.Cleanup(func() { searchMinLength = prev })
+	useExcludedCategories(t)
+
+	// Padding doesn't count towards the minimum.
+	for _, term := range []string{"ab", "++a+"} {
+		fake := useDB(t)
+		w := httptest.NewRecorder()
+		getProducts(w, httptest.NewRequest(http.MethodGet, "/products?search="+term, nil))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "at least 3 characters") {
+			t.Errorf("search %q: status = %d, body %q", term, w.Code, w.Body)
+		}
+		if len(fake.Calls()) != 0 {
+			t.Errorf("search %q queried the database", term)
+		}
+	}
+
+	fake := useDB(t)
+	fake.On(`^SELECT COUNT\(\*\) FROM products WHERE deleted_at IS NULL AND \(name ILIKE \$1 OR description ILIKE \$1\)$`).Rows([]string{"count"}, []interface{}{1})
+	fake.On(`ILIKE \$1\) ORDER BY`).Rows(productColumnNames, productRow(5, "Red shoes", 60, time.Now()))
+
+	page := listProducts(t, "search=++red+++shoes+")
+	if len(page.Products) != 1 || page.Products[0].ID != 5 {
+		t.Errorf("page = %+v", page)
+	}
+	if calls := fake.Matching(`ILIKE`); len(calls) != 2 || calls[0].Args[0] != "%red shoes%" {
+		t.Errorf("search args = %+v, want the normalized term", calls)
+	}
+}
+
+func TestProductSortOrders(t *testing.T) {
+	useExcludedCategories(t)
+	tests := map[string]string{
+		"price_asc":          "price ASC, id ASC",
+		"price_desc":         "price DESC, id DESC",
+		"name_asc":           "name ASC, id ASC",
+		"name_desc":          "name DESC, id DESC",
+		"newest":             "created_at DESC, id DESC",
+		"":                   "created_at DESC, id DESC",
+		"price;DROP+TABLE+x": "created_at DESC, id DESC",
+		"created_at+ASC":     "created_at DESC, id DESC",
+	}
+	for sort, orderBy := range tests {
+		fake := useDB(t)
+		fake.On(`^SELECT COUNT`).Rows([]string{"count"}, []interface{}{0})
+		fake.On(`ORDER BY`).Rows(productColumnNames)
+
+		listProducts(t, "sort="+sort)
+		page := fake.Matching(`ORDER BY`)
+		if len(page) != 1 || !strings.HasSuffix(page[0].Query, "ORDER BY "+orderBy+" LIMIT $1 OFFSET $2") {
+			t.Errorf("sort=%s: query = %+v, want ORDER BY %s", sort, page, orderBy)
+		}
+	}
+}
+
+func TestProductSortCombinesWithFilters(t *testing.T) {
+	useExcludedCategories(t)
+	fake := useDB(t)
+	fake.On(`^SELECT COUNT`).Rows([]string{"count"}, []interface{}{0})
+	fake.On(`ORDER BY`).Rows(productColumnNames)
+
+	listProducts(t, "category=Lamps&search=brass&sort=price_desc&limit=5&offset=10")
+	page := fake.Matching(`ORDER BY`)
+	want := "WHERE deleted_at IS NULL AND category = $1 AND (name ILIKE $2 OR description ILIKE $2) ORDER BY price DESC, id DESC LIMIT $3 OFFSET $4"
+	if len(page) != 1 || !strings.HasSuffix(page[0].Query, want) {
+		t.Fatalf("query = %+v, want it to end %q", page, want)
+	}
+	if args := page[0].Args; args[0] != "Lamps" || args[1] != "%brass%" || args[2] != int64(5) || args[3] != int64(10) {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestProductPriceRange(t *testing.T) {
+	useExcludedCategories(t)
+	tests := []struct {
+		query string
+		where string
+		args  []interface{}
+	}{
+		{"min_price=10", "AND price >= $1", []interface{}{10.0}},
+		{"max_price=99.5", "AND price <= $1", []interface{}{99.5}},
+		{"min_price=10&max_price=10", "AND price >= $1 AND price <= $2", []interface{}{10.0, 10.0}},
+		{"search=lamp&min_price=0&max_price=20", "ILIKE $1) AND price >= $2 AND price <= $3", []interface{}{"%lamp%", 0.0, 20.0}},
+	}
+	for _, tt := range tests {
+		fake := useDB(t)
+		fake.On(`^SELECT COUNT`).Rows([]string{"count"}, []interface{}{0})
+		fake.On(`ORDER BY`).Rows(productColumnNames)
+
+		listProducts(t, tt.query)
+		for _, c := range fake.Calls() {
+			if !strings.Contains(c.Query, tt.where) {
+				t.Errorf("%s: query %q lacks %q", tt.query, c.Query, tt.where)
+				continue
+			}
+			for i, want := range tt.args {
+				if c.Args[i] != want {
+					t.Errorf("%s: arg %d = %v, want %v", tt.query, i+1, c.Args[i], want)
+				}
+			}
+		}
+	}
+}
+
+func TestProductPriceRangeRejectsInvalid(t *testing.T) {
+	for _, query := range []string{"min_price=abc", "max_price=-1", "min_price=NaN", "min_price=20&max_price=10"} {
+		fake := useDB(t)
+		w := httptest.NewRecorder()
+		getProducts(w, httptest.NewRequest(http.MethodGet, "/products?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+		}
+		if len(fake.Calls()) != 0 {
+			t.Errorf("%s: queried the database", query)
+		}
+	}
+}
+
+func TestProductTotalReflectsFilters(t *testing.T) {
+	useExcludedCategories(t)
+	fake := useDB(t)
+	filtered := `WHERE deleted_at IS NULL AND category = \$1 AND \(name ILIKE \$2 OR description ILIKE \$2\) AND price >= \$3 AND price <= \$4`
+	fake.On(`^SELECT COUNT\(\*\) FROM products `+filtered+`$`).Rows([]string{"count"}, []interface{}{37})
+	fake.On(filtered+` ORDER BY`).Rows(productColumnNames,
+		productRow(9, "Brass lamp", 40, time.Now()), productRow(8, "Brass sconce", 30, time.Now()))
+
+	page := listProducts(t, "category=Lamps&search=brass&min_price=10&max_price=50&limit=2&offset=4&sort=newest")
+	if len(page.Products) != 2 || page.Total != 37 || page.Limit != 2 || page.Offset != 4 {
+		t.Errorf("page = %d products, total %d, limit %d, offset %d; want 2 of 37 at 4", len(page.Products), page.Total, page.Limit, page.Offset)
+	}
+
+	// The count sees exactly the page's filter arguments and no paging.
+	count, rows := fake.Matching(`^SELECT COUNT`)[0], fake.Matching(`ORDER BY`)[0]
+	if len(count.Args) != 4 || len(rows.Args) != 6 {
+		t.Fatalf("count args = %v, page args = %v", count.Args, rows.Args)
+	}
+	for i := range count.Args {
+		if count.Args[i] != rows.Args[i] {
+			t.Errorf("arg %d: count %v, page %v", i+1, count.Args[i], rows.Args[i])
+		}
+	}
+}
+
+func TestCategoryDefaultSort(t *testing.T) {
+	tests := []struct {
+		query string
+		order string
+	}{
+		{"category=Books", "price ASC, id ASC"},
+		{"category=Home", "created_at DESC, id DESC"},
+		{"category=Books&sort=name_desc", "name DESC, id DESC"},
+		{"", "created_at DESC, id DESC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			useExcludedCategories(t)
+			useCategoryCache(t)
+			fake := useDB(t)
+			fake.On(`FROM categories ORDER BY name`).Rows([]string{"id", "name", "parent_id", "default_sort"},
+				[]interface{}{1, "Books", nil, "price_asc"}, []interface{}{2, "Home", nil, ""})
+			fake.On(`^SELECT COUNT`).Rows([]string{"count"}, []interface{}{0})
+			fake.On(`ORDER BY ` + regexp.QuoteMeta(tt.order) + ` LIMIT`).Rows(productColumnNames)
+
+			listProducts(t, tt.query)
+		})
+	}
+}
+
+func TestCreateCategoryRejectsUnknownDefaultSort(t *testing.T) {
+	fake := useDB(t)
+
+	if w := sendAsAdmin(t, "POST", "/categories", `{"name": "Books", "default_sort": "popularity"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("an invalid default sort reached the database")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// lowStockDefault is the low_stock_threshold given to products that don't
+// set their own.
+var lowStockDefault = envInt("PRODUCT_LOW_STOCK_THRESHOLD", 5)
+
+// lowStockAlertUserID receives low-stock alerts. When it is 0 alerts are
+// only logged.
+var lowStockAlertUserID = envInt("PRODUCT_LOW_STOCK_ALERT_USER_ID", 0)
+
+var notificationClient = &http.Client{Timeout: 5 * time.Second}
+
+func notificationServiceURL() string {
+	if url := os.Getenv("NOTIFICATION_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://notification-service:8006"
+}
+
+// checkLowStock alerts when a stock change takes a product from above its
+// threshold to at or below it. Later changes that keep it low stay quiet so
+// merchandisers get one alert per dip, not one per sale.
+func checkLowStock(productID int64, name string, before, after, threshold int) {
+	if before <= threshold || after > threshold {
+		return
+	}
+	log.Printf("Product %d (%s) is low on stock: %d left, threshold %d", productID, name, after, threshold)
+	if lowStockAlertUserID == 0 {
+		return
+	}
+	go func() {
+		if err := sendLowStockAlert(productID, name, after, threshold); err != nil {
+			log.Printf("Failed to send low-stock alert for product %d: %v", productID, err)
+		}
+	}()
+}
+
+func sendLowStockAlert(productID int64, name string, stock, threshold int) error {
+	metadata, _ := json.Marshal(map[string]interface{}{
+		"product_id": productID,
+		"stock":      stock,
+		"threshold":  threshold,
+	})
+	body, err := json.Marshal(map[string]interface{}{
+		"user_id":  lowStockAlertUserID,
+		"type":     "low_stock",
+		"channel":  "email",
+		"subject":  "Low stock: " + name,
+		"message":  fmt.Sprintf("%s (product %d) is down to %d in stock, at or below its threshold of %d.", name, productID, stock, threshold),
+		"metadata": string(metadata),
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := notificationClient.Post(notificationServiceURL()+"/notifications", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("notification service returned %d", resp.StatusCode)
+	}
+	return nil
+}
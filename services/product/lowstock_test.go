@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestLowStockListing(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`FROM products WHERE stock <= low_stock_threshold AND deleted_at IS NULL ORDER BY shortfall DESC`).Rows(
+		[]string{"id", "name", "category", "stock", "low_stock_threshold", "shortfall"},
+		[]interface{}{4, "Rug", "Home", 2, 20, 18},
+		[]interface{}{7, "Lamp", "Home", 0, 5, 5},
+	)
+
+	r := authorize(t, httptest.NewRequest(http.MethodGet, "/products/low-stock", nil), 1, "admin")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var items []LowStockItem
+	if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].ProductID != 4 || items[0].Shortfall != 18 || items[1].ProductID != 7 {
+		t.Errorf("items = %+v", items)
+	}
+	// Only products at or below their threshold are selected, furthest
+	// below first.
+	query := fake.Matching(`low_stock_threshold`)[0].Query
+	if !strings.Contains(query, "WHERE stock <= low_stock_threshold") || !strings.Contains(query, "ORDER BY shortfall DESC, stock ASC, id") {
+		t.Errorf("query = %s", query)
+	}
+}
+
+func TestLowStockListingRequiresAdmin(t *testing.T) {
+	r := authorize(t, httptest.NewRequest(http.MethodGet, "/products/low-stock", nil), 7, "")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
+
+// stubLowStockAlerts sends alerts to user 42 through a stub notification
+// service and returns the notifications it receives.
+func stubLowStockAlerts(t *testing.T) <-chan map[string]interface{} {
+	t.Helper()
+	alerts := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&n)
+		alerts <- n
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("NOTIFICATION_SERVICE_URL", srv.URL)
+
+	prev := lowStockAlertUserID
+	lowStockAlertUserID = 42
+	t.Cleanup(func() { lowStockAlertUserID = prev })
+	return alerts
+}
+
+func TestUpdateStockAlertsWhenCrossingThreshold(t *testing.T) {
+	alerts := stubLowStockAlerts(t)
+	fake := useDB(t)
+	fake.On(`^UPDATE products SET stock = stock \+ \$1`).Rows([]string{"name", "stock", "low_stock_threshold"}, []interface{}{"Lamp", 4, 5})
+	fake.On(`INSERT INTO stock_movements`)
+
+	r := httptest.NewRequest(http.MethodPatch, "/products/3/stock", strings.NewReader(`{"quantity": -4}`))
+	w := httptest.NewRecorder()
+	updateStock(w, mux.SetURLVars(r, map[string]string{"id": "3"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	select {
+	case n := <-alerts:
+		if n["user_id"] != 42.0 || n["type"] != "low_stock" || !strings.Contains(n["metadata"].(string), `"stock":4`) {
+			t.Errorf("alert = %v", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no alert for stock going from 8 to 4 with a threshold of 5")
+	}
+}
+
+func TestReserveAlertsWhenCrossingThreshold(t *testing.T) {
+	alerts := stubLowStockAlerts(t)
+	fake := useDB(t)
+	fake.On(`^UPDATE products SET stock = stock - \$1`).Rows([]string{"name", "stock", "low_stock_threshold"}, []interface{}{"Lamp", 5, 5})
+	fake.On(`INSERT INTO stock_movements`)
+
+	if w := sendAsAdmin(t, "POST", "/products/3/reserve", `{"quantity": 2}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	select {
+	case n := <-alerts:
+		if !strings.Contains(n["metadata"].(string), `"stock":5`) {
+			t.Errorf("alert = %v", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no alert for a reservation taking stock from 7 to 5 with a threshold of 5")
+	}
+}
+
+func TestCheckLowStockAlertsOncePerDip(t *testing.T) {
+	alerts := stubLowStockAlerts(t)
+
+	// Staying above, staying below and recovering are all quiet.
+	checkLowStock(3, "Lamp", 20, 6, 5)
+	checkLowStock(3, "Lamp", 4, 2, 5)
+	checkLowStock(3, "Lamp", 2, 9, 5)
+	checkLowStock(3, "Lamp", 6, 5, 5)
+
+	select {
+	case <-alerts:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no alert for the crossing")
+	}
+	select {
+	case n := <-alerts:
+		t.Errorf("unexpected second alert %v", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLowStockDefaultFromEnv(t *testing.T) {
+	for value, want := range map[string]int{"": 5, "12": 12, "0": 0, "-3": 5, "few": 5} {
+		t.Setenv("PRODUCT_LOW_STOCK_THRESHOLD", value)
+		if got := envInt("PRODUCT_LOW_STOCK_THRESHOLD", 5); got != want {
+			t.Errorf("PRODUCT_LOW_STOCK_THRESHOLD=%q gave %d, want %d", value, got, want)
+		}
+	}
+}
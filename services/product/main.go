@@ -3,14 +3,25 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/database"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/featureflags"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/pagination"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/response"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/server"
+	"github.com/lib/pq"
 )
 
 type Product struct {
@@ -21,40 +32,171 @@ type Product struct {
 	Stock       int       `json:"stock"`
 	Category    string    `json:"category"`
 	ImageURL    string    `json:"image_url"`
+	SKU         string    `json:"sku,omitempty"`
+	LengthCM    float64   `json:"length_cm"`
+	WidthCM     float64   `json:"width_cm"`
+	HeightCM    float64   `json:"height_cm"`
+	WeightGrams int       `json:"weight_grams"`
 	CreatedAt   time.Time `json:"created_at"`
+	// Version goes up on every write. Sending it back on PUT makes the
+	// update conditional, so a stale edit can't overwrite a newer one.
+	Version int `json:"version"`
+	// SalePrice replaces Price until SaleEndsAt (or indefinitely when that is
+	// nil). Responses carry the resulting EffectivePrice.
+	SalePrice      *float64   `json:"sale_price,omitempty"`
+	SaleEndsAt     *time.Time `json:"sale_ends_at,omitempty"`
+	EffectivePrice float64    `json:"effective_price"`
+	Tags           []string   `json:"tags"`
+	// Images is the full gallery in display order, led by ImageURL. It and
+	// the rating summary are left out of listings.
+	Images        []string `json:"images,omitempty"`
+	AverageRating *float64 `json:"average_rating,omitempty"`
+	ReviewCount   *int     `json:"review_count,omitempty"`
 }
 
+// defaultImageURL is shown for products without an image. Stored rows keep
+// their empty image_url; the placeholder is only applied in responses.
+var defaultImageURL = os.Getenv("PRODUCT_DEFAULT_IMAGE_URL")
+
+func (p Product) MarshalJSON() ([]byte, error) {
+	type product Product
+	out := product(p)
+	if out.ImageURL == "" {
+		out.ImageURL = defaultImageURL
+	}
+	out.EffectivePrice = p.effectivePrice(time.Now())
+	return json.Marshal(out)
+}
+
+// onSale reports whether the product's sale price applies at now.
+func (p Product) onSale(now time.Time) bool {
+	return p.SalePrice != nil && (p.SaleEndsAt == nil || now.Before(*p.SaleEndsAt))
+}
+
+func (p Product) effectivePrice(now time.Time) float64 {
+	if p.onSale(now) {
+		return *p.SalePrice
+	}
+	return p.Price
+}
+
+// activeSaleSQL matches rows whose sale price currently applies; it mirrors
+// Product.onSale.
+const activeSaleSQL = "sale_price IS NOT NULL AND (sale_ends_at IS NULL OR sale_ends_at > CURRENT_TIMESTAMP)"
+
 type Category struct {
-	ID   uint   `json:"id"`
-	Name string `json:"name"`
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	ParentID *uint  `json:"parent_id,omitempty"`
+	// DefaultSort is the ?sort= applied to the category's listing when the
+	// request doesn't give one.
+	DefaultSort string `json:"default_sort,omitempty"`
+}
+
+// productColumns is the select list scanProduct expects.
+const productColumns = "id, name, COALESCE(description, ''), price, COALESCE(stock, 0), COALESCE(category, ''), COALESCE(image_url, ''), COALESCE(sku, ''), length_cm, width_cm, height_cm, weight_grams, created_at, version, sale_price, sale_ends_at, " +
+	"ARRAY(SELECT tag FROM product_tags t WHERE t.product_id = products.id ORDER BY tag)"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProduct(row rowScanner, p *Product) error {
+	return row.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.Category, &p.ImageURL, &p.SKU, &p.LengthCM, &p.WidthCM, &p.HeightCM, &p.WeightGrams, &p.CreatedAt, &p.Version, &p.SalePrice, &p.SaleEndsAt, pq.Array(&p.Tags))
 }
 
 var db *sql.DB
+var dbOnce database.Once
+
+// excludedCategories are hidden from the default catalog listing; asking for
+// one explicitly with ?category= still shows its products.
+var excludedCategories = parseCategoryList(os.Getenv("PRODUCT_EXCLUDED_CATEGORIES"))
+
+// searchMinLength is the shortest search term accepted; one-letter terms
+// match nearly every product and make for expensive scans.
+var searchMinLength = envInt("PRODUCT_SEARCH_MIN_LENGTH", 2)
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+		log.Printf("Invalid %s %q, using %d", key, v, fallback)
+	}
+	return fallback
+}
+
+func parseCategoryList(value string) []string {
+	categories := []string{}
+	for _, c := range strings.Split(value, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			categories = append(categories, c)
+		}
+	}
+	return categories
+}
 
 func main() {
 	var err error
-	db, err = database.NewConnection("products_db")
+	err = dbOnce.Open(&db, func() (*sql.DB, error) { return database.NewConnection("products_db") })
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
 
+	stopKeepalive := database.StartKeepalive(db, database.KeepaliveInterval())
+	defer stopKeepalive()
+
 	initDB()
 
+	if os.Getenv("PRODUCT_CACHE_WARM") == "true" {
+		warmCaches()
+	}
+
+	readOnly.Store(os.Getenv("PRODUCT_READONLY") == "true")
+
+	log.Println("Product service running on :8002")
+	if err := server.Run(":8002", middleware.TrimTrailingSlash(newRouter())); err != nil {
+		log.Fatal("Server error:", err)
+	}
+}
+
+// newRouter registers the product service's routes.
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.Use(middleware.CORS)
+	r.Use(middleware.LogRequestBodies)
+	r.Use(readOnlyGuard)
 
 	r.HandleFunc("/health", healthCheck).Methods("GET")
+	r.HandleFunc("/products/readonly", getReadOnly).Methods("GET")
+	r.Handle("/products/readonly", adminOnly(setReadOnly)).Methods("PUT").Name(readOnlyToggleRoute)
 	r.HandleFunc("/products", getProducts).Methods("GET")
+	r.Handle("/products/low-stock", adminOnly(getLowStockProducts)).Methods("GET")
+	r.HandleFunc("/products/sku/{sku}", getProductBySKU).Methods("GET")
+	r.HandleFunc("/products/batch", batchGetProducts).Methods("POST").Name(batchLookupRoute)
 	r.HandleFunc("/products/{id}", getProduct).Methods("GET")
-	r.HandleFunc("/products", createProduct).Methods("POST")
-	r.HandleFunc("/products/{id}", updateProduct).Methods("PUT")
-	r.HandleFunc("/products/{id}", deleteProduct).Methods("DELETE")
+	r.Handle("/products", adminOnly(createProduct)).Methods("POST")
+	r.Handle("/products/{id}", adminOnly(updateProduct)).Methods("PUT")
+	r.Handle("/products/{id}", adminOnly(deleteProduct)).Methods("DELETE")
+	r.Handle("/products/{id}/restore", adminOnly(restoreProduct)).Methods("POST")
 	r.HandleFunc("/products/{id}/stock", updateStock).Methods("PATCH")
+	r.Handle("/products/{id}/reserve", adminOrService(reserveStock)).Methods("POST")
+	r.Handle("/products/{id}/stock-history", adminOnly(getStockHistory)).Methods("GET")
+	r.Handle("/products/{id}/images", adminOnly(addProductImage)).Methods("POST")
+	r.Handle("/products/{id}/images/{image_id}", adminOnly(deleteProductImage)).Methods("DELETE")
+	r.Handle("/products/{id}/reviews", featureflags.Gate("reviews", true)(http.HandlerFunc(getReviews))).Methods("GET")
+	r.Handle("/products/{id}/reviews", featureflags.Gate("reviews", true)(middleware.AuthMiddleware(http.HandlerFunc(createReview)))).Methods("POST")
 	r.HandleFunc("/categories", getCategories).Methods("GET")
+	r.Handle("/categories", adminOnly(createCategory)).Methods("POST")
+	r.Handle("/categories/{id}", adminOnly(renameCategory)).Methods("PUT")
+	r.Handle("/categories/{id}", adminOnly(deleteCategory)).Methods("DELETE")
+	r.HandleFunc("/categories/{id}/breadcrumb", getCategoryBreadcrumb).Methods("GET")
+	r.Handle("/products/bulk-price", adminOnly(bulkUpdatePrices)).Methods("POST")
+	r.Handle("/products/bulk", adminOnly(bulkCreateProducts)).Methods("POST")
+	r.Handle("/products/import/url", importRateLimit(adminOnly(importProductsFromURL))).Methods("POST")
 
-	log.Println("Product service running on :8002")
-	log.Fatal(http.ListenAndServe(":8002", r))
+	return r
 }
 
 func initDB() {
@@ -73,6 +215,60 @@ func initDB() {
 			image_url TEXT,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_id INT REFERENCES categories(id) ON DELETE SET NULL`,
+		`ALTER TABLE categories ADD COLUMN IF NOT EXISTS default_sort VARCHAR(20)`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS low_stock_threshold INT NOT NULL DEFAULT 5`,
+		fmt.Sprintf(`ALTER TABLE products ALTER COLUMN low_stock_threshold SET DEFAULT %d`, lowStockDefault),
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS sku VARCHAR(100) UNIQUE`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS length_cm NUMERIC(8,2) NOT NULL DEFAULT 0 CHECK (length_cm >= 0)`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS width_cm NUMERIC(8,2) NOT NULL DEFAULT 0 CHECK (width_cm >= 0)`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS height_cm NUMERIC(8,2) NOT NULL DEFAULT 0 CHECK (height_cm >= 0)`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS weight_grams INT NOT NULL DEFAULT 0 CHECK (weight_grams >= 0)`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS sale_price DECIMAL(10,2)`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS sale_ends_at TIMESTAMP`,
+		`CREATE TABLE IF NOT EXISTS price_history (
+			id SERIAL PRIMARY KEY,
+			product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			old_price DECIMAL(10,2) NOT NULL,
+			new_price DECIMAL(10,2) NOT NULL,
+			reason VARCHAR(255),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS stock_movements (
+			id SERIAL PRIMARY KEY,
+			product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			delta INT NOT NULL,
+			reason VARCHAR(50) NOT NULL,
+			reference_id VARCHAR(100),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_stock_movements_product ON stock_movements (product_id, created_at)`,
+		`CREATE TABLE IF NOT EXISTS product_images (
+			id SERIAL PRIMARY KEY,
+			product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			position INT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_product_images_product ON product_images (product_id, position)`,
+		`CREATE TABLE IF NOT EXISTS product_tags (
+			product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			tag VARCHAR(50) NOT NULL,
+			PRIMARY KEY (product_id, tag)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_product_tags_tag ON product_tags (tag)`,
+		`CREATE TABLE IF NOT EXISTS reviews (
+			id SERIAL PRIMARY KEY,
+			product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			user_id INT NOT NULL,
+			rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+			title VARCHAR(200),
+			body TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (product_id, user_id)
+		)`,
 	}
 
 	for _, query := range queries {
@@ -82,23 +278,158 @@ func initDB() {
 		}
 	}
 
-	// Insert sample categories
-	categories := []string{"Electronics", "Clothing", "Books", "Home & Garden", "Sports"}
-	for _, cat := range categories {
-		db.Exec("INSERT INTO categories (name) VALUES ($1) ON CONFLICT DO NOTHING", cat)
+	// Insert sample categories into a fresh database only, so categories an
+	// admin has deleted don't come back on restart.
+	var hasCategories bool
+	db.QueryRow("SELECT EXISTS (SELECT 1 FROM categories)").Scan(&hasCategories)
+	if !hasCategories {
+		categories := []string{"Electronics", "Clothing", "Books", "Home & Garden", "Sports"}
+		for _, cat := range categories {
+			db.Exec("INSERT INTO categories (name) VALUES ($1) ON CONFLICT DO NOTHING", cat)
+		}
+	}
+}
+
+// importRateLimit protects the URL import, which fetches and upserts a whole
+// remote catalog, even when the gateway is bypassed.
+var importRateLimit = middleware.RateLimit(middleware.RateLimitFromEnv(
+	"PRODUCT_IMPORT_RATE_LIMIT", middleware.RateLimitConfig{PerMinute: 5, Burst: 2},
+))
+
+// adminOnly requires a valid token carrying the admin role.
+func adminOnly(h http.HandlerFunc) http.Handler {
+	return middleware.AuthMiddleware(middleware.RequireRole("admin")(h))
+}
+
+// adminOrService also admits other services' tokens, for routes such as
+// reserving stock that the order service calls at checkout.
+func adminOrService(h http.HandlerFunc) http.Handler {
+	return middleware.AuthMiddleware(middleware.RequireRole("admin", middleware.RoleService)(h))
+}
+
+// readOnly blocks catalog writes during maintenance while reads keep working.
+// It starts from PRODUCT_READONLY and can be flipped at runtime by an admin.
+var readOnly atomic.Bool
+
+// Routes named here stay open in read-only mode: the toggle itself, and the
+// batch lookup, which is a read that happens to be a POST.
+const (
+	readOnlyToggleRoute = "readonly-toggle"
+	batchLookupRoute    = "batch-lookup"
+)
+
+var readOnlyExempt = map[string]bool{readOnlyToggleRoute: true, batchLookupRoute: true}
+
+func readOnlyGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if readOnly.Load() {
+			if route := mux.CurrentRoute(r); route == nil || !readOnlyExempt[route.GetName()] {
+				w.Header().Set("Retry-After", "60")
+				http.Error(w, "Product service is in read-only mode", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func getReadOnly(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"read_only": readOnly.Load()})
+}
+
+func setReadOnly(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ReadOnly bool `json:"read_only"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
 	}
+
+	readOnly.Store(req.ReadOnly)
+	log.Printf("Product read-only mode set to %v", req.ReadOnly)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"read_only": req.ReadOnly})
 }
 
 func healthCheck(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 }
 
+// productSortOrders whitelists the ORDER BY clauses ?sort= may select; the
+// value is never interpolated into SQL directly. The id tiebreaker keeps
+// pages stable when the sort key repeats.
+var productSortOrders = map[string]string{
+	"newest":     "created_at DESC, id DESC",
+	"price_asc":  "price ASC, id ASC",
+	"price_desc": "price DESC, id DESC",
+	"name_asc":   "name ASC, id ASC",
+	"name_desc":  "name DESC, id DESC",
+}
+
+const defaultProductSort = "newest"
+
 func getProducts(w http.ResponseWriter, r *http.Request) {
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if ids := r.URL.Query().Get("ids"); ids != "" {
+		getProductsByIDs(w, ids, fields)
+		return
+	}
+
 	category := r.URL.Query().Get("category")
-	search := r.URL.Query().Get("search")
+	// Collapse runs of whitespace so "  red   shoes " searches for "red shoes".
+	search := strings.Join(strings.Fields(r.URL.Query().Get("search")), " ")
+	if search != "" && utf8.RuneCountInString(search) < searchMinLength {
+		http.Error(w, fmt.Sprintf("Search term must be at least %d characters", searchMinLength), http.StatusBadRequest)
+		return
+	}
+	minPrice, hasMin, err := parsePriceParam(r, "min_price")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxPrice, hasMax, err := parsePriceParam(r, "max_price")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hasMin && hasMax && minPrice > maxPrice {
+		http.Error(w, "min_price cannot be greater than max_price", http.StatusBadRequest)
+		return
+	}
 	limit := r.URL.Query().Get("limit")
 	offset := r.URL.Query().Get("offset")
 
+	// Passing a cursor (empty for the first page) switches to keyset
+	// pagination, which stays fast on large tables.
+	useCursor := r.URL.Query().Has("cursor")
+	cursor := r.URL.Query().Get("cursor")
+
+	// Without ?sort= a category listing uses the category's default sort.
+	// Unknown sorts fall back to newest. Cursor pages are keyed on
+	// created_at, so they are always newest first.
+	sort := r.URL.Query().Get("sort")
+	if sort == "" && category != "" {
+		sort = categoryDefaultSort(category)
+	}
+	orderBy, ok := productSortOrders[sort]
+	if !ok || useCursor {
+		orderBy = productSortOrders[defaultProductSort]
+	}
+
 	if limit == "" {
 		limit = "50"
 	}
@@ -106,29 +437,97 @@ func getProducts(w http.ResponseWriter, r *http.Request) {
 		offset = "0"
 	}
 
-	query := "SELECT id, name, description, price, stock, category, image_url, created_at FROM products WHERE 1=1"
+	where := " WHERE deleted_at IS NULL"
 	args := []interface{}{}
 	argCount := 0
 
 	if category != "" {
 		argCount++
-		query += " AND category = $" + strconv.Itoa(argCount)
+		where += " AND category = $" + strconv.Itoa(argCount)
 		args = append(args, category)
+	} else if len(excludedCategories) > 0 {
+		argCount++
+		where += " AND (category IS NULL OR NOT category = ANY($" + strconv.Itoa(argCount) + "))"
+		args = append(args, pq.Array(excludedCategories))
 	}
 
 	if search != "" {
 		argCount++
-		query += " AND (name ILIKE $" + strconv.Itoa(argCount) + " OR description ILIKE $" + strconv.Itoa(argCount) + ")"
+		where += " AND (name ILIKE $" + strconv.Itoa(argCount) + " OR description ILIKE $" + strconv.Itoa(argCount) + ")"
 		args = append(args, "%"+search+"%")
 	}
 
-	argCount++
-	query += " ORDER BY created_at DESC LIMIT $" + strconv.Itoa(argCount)
-	args = append(args, limit)
+	if hasMin {
+		argCount++
+		where += " AND price >= $" + strconv.Itoa(argCount)
+		args = append(args, minPrice)
+	}
+	if hasMax {
+		argCount++
+		where += " AND price <= $" + strconv.Itoa(argCount)
+		args = append(args, maxPrice)
+	}
+	if r.URL.Query().Get("on_sale") == "true" {
+		where += " AND " + activeSaleSQL
+	}
+	if tags := normalizeTags(strings.Split(r.URL.Query().Get("tags"), ",")); len(tags) > 0 {
+		argCount++
+		where += tagFilter(r.URL.Query().Get("tag_mode"), argCount, len(tags))
+		args = append(args, pq.Array(tags))
+	}
+
+	query := "SELECT " + productColumns + " FROM products" + where
+
+	pageSize, pageOffset, total := 0, 0, 0
+	if useCursor {
+		var err error
+		pageSize, err = strconv.Atoi(limit)
+		if err != nil || pageSize <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+
+		if cursor != "" {
+			createdAt, id, err := pagination.DecodeCursor(cursor)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+			query += " AND (created_at, id) < ($" + strconv.Itoa(argCount+1) + ", $" + strconv.Itoa(argCount+2) + ")"
+			args = append(args, createdAt, id)
+			argCount += 2
+		}
+
+		// Fetch one extra row to know whether there is a next page.
+		argCount++
+		query += " ORDER BY created_at DESC, id DESC LIMIT $" + strconv.Itoa(argCount)
+		args = append(args, pageSize+1)
+	} else {
+		var err error
+		if pageSize, err = strconv.Atoi(limit); err != nil || pageSize < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if pageOffset, err = strconv.Atoi(offset); err != nil || pageOffset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+
+		// The total uses the same filters as the page so "page N of M"
+		// stays consistent with what is listed.
+		if err := db.QueryRow("SELECT COUNT(*) FROM products"+where, args...).Scan(&total); err != nil {
+			http.Error(w, "Failed to count products", http.StatusInternalServerError)
+			return
+		}
+
+		argCount++
+		query += " ORDER BY " + orderBy + " LIMIT $" + strconv.Itoa(argCount)
+		args = append(args, pageSize)
 
-	argCount++
-	query += " OFFSET $" + strconv.Itoa(argCount)
-	args = append(args, offset)
+		argCount++
+		query += " OFFSET $" + strconv.Itoa(argCount)
+		args = append(args, pageOffset)
+	}
 
 	rows, err := db.Query(query, args...)
 	if err != nil {
@@ -140,32 +539,170 @@ func getProducts(w http.ResponseWriter, r *http.Request) {
 	products := []Product{}
 	for rows.Next() {
 		var p Product
-		err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.Category, &p.ImageURL, &p.CreatedAt)
+		err := scanProduct(rows, &p)
 		if err != nil {
 			continue
 		}
 		products = append(products, p)
 	}
 
+	nextCursor := ""
+	if useCursor && len(products) > pageSize {
+		products = products[:pageSize]
+		last := products[len(products)-1]
+		nextCursor = pagination.EncodeCursor(last.CreatedAt, last.ID)
+	}
+
+	listed, err := projectProducts(products, fields)
+	if err != nil {
+		http.Error(w, "Failed to encode products", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !useCursor {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"products": listed,
+			"total":    total,
+			"limit":    pageSize,
+			"offset":   pageOffset,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"products": listed, "next_cursor": nextCursor})
+}
+
+// parsePriceParam reads an optional non-negative price from the query string.
+func parsePriceParam(r *http.Request, name string) (float64, bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, false, nil
+	}
+	price, err := strconv.ParseFloat(value, 64)
+	if err != nil || price < 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+		return 0, false, fmt.Errorf("%s must be a non-negative number", name)
+	}
+	return price, true, nil
+}
+
+// maxBatchProducts caps how many ids one batch lookup may ask for.
+const maxBatchProducts = 100
+
+// getProductsByIDs returns the products for a comma-separated id list in one
+// query, in the order requested, along with any ids that don't exist.
+func getProductsByIDs(w http.ResponseWriter, idList string, fields []string) {
+	ids := []int64{}
+	for _, part := range strings.Split(idList, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "Invalid product ID: "+part, http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+	writeProductsByIDs(w, ids, fields)
+}
+
+// batchGetProducts is the POST form of ?ids=, taking {"ids": [...]} for
+// lists too long to fit comfortably in a URL. ?fields= projects as it does
+// for listings.
+func batchGetProducts(w http.ResponseWriter, r *http.Request) {
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		http.Error(w, "ids is required", http.StatusBadRequest)
+		return
+	}
+	for _, id := range req.IDs {
+		if id <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid product ID: %d", id), http.StatusBadRequest)
+			return
+		}
+	}
+	writeProductsByIDs(w, req.IDs, fields)
+}
+
+func writeProductsByIDs(w http.ResponseWriter, ids []int64, fields []string) {
+	if len(ids) > maxBatchProducts {
+		http.Error(w, fmt.Sprintf("At most %d product IDs may be requested at once", maxBatchProducts), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(
+		"SELECT "+productColumns+" FROM products WHERE id = ANY($1) AND deleted_at IS NULL",
+		pq.Array(ids),
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	found := make(map[int64]Product, len(ids))
+	for rows.Next() {
+		var p Product
+		err := scanProduct(rows, &p)
+		if err != nil {
+			continue
+		}
+		found[int64(p.ID)] = p
+	}
+
+	products := []Product{}
+	missing := []int64{}
+	for _, id := range ids {
+		if p, ok := found[id]; ok {
+			products = append(products, p)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	listed, err := projectProducts(products, fields)
+	if err != nil {
+		http.Error(w, "Failed to encode products", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(products)
+	json.NewEncoder(w).Encode(map[string]interface{}{"products": listed, "missing": missing})
 }
 
 func getProduct(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	writeProduct(w, "id", mux.Vars(r)["id"])
+}
 
-	var p Product
-	err := db.QueryRow(
-		"SELECT id, name, description, price, stock, category, image_url, created_at FROM products WHERE id = $1",
-		id,
-	).Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.Category, &p.ImageURL, &p.CreatedAt)
+// getProductBySKU looks a product up by the SKU warehouse systems use.
+func getProductBySKU(w http.ResponseWriter, r *http.Request) {
+	writeProduct(w, "sku", mux.Vars(r)["sku"])
+}
 
+// writeProduct sends the product whose column matches value, with its
+// gallery and rating summary. column is always a constant from the caller.
+func writeProduct(w http.ResponseWriter, column, value string) {
+	var p Product
+	err := scanProduct(db.QueryRow("SELECT "+productColumns+" FROM products WHERE "+column+" = $1 AND deleted_at IS NULL", value), &p)
 	if err != nil {
 		http.Error(w, "Product not found", http.StatusNotFound)
 		return
 	}
 
+	if p.Images, err = loadProductImages(db, p.ID); err != nil {
+		log.Printf("Failed to load images for product %d: %v", p.ID, err)
+	}
+	loadRatingSummary(&p)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(p)
 }
@@ -177,96 +714,505 @@ func createProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := db.QueryRow(
-		`INSERT INTO products (name, description, price, stock, category, image_url)
-		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
-		p.Name, p.Description, p.Price, p.Stock, p.Category, p.ImageURL,
-	).Scan(&p.ID, &p.CreatedAt)
+	normalizeProduct(&p)
+	if err := validateProduct(p); err != nil {
+		writeProductError(w, err)
+		return
+	}
+	normalizeProductImages(&p)
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`INSERT INTO products (name, description, price, stock, category, image_url, sku, length_cm, width_cm, height_cm, weight_grams, sale_price, sale_ends_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10, $11, $12, $13) RETURNING id, created_at, version`,
+		p.Name, p.Description, p.Price, p.Stock, p.Category, p.ImageURL, p.SKU,
+		p.LengthCM, p.WidthCM, p.HeightCM, p.WeightGrams, p.SalePrice, p.SaleEndsAt,
+	).Scan(&p.ID, &p.CreatedAt, &p.Version)
 
+	if isSKUConflict(err) {
+		http.Error(w, "SKU already exists", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to create product", http.StatusInternalServerError)
 		return
 	}
 
+	if err := recordStockSet(tx, int64(p.ID), 0, p.Stock, reasonInitialStock); err != nil {
+		http.Error(w, "Failed to create product", http.StatusInternalServerError)
+		return
+	}
+	if err := insertProductImages(tx, p.ID, p.Images); err != nil {
+		http.Error(w, "Failed to save product images", http.StatusInternalServerError)
+		return
+	}
+	if p.Tags == nil {
+		p.Tags = []string{}
+	}
+	if err := replaceProductTags(tx, p.ID, p.Tags); err != nil {
+		http.Error(w, "Failed to save product tags", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(p)
 }
 
 func updateProduct(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
 
 	var p Product
 	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	normalizeProduct(&p)
+	if err := validateProduct(p); err != nil {
+		writeProductError(w, err)
+		return
+	}
 
-	_, err := db.Exec(
-		`UPDATE products SET name = $1, description = $2, price = $3, stock = $4, category = $5, image_url = $6 WHERE id = $7`,
-		p.Name, p.Description, p.Price, p.Stock, p.Category, p.ImageURL, id,
-	)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
 
+	// The stock being replaced is read under lock so the change can be
+	// logged as a movement.
+	var oldStock int
+	err = tx.QueryRow("SELECT COALESCE(stock, 0) FROM products WHERE id = $1 FOR UPDATE", id).Scan(&oldStock)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to update product", http.StatusInternalServerError)
 		return
 	}
 
+	// Without a version (0) the update is unconditional, as it was before
+	// clients knew about versions.
+	var version, threshold int
+	err = tx.QueryRow(
+		`UPDATE products SET name = $1, description = $2, price = $3, stock = $4, category = $5, image_url = $6, sku = NULLIF($7, ''),
+		 length_cm = $8, width_cm = $9, height_cm = $10, weight_grams = $11, sale_price = $12, sale_ends_at = $13, version = version + 1
+		 WHERE id = $14 AND ($15 = 0 OR version = $15) RETURNING version, low_stock_threshold`,
+		p.Name, p.Description, p.Price, p.Stock, p.Category, p.ImageURL, p.SKU,
+		p.LengthCM, p.WidthCM, p.HeightCM, p.WeightGrams, p.SalePrice, p.SaleEndsAt, id, p.Version,
+	).Scan(&version, &threshold)
+	if err == sql.ErrNoRows {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "stale version"})
+		return
+	}
+	if isSKUConflict(err) {
+		http.Error(w, "SKU already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update product", http.StatusInternalServerError)
+		return
+	}
+	if err := recordStockSet(tx, id, oldStock, p.Stock, reasonManualAdjustment); err != nil {
+		http.Error(w, "Failed to update product", http.StatusInternalServerError)
+		return
+	}
+	if err := replacePrimaryImage(tx, id, p.ImageURL); err != nil {
+		http.Error(w, "Failed to update product images", http.StatusInternalServerError)
+		return
+	}
+	// Omitting tags leaves them as they are; an empty list clears them.
+	if p.Tags != nil {
+		if err := replaceProductTags(tx, uint(id), p.Tags); err != nil {
+			http.Error(w, "Failed to save product tags", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	checkLowStock(id, p.Name, oldStock, p.Stock, threshold)
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Product updated successfully"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Product updated successfully", "version": version})
+}
+
+// isSKUConflict reports whether err is a unique violation on products.sku.
+func isSKUConflict(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505" && pqErr.Constraint == "products_sku_key"
 }
 
 func deleteProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	id := vars["id"]
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
 
-	_, err := db.Exec("DELETE FROM products WHERE id = $1", id)
+	// Products are soft-deleted so order history and analytics can still
+	// join to them; ?force=true removes the row for good.
+	query := "UPDATE products SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL"
+	if r.URL.Query().Get("force") == "true" {
+		query = "DELETE FROM products WHERE id = $1"
+	}
+
+	result, err := db.Exec(query, id)
 	if err != nil {
 		http.Error(w, "Failed to delete product", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	n, _ := result.RowsAffected()
+	response.Deleted(w, r, id, n > 0)
+}
+
+// restoreProduct undoes a soft delete and returns the product.
+func restoreProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("UPDATE products SET deleted_at = NULL WHERE id = $1", id)
+	if err != nil {
+		http.Error(w, "Failed to restore product", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	writeProduct(w, "id", strconv.FormatInt(id, 10))
 }
 
 func updateStock(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
 
 	var stock struct {
-		Quantity int `json:"quantity"`
+		Quantity    int    `json:"quantity"`
+		Reason      string `json:"reason"`
+		ReferenceID string `json:"reference_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&stock); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if stock.Reason == "" {
+		stock.Reason = reasonManualAdjustment
+	}
+	if !stockReasons[stock.Reason] {
+		http.Error(w, "Invalid reason", http.StatusBadRequest)
+		return
+	}
+	if len(stock.ReferenceID) > maxReferenceIDLength {
+		http.Error(w, "reference_id is too long", http.StatusBadRequest)
+		return
+	}
 
-	_, err := db.Exec("UPDATE products SET stock = stock + $1 WHERE id = $2", stock.Quantity, id)
+	tx, err := db.Begin()
 	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var name string
+	var newStock, threshold int
+	err = tx.QueryRow(
+		"UPDATE products SET stock = stock + $1, version = version + 1 WHERE id = $2 RETURNING name, stock, low_stock_threshold",
+		stock.Quantity, id,
+	).Scan(&name, &newStock, &threshold)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update stock", http.StatusInternalServerError)
+		return
+	}
+	if err := recordStockMovement(tx, id, stock.Quantity, stock.Reason, stock.ReferenceID); err != nil {
 		http.Error(w, "Failed to update stock", http.StatusInternalServerError)
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	checkLowStock(id, name, newStock-stock.Quantity, newStock, threshold)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Stock updated successfully"})
 }
 
-func getCategories(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT id, name FROM categories ORDER BY name")
+// reserveStock takes quantity units out of stock in a single guarded UPDATE,
+// so concurrent checkouts can never drive stock below zero.
+func reserveStock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
-		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Quantity    int    `json:"quantity"`
+		ReferenceID string `json:"reference_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Quantity <= 0 {
+		http.Error(w, "Quantity must be positive", http.StatusBadRequest)
+		return
+	}
+	if len(req.ReferenceID) > maxReferenceIDLength {
+		http.Error(w, "reference_id is too long", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var name string
+	var stock, threshold int
+	err = tx.QueryRow(
+		"UPDATE products SET stock = stock - $1, version = version + 1 WHERE id = $2 AND stock >= $1 AND deleted_at IS NULL RETURNING name, stock, low_stock_threshold",
+		req.Quantity, id,
+	).Scan(&name, &stock, &threshold)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL)", id).Scan(&exists); err != nil {
+			http.Error(w, "Failed to reserve stock", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Insufficient stock", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to reserve stock", http.StatusInternalServerError)
+		return
+	}
+	if err := recordStockMovement(tx, id, -req.Quantity, reasonOrderReserve, req.ReferenceID); err != nil {
+		http.Error(w, "Failed to reserve stock", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	checkLowStock(id, name, stock+req.Quantity, stock, threshold)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"product_id": id,
+		"reserved":   req.Quantity,
+		"stock":      stock,
+	})
+}
+
+type LowStockItem struct {
+	ProductID         uint   `json:"product_id"`
+	Name              string `json:"name"`
+	Category          string `json:"category"`
+	Stock             int    `json:"stock"`
+	LowStockThreshold int    `json:"low_stock_threshold"`
+	Shortfall         int    `json:"shortfall"`
+}
+
+// getLowStockProducts lists products at or below their restock threshold,
+// furthest below it first; among equal shortfalls the emptiest leads.
+func getLowStockProducts(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(
+		`SELECT id, name, COALESCE(category, ''), stock, low_stock_threshold, low_stock_threshold - stock AS shortfall
+		 FROM products WHERE stock <= low_stock_threshold AND deleted_at IS NULL
+		 ORDER BY shortfall DESC, stock ASC, id`,
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch low-stock products", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	categories := []Category{}
+	items := []LowStockItem{}
 	for rows.Next() {
-		var c Category
-		rows.Scan(&c.ID, &c.Name)
-		categories = append(categories, c)
+		var item LowStockItem
+		if err := rows.Scan(&item.ProductID, &item.Name, &item.Category, &item.Stock, &item.LowStockThreshold, &item.Shortfall); err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func getCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := categoriesCache.get()
+	if err != nil {
+		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(categories)
 }
+
+type PriceChange struct {
+	ProductID uint    `json:"product_id"`
+	Name      string  `json:"name"`
+	OldPrice  float64 `json:"old_price"`
+	NewPrice  float64 `json:"new_price"`
+	// SaleCleared is set when the product's sale price would no longer be
+	// below the new price, so the sale is ended along with the change.
+	SaleCleared bool `json:"sale_cleared,omitempty"`
+}
+
+// bulkPrice applies a percentage or fixed change to price, rounded to cents.
+func bulkPrice(price float64, percent, amount *float64) float64 {
+	if percent != nil {
+		price *= 1 + *percent/100
+	} else {
+		price += *amount
+	}
+	return math.Round(price*100) / 100
+}
+
+// bulkUpdatePrices applies a percentage or fixed price change to every
+// product in a category. With dry_run it only reports what would change.
+func bulkUpdatePrices(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Category string   `json:"category"`
+		Percent  *float64 `json:"percent"`
+		Amount   *float64 `json:"amount"`
+		Reason   string   `json:"reason"`
+		DryRun   bool     `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("dry_run") == "true" {
+		req.DryRun = true
+	}
+
+	if req.Category == "" {
+		http.Error(w, "category is required", http.StatusBadRequest)
+		return
+	}
+	if (req.Percent == nil) == (req.Amount == nil) {
+		http.Error(w, "Exactly one of percent or amount is required", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "bulk price update"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, name, price, sale_price FROM products WHERE category = $1 AND deleted_at IS NULL ORDER BY id FOR UPDATE", req.Category)
+	if err != nil {
+		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+		return
+	}
+
+	changes := []PriceChange{}
+	for rows.Next() {
+		var c PriceChange
+		var salePrice *float64
+		if err := rows.Scan(&c.ProductID, &c.Name, &c.OldPrice, &salePrice); err != nil {
+			rows.Close()
+			http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+			return
+		}
+
+		c.NewPrice = bulkPrice(c.OldPrice, req.Percent, req.Amount)
+		c.SaleCleared = salePrice != nil && *salePrice >= c.NewPrice
+
+		if c.NewPrice < 0 {
+			rows.Close()
+			http.Error(w, fmt.Sprintf("Price change would make product %d negative", c.ProductID), http.StatusBadRequest)
+			return
+		}
+		changes = append(changes, c)
+	}
+	rows.Close()
+
+	if !req.DryRun {
+		for _, c := range changes {
+			// A sale price at or above the new price would be a markup, so
+			// the sale ends in the same statement that changes the price.
+			_, err := tx.Exec(
+				`UPDATE products SET price = $1,
+				 sale_price = CASE WHEN sale_price >= $1 THEN NULL ELSE sale_price END,
+				 sale_ends_at = CASE WHEN sale_price >= $1 THEN NULL ELSE sale_ends_at END,
+				 version = version + 1
+				 WHERE id = $2`,
+				c.NewPrice, c.ProductID,
+			)
+			if err != nil {
+				http.Error(w, "Failed to update prices", http.StatusInternalServerError)
+				return
+			}
+			_, err = tx.Exec(
+				"INSERT INTO price_history (product_id, old_price, new_price, reason) VALUES ($1, $2, $3, $4)",
+				c.ProductID, c.OldPrice, c.NewPrice, req.Reason,
+			)
+			if err != nil {
+				http.Error(w, "Failed to record price history", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dry_run": req.DryRun,
+		"updated": len(changes),
+		"changes": changes,
+	})
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+// useDB points the service at a scripted database for the rest of the test.
+func useDB(t *testing.T) *dbtest.DB {
+	t.Helper()
+	fake := dbtest.New(t)
+	prev := db
+	db = fake.DB
+	t.Cleanup(func() { db = prev })
+	return fake
+}
+
+// productColumnNames label the columns of productColumns for scripted rows.
+var productColumnNames = []string{"id", "name", "description", "price", "stock", "category", "image_url", "sku", "length_cm", "width_cm", "height_cm", "weight_grams", "created_at", "version", "sale_price", "sale_ends_at", "tags"}
+
+// productRow is a scripted products row in productColumns order.
+func productRow(id int, name string, price float64, createdAt time.Time) []interface{} {
+	return []interface{}{id, name, "", price, 10, "Home", "", "", 0.0, 0.0, 0.0, 0, createdAt, 1, nil, nil, "{}"}
+}
+
+// authorize signs a token for userID (with role, if any) and sets it on r.
+func authorize(t *testing.T, r *http.Request, userID uint, role string) *http.Request {
+	t.Helper()
+	claims := &middleware.Claims{
+		UserID: userID,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    middleware.GetJWTIssuer(),
+			Audience:  jwt.ClaimStrings{middleware.GetJWTAudience()},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(middleware.GetJWTSecret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestBulkPrice(t *testing.T) {
+	pct := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name            string
+		price           float64
+		percent, amount *float64
+		want            float64
+	}{
+		{"ten percent off", 19.99, pct(-10), nil, 17.99},
+		{"percent increase", 10, pct(12.5), nil, 11.25},
+		{"fixed increase", 4.5, nil, pct(1.25), 5.75},
+		{"fixed decrease", 4.5, nil, pct(-4.5), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bulkPrice(tt.price, tt.percent, tt.amount); got != tt.want {
+				t.Errorf("bulkPrice(%v) = %v, want %v", tt.price, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBulkUpdatePricesRejectsBadRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"missing category", `{"percent": -10}`},
+		{"neither change", `{"category": "Electronics"}`},
+		{"both changes", `{"category": "Electronics", "percent": -10, "amount": 1}`},
+		{"malformed", `{`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/products/bulk-price", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			bulkUpdatePrices(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", w.Code)
+			}
+		})
+	}
+}
+
+func TestReserveRequiresAdminOrService(t *testing.T) {
+	router := newRouter()
+
+	r := httptest.NewRequest(http.MethodPost, "/products/1/reserve", strings.NewReader(`{"quantity": 1}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status = %d, want 401", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/products/1/reserve", strings.NewReader(`{"quantity": 1}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authorize(t, r, 7, ""))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("as a customer: status = %d, want 403", w.Code)
+	}
+
+	// An admin gets past auth to the handler's own validation.
+	r = httptest.NewRequest(http.MethodPost, "/products/1/reserve", strings.NewReader(`{"quantity": 0}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authorize(t, r, 1, "admin"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("as an admin: status = %d, want 400", w.Code)
+	}
+
+	// So does another service, such as the order service at checkout.
+	token, err := middleware.ServiceToken("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/products/1/reserve", strings.NewReader(`{"quantity": 0}`))
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("as a service: status = %d, want 400", w.Code)
+	}
+}
+
+func TestBulkUpdatePricesDryRunVersusApplied(t *testing.T) {
+	run := func(t *testing.T, body string) (*dbtest.DB, []PriceChange) {
+		fake := useDB(t)
+		fake.On(`SELECT id, name, price, sale_price FROM products WHERE category = \$1`).Rows(
+			[]string{"id", "name", "price", "sale_price"},
+			[]interface{}{1, "Lamp", 40.0, 30.0},
+			[]interface{}{2, "Rug", 100.0, 95.0},
+		)
+		fake.On(`^UPDATE products SET price`)
+		fake.On(`^INSERT INTO price_history`)
+
+		w := httptest.NewRecorder()
+		bulkUpdatePrices(w, httptest.NewRequest(http.MethodPost, "/products/bulk-price", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var resp struct {
+			Updated int           `json:"updated"`
+			Changes []PriceChange `json:"changes"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Updated != 2 {
+			t.Errorf("updated = %d, want 2", resp.Updated)
+		}
+		return fake, resp.Changes
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		fake, changes := run(t, `{"category": "Home", "percent": -10, "dry_run": true}`)
+		if changes[0].NewPrice != 36 || changes[1].NewPrice != 90 {
+			t.Errorf("changes = %+v", changes)
+		}
+		if changes[0].SaleCleared || !changes[1].SaleCleared {
+			t.Errorf("only the rug's 95 sale price is at or above its new price: %+v", changes)
+		}
+		if n := len(fake.Matching(`^UPDATE|^INSERT|^COMMIT`)); n != 0 {
+			t.Errorf("dry run wrote %d statements", n)
+		}
+	})
+
+	t.Run("applied", func(t *testing.T) {
+		fake, _ := run(t, `{"category": "Home", "percent": -10, "reason": "spring sale"}`)
+		updates := fake.Matching(`^UPDATE products SET price`)
+		if len(updates) != 2 || updates[0].Args[0] != 36.0 {
+			t.Fatalf("updates = %+v", updates)
+		}
+		if !strings.Contains(updates[0].Query, "sale_price = CASE WHEN sale_price >= $1 THEN NULL") {
+			t.Errorf("update does not clear inverted sale prices: %s", updates[0].Query)
+		}
+		history := fake.Matching(`^INSERT INTO price_history`)
+		if len(history) != 2 || history[1].Args[1] != 100.0 || history[1].Args[2] != 90.0 || history[1].Args[3] != "spring sale" {
+			t.Errorf("price history = %+v", history)
+		}
+		if len(fake.Matching(`^COMMIT$`)) != 1 {
+			t.Error("changes were not committed")
+		}
+	})
+}
+
+func TestProductJSONUsesDefaultImage(t *testing.T) {
+	prev := defaultImageURL
+	defaultImageURL = "https://cdn.example.com/placeholder.png"
+	t.Cleanup(func() { defaultImageURL = prev })
+
+	tests := []struct {
+		imageURL string
+		want     string
+	}{
+		{"", "https://cdn.example.com/placeholder.png"},
+		{"https://cdn.example.com/lamp.png", "https://cdn.example.com/lamp.png"},
+	}
+	for _, tt := range tests {
+		p := Product{ID: 1, Name: "Lamp", ImageURL: tt.imageURL}
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			ImageURL string `json:"image_url"`
+		}
+		json.Unmarshal(b, &got)
+		if got.ImageURL != tt.want {
+			t.Errorf("image_url %q serialized as %q, want %q", tt.imageURL, got.ImageURL, tt.want)
+		}
+		if p.ImageURL != tt.imageURL {
+			t.Errorf("serializing changed the product's image_url to %q", p.ImageURL)
+		}
+	}
+}
+
+func TestDeleteProductRequiresAdmin(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`UPDATE products SET deleted_at`)
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, authorize(t, httptest.NewRequest("DELETE", "/products/3", nil), 5, "customer"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("customer: status = %d, want 403", w.Code)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Fatal("a customer's delete reached the database")
+	}
+
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, authorize(t, httptest.NewRequest("DELETE", "/products/3", nil), 1, "admin"))
+	if w.Code != http.StatusNoContent || len(fake.Matching(`deleted_at`)) != 1 {
+		t.Errorf("admin: status = %d, want 204 and the product soft-deleted", w.Code)
+	}
+}
+
+func TestImportFromURLRateLimited(t *testing.T) {
+	prev := importRateLimit
+	importRateLimit = middleware.RateLimit(middleware.RateLimitConfig{PerMinute: 5, Burst: 2})
+	t.Cleanup(func() { importRateLimit = prev })
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("POST", "/products/import/url", nil)
+		r.RemoteAddr = "192.0.2.59:4000"
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+		codes = append(codes, w.Code)
+	}
+	// The limit applies before authentication, so unauthenticated callers
+	// can't hammer the endpoint either.
+	if codes[0] != http.StatusUnauthorized || codes[1] != http.StatusUnauthorized || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("statuses = %v, want 401 twice then 429", codes)
+	}
+}
+
+func TestGetProductNullOptionalColumns(t *testing.T) {
+	// The scripted row is what Postgres returns for a product whose optional
+	// columns are NULL, which it only does if the query coalesces them.
+	fake := useDB(t)
+	fake.On(`SELECT id, name, COALESCE\(description, ''\), price, COALESCE\(stock, 0\), COALESCE\(category, ''\), COALESCE\(image_url, ''\), COALESCE\(sku, ''\), .* FROM products WHERE id = \$1`).
+		Rows(productColumnNames, []interface{}{3, "Lamp", "", 40.0, 0, "", "", "", 0.0, 0.0, 0.0, 0, time.Now(), 1, nil, nil, "{}"})
+	fake.On(`product_images`).Rows([]string{"id", "url", "is_primary", "sort_order"})
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/products/3", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var p map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&p)
+	if p["description"] != "" || p["category"] != "" || p["stock"] != 0.0 || p["sale_price"] != nil {
+		t.Errorf("product = %v, want empty defaults", p)
+	}
+}
+
+func TestReserveNeverOversells(t *testing.T) {
+	// The guarded UPDATE matches only while stock covers the quantity, so
+	// with 5 in stock the database answers the first 5 and no more.
+	const stock, attempts = 5, 20
+	fake := useDB(t)
+	fake.On(`UPDATE products SET stock = stock - \$1, .* WHERE id = \$2 AND stock >= \$1`).
+		Rows([]string{"name", "stock", "low_stock_threshold"}, []interface{}{"Lamp", 0, 0}).Times(stock)
+	fake.On(`UPDATE products SET stock = stock - \$1`)
+	fake.On(`SELECT EXISTS`).Rows([]string{"exists"}, []interface{}{true})
+	fake.On(`INSERT INTO stock_movements`)
+
+	router := newRouter()
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		r := authorize(t, httptest.NewRequest(http.MethodPost, "/products/1/reserve", strings.NewReader(`{"quantity": 1}`)), 1, "admin")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusOK] != stock || counts[http.StatusConflict] != attempts-stock {
+		t.Errorf("statuses = %v, want %d reserved and %d conflicts", counts, stock, attempts-stock)
+	}
+	if n := len(fake.Matching(`INSERT INTO stock_movements`)); n != stock {
+		t.Errorf("%d stock movements recorded, want %d", n, stock)
+	}
+}
+
+func TestReviewsRouteHiddenWhenFlagOff(t *testing.T) {
+	t.Setenv("FEATURE_REVIEWS", "off")
+	fake := useDB(t)
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/products/1/reviews", nil))
+	if w.Code != http.StatusNotFound || len(fake.Calls()) != 0 {
+		t.Errorf("status = %d with %d queries, want 404 and none", w.Code, len(fake.Calls()))
+	}
+}
+
+func TestSoftDeletedProductHiddenUntilRestored(t *testing.T) {
+	useExcludedCategories(t)
+	fake := useDB(t)
+	fake.On(`^UPDATE products SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1 AND deleted_at IS NULL`)
+	fake.On(`^SELECT COUNT\(\*\) FROM products WHERE deleted_at IS NULL`).Rows([]string{"count"}, []interface{}{0})
+	fake.On(`FROM products WHERE deleted_at IS NULL ORDER BY`).Rows(productColumnNames)
+	fake.On(`FROM products WHERE id = \$1 AND deleted_at IS NULL`).Times(1)
+
+	if w := sendAsAdmin(t, "DELETE", "/products/3", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d: %s", w.Code, w.Body)
+	}
+	if len(fake.Matching(`^DELETE`)) != 0 {
+		t.Fatal("a delete without force removed the row")
+	}
+
+	// Every read filters on deleted_at, so the product drops out of both
+	// the listing and direct lookups.
+	if page := listProducts(t, ""); len(page.Products) != 0 || page.Total != 0 {
+		t.Errorf("listing = %+v, want the deleted product gone", page)
+	}
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/products/3", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("get after delete: status = %d, want 404", w.Code)
+	}
+
+	fake.On(`^UPDATE products SET deleted_at = NULL WHERE id = \$1`)
+	fake.On(`FROM products WHERE id = \$1 AND deleted_at IS NULL`).Rows(productColumnNames, productRow(3, "Lamp", 40, time.Now()))
+	fake.On(`FROM product_images`).Rows([]string{"url"})
+	fake.On(`FROM reviews`).Rows([]string{"count", "avg"}, []interface{}{0, nil})
+
+	w = sendAsAdmin(t, "POST", "/products/3/restore", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore: status = %d: %s", w.Code, w.Body)
+	}
+	var p Product
+	json.NewDecoder(w.Body).Decode(&p)
+	if p.ID != 3 {
+		t.Errorf("restored product = %+v, want product 3", p)
+	}
+}
+
+func TestRestoreMissingProduct(t *testing.T) {
+	useDB(t).On(`^UPDATE products SET deleted_at = NULL`).Affected(0)
+
+	if w := sendAsAdmin(t, "POST", "/products/3/restore", ""); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestForceDeleteRemovesRow(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^DELETE FROM products WHERE id = \$1`)
+
+	if w := sendAsAdmin(t, "DELETE", "/products/3?force=true", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if len(fake.Matching(`deleted_at`)) != 0 {
+		t.Error("a forced delete only soft-deleted the product")
+	}
+}
+
+func TestConcurrentUpdatesSecondIsStale(t *testing.T) {
+	// Both clients read version 1. The guarded UPDATE matches for the
+	// first; by the second the row is at version 2 and nothing matches.
+	fake := useDB(t)
+	fake.On(`SELECT COALESCE\(stock, 0\) FROM products WHERE id = \$1 FOR UPDATE`).Rows([]string{"stock"}, []interface{}{10})
+	guarded := `^UPDATE products SET name .* WHERE id = \$14 AND \(\$15 = 0 OR version = \$15\) RETURNING version`
+	fake.On(guarded).Rows([]string{"version", "low_stock_threshold"}, []interface{}{2, 5}).Times(1)
+	fake.On(guarded)
+	fake.On(`FROM product_images`)
+
+	first := sendAsAdmin(t, "PUT", "/products/3", `{"name": "Lamp", "price": 40, "stock": 10, "category": "Home", "version": 1}`)
+	second := sendAsAdmin(t, "PUT", "/products/3", `{"name": "Desk lamp", "price": 45, "stock": 10, "category": "Home", "version": 1}`)
+
+	var ok struct {
+		Version int `json:"version"`
+	}
+	json.NewDecoder(first.Body).Decode(&ok)
+	if first.Code != http.StatusOK || ok.Version != 2 {
+		t.Errorf("first update: status = %d, version = %d; want 200 at version 2", first.Code, ok.Version)
+	}
+	if second.Code != http.StatusConflict || strings.TrimSpace(second.Body.String()) != `{"error":"stale version"}` {
+		t.Errorf("second update: status = %d, body = %s; want 409 stale version", second.Code, second.Body)
+	}
+	for _, c := range fake.Matching(guarded) {
+		if c.Args[14] != int64(1) {
+			t.Errorf("expected version arg = %v, want 1", c.Args[14])
+		}
+	}
+	if commits := fake.Matching(`^COMMIT`); len(commits) != 1 {
+		t.Errorf("%d commits, want only the first update's", len(commits))
+	}
+}
+
+func TestGetProductIncludesVersion(t *testing.T) {
+	fake := useDB(t)
+	row := productRow(3, "Lamp", 40, time.Now())
+	row[13] = 4
+	fake.On(`FROM products WHERE id = \$1`).Rows(productColumnNames, row)
+	fake.On(`FROM product_images`).Rows([]string{"url"})
+	fake.On(`FROM reviews`).Rows([]string{"count", "avg"}, []interface{}{0, nil})
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/products/3", nil))
+	var p struct {
+		Version int `json:"version"`
+	}
+	json.NewDecoder(w.Body).Decode(&p)
+	if w.Code != http.StatusOK || p.Version != 4 {
+		t.Errorf("status = %d, version = %d; want 200 and 4", w.Code, p.Version)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useReadOnly sets read-only mode for the rest of the test.
+func useReadOnly(t *testing.T, on bool) {
+	t.Helper()
+	prev := readOnly.Load()
+	readOnly.Store(on)
+	t.Cleanup(func() { readOnly.Store(prev) })
+}
+
+func TestReadOnlyBlocksWrites(t *testing.T) {
+	useReadOnly(t, true)
+	fake := useDB(t)
+
+	for _, r := range []*http.Request{
+		authorize(t, httptest.NewRequest("POST", "/products", strings.NewReader(`{"name": "Lamp", "price": 10}`)), 1, "admin"),
+		authorize(t, httptest.NewRequest("PUT", "/products/1", strings.NewReader(`{"name": "Lamp", "price": 10}`)), 1, "admin"),
+		authorize(t, httptest.NewRequest("DELETE", "/products/1", nil), 1, "admin"),
+		httptest.NewRequest("PATCH", "/products/1/stock", strings.NewReader(`{"quantity": -1}`)),
+		authorize(t, httptest.NewRequest("POST", "/products/1/reserve", strings.NewReader(`{"quantity": 1}`)), 1, "admin"),
+	} {
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s %s: status = %d, want 503 with Retry-After", r.Method, r.URL, w.Code)
+		}
+	}
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("blocked writes reached the database: %+v", calls)
+	}
+}
+
+func TestReadOnlyServesReads(t *testing.T) {
+	useReadOnly(t, true)
+	fake := useDB(t)
+	fake.On(`WHERE id = ANY\(\$1\)`).Rows(productColumnNames, productRow(1, "Lamp", 40, time.Now()))
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest("GET", "/products?ids=1", nil),
+		httptest.NewRequest("POST", "/products/batch", strings.NewReader(`{"ids": [1]}`)),
+		httptest.NewRequest("GET", "/products/readonly", nil),
+	} {
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s %s: status = %d, want 200", r.Method, r.URL, w.Code)
+		}
+	}
+}
+
+func TestReadOnlyToggle(t *testing.T) {
+	useReadOnly(t, true)
+
+	r := authorize(t, httptest.NewRequest("PUT", "/products/readonly", strings.NewReader(`{"read_only": false}`)), 1, "")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || !readOnly.Load() {
+		t.Fatalf("customer toggle: status = %d, read_only = %v", w.Code, readOnly.Load())
+	}
+
+	r = authorize(t, httptest.NewRequest("PUT", "/products/readonly", strings.NewReader(`{"read_only": false}`)), 1, "admin")
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusOK || readOnly.Load() {
+		t.Errorf("admin toggle: status = %d, read_only = %v", w.Code, readOnly.Load())
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/validation"
+	"github.com/lib/pq"
+)
+
+const (
+	maxReviewTitleLength = 200
+	maxReviewBodyLength  = 5000
+	defaultReviewLimit   = 20
+	maxReviewLimit       = 100
+)
+
+type Review struct {
+	ID        uint      `json:"id"`
+	ProductID uint      `json:"product_id"`
+	UserID    uint      `json:"user_id"`
+	Rating    int       `json:"rating"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// createReview adds the caller's review of a product. Each user may review a
+// product once.
+func createReview(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var review Review
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	review.Title = strings.TrimSpace(review.Title)
+	review.Body = strings.TrimSpace(review.Body)
+
+	v := validation.New()
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(len(review.Title) <= maxReviewTitleLength, "title", "is too long")
+	v.Check(len(review.Body) <= maxReviewBodyLength, "body", "is too long")
+	if !v.Valid() {
+		validation.WriteError(w, v.Errors())
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	review.ProductID = uint(productID)
+	review.UserID = claims.UserID
+
+	err = db.QueryRow(
+		`INSERT INTO reviews (product_id, user_id, rating, title, body)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		review.ProductID, review.UserID, review.Rating, review.Title, review.Body,
+	).Scan(&review.ID, &review.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case "23505":
+				http.Error(w, "You have already reviewed this product", http.StatusConflict)
+				return
+			case "23503":
+				http.Error(w, "Product not found", http.StatusNotFound)
+				return
+			}
+		}
+		http.Error(w, "Failed to create review", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(review)
+}
+
+// getReviews lists a product's reviews, newest first.
+func getReviews(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset := defaultReviewLimit, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > maxReviewLimit {
+			limit = maxReviewLimit
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, err := db.Query(
+		`SELECT id, product_id, user_id, rating, COALESCE(title, ''), COALESCE(body, ''), created_at
+		 FROM reviews WHERE product_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3`,
+		productID, limit, offset,
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch reviews", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	reviews := []Review{}
+	for rows.Next() {
+		var rv Review
+		if err := rows.Scan(&rv.ID, &rv.ProductID, &rv.UserID, &rv.Rating, &rv.Title, &rv.Body, &rv.CreatedAt); err != nil {
+			continue
+		}
+		reviews = append(reviews, rv)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"reviews": reviews,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// loadRatingSummary fills in the product's review count and average rating.
+// A failure only drops the summary from the response.
+func loadRatingSummary(p *Product) {
+	var count int
+	var avg sql.NullFloat64
+	err := db.QueryRow("SELECT COUNT(*), AVG(rating) FROM reviews WHERE product_id = $1", p.ID).Scan(&count, &avg)
+	if err != nil {
+		log.Printf("Failed to load rating summary for product %d: %v", p.ID, err)
+		return
+	}
+
+	p.ReviewCount = &count
+	if avg.Valid {
+		rounded := math.Round(avg.Float64*100) / 100
+		p.AverageRating = &rounded
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func postReview(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := authorize(t, httptest.NewRequest("POST", "/products/3/reviews", strings.NewReader(body)), 7, "")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+func TestCreateReview(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`INSERT INTO reviews`).Rows([]string{"id", "created_at"}, []interface{}{11, time.Now()})
+
+	w := postReview(t, `{"rating": 4, "title": " Bright ", "body": "Lights the room", "user_id": 99}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	// The reviewer comes from the token, never the body.
+	args := fake.Matching(`INSERT INTO reviews`)[0].Args
+	if args[0] != int64(3) || args[1] != int64(7) || args[2] != int64(4) || args[3] != "Bright" {
+		t.Errorf("insert args = %v", args)
+	}
+}
+
+func TestCreateReviewRejectsBadRating(t *testing.T) {
+	for _, rating := range []string{"0", "6", "-1"} {
+		fake := useDB(t)
+		if w := postReview(t, `{"rating": `+rating+`}`); w.Code != http.StatusBadRequest {
+			t.Errorf("rating %s: status = %d, want 400", rating, w.Code)
+		}
+		if len(fake.Calls()) != 0 {
+			t.Errorf("rating %s reached the database", rating)
+		}
+	}
+}
+
+func TestCreateReviewDuplicateConflicts(t *testing.T) {
+	useDB(t).On(`INSERT INTO reviews`).Err(&pq.Error{Code: "23505"})
+
+	if w := postReview(t, `{"rating": 5}`); w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", w.Code)
+	}
+}
+
+func TestGetReviewsPaginates(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`FROM reviews WHERE product_id = \$1 ORDER BY created_at DESC, id DESC LIMIT \$2 OFFSET \$3`).Rows(
+		[]string{"id", "product_id", "user_id", "rating", "title", "body", "created_at"},
+		[]interface{}{12, 3, 8, 5, "", "", time.Now()}, []interface{}{11, 3, 7, 4, "Bright", "", time.Now()})
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/products/3/reviews?limit=500&offset=2", nil))
+	var resp struct {
+		Reviews []Review `json:"reviews"`
+		Limit   int      `json:"limit"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.Reviews) != 2 || resp.Reviews[0].ID != 12 || resp.Limit != maxReviewLimit {
+		t.Errorf("status = %d, response = %+v", w.Code, resp)
+	}
+	if args := fake.Matching(`FROM reviews`)[0].Args; args[1] != int64(maxReviewLimit) || args[2] != int64(2) {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestLoadRatingSummary(t *testing.T) {
+	tests := []struct {
+		count   int
+		avg     interface{}
+		wantAvg *float64
+	}{
+		{3, 4.333333, func() *float64 { v := 4.33; return &v }()},
+		{0, nil, nil},
+	}
+	for _, tt := range tests {
+		useDB(t).On(`SELECT COUNT\(\*\), AVG\(rating\) FROM reviews WHERE product_id = \$1`).Rows(
+			[]string{"count", "avg"}, []interface{}{tt.count, tt.avg})
+
+		p := Product{ID: 3}
+		loadRatingSummary(&p)
+		if p.ReviewCount == nil || *p.ReviewCount != tt.count {
+			t.Errorf("review_count = %v, want %d", p.ReviewCount, tt.count)
+		}
+		if (p.AverageRating == nil) != (tt.wantAvg == nil) || (p.AverageRating != nil && *p.AverageRating != *tt.wantAvg) {
+			t.Errorf("%d reviews: average_rating = %v, want %v", tt.count, p.AverageRating, tt.wantAvg)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestEffectivePrice(t *testing.T) {
+	sale := 30.0
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	tests := []struct {
+		name    string
+		sale    *float64
+		endsAt  *time.Time
+		want    float64
+		wantHas bool
+	}{
+		{"active sale", &sale, &future, 30, true},
+		{"open-ended sale", &sale, nil, 30, true},
+		{"expired sale", &sale, &past, 40, false},
+		{"no sale", nil, nil, 40, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Product{ID: 3, Name: "Lamp", Price: 40, SalePrice: tt.sale, SaleEndsAt: tt.endsAt}
+			if p.onSale(time.Now()) != tt.wantHas {
+				t.Errorf("onSale = %v, want %v", !tt.wantHas, tt.wantHas)
+			}
+			b, err := json.Marshal(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				Price          float64 `json:"price"`
+				EffectivePrice float64 `json:"effective_price"`
+			}
+			json.Unmarshal(b, &got)
+			if got.EffectivePrice != tt.want || got.Price != 40 {
+				t.Errorf("price = %v, effective_price = %v; want 40 and %v", got.Price, got.EffectivePrice, tt.want)
+			}
+		})
+	}
+}
+
+func TestProductOnSaleFilter(t *testing.T) {
+	useExcludedCategories(t)
+	fake := useDB(t)
+	onSale := `WHERE deleted_at IS NULL AND ` + regexp.QuoteMeta(activeSaleSQL)
+	fake.On(`^SELECT COUNT\(\*\) FROM products `+onSale+`$`).Rows([]string{"count"}, []interface{}{1})
+	row := productRow(3, "Lamp", 40, time.Now())
+	row[14] = 30.0
+	fake.On(onSale+` ORDER BY`).Rows(productColumnNames, row)
+
+	page := listProducts(t, "on_sale=true")
+	if len(page.Products) != 1 || page.Products[0].EffectivePrice != 30 || page.Total != 1 {
+		t.Errorf("page = %+v, want the one discounted product at 30", page)
+	}
+
+	fake = useDB(t)
+	fake.On(`^SELECT COUNT`).Rows([]string{"count"}, []interface{}{0})
+	fake.On(`ORDER BY`).Rows(productColumnNames)
+	listProducts(t, "on_sale=false")
+	if n := len(fake.Matching(`sale_price IS NOT NULL`)); n != 0 {
+		t.Errorf("on_sale=false still filtered %d queries", n)
+	}
+}
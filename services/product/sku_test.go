@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestGetProductBySKU(t *testing.T) {
+	fake := useDB(t)
+	row := productRow(3, "Lamp", 40, time.Now())
+	row[7] = "LAMP-01"
+	fake.On(`FROM products WHERE sku = \$1 AND deleted_at IS NULL`).Rows(productColumnNames, row)
+	fake.On(`FROM product_images`).Rows([]string{"url"})
+	fake.On(`FROM reviews`).Rows([]string{"count", "avg"}, []interface{}{0, nil})
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/products/sku/LAMP-01", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var p Product
+	json.NewDecoder(w.Body).Decode(&p)
+	if p.ID != 3 || p.SKU != "LAMP-01" {
+		t.Errorf("product = %d %q, want 3 LAMP-01", p.ID, p.SKU)
+	}
+	if lookups := fake.Matching(`WHERE sku`); lookups[0].Args[0] != "LAMP-01" {
+		t.Errorf("looked up %v", lookups[0].Args[0])
+	}
+}
+
+func TestGetProductBySKUMiss(t *testing.T) {
+	useDB(t).On(`FROM products WHERE sku = \$1`)
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/products/sku/NOPE", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestCreateProductDuplicateSKUConflicts(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^INSERT INTO products`).Err(&pq.Error{Code: "23505", Constraint: "products_sku_key"})
+
+	w := sendAsAdmin(t, "POST", "/products", `{"name": "Lamp", "price": 40, "category": "Home", "sku": "LAMP-01"}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", w.Code)
+	}
+	if len(fake.Matching(`^COMMIT`)) != 0 {
+		t.Error("a conflicting product was committed")
+	}
+}
+
+func TestCreateProductWithoutSKUStoresNull(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^INSERT INTO products`).Rows([]string{"id", "created_at", "version"}, []interface{}{3, time.Now(), 1})
+	fake.On(`product_tags`)
+
+	if w := sendAsAdmin(t, "POST", "/products", `{"name": "Lamp", "price": 40, "category": "Home"}`); w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	// The insert wraps the SKU in NULLIF, so an empty one is stored as NULL
+	// and doesn't collide with other products that have none.
+	inserts := fake.Matching(`^INSERT INTO products .*NULLIF\(\$7, ''\)`)
+	if len(inserts) != 1 || inserts[0].Args[6] != "" {
+		t.Errorf("inserts = %+v, want an empty SKU argument", inserts)
+	}
+}
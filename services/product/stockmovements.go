@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	reasonManualAdjustment = "manual_adjustment"
+	reasonOrderReserve     = "order_reserve"
+	reasonOrderCancel      = "order_cancel"
+	reasonOrderEdit        = "order_edit"
+	reasonOrderReturn      = "order_return"
+	reasonInitialStock     = "initial_stock"
+	reasonImport           = "import"
+
+	maxReferenceIDLength   = 100
+	defaultStockHistoryLen = 50
+	maxStockHistoryLen     = 500
+)
+
+// stockReasons are the reasons a caller may give when adjusting stock.
+// order_reserve, initial_stock and import are not listed: they are only
+// written by reserveStock, product creation and the feed import.
+var stockReasons = map[string]bool{
+	reasonManualAdjustment: true,
+	reasonOrderCancel:      true,
+	reasonOrderEdit:        true,
+	reasonOrderReturn:      true,
+}
+
+type StockMovement struct {
+	ID          uint      `json:"id"`
+	ProductID   uint      `json:"product_id"`
+	Delta       int       `json:"delta"`
+	Reason      string    `json:"reason"`
+	ReferenceID string    `json:"reference_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// recordStockMovement logs a stock change in the same transaction as the
+// change itself, so the history always sums to the current count.
+func recordStockMovement(tx *sql.Tx, productID int64, delta int, reason, referenceID string) error {
+	_, err := tx.Exec(
+		"INSERT INTO stock_movements (product_id, delta, reason, reference_id) VALUES ($1, $2, $3, NULLIF($4, ''))",
+		productID, delta, reason, referenceID,
+	)
+	return err
+}
+
+// recordStockSet logs a write that set stock outright, such as a create,
+// replace or import, as the difference from what was there before.
+func recordStockSet(tx *sql.Tx, productID int64, before, after int, reason string) error {
+	if before == after {
+		return nil
+	}
+	return recordStockMovement(tx, productID, after-before, reason, "")
+}
+
+// getStockHistory lists a product's stock movements, newest first.
+func getStockHistory(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultStockHistoryLen
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > maxStockHistoryLen {
+			limit = maxStockHistoryLen
+		}
+	}
+
+	var stock int
+	err = db.QueryRow("SELECT stock FROM products WHERE id = $1", productID).Scan(&stock)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch stock history", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query(
+		`SELECT id, product_id, delta, reason, COALESCE(reference_id, ''), created_at
+		 FROM stock_movements WHERE product_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2`,
+		productID, limit,
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch stock history", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	movements := []StockMovement{}
+	for rows.Next() {
+		var m StockMovement
+		if err := rows.Scan(&m.ID, &m.ProductID, &m.Delta, &m.Reason, &m.ReferenceID, &m.CreatedAt); err != nil {
+			continue
+		}
+		movements = append(movements, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"product_id": productID,
+		"stock":      stock,
+		"movements":  movements,
+		"limit":      limit,
+	})
+}
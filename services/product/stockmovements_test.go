@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+)
+
+func TestRecordStockSetSkipsUnchangedStock(t *testing.T) {
+	// A nil transaction would panic if an insert were attempted.
+	if err := recordStockSet(nil, 1, 12, 12, reasonManualAdjustment); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateStockRejectsSystemReasons(t *testing.T) {
+	for _, reason := range []string{reasonOrderReserve, reasonInitialStock, reasonImport, "restock"} {
+		t.Run(reason, func(t *testing.T) {
+			body := `{"quantity": 5, "reason": "` + reason + `"}`
+			r := httptest.NewRequest(http.MethodPatch, "/products/1/stock", strings.NewReader(body))
+			r = mux.SetURLVars(r, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+			updateStock(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", w.Code)
+			}
+		})
+	}
+}
+
+// assertMovement checks fake logged exactly one stock movement for product 3
+// with delta, reason and referenceID, inside the committed transaction.
+func assertMovement(t *testing.T, fake *dbtest.DB, delta int64, reason, referenceID string) {
+	t.Helper()
+	moves := fake.Matching(`^INSERT INTO stock_movements`)
+	if len(moves) != 1 {
+		t.Fatalf("%d movements logged, want 1", len(moves))
+	}
+	args := moves[0].Args
+	if args[0] != int64(3) || args[1] != delta || args[2] != reason || args[3] != referenceID {
+		t.Errorf("movement = %v, want product 3, %d, %s, %q", args, delta, reason, referenceID)
+	}
+	if calls := fake.Calls(); calls[len(calls)-1].Query != "COMMIT" {
+		t.Error("movement not committed with the stock change")
+	}
+}
+
+func TestUpdateStockLogsMovement(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^UPDATE products SET stock = stock \+ \$1`).Rows([]string{"name", "stock", "low_stock_threshold"}, []interface{}{"Lamp", 9, 5})
+	fake.On(`^INSERT INTO stock_movements`)
+
+	r := httptest.NewRequest(http.MethodPatch, "/products/3/stock", strings.NewReader(`{"quantity": 2, "reason": "order_cancel", "reference_id": "ORD-2026-000012"}`))
+	w := httptest.NewRecorder()
+	updateStock(w, mux.SetURLVars(r, map[string]string{"id": "3"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	assertMovement(t, fake, 2, reasonOrderCancel, "ORD-2026-000012")
+}
+
+func TestUpdateStockDefaultsToManualAdjustment(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^UPDATE products SET stock`).Rows([]string{"name", "stock", "low_stock_threshold"}, []interface{}{"Lamp", 20, 5})
+	fake.On(`^INSERT INTO stock_movements`)
+
+	r := httptest.NewRequest(http.MethodPatch, "/products/3/stock", strings.NewReader(`{"quantity": -1}`))
+	updateStock(httptest.NewRecorder(), mux.SetURLVars(r, map[string]string{"id": "3"}))
+	assertMovement(t, fake, -1, reasonManualAdjustment, "")
+}
+
+func TestReserveLogsMovement(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^UPDATE products SET stock = stock - \$1`).Rows([]string{"name", "stock", "low_stock_threshold"}, []interface{}{"Lamp", 7, 5})
+	fake.On(`^INSERT INTO stock_movements`)
+
+	if w := sendAsAdmin(t, "POST", "/products/3/reserve", `{"quantity": 3, "reference_id": "ORD-2026-000012"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	assertMovement(t, fake, -3, reasonOrderReserve, "ORD-2026-000012")
+}
+
+func TestReplaceProductLogsStockDifference(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`SELECT COALESCE\(stock, 0\) FROM products WHERE id = \$1 FOR UPDATE`).Rows([]string{"stock"}, []interface{}{10})
+	fake.On(`^UPDATE products SET name`).Rows([]string{"version", "low_stock_threshold"}, []interface{}{2, 5})
+	fake.On(`^INSERT INTO stock_movements`)
+	fake.On(`FROM product_images`)
+
+	if w := sendAsAdmin(t, "PUT", "/products/3", `{"name": "Lamp", "price": 40, "stock": 14, "category": "Home"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	assertMovement(t, fake, 4, reasonManualAdjustment, "")
+}
+
+func TestCreateProductLogsInitialStock(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^INSERT INTO products`).Rows([]string{"id", "created_at", "version"}, []interface{}{3, time.Now(), 1})
+	fake.On(`^INSERT INTO stock_movements`)
+	fake.On(`product_tags`)
+
+	if w := sendAsAdmin(t, "POST", "/products", `{"name": "Lamp", "price": 40, "stock": 12, "category": "Home"}`); w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	assertMovement(t, fake, 12, reasonInitialStock, "")
+}
+
+func TestGetStockHistory(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`SELECT stock FROM products WHERE id = \$1`).Rows([]string{"stock"}, []interface{}{7})
+	fake.On(`FROM stock_movements WHERE product_id = \$1 ORDER BY created_at DESC, id DESC LIMIT \$2`).Rows(
+		[]string{"id", "product_id", "delta", "reason", "reference_id", "created_at"},
+		[]interface{}{2, 3, -3, reasonOrderReserve, "ORD-2026-000012", time.Now()},
+		[]interface{}{1, 3, 10, reasonInitialStock, "", time.Now().Add(-time.Hour)},
+	)
+
+	r := authorize(t, httptest.NewRequest(http.MethodGet, "/products/3/stock-history?limit=1000", nil), 1, "admin")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var history struct {
+		Stock     int             `json:"stock"`
+		Limit     int             `json:"limit"`
+		Movements []StockMovement `json:"movements"`
+	}
+	json.NewDecoder(w.Body).Decode(&history)
+	if history.Stock != 7 || history.Limit != maxStockHistoryLen || len(history.Movements) != 2 ||
+		history.Movements[0].Delta != -3 || history.Movements[0].Reason != reasonOrderReserve {
+		t.Errorf("history = %+v", history)
+	}
+}
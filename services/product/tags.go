@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	maxProductTags = 20
+	maxTagLength   = 50
+)
+
+// normalizeTags lower-cases and trims tags, dropping blanks and duplicates
+// while keeping the first-seen order. A nil slice stays nil so callers can
+// tell "no tags given" from "clear the tags".
+func normalizeTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+	out := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+// replaceProductTags sets the product's tags to exactly tags.
+func replaceProductTags(tx *sql.Tx, productID uint, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM product_tags WHERE product_id = $1", productID); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(
+		"INSERT INTO product_tags (product_id, tag) SELECT $1, unnest($2::text[])",
+		productID, pq.Array(tags),
+	)
+	return err
+}
+
+// tagFilter returns the WHERE fragment for ?tags=, matching products with
+// every tag or, with tag_mode=any, at least one. The tag array is bound to
+// placeholder $arg.
+func tagFilter(mode string, arg, count int) string {
+	placeholder := "$" + strconv.Itoa(arg)
+	if mode == "any" {
+		return " AND EXISTS (SELECT 1 FROM product_tags t WHERE t.product_id = products.id AND t.tag = ANY(" + placeholder + "))"
+	}
+	return " AND (SELECT COUNT(*) FROM product_tags t WHERE t.product_id = products.id AND t.tag = ANY(" + placeholder + ")) = " + strconv.Itoa(count)
+}
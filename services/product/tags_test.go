@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want []string
+	}{
+		{[]string{" Eco-Friendly", "bestseller", "ECO-FRIENDLY", "", "  "}, []string{"eco-friendly", "bestseller"}},
+		{[]string{}, []string{}},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		if got := normalizeTags(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeTags(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestProductTagFilterModes(t *testing.T) {
+	tests := []struct {
+		mode  string
+		query string
+	}{
+		{"", `\(SELECT COUNT\(\*\) FROM product_tags t WHERE t.product_id = products.id AND t.tag = ANY\(\$1\)\) = 2`},
+		{"all", `\(SELECT COUNT\(\*\) FROM product_tags t WHERE t.product_id = products.id AND t.tag = ANY\(\$1\)\) = 2`},
+		{"any", `EXISTS \(SELECT 1 FROM product_tags t WHERE t.product_id = products.id AND t.tag = ANY\(\$1\)\)`},
+	}
+	for _, tt := range tests {
+		t.Run("mode "+tt.mode, func(t *testing.T) {
+			useExcludedCategories(t)
+			fake := useDB(t)
+			fake.On(`^SELECT COUNT\(\*\) FROM products WHERE deleted_at IS NULL AND `+tt.query+`$`).Rows([]string{"count"}, []interface{}{1})
+			row := productRow(3, "Lamp", 40, time.Now())
+			row[16] = "{bestseller,eco-friendly}"
+			fake.On(tt.query+` ORDER BY`).Rows(productColumnNames, row)
+
+			page := listProducts(t, "tags=Eco-Friendly,bestseller,eco-friendly&tag_mode="+tt.mode)
+			if len(page.Products) != 1 || !reflect.DeepEqual(page.Products[0].Tags, []string{"bestseller", "eco-friendly"}) {
+				t.Errorf("page = %+v, want product 3 with its tags", page)
+			}
+			// Tags are normalized and deduplicated before binding, so the
+			// all-match count is of distinct tags.
+			for _, c := range fake.Matching(`product_tags`) {
+				if c.Args[0] != `{"eco-friendly","bestseller"}` {
+					t.Errorf("tags arg = %v", c.Args[0])
+				}
+			}
+		})
+	}
+}
+
+func TestCreateProductStoresNormalizedTags(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`^INSERT INTO products`).Rows([]string{"id", "created_at", "version"}, []interface{}{3, time.Now(), 1})
+	fake.On(`product_tags`)
+
+	w := sendAsAdmin(t, "POST", "/products", `{"name": "Lamp", "price": 40, "category": "Home", "tags": ["Bestseller", " bestseller ", "Eco"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	inserts := fake.Matching(`^INSERT INTO product_tags`)
+	if len(inserts) != 1 || inserts[0].Args[1] != `{"bestseller","eco"}` {
+		t.Errorf("tag inserts = %+v", inserts)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/validation"
+)
+
+// maxProductPrice is the largest amount a DECIMAL(10,2) column holds; larger
+// values would be rejected or mangled by the database.
+const maxProductPrice = 99999999.99
+
+// normalizeProduct trims the free-text fields a client may pad.
+func normalizeProduct(p *Product) {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Category = strings.TrimSpace(p.Category)
+	p.SKU = strings.TrimSpace(p.SKU)
+	p.Tags = normalizeTags(p.Tags)
+}
+
+// validateProduct checks a product about to be created or replaced. The
+// error, if any, is a validation.Errors naming each field that failed.
+func validateProduct(p Product) error {
+	v := validation.New()
+	v.Check(p.Name != "", "name", "is required")
+	v.Check(p.Category != "", "category", "is required")
+	checkMoney(v, "price", p.Price)
+	v.Check(p.Stock >= 0, "stock", "must not be negative")
+	v.Check(p.Stock <= math.MaxInt32, "stock", "is too large")
+
+	v.Check(p.LengthCM >= 0, "length_cm", "must not be negative")
+	v.Check(p.WidthCM >= 0, "width_cm", "must not be negative")
+	v.Check(p.HeightCM >= 0, "height_cm", "must not be negative")
+	v.Check(p.WeightGrams >= 0, "weight_grams", "must not be negative")
+
+	v.Check(len(p.Tags) <= maxProductTags, "tags", "has too many entries")
+	for i, tag := range p.Tags {
+		if len(tag) > maxTagLength {
+			v.Field("tags").Index(i).Fail("is too long")
+		}
+	}
+
+	if p.SalePrice != nil {
+		checkMoney(v, "sale_price", *p.SalePrice)
+		v.Check(*p.SalePrice < p.Price, "sale_price", "must be less than price")
+	} else {
+		v.Check(p.SaleEndsAt == nil, "sale_ends_at", "requires sale_price")
+	}
+
+	if !v.Valid() {
+		return v.Errors()
+	}
+	return nil
+}
+
+// checkMoney requires a non-negative amount that fits DECIMAL(10,2) exactly,
+// so fractions of a cent aren't silently rounded away on insert.
+func checkMoney(v *validation.Validator, field string, amount float64) {
+	switch {
+	case math.IsNaN(amount) || math.IsInf(amount, 0):
+		v.Field(field).Fail("must be a number")
+	case amount < 0:
+		v.Field(field).Fail("must not be negative")
+	case amount > maxProductPrice:
+		v.Field(field).Fail("is too large")
+	case math.Abs(amount*100-math.Round(amount*100)) > 1e-6:
+		v.Field(field).Fail("must not have more than two decimal places")
+	}
+}
+
+// writeProductError answers 400 for a validateProduct failure.
+func writeProductError(w http.ResponseWriter, err error) {
+	var errs validation.Errors
+	if errors.As(err, &errs) {
+		validation.WriteError(w, errs)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/validation"
+)
+
+func TestValidateProduct(t *testing.T) {
+	valid := Product{Name: "Lamp", Category: "Home", Price: 40, Stock: 3}
+	tests := []struct {
+		name    string
+		edit    func(p *Product)
+		field   string
+		message string
+	}{
+		{"valid", func(p *Product) {}, "", ""},
+		{"free and out of stock", func(p *Product) { p.Price, p.Stock = 0, 0 }, "", ""},
+		{"largest price", func(p *Product) { p.Price = maxProductPrice }, "", ""},
+		{"missing name", func(p *Product) { p.Name = "" }, "name", "is required"},
+		{"missing category", func(p *Product) { p.Category = "" }, "category", "is required"},
+		{"negative price", func(p *Product) { p.Price = -0.01 }, "price", "must not be negative"},
+		{"price not a number", func(p *Product) { p.Price = math.NaN() }, "price", "must be a number"},
+		{"price too large", func(p *Product) { p.Price = 1e9 }, "price", "is too large"},
+		{"fraction of a cent", func(p *Product) { p.Price = 19.999 }, "price", "must not have more than two decimal places"},
+		{"negative stock", func(p *Product) { p.Stock = -1 }, "stock", "must not be negative"},
+		{"stock too large", func(p *Product) { p.Stock = math.MaxInt32 + 1 }, "stock", "is too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid
+			tt.edit(&p)
+			err := validateProduct(p)
+			if tt.field == "" {
+				if err != nil {
+					t.Errorf("validateProduct = %v, want nil", err)
+				}
+				return
+			}
+			var errs validation.Errors
+			if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != tt.field || errs[0].Message != tt.message {
+				t.Errorf("validateProduct = %v, want %s: %s", err, tt.field, tt.message)
+			}
+		})
+	}
+}
+
+func TestCreateAndUpdateReportInvalidField(t *testing.T) {
+	fake := useDB(t)
+	for _, req := range []struct{ method, path string }{{"POST", "/products"}, {"PUT", "/products/3"}} {
+		w := sendAsAdmin(t, req.method, req.path, `{"name": "Lamp", "category": "Home", "price": 40, "stock": -2}`)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"field":"stock"`) {
+			t.Errorf("%s %s: status = %d, body = %s; want 400 naming stock", req.method, req.path, w.Code, w.Body)
+		}
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("an invalid product reached the database")
+	}
+}
+
+func TestValidateProductRejectsNegativeDimensions(t *testing.T) {
+	p := Product{Name: "Lamp", Category: "Home", Price: 40, LengthCM: -1, WidthCM: 10, HeightCM: -0.5, WeightGrams: -200}
+	var errs validation.Errors
+	if err := validateProduct(p); !errors.As(err, &errs) {
+		t.Fatalf("validateProduct = %v, want validation errors", err)
+	}
+	got := map[string]bool{}
+	for _, e := range errs {
+		got[e.Field] = true
+	}
+	for _, field := range []string{"length_cm", "height_cm", "weight_grams"} {
+		if !got[field] {
+			t.Errorf("%s not rejected: %v", field, errs)
+		}
+	}
+	if got["width_cm"] {
+		t.Error("a valid width_cm was rejected")
+	}
+
+	p.LengthCM, p.HeightCM, p.WeightGrams = 0, 0, 0
+	if err := validateProduct(p); err != nil {
+		t.Errorf("zero dimensions rejected: %v", err)
+	}
+}
+
+func TestValidateProductSalePrice(t *testing.T) {
+	ends := time.Now().Add(time.Hour)
+	tests := []struct {
+		name  string
+		sale  float64
+		field string
+	}{
+		{"below price", 30, ""},
+		{"equal to price", 40, "sale_price"},
+		{"above price", 45, "sale_price"},
+		{"negative", -1, "sale_price"},
+	}
+	for _, tt := range tests {
+		sale := tt.sale
+		err := validateProduct(Product{Name: "Lamp", Category: "Home", Price: 40, SalePrice: &sale, SaleEndsAt: &ends})
+		var errs validation.Errors
+		errors.As(err, &errs)
+		if (tt.field == "") != (err == nil) || (tt.field != "" && (len(errs) == 0 || errs[0].Field != tt.field)) {
+			t.Errorf("%s: validateProduct = %v, want an error on %q", tt.name, err, tt.field)
+		}
+	}
+
+	err := validateProduct(Product{Name: "Lamp", Category: "Home", Price: 40, SaleEndsAt: &ends})
+	var errs validation.Errors
+	if !errors.As(err, &errs) || errs[0].Field != "sale_ends_at" {
+		t.Errorf("sale_ends_at without sale_price: %v", err)
+	}
+}
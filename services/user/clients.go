@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// serviceClient is used for calls to the other services.
+var serviceClient = &http.Client{Timeout: 5 * time.Second}
+
+func serviceURL(envKey, fallback string) string {
+	if url := os.Getenv(envKey); url != "" {
+		return url
+	}
+	return fallback
+}
+
+func notificationServiceURL() string {
+	return serviceURL("NOTIFICATION_SERVICE_URL", "http://notification-service:8006")
+}
+
+// appBaseURL is the public origin used when building links sent to users.
+func appBaseURL() string {
+	if url := os.Getenv("APP_BASE_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:8080"
+}
+
+// sendEmail asks the notification service to deliver an email to a user.
+func sendEmail(userID uint, notificationType, subject, message string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"user_id": userID,
+		"type":    notificationType,
+		"channel": "email",
+		"subject": subject,
+		"message": message,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := serviceClient.Post(notificationServiceURL()+"/notifications", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("notification service returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// emailChangeTTL bounds how long a verification link for a new address stays
+// valid.
+const emailChangeTTL = 24 * time.Hour
+
+// newVerificationToken returns a random token for the link and the hash that
+// is stored, so a database leak does not expose usable links.
+func newVerificationToken() (token, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// emailInUse reports whether another user already has email, ignoring case.
+func emailInUse(q rowQuerier, email string, exceptUserID int) (bool, error) {
+	var exists bool
+	err := q.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($1) AND id <> $2)",
+		email, exceptUserID,
+	).Scan(&exists)
+	return exists, err
+}
+
+// requestEmailChange records a pending address for the user and mails a
+// verification link to it. The stored email is left untouched until the link
+// is confirmed.
+func requestEmailChange(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(uint(id)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		NewEmail        string `json:"new_email"`
+		CurrentPassword string `json:"current_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.NewEmail = normalizeEmail(req.NewEmail)
+	if !isValidEmail(req.NewEmail) {
+		writeInvalidEmail(w)
+		return
+	}
+
+	var currentEmail, hashedPassword string
+	err = db.QueryRow("SELECT email, password FROM users WHERE id = $1", id).Scan(&currentEmail, &hashedPassword)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.CurrentPassword)); err != nil {
+		http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+		return
+	}
+
+	if strings.EqualFold(req.NewEmail, currentEmail) {
+		http.Error(w, "New email matches the current email", http.StatusBadRequest)
+		return
+	}
+
+	inUse, err := emailInUse(db, req.NewEmail, id)
+	if err != nil {
+		http.Error(w, "Failed to check email", http.StatusInternalServerError)
+		return
+	}
+	if inUse {
+		http.Error(w, "Email already exists", http.StatusConflict)
+		return
+	}
+
+	token, tokenHash, err := newVerificationToken()
+	if err != nil {
+		http.Error(w, "Failed to create verification token", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Only the most recent request can be confirmed.
+	if _, err := tx.Exec("DELETE FROM email_changes WHERE user_id = $1", id); err != nil {
+		http.Error(w, "Failed to request email change", http.StatusInternalServerError)
+		return
+	}
+
+	expiresAt := time.Now().Add(emailChangeTTL)
+	_, err = tx.Exec(
+		"INSERT INTO email_changes (user_id, new_email, token_hash, expires_at) VALUES ($1, $2, $3, $4)",
+		id, req.NewEmail, tokenHash, expiresAt,
+	)
+	if err != nil {
+		http.Error(w, "Failed to request email change", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	link := appBaseURL() + "/api/users/email/confirm?token=" + url.QueryEscape(token)
+	message := "Confirm your new email address " + req.NewEmail + " by visiting " + link
+	if err := sendEmail(uint(id), "email_verification", "Confirm your new email address", message); err != nil {
+		log.Printf("Failed to send email verification for user %d: %v", id, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":       "Verification email sent",
+		"pending_email": req.NewEmail,
+		"expires_at":    expiresAt,
+	})
+}
+
+// confirmEmailChange switches the user's email to the pending address behind
+// a verification token.
+func confirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var newEmail string
+	err = tx.QueryRow(
+		`DELETE FROM email_changes WHERE token_hash = $1 AND expires_at > CURRENT_TIMESTAMP
+		 RETURNING user_id, new_email`,
+		hashToken(token),
+	).Scan(&userID, &newEmail)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to confirm email change", http.StatusInternalServerError)
+		return
+	}
+
+	// The address may have been claimed by someone else since the request.
+	taken, err := emailInUse(tx, newEmail, userID)
+	if err != nil {
+		http.Error(w, "Failed to confirm email change", http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		http.Error(w, "Email already exists", http.StatusConflict)
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE users SET email = $1 WHERE id = $2", newEmail, userID); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			http.Error(w, "Email already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to confirm email change", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email updated successfully", "email": newEmail})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// stubNotifications points the service at a notification stub and returns
+// the messages it was asked to send.
+func stubNotifications(t *testing.T) *[]string {
+	t.Helper()
+	var messages []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		messages = append(messages, body.Message)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("NOTIFICATION_SERVICE_URL", srv.URL)
+	return &messages
+}
+
+// seedEmailChange scripts user 3, ann@example.com with password "hunter22".
+func seedEmailChange(t *testing.T, taken bool) *dbtest.DB {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := useDB(t)
+	fake.On(`SELECT email, password FROM users WHERE id = \$1`).Rows([]string{"email", "password"}, []interface{}{"ann@example.com", string(hash)})
+	fake.On(`SELECT EXISTS \(SELECT 1 FROM users WHERE LOWER\(email\) = LOWER\(\$1\)`).Rows([]string{"exists"}, []interface{}{taken})
+	fake.On(`DELETE FROM email_changes WHERE user_id`)
+	fake.On(`INSERT INTO email_changes`)
+	return fake
+}
+
+func changeEmail(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := authorize(t, httptest.NewRequest("PUT", "/users/3/email", strings.NewReader(body)), 3, "")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+func TestEmailChangeRejectsDuplicate(t *testing.T) {
+	messages := stubNotifications(t)
+	fake := seedEmailChange(t, true)
+
+	w := changeEmail(t, `{"new_email": "Bob@Example.com", "current_password": "hunter22"}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", w.Code)
+	}
+	if exists := fake.Matching(`SELECT EXISTS`); len(exists) != 1 || exists[0].Args[0] != "bob@example.com" {
+		t.Errorf("uniqueness check = %+v, want it on the normalized address", exists)
+	}
+	if len(fake.Matching(`email_changes`)) != 0 || len(*messages) != 0 {
+		t.Error("a taken address was recorded or mailed")
+	}
+}
+
+func TestEmailChangeRequiresCurrentPassword(t *testing.T) {
+	stubNotifications(t)
+	fake := seedEmailChange(t, false)
+
+	if w := changeEmail(t, `{"new_email": "new@example.com", "current_password": "wrong"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+	if len(fake.Matching(`email_changes`)) != 0 {
+		t.Error("change recorded without the current password")
+	}
+}
+
+func TestEmailChangeStaysPendingUntilConfirmed(t *testing.T) {
+	messages := stubNotifications(t)
+	fake := seedEmailChange(t, false)
+
+	w := changeEmail(t, `{"new_email": "new@example.com", "current_password": "hunter22"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if n := len(fake.Matching(`UPDATE users`)); n != 0 {
+		t.Error("the stored email changed before confirmation")
+	}
+
+	// The link goes to the new address's owner; only its hash is stored.
+	if len(*messages) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(*messages))
+	}
+	i := strings.Index((*messages)[0], "token=")
+	if i < 0 {
+		t.Fatalf("message %q has no link", (*messages)[0])
+	}
+	token, _ := url.QueryUnescape((*messages)[0][i+len("token="):])
+	inserts := fake.Matching(`INSERT INTO email_changes`)
+	if len(inserts) != 1 || inserts[0].Args[1] != "new@example.com" || inserts[0].Args[2] != hashToken(token) {
+		t.Errorf("inserts = %+v, want the new address with the token's hash", inserts)
+	}
+}
+
+func TestConfirmEmailChangeSwitchesEmail(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`DELETE FROM email_changes WHERE token_hash = \$1 AND expires_at > CURRENT_TIMESTAMP`).Rows([]string{"user_id", "new_email"}, []interface{}{3, "new@example.com"})
+	fake.On(`SELECT EXISTS`).Rows([]string{"exists"}, []interface{}{false})
+	fake.On(`UPDATE users SET email = \$1 WHERE id = \$2`)
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/users/email/confirm?token=abc", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if deletes := fake.Matching(`DELETE FROM email_changes`); deletes[0].Args[0] != hashToken("abc") {
+		t.Errorf("looked up token by %v, want its hash", deletes[0].Args[0])
+	}
+	updates := fake.Matching(`UPDATE users`)
+	if len(updates) != 1 || updates[0].Args[0] != "new@example.com" || updates[0].Args[1] != int64(3) {
+		t.Errorf("updates = %+v", updates)
+	}
+	if calls := fake.Calls(); calls[len(calls)-1].Query != "COMMIT" {
+		t.Error("email change was not committed")
+	}
+}
+
+func TestConfirmEmailChangeRejectsUnknownToken(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`DELETE FROM email_changes WHERE token_hash`)
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/users/email/confirm?token=expired", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+	if len(fake.Matching(`UPDATE users`)) != 0 {
+		t.Error("email switched on an unknown token")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+// maxEmailLength is the longest address SMTP allows in a forward path.
+const maxEmailLength = 254
+
+// isValidEmail accepts only a bare address such as "joyce@example.com";
+// display names and angle brackets are rejected.
+func isValidEmail(email string) bool {
+	if email == "" || len(email) > maxEmailLength {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return false
+	}
+	return addr.Name == "" && addr.Address == email
+}
+
+// normalizeEmail is the form emails are stored and compared in. Addresses are
+// matched case-insensitively so "Joyce@X.com" and "joyce@x.com" are the same
+// account.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func writeInvalidEmail(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": "invalid email"})
+}
+
+func writeEmailInUse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]string{"error": "email already in use"})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestIsValidEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"joyce@example.com", true},
+		{"first.last+tag@sub.example.co.uk", true},
+		{"", false},
+		{"not-an-email", false},
+		{"joyce@", false},
+		{"@example.com", false},
+		{"Joyce <joyce@x.com>", false},
+		{"<joyce@x.com>", false},
+		{"joyce@x.com, bob@x.com", false},
+		{strings.Repeat("a", 250) + "@x.com", false},
+	}
+	for _, tt := range tests {
+		if got := isValidEmail(tt.email); got != tt.want {
+			t.Errorf("isValidEmail(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterAndLoginRejectInvalidEmail(t *testing.T) {
+	fake := useDB(t)
+
+	for name, handler := range map[string]http.HandlerFunc{"register": register, "login": login} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/"+name, strings.NewReader(`{"email": "Joyce <joyce@x.com>", "password": "correct horse 9"}`)))
+
+		var body map[string]string
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusBadRequest || body["error"] != "invalid email" {
+			t.Errorf("%s: status = %d, body = %v; want 400 invalid email", name, w.Code, body)
+		}
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("an invalid email reached the database")
+	}
+}
+
+func TestRegisterStoresNormalizedEmail(t *testing.T) {
+	useBcryptCost(t, bcrypt.MinCost)
+	fake := useDB(t)
+	fake.On(`SELECT EXISTS`).Rows([]string{"exists"}, []interface{}{false})
+	fake.On(`INSERT INTO users`).Rows([]string{"id", "role", "created_at"}, []interface{}{3, "customer", time.Now()})
+
+	w := httptest.NewRecorder()
+	register(w, httptest.NewRequest("POST", "/register", strings.NewReader(`{"email": " Joyce@X.com ", "password": "correct horse 9"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	for _, c := range fake.Matching(`SELECT EXISTS|INSERT INTO users`) {
+		if c.Args[0] != "joyce@x.com" {
+			t.Errorf("%s: email = %v, want joyce@x.com", c.Query, c.Args[0])
+		}
+	}
+}
+
+func TestRegisterRejectsEmailInAnotherCase(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`SELECT EXISTS \(SELECT 1 FROM users WHERE LOWER\(email\) = LOWER\(\$1\)`).Rows([]string{"exists"}, []interface{}{true})
+
+	w := httptest.NewRecorder()
+	register(w, httptest.NewRequest("POST", "/register", strings.NewReader(`{"email": "JOYCE@x.com", "password": "correct horse 9"}`)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", w.Code)
+	}
+	if len(fake.Matching(`INSERT`)) != 0 {
+		t.Error("a second account was created for the same address")
+	}
+}
+
+func TestLoginMatchesEmailInAnyCase(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	fake := useDB(t)
+	fake.On(`login_attempts WHERE email`)
+	fake.On(`DELETE FROM login_attempts`)
+	fake.On(`UPDATE users SET last_login`).Rows([]string{"last_login"}, []interface{}{time.Now()})
+	// The stored row predates normalization and is mixed-case.
+	fake.On(`FROM users WHERE LOWER\(email\) = \$1`).Rows(
+		[]string{"id", "email", "password", "first_name", "last_name", "phone", "address", "role", "created_at"},
+		[]interface{}{3, "Joyce@X.com", string(hash), "", "", "", "", "customer", time.Now()})
+
+	w := httptest.NewRecorder()
+	login(w, httptest.NewRequest("POST", "/login", strings.NewReader(`{"email": "  JOYCE@x.COM", "password": "hunter22"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if q := fake.Matching(`FROM users WHERE LOWER`); q[0].Args[0] != "joyce@x.com" {
+		t.Errorf("looked up %v, want joyce@x.com", q[0].Args[0])
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// seedLogin scripts ann@example.com, user 3, with password "hunter22".
+func seedLogin(t *testing.T) *dbtest.DB {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := useDB(t)
+	fake.On(`FROM login_attempts WHERE email = \$1 AND locked_until`)
+	fake.On(`DELETE FROM login_attempts`)
+	fake.On(`SELECT id, email, password, .* FROM users WHERE LOWER\(email\) = \$1`).Rows(
+		[]string{"id", "email", "password", "first_name", "last_name", "phone", "address", "role", "created_at"},
+		[]interface{}{3, "ann@example.com", string(hash), "Ann", "", "", "", "customer", time.Now()})
+	return fake
+}
+
+func loginAsAnn(t *testing.T) AuthResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	login(w, httptest.NewRequest("POST", "/login", strings.NewReader(`{"email": "ann@example.com", "password": "hunter22"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp AuthResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestLoginAdvancesLastLogin(t *testing.T) {
+	first := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	fake := seedLogin(t)
+	fake.On(`UPDATE users SET last_login = CURRENT_TIMESTAMP WHERE id = \$1`).Rows([]string{"last_login"}, []interface{}{first}).Times(1)
+	fake.On(`UPDATE users SET last_login = CURRENT_TIMESTAMP WHERE id = \$1`).Rows([]string{"last_login"}, []interface{}{first.Add(time.Hour)})
+
+	a, b := loginAsAnn(t), loginAsAnn(t)
+	if a.User.LastLogin == nil || b.User.LastLogin == nil || !b.User.LastLogin.After(*a.User.LastLogin) {
+		t.Errorf("last_login went from %v to %v, want it to advance", a.User.LastLogin, b.User.LastLogin)
+	}
+	for _, c := range fake.Matching(`last_login`) {
+		if c.Args[0] != int64(3) {
+			t.Errorf("last_login recorded for user %v", c.Args[0])
+		}
+	}
+}
+
+func TestLoginSurvivesLastLoginFailure(t *testing.T) {
+	fake := seedLogin(t)
+	fake.On(`UPDATE users SET last_login`).Err(errors.New("disk full"))
+
+	if resp := loginAsAnn(t); resp.Token == "" || resp.User.LastLogin != nil {
+		t.Errorf("response = %+v, want a token and no last_login", resp)
+	}
+}
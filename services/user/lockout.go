@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// After loginMaxAttempts consecutive failures an email is locked out of login
+// for loginLockoutMinutes. Lockouts are stored in the database so they hold
+// across restarts and replicas.
+var (
+	loginMaxAttempts    = envInt("LOGIN_MAX_ATTEMPTS", 5)
+	loginLockoutMinutes = envInt("LOGIN_LOCKOUT_MINUTES", 15)
+)
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Invalid %s %q, using %d", key, v, fallback)
+	}
+	return fallback
+}
+
+// loginLockedFor returns the seconds left on an email's lockout, or 0 when it
+// may attempt to log in.
+func loginLockedFor(email string) (int, error) {
+	var seconds int
+	err := db.QueryRow(
+		`SELECT CEIL(EXTRACT(EPOCH FROM (locked_until - CURRENT_TIMESTAMP)))::int
+		 FROM login_attempts WHERE email = $1 AND locked_until > CURRENT_TIMESTAMP`,
+		strings.ToLower(email),
+	).Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seconds, err
+}
+
+// recordFailedLogin counts a failed attempt and starts a lockout once the
+// limit is reached. A lockout that has run out starts the count again.
+func recordFailedLogin(email string) error {
+	email = strings.ToLower(email)
+
+	var failures int
+	err := db.QueryRow(
+		`INSERT INTO login_attempts (email, failures, updated_at) VALUES ($1, 1, CURRENT_TIMESTAMP)
+		 ON CONFLICT (email) DO UPDATE SET
+			failures = CASE WHEN login_attempts.locked_until IS NOT NULL THEN 1 ELSE login_attempts.failures + 1 END,
+			locked_until = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		 RETURNING failures`,
+		email,
+	).Scan(&failures)
+	if err != nil {
+		return err
+	}
+
+	if failures < loginMaxAttempts {
+		return nil
+	}
+
+	log.Printf("Login locked for %s after %d failed attempts", email, failures)
+	_, err = db.Exec(
+		"UPDATE login_attempts SET locked_until = CURRENT_TIMESTAMP + make_interval(mins => $2) WHERE email = $1",
+		email, loginLockoutMinutes,
+	)
+	return err
+}
+
+func resetFailedLogins(email string) {
+	if _, err := db.Exec("DELETE FROM login_attempts WHERE email = $1", strings.ToLower(email)); err != nil {
+		log.Printf("Failed to reset login attempts for %s: %v", email, err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func attemptLogin(password string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	body := `{"email": "Ann@example.com", "password": "` + password + `"}`
+	login(w, httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+	return w
+}
+
+func TestLoginLocksOutAfterFailures(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	fake := useDB(t)
+	fake.On(`FROM login_attempts WHERE email = \$1 AND locked_until`)
+	fake.On(`SELECT id, email, password, .* FROM users`).Rows(
+		[]string{"id", "email", "password", "first_name", "last_name", "phone", "address", "role", "created_at"},
+		[]interface{}{3, "ann@example.com", string(hash), "", "", "", "", "customer", time.Now()})
+	for failures := 1; failures <= 5; failures++ {
+		fake.On(`INSERT INTO login_attempts`).Rows([]string{"failures"}, []interface{}{failures}).Times(1)
+	}
+	fake.On(`UPDATE login_attempts SET locked_until`)
+
+	for i := 1; i <= 5; i++ {
+		if w := attemptLogin("wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want 401", i, w.Code)
+		}
+		locks := fake.Matching(`SET locked_until`)
+		if i < 5 && len(locks) != 0 {
+			t.Fatalf("locked after %d failures", i)
+		}
+		if i == 5 && (len(locks) != 1 || locks[0].Args[0] != "ann@example.com" || locks[0].Args[1] != int64(15)) {
+			t.Errorf("lock = %+v, want ann@example.com for 15 minutes", locks)
+		}
+	}
+}
+
+func TestLockedOutLoginAnswers429(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`FROM login_attempts WHERE email = \$1 AND locked_until > CURRENT_TIMESTAMP`).Rows([]string{"seconds"}, []interface{}{840})
+
+	// Even the right password is refused until the lockout ends.
+	w := attemptLogin("hunter22")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "840" {
+		t.Errorf("status = %d, Retry-After = %q; want 429 and 840", w.Code, w.Header().Get("Retry-After"))
+	}
+	if len(fake.Matching(`FROM users`)) != 0 {
+		t.Error("a locked-out login checked the password")
+	}
+}
+
+func TestLoginAfterLockoutExpiresResetsCount(t *testing.T) {
+	// Once locked_until has passed the lockout query finds nothing.
+	fake := seedLogin(t)
+	fake.On(`UPDATE users SET last_login`).Rows([]string{"last_login"}, []interface{}{time.Now()})
+
+	loginAsAnn(t)
+	if resets := fake.Matching(`DELETE FROM login_attempts WHERE email = \$1`); len(resets) != 1 || resets[0].Args[0] != "ann@example.com" {
+		t.Errorf("resets = %+v, want the count cleared", resets)
+	}
+}
+
+func TestLockoutThresholdsFromEnv(t *testing.T) {
+	t.Setenv("TEST_LOGIN_LIMIT", "3")
+	if n := envInt("TEST_LOGIN_LIMIT", 5); n != 3 {
+		t.Errorf("envInt = %d, want 3", n)
+	}
+	t.Setenv("TEST_LOGIN_LIMIT", "-1")
+	if n := envInt("TEST_LOGIN_LIMIT", 5); n != 5 {
+		t.Errorf("envInt = %d, want the fallback for a bad value", n)
+	}
+}
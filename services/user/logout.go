@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+// revokedTokenCleanupInterval is how often expired revocations are purged;
+// once a token has expired it is rejected anyway.
+const revokedTokenCleanupInterval = time.Hour
+
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// isTokenRevoked is installed as the middleware's revocation checker in this
+// service, which owns the revoked_tokens table.
+func isTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)", jti).Scan(&revoked)
+	return revoked, err
+}
+
+// logout revokes the token used to make the request.
+func logout(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if claims.ID == "" {
+		http.Error(w, "Token cannot be revoked", http.StatusBadRequest)
+		return
+	}
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	_, err := db.Exec(
+		`INSERT INTO revoked_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3)
+		 ON CONFLICT (jti) DO NOTHING`,
+		claims.ID, claims.UserID, expiresAt,
+	)
+	if err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
+}
+
+// getTokenRevocation lets other services check a jti against the revocation
+// list. It is not routed through the gateway.
+func getTokenRevocation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	revoked, err := isTokenRevoked(vars["jti"])
+	if err != nil {
+		http.Error(w, "Failed to check token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"revoked": revoked})
+}
+
+// startRevokedTokenCleanup periodically deletes revocations in conn for tokens
+// that have since expired. Call the returned func to stop.
+func startRevokedTokenCleanup(conn *sql.DB, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				res, err := conn.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP")
+				if err != nil {
+					log.Printf("Revoked token cleanup failed: %v", err)
+					continue
+				}
+				if n, _ := res.RowsAffected(); n > 0 {
+					log.Printf("Revoked token cleanup removed %d expired entries", n)
+				}
+			}
+		}
+	}()
+	return cancel
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+// useRevocationList makes AuthMiddleware consult this service's revoked_tokens
+// table, as main does.
+func useRevocationList(t *testing.T) {
+	t.Helper()
+	middleware.SetRevocationChecker(isTokenRevoked)
+	t.Cleanup(func() { middleware.SetRevocationChecker(nil) })
+}
+
+func TestLoggedOutTokenIsRejected(t *testing.T) {
+	useRevocationList(t)
+	fake := useDB(t)
+	revoked := `SELECT EXISTS \(SELECT 1 FROM revoked_tokens WHERE jti = \$1\)`
+	fake.On(revoked).Rows([]string{"exists"}, []interface{}{false}).Times(1)
+	fake.On(revoked).Rows([]string{"exists"}, []interface{}{true})
+	fake.On(`INSERT INTO revoked_tokens`)
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	claims := &middleware.Claims{UserID: 5, RegisteredClaims: jwt.RegisteredClaims{
+		ID:        "jti-5",
+		Issuer:    middleware.GetJWTIssuer(),
+		Audience:  jwt.ClaimStrings{middleware.GetJWTAudience()},
+		ExpiresAt: jwt.NewNumericDate(expires),
+	}}
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, withClaims(t, httptest.NewRequest("POST", "/logout", nil), claims))
+	if w.Code != http.StatusOK {
+		t.Fatalf("logout: status = %d: %s", w.Code, w.Body)
+	}
+	inserts := fake.Matching(`INSERT INTO revoked_tokens`)
+	if len(inserts) != 1 || inserts[0].Args[0] != "jti-5" || !inserts[0].Args[2].(time.Time).Equal(expires) {
+		t.Errorf("revocation = %+v, want jti-5 until the token's expiry", inserts)
+	}
+
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, withClaims(t, httptest.NewRequest("GET", "/users/5", nil), claims))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("next call: status = %d, want 401", w.Code)
+	}
+}
+
+func TestLogoutNeedsTokenID(t *testing.T) {
+	useDB(t)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, authorize(t, httptest.NewRequest("POST", "/logout", nil), 5, ""))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestRevokedTokenCleanupDeletesExpired(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`DELETE FROM revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP`).Affected(0)
+
+	stop := startRevokedTokenCleanup(fake.DB, 5*time.Millisecond)
+	defer stop()
+	deadline := time.Now().Add(time.Second)
+	for len(fake.Matching(`DELETE FROM revoked_tokens`)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("cleanup never ran")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
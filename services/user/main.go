@@ -6,71 +6,156 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/database"
 	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/server"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID        uint      `json:"id"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password,omitempty"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Phone     string    `json:"phone"`
-	Address   string    `json:"address"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        uint       `json:"id"`
+	Email     string     `json:"email"`
+	Password  string     `json:"password,omitempty"`
+	FirstName string     `json:"first_name"`
+	LastName  string     `json:"last_name"`
+	Phone     string     `json:"phone"`
+	Address   string     `json:"address"`
+	Role      string     `json:"role"`
+	LastLogin *time.Time `json:"last_login,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
 }
 
 type AuthResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ExpiresIn int64     `json:"expires_in"`
+	User      User      `json:"user"`
+}
+
+func newAuthResponse(token string, expiresAt time.Time, user User) AuthResponse {
+	return AuthResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+		ExpiresIn: int64(time.Until(expiresAt).Seconds()),
+		User:      user,
+	}
 }
 
 var db *sql.DB
+var dbOnce database.Once
 
 func main() {
 	var err error
-	db, err = database.NewConnection("users_db")
+	err = dbOnce.Open(&db, func() (*sql.DB, error) { return database.NewConnection("users_db") })
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
 
+	stopKeepalive := database.StartKeepalive(db, database.KeepaliveInterval())
+	defer stopKeepalive()
+
 	initDB()
 
+	middleware.SetRevocationChecker(isTokenRevoked)
+	stopCleanup := startRevokedTokenCleanup(db, revokedTokenCleanupInterval)
+	defer stopCleanup()
+
+	log.Println("User service running on :8001")
+	if err := server.Run(":8001", middleware.TrimTrailingSlash(newRouter())); err != nil {
+		log.Fatal("Server error:", err)
+	}
+}
+
+// newRouter registers the user service's routes.
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.Use(middleware.CORS)
+	r.Use(middleware.LogRequestBodies)
 
 	r.HandleFunc("/health", healthCheck).Methods("GET")
 	r.HandleFunc("/register", register).Methods("POST")
 	r.HandleFunc("/login", login).Methods("POST")
-	r.HandleFunc("/users/{id}", getUser).Methods("GET")
-	r.HandleFunc("/users/{id}", updateUser).Methods("PUT")
-
-	log.Println("User service running on :8001")
-	log.Fatal(http.ListenAndServe(":8001", r))
+	r.HandleFunc("/refresh", refreshToken).Methods("POST")
+	r.Handle("/logout", middleware.AuthMiddleware(http.HandlerFunc(logout))).Methods("POST")
+	r.HandleFunc("/password-reset/request", requestPasswordReset).Methods("POST")
+	r.HandleFunc("/password-reset/confirm", confirmPasswordReset).Methods("POST")
+	r.HandleFunc("/tokens/revoked/{jti}", getTokenRevocation).Methods("GET")
+	r.HandleFunc("/users/email/confirm", confirmEmailChange).Methods("GET")
+	r.Handle("/users", middleware.AuthMiddleware(middleware.RequireRole("admin")(http.HandlerFunc(listUsers)))).Methods("GET")
+	r.Handle("/users/{id}", middleware.AuthMiddleware(http.HandlerFunc(getUser))).Methods("GET")
+	r.Handle("/users/{id}", middleware.AuthMiddleware(http.HandlerFunc(updateUser))).Methods("PUT")
+	r.Handle("/users/{id}", middleware.AuthMiddleware(http.HandlerFunc(deleteUser))).Methods("DELETE")
+	r.Handle("/users/{id}/password", middleware.AuthMiddleware(http.HandlerFunc(changePassword))).Methods("PUT")
+	r.Handle("/users/{id}/email", middleware.AuthMiddleware(http.HandlerFunc(requestEmailChange))).Methods("PUT")
+	r.Handle("/users/{id}/impersonate", middleware.AuthMiddleware(middleware.RequireRole("admin")(http.HandlerFunc(impersonateUser)))).Methods("POST")
+
+	return r
 }
 
 func initDB() {
-	query := `
-	CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		email VARCHAR(255) UNIQUE NOT NULL,
-		password VARCHAR(255) NOT NULL,
-		first_name VARCHAR(100),
-		last_name VARCHAR(100),
-		phone VARCHAR(20),
-		address TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	)`
-	_, err := db.Exec(query)
-	if err != nil {
-		log.Fatal("Failed to create users table:", err)
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS users (
+			id SERIAL PRIMARY KEY,
+			email VARCHAR(255) UNIQUE NOT NULL,
+			password VARCHAR(255) NOT NULL,
+			first_name VARCHAR(100),
+			last_name VARCHAR(100),
+			phone VARCHAR(20),
+			address TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'customer'`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login TIMESTAMP`,
+		`CREATE TABLE IF NOT EXISTS login_attempts (
+			email VARCHAR(255) PRIMARY KEY,
+			failures INT NOT NULL DEFAULT 0,
+			locked_until TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS revoked_tokens (
+			jti VARCHAR(64) PRIMARY KEY,
+			user_id INT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at)`,
+		`CREATE TABLE IF NOT EXISTS password_resets (
+			id SERIAL PRIMARY KEY,
+			user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			token_hash CHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS email_changes (
+			id SERIAL PRIMARY KEY,
+			user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			new_email VARCHAR(255) NOT NULL,
+			token_hash CHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+	}
+
+	for _, query := range queries {
+		_, err := db.Exec(query)
+		if err != nil {
+			log.Fatal("Failed to create users table:", err)
+		}
+	}
+
+	// Emails are stored lowercased, but rows from before that may differ
+	// only in case. The index is skipped rather than failing startup until
+	// those duplicates are merged by hand.
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (LOWER(email))`); err != nil {
+		log.Printf("Failed to create case-insensitive email index: %v", err)
 	}
 }
 
@@ -85,7 +170,30 @@ func register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	user.Email = normalizeEmail(user.Email)
+	if !isValidEmail(user.Email) {
+		writeInvalidEmail(w)
+		return
+	}
+
+	if err := validatePassword(user.Password); err != nil {
+		writePasswordError(w, err)
+		return
+	}
+
+	// Older rows may be stored mixed-case, which the unique column alone
+	// would not catch.
+	inUse, err := emailInUse(db, user.Email, 0)
+	if err != nil {
+		http.Error(w, "Failed to check email", http.StatusInternalServerError)
+		return
+	}
+	if inUse {
+		http.Error(w, "Email already exists", http.StatusConflict)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcryptCost)
 	if err != nil {
 		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
@@ -93,16 +201,16 @@ func register(w http.ResponseWriter, r *http.Request) {
 
 	err = db.QueryRow(
 		`INSERT INTO users (email, password, first_name, last_name, phone, address)
-		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, role, created_at`,
 		user.Email, string(hashedPassword), user.FirstName, user.LastName, user.Phone, user.Address,
-	).Scan(&user.ID, &user.CreatedAt)
+	).Scan(&user.ID, &user.Role, &user.CreatedAt)
 
 	if err != nil {
 		http.Error(w, "Email already exists", http.StatusConflict)
 		return
 	}
 
-	token, err := generateToken(user.ID, user.Email)
+	token, expiresAt, err := generateToken(user.ID, user.Email, user.Role)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
@@ -110,7 +218,7 @@ func register(w http.ResponseWriter, r *http.Request) {
 
 	user.Password = ""
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(AuthResponse{Token: token, User: user})
+	json.NewEncoder(w).Encode(newAuthResponse(token, expiresAt, user))
 }
 
 func login(w http.ResponseWriter, r *http.Request) {
@@ -124,32 +232,61 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	credentials.Email = normalizeEmail(credentials.Email)
+	if !isValidEmail(credentials.Email) {
+		writeInvalidEmail(w)
+		return
+	}
+
+	lockedFor, err := loginLockedFor(credentials.Email)
+	if err != nil {
+		http.Error(w, "Failed to check login attempts", http.StatusInternalServerError)
+		return
+	}
+	if lockedFor > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(lockedFor))
+		http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
 	var user User
 	var hashedPassword string
-	err := db.QueryRow(
-		`SELECT id, email, password, first_name, last_name, phone, address, created_at
-		 FROM users WHERE email = $1`,
+	err = db.QueryRow(
+		`SELECT id, email, password, COALESCE(first_name, ''), COALESCE(last_name, ''), COALESCE(phone, ''), COALESCE(address, ''), role, created_at
+		 FROM users WHERE LOWER(email) = $1`,
 		credentials.Email,
-	).Scan(&user.ID, &user.Email, &hashedPassword, &user.FirstName, &user.LastName, &user.Phone, &user.Address, &user.CreatedAt)
+	).Scan(&user.ID, &user.Email, &hashedPassword, &user.FirstName, &user.LastName, &user.Phone, &user.Address, &user.Role, &user.CreatedAt)
 
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(credentials.Password))
+	}
 	if err != nil {
+		// Unknown emails count too, so lockouts don't reveal which exist.
+		if err := recordFailedLogin(credentials.Email); err != nil {
+			log.Printf("Failed to record login attempt: %v", err)
+		}
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	resetFailedLogins(credentials.Email)
 
-	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(credentials.Password)); err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-		return
+	// Recording the login is for auditing only and must not block it.
+	var lastLogin time.Time
+	err = db.QueryRow("UPDATE users SET last_login = CURRENT_TIMESTAMP WHERE id = $1 RETURNING last_login", user.ID).Scan(&lastLogin)
+	if err != nil {
+		log.Printf("Failed to record last login for user %d: %v", user.ID, err)
+	} else {
+		user.LastLogin = &lastLogin
 	}
 
-	token, err := generateToken(user.ID, user.Email)
+	token, expiresAt, err := generateToken(user.ID, user.Email, user.Role)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(AuthResponse{Token: token, User: user})
+	json.NewEncoder(w).Encode(newAuthResponse(token, expiresAt, user))
 }
 
 func getUser(w http.ResponseWriter, r *http.Request) {
@@ -160,22 +297,91 @@ func getUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(uint(id)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	var user User
+	var lastLogin sql.NullTime
 	err = db.QueryRow(
-		`SELECT id, email, first_name, last_name, phone, address, created_at
+		`SELECT id, email, COALESCE(first_name, ''), COALESCE(last_name, ''), COALESCE(phone, ''), COALESCE(address, ''), role, last_login, created_at
 		 FROM users WHERE id = $1`,
 		id,
-	).Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Phone, &user.Address, &user.CreatedAt)
+	).Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Phone, &user.Address, &user.Role, &lastLogin, &user.CreatedAt)
 
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	if lastLogin.Valid {
+		user.LastLogin = &lastLogin.Time
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
 
+// listUsers pages through registered users for support staff, newest first,
+// optionally filtered by a case-insensitive match on email or name.
+func listUsers(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	where := ""
+	args := []interface{}{}
+	if search := strings.TrimSpace(r.URL.Query().Get("search")); search != "" {
+		where = " WHERE email ILIKE $1 OR first_name ILIKE $1 OR last_name ILIKE $1"
+		args = append(args, "%"+search+"%")
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users"+where, args...).Scan(&total); err != nil {
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+
+	args = append(args, limit, offset)
+	rows, err := db.Query(
+		`SELECT id, email, COALESCE(first_name, ''), COALESCE(last_name, ''), COALESCE(phone, ''), COALESCE(address, ''), role, last_login, created_at FROM users`+where+
+			` ORDER BY created_at DESC, id DESC LIMIT $`+strconv.Itoa(len(args)-1)+` OFFSET $`+strconv.Itoa(len(args)),
+		args...,
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		var lastLogin sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Email, &u.FirstName, &u.LastName, &u.Phone, &u.Address, &u.Role, &lastLogin, &u.CreatedAt); err != nil {
+			continue
+		}
+		if lastLogin.Valid {
+			u.LastLogin = &lastLogin.Time
+		}
+		users = append(users, u)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users":  users,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
 func updateUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
@@ -184,36 +390,169 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(uint(id)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	_, err = db.Exec(
-		`UPDATE users SET first_name = $1, last_name = $2, phone = $3, address = $4 WHERE id = $5`,
-		user.FirstName, user.LastName, user.Phone, user.Address, id,
-	)
+	// An empty email leaves the current one in place. Only the account owner
+	// may change it, since it is what they log in with.
+	user.Email = normalizeEmail(user.Email)
+	if user.Email != "" {
+		if claims.UserID != uint(id) || claims.ImpersonatedBy != 0 {
+			http.Error(w, "Only the account owner can change its email", http.StatusForbidden)
+			return
+		}
+		if !isValidEmail(user.Email) {
+			writeInvalidEmail(w)
+			return
+		}
+		inUse, err := emailInUse(db, user.Email, id)
+		if err != nil {
+			http.Error(w, "Failed to check email", http.StatusInternalServerError)
+			return
+		}
+		if inUse {
+			writeEmailInUse(w)
+			return
+		}
+	}
 
+	err = db.QueryRow(
+		`UPDATE users SET first_name = $1, last_name = $2, phone = $3, address = $4, email = COALESCE(NULLIF($5, ''), email)
+		 WHERE id = $6 RETURNING email, role`,
+		user.FirstName, user.LastName, user.Phone, user.Address, user.Email, id,
+	).Scan(&user.Email, &user.Role)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			writeEmailInUse(w)
+			return
+		}
 		http.Error(w, "Failed to update user", http.StatusInternalServerError)
 		return
 	}
 
+	response := map[string]interface{}{"message": "User updated successfully"}
+
+	// The caller's token still names the old address, so hand back one that
+	// matches. The original auth time carries over to keep the session cap.
+	if user.Email != claims.Email && claims.UserID == uint(id) {
+		authTime := time.Now()
+		if claims.AuthTime != nil {
+			authTime = claims.AuthTime.Time
+		}
+		token, expiresAt, err := issueToken(uint(id), user.Email, user.Role, authTime)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		response["email"] = user.Email
+		response["token"] = token
+		response["expires_at"] = expiresAt
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// impersonationTTL is kept short so a support session cannot outlive the
+// ticket it was opened for.
+const impersonationTTL = 15 * time.Minute
+
+func impersonateUser(w http.ResponseWriter, r *http.Request) {
+	admin, _ := middleware.ClaimsFromContext(r.Context())
+	if admin.ImpersonatedBy != 0 {
+		http.Error(w, "Cannot impersonate from an impersonation token", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	err = db.QueryRow(
+		`SELECT id, email, COALESCE(first_name, ''), COALESCE(last_name, ''), COALESCE(phone, ''), COALESCE(address, ''), role, created_at
+		 FROM users WHERE id = $1`,
+		id,
+	).Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Phone, &user.Address, &user.Role, &user.CreatedAt)
+
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	claims := &middleware.Claims{
+		UserID:         user.ID,
+		Email:          user.Email,
+		Role:           user.Role,
+		ImpersonatedBy: admin.UserID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(impersonationTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token, err := signClaims(claims)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	expiresAt := claims.ExpiresAt.Time
+	log.Printf("AUDIT: admin %d (%s) started impersonating user %d", admin.UserID, admin.Email, user.ID)
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "User updated successfully"})
+	json.NewEncoder(w).Encode(newAuthResponse(token, expiresAt, user))
+}
+
+// generateToken mints a token for a fresh login and returns it with its expiry.
+func generateToken(userID uint, email, role string) (string, time.Time, error) {
+	return issueToken(userID, email, role, time.Now())
 }
 
-func generateToken(userID uint, email string) (string, error) {
+// issueToken mints a 24h access token for a session that started at authTime.
+func issueToken(userID uint, email, role string, authTime time.Time) (string, time.Time, error) {
 	claims := &middleware.Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:   userID,
+		Email:    email,
+		Role:     role,
+		AuthTime: jwt.NewNumericDate(authTime),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
+	token, err := signClaims(claims)
+	return token, claims.ExpiresAt.Time, err
+}
+
+func signClaims(claims *middleware.Claims) (string, error) {
+	if claims.ID == "" {
+		id, err := newTokenID()
+		if err != nil {
+			return "", err
+		}
+		claims.ID = id
+	}
+	claims.Issuer = middleware.GetJWTIssuer()
+	claims.Audience = jwt.ClaimStrings{middleware.GetJWTAudience()}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(middleware.GetJWTSecret())
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+	"github.com/lib/pq"
+)
+
+// authorize signs a token for userID (with role, if any) and sets it on r.
+func authorize(t *testing.T, r *http.Request, userID uint, role string) *http.Request {
+	t.Helper()
+	claims := &middleware.Claims{
+		UserID: userID,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    middleware.GetJWTIssuer(),
+			Audience:  jwt.ClaimStrings{middleware.GetJWTAudience()},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	return withClaims(t, r, claims)
+}
+
+// withClaims signs claims and sets the token on r.
+func withClaims(t *testing.T, r *http.Request, claims *middleware.Claims) *http.Request {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(middleware.GetJWTSecret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// useDB points the service at a scripted database for the rest of the test.
+func useDB(t *testing.T) *dbtest.DB {
+	t.Helper()
+	fake := dbtest.New(t)
+	prev := db
+	db = fake.DB
+	t.Cleanup(func() { db = prev })
+	return fake
+}
+
+// parseToken validates a token the service issued and returns its claims.
+func parseToken(t *testing.T, token string) *middleware.Claims {
+	t.Helper()
+	claims := &middleware.Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return middleware.GetJWTSecret(), nil
+	}, jwt.WithIssuer(middleware.GetJWTIssuer()), jwt.WithAudience(middleware.GetJWTAudience()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+var userColumns = []string{"id", "email", "first_name", "last_name", "phone", "address", "role", "created_at"}
+
+func TestImpersonateRequiresAdmin(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/users/5/impersonate", nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, authorize(t, r, 7, "customer"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
+
+func TestImpersonateIssuesScopedToken(t *testing.T) {
+	useDB(t).On(`FROM users WHERE id = \$1`).Rows(userColumns,
+		[]interface{}{5, "ann@example.com", "Ann", "Lee", "", "", "customer", time.Now()})
+
+	r := httptest.NewRequest(http.MethodPost, "/users/5/impersonate", nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, authorize(t, r, 1, "admin"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var resp AuthResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	claims := parseToken(t, resp.Token)
+	if claims.UserID != 5 || claims.Email != "ann@example.com" || claims.Role != "customer" {
+		t.Errorf("token subject = %d %s %s, want user 5 as a customer", claims.UserID, claims.Email, claims.Role)
+	}
+	if claims.ImpersonatedBy != 1 {
+		t.Errorf("impersonated_by = %d, want 1", claims.ImpersonatedBy)
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl > impersonationTTL || ttl < impersonationTTL-time.Minute {
+		t.Errorf("token lives %v, want about %v", ttl, impersonationTTL)
+	}
+}
+
+func TestImpersonationTokenCannotImpersonate(t *testing.T) {
+	claims := &middleware.Claims{
+		UserID:         9,
+		Role:           "admin",
+		ImpersonatedBy: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    middleware.GetJWTIssuer(),
+			Audience:  jwt.ClaimStrings{middleware.GetJWTAudience()},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	r := withClaims(t, httptest.NewRequest(http.MethodPost, "/users/5/impersonate", nil), claims)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
+
+func getUserAs(t *testing.T, userID uint, role string) (*httptest.ResponseRecorder, *dbtest.DB) {
+	t.Helper()
+	fake := useDB(t)
+	fake.On(`role, last_login, created_at FROM users WHERE id = \$1`).Rows(
+		[]string{"id", "email", "first_name", "last_name", "phone", "address", "role", "last_login", "created_at"},
+		[]interface{}{5, "ann@example.com", "Ann", "Lee", "555-0100", "1 Main St", "customer", nil, time.Now()})
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, authorize(t, httptest.NewRequest("GET", "/users/5", nil), userID, role))
+	return w, fake
+}
+
+func TestGetUserOwnProfile(t *testing.T) {
+	for _, tt := range []struct {
+		userID uint
+		role   string
+	}{{5, ""}, {1, "admin"}} {
+		w, _ := getUserAs(t, tt.userID, tt.role)
+		var user User
+		json.NewDecoder(w.Body).Decode(&user)
+		if w.Code != http.StatusOK || user.ID != 5 || user.Email != "ann@example.com" {
+			t.Errorf("user %d (%q): status = %d, user = %+v", tt.userID, tt.role, w.Code, user)
+		}
+	}
+}
+
+func TestGetUserRejectsOtherUsers(t *testing.T) {
+	w, fake := getUserAs(t, 6, "")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("another user's profile was loaded")
+	}
+}
+
+func listUsersAs(t *testing.T, query, role string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := authorize(t, httptest.NewRequest("GET", "/users?"+query, nil), 1, role)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+var listColumns = []string{"id", "email", "first_name", "last_name", "phone", "address", "role", "last_login", "created_at"}
+
+func TestListUsersPaginationBounds(t *testing.T) {
+	tests := []struct {
+		query         string
+		limit, offset int64
+	}{
+		{"", 20, 0},
+		{"limit=5&offset=10", 5, 10},
+		{"limit=500&offset=-3", 20, 0},
+		{"limit=abc", 20, 0},
+	}
+	for _, tt := range tests {
+		fake := useDB(t)
+		fake.On(`^SELECT COUNT\(\*\) FROM users$`).Rows([]string{"count"}, []interface{}{42})
+		fake.On(`FROM users ORDER BY created_at DESC, id DESC LIMIT \$1 OFFSET \$2`).Rows(listColumns,
+			[]interface{}{3, "ann@example.com", "Ann", "Lee", "", "", "customer", nil, time.Now()})
+
+		w := listUsersAs(t, tt.query, "admin")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d: %s", tt.query, w.Code, w.Body)
+		}
+		if strings.Contains(w.Body.String(), "password") {
+			t.Errorf("%q: response exposes passwords: %s", tt.query, w.Body)
+		}
+		var page struct {
+			Users  []User `json:"users"`
+			Total  int    `json:"total"`
+			Limit  int64  `json:"limit"`
+			Offset int64  `json:"offset"`
+		}
+		json.NewDecoder(w.Body).Decode(&page)
+		if len(page.Users) != 1 || page.Total != 42 || page.Limit != tt.limit || page.Offset != tt.offset {
+			t.Errorf("%q: page = %+v", tt.query, page)
+		}
+		if q := fake.Matching(`LIMIT`); q[0].Args[0] != tt.limit || q[0].Args[1] != tt.offset {
+			t.Errorf("%q: LIMIT/OFFSET args = %v", tt.query, q[0].Args)
+		}
+	}
+}
+
+func TestListUsersSearch(t *testing.T) {
+	fake := useDB(t)
+	match := `WHERE email ILIKE \$1 OR first_name ILIKE \$1 OR last_name ILIKE \$1`
+	fake.On(`^SELECT COUNT\(\*\) FROM users `+match+`$`).Rows([]string{"count"}, []interface{}{1})
+	fake.On(match + ` ORDER BY created_at DESC, id DESC LIMIT \$2 OFFSET \$3`).Rows(listColumns)
+
+	if w := listUsersAs(t, "search=+Lee+", "admin"); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	for _, c := range fake.Calls() {
+		if c.Args[0] != "%Lee%" {
+			t.Errorf("%s: search arg = %v, want %%Lee%%", c.Query, c.Args[0])
+		}
+	}
+}
+
+func TestListUsersRequiresAdmin(t *testing.T) {
+	fake := useDB(t)
+	if w := listUsersAs(t, "", "customer"); w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("a customer's listing reached the database")
+	}
+}
+
+func TestAuthResponseExpiryMatchesToken(t *testing.T) {
+	fake := seedLogin(t)
+	fake.On(`UPDATE users SET last_login`).Rows([]string{"last_login"}, []interface{}{time.Now()})
+
+	resp := loginAsAnn(t)
+	claims := parseToken(t, resp.Token)
+	if !resp.ExpiresAt.Equal(claims.ExpiresAt.Time) {
+		t.Errorf("expires_at = %v, token exp = %v", resp.ExpiresAt, claims.ExpiresAt.Time)
+	}
+	if want := int64(time.Until(claims.ExpiresAt.Time).Seconds()); resp.ExpiresIn < want-1 || resp.ExpiresIn > want {
+		t.Errorf("expires_in = %d, want about %d", resp.ExpiresIn, want)
+	}
+}
+
+func updateUserAs(t *testing.T, userID uint, role, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := authorize(t, httptest.NewRequest("PUT", "/users/3", strings.NewReader(body)), userID, role)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+func TestUpdateUserChangesEmail(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`SELECT EXISTS`).Rows([]string{"exists"}, []interface{}{false})
+	fake.On(`UPDATE users SET .* RETURNING email, role`).Rows([]string{"email", "role"}, []interface{}{"new@example.com", "customer"})
+
+	w := updateUserAs(t, 3, "", `{"email": " New@Example.com", "first_name": "Ann"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if q := fake.Matching(`UPDATE users`); q[0].Args[4] != "new@example.com" {
+		t.Errorf("stored email = %v, want it normalized", q[0].Args[4])
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if claims := parseToken(t, resp.Token); claims.UserID != 3 || claims.Email != "new@example.com" {
+		t.Errorf("fresh token claims = %+v, want the new email", claims)
+	}
+}
+
+func TestUpdateUserEmailConflicts(t *testing.T) {
+	tests := map[string]func(*dbtest.DB){
+		"found by check": func(fake *dbtest.DB) {
+			fake.On(`SELECT EXISTS`).Rows([]string{"exists"}, []interface{}{true})
+		},
+		"unique violation": func(fake *dbtest.DB) {
+			fake.On(`SELECT EXISTS`).Rows([]string{"exists"}, []interface{}{false})
+			fake.On(`UPDATE users`).Err(&pq.Error{Code: "23505"})
+		},
+	}
+	for name, script := range tests {
+		t.Run(name, func(t *testing.T) {
+			script(useDB(t))
+
+			w := updateUserAs(t, 3, "", `{"email": "bob@example.com"}`)
+			if w.Code != http.StatusConflict || strings.TrimSpace(w.Body.String()) != `{"error":"email already in use"}` {
+				t.Errorf("status = %d, body = %s; want 409 email already in use", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestUpdateUserEmailOnlyByOwner(t *testing.T) {
+	fake := useDB(t)
+	for _, role := range []string{"", "admin"} {
+		if w := updateUserAs(t, 4, role, `{"email": "bob@example.com"}`); w.Code != http.StatusForbidden {
+			t.Errorf("user 4 (role %q): status = %d, want 403", role, w.Code)
+		}
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("another user's email was changed")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 8
+
+// bcryptCost is the work factor for new password hashes. BCRYPT_COST lets CI
+// use a low cost for speed and production raise it as hardware improves.
+var bcryptCost = bcryptCostFromEnv()
+
+func bcryptCostFromEnv() int {
+	v := os.Getenv("BCRYPT_COST")
+	if v == "" {
+		return bcrypt.DefaultCost
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < bcrypt.MinCost || n > bcrypt.MaxCost {
+		log.Printf("Invalid BCRYPT_COST %q, using %d", v, bcrypt.DefaultCost)
+		return bcrypt.DefaultCost
+	}
+	return n
+}
+
+// commonPasswords is a small denylist of passwords that satisfy the length
+// and character rules but are among the first ones guessed.
+var commonPasswords = map[string]bool{
+	"password1":   true,
+	"password12":  true,
+	"password123": true,
+	"passw0rd":    true,
+	"12345678a":   true,
+	"abc12345":    true,
+	"abcd1234":    true,
+	"qwerty123":   true,
+	"qwerty12":    true,
+	"letmein1":    true,
+	"welcome1":    true,
+	"iloveyou1":   true,
+	"admin123":    true,
+	"changeme1":   true,
+	"trustno1":    true,
+	"1q2w3e4r":    true,
+	"1qaz2wsx":    true,
+	"zaq12wsx":    true,
+	"monkey123":   true,
+	"dragon123":   true,
+}
+
+// PasswordError lists every rule a rejected password failed.
+type PasswordError struct {
+	Reasons []string
+}
+
+func (e *PasswordError) Error() string {
+	return "password too weak: " + strings.Join(e.Reasons, "; ")
+}
+
+// validatePassword enforces the minimum password policy for new passwords.
+func validatePassword(pw string) error {
+	var reasons []string
+
+	if len(pw) < minPasswordLength {
+		reasons = append(reasons, "must be at least 8 characters")
+	}
+
+	var hasLetter, hasDigit bool
+	for _, c := range pw {
+		switch {
+		case unicode.IsLetter(c):
+			hasLetter = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		}
+	}
+	if !hasLetter {
+		reasons = append(reasons, "must contain a letter")
+	}
+	if !hasDigit {
+		reasons = append(reasons, "must contain a digit")
+	}
+
+	if commonPasswords[strings.ToLower(pw)] {
+		reasons = append(reasons, "is too common")
+	}
+
+	if len(reasons) > 0 {
+		return &PasswordError{Reasons: reasons}
+	}
+	return nil
+}
+
+// writePasswordError reports a validatePassword failure as a 400 listing the
+// failed rules.
+func writePasswordError(w http.ResponseWriter, err error) {
+	var pwErr *PasswordError
+	if !errors.As(err, &pwErr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   "password too weak",
+		"reasons": pwErr.Reasons,
+	})
+}
+
+// changePassword replaces the caller's password after re-checking the current
+// one. Only the account owner may do this; admins cannot.
+func changePassword(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if claims.UserID != uint(id) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var hashedPassword string
+	err = db.QueryRow("SELECT password FROM users WHERE id = $1", id).Scan(&hashedPassword)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.CurrentPassword)); err != nil {
+		http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+		return
+	}
+
+	if err := validatePassword(req.NewPassword); err != nil {
+		writePasswordError(w, err)
+		return
+	}
+
+	newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcryptCost)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.Exec("UPDATE users SET password = $1 WHERE id = $2", string(newHash), id); err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password updated successfully"})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// useBcryptCost sets the hashing cost for the rest of the test.
+func useBcryptCost(t *testing.T, cost int) {
+	t.Helper()
+	prev := bcryptCost
+	bcryptCost = cost
+	t.Cleanup(func() { bcryptCost = prev })
+}
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		password string
+		reasons  []string
+	}{
+		{"", []string{"must be at least 8 characters", "must contain a letter", "must contain a digit"}},
+		{"ab1", []string{"must be at least 8 characters"}},
+		{"abcdefghij", []string{"must contain a digit"}},
+		{"1234567890", []string{"must contain a letter"}},
+		{"Password123", []string{"is too common"}},
+		{"correct horse 9", nil},
+		{"tr0ub4dor&3", nil},
+	}
+	for _, tt := range tests {
+		err := validatePassword(tt.password)
+		if tt.reasons == nil {
+			if err != nil {
+				t.Errorf("validatePassword(%q) = %v, want nil", tt.password, err)
+			}
+			continue
+		}
+		var pwErr *PasswordError
+		if !errors.As(err, &pwErr) || !reflect.DeepEqual(pwErr.Reasons, tt.reasons) {
+			t.Errorf("validatePassword(%q) = %v, want reasons %q", tt.password, err, tt.reasons)
+		}
+	}
+}
+
+func TestRegisterRejectsWeakPassword(t *testing.T) {
+	fake := useDB(t)
+
+	w := httptest.NewRecorder()
+	register(w, httptest.NewRequest("POST", "/register", strings.NewReader(`{"email": "ann@example.com", "password": "short"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+	var body struct {
+		Error   string   `json:"error"`
+		Reasons []string `json:"reasons"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != "password too weak" || len(body.Reasons) != 2 {
+		t.Errorf("body = %+v", body)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("a weak password reached the database")
+	}
+}
+
+func TestRegisterAcceptsStrongPassword(t *testing.T) {
+	useBcryptCost(t, bcrypt.MinCost)
+	fake := useDB(t)
+	fake.On(`SELECT EXISTS`).Rows([]string{"exists"}, []interface{}{false})
+	fake.On(`INSERT INTO users`).Rows([]string{"id", "role", "created_at"}, []interface{}{3, "customer", time.Now()})
+
+	w := httptest.NewRecorder()
+	register(w, httptest.NewRequest("POST", "/register", strings.NewReader(`{"email": "ann@example.com", "password": "correct horse 9"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	inserts := fake.Matching(`INSERT INTO users`)
+	if len(inserts) != 1 {
+		t.Fatalf("inserts = %+v", inserts)
+	}
+	hash, _ := inserts[0].Args[1].(string)
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte("correct horse 9")) != nil {
+		t.Errorf("stored password %q is not a bcrypt hash of the password", hash)
+	}
+}
+
+// seedPassword scripts user 3 with password "hunter22".
+func seedPassword(t *testing.T) *dbtest.DB {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := useDB(t)
+	fake.On(`SELECT password FROM users WHERE id = \$1`).Rows([]string{"password"}, []interface{}{string(hash)})
+	fake.On(`UPDATE users SET password = \$1 WHERE id = \$2`)
+	return fake
+}
+
+func changePasswordAs(t *testing.T, userID uint, role, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := authorize(t, httptest.NewRequest("PUT", "/users/3/password", strings.NewReader(body)), userID, role)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+func TestChangePassword(t *testing.T) {
+	useBcryptCost(t, bcrypt.MinCost)
+	fake := seedPassword(t)
+
+	w := changePasswordAs(t, 3, "", `{"current_password": "hunter22", "new_password": "correct horse 9"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	updates := fake.Matching(`UPDATE users SET password`)
+	if len(updates) != 1 || updates[0].Args[1] != int64(3) {
+		t.Fatalf("updates = %+v", updates)
+	}
+	hash, _ := updates[0].Args[0].(string)
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte("correct horse 9")) != nil {
+		t.Error("stored hash does not match the new password")
+	}
+}
+
+func TestChangePasswordRejections(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID uint
+		role   string
+		body   string
+		want   int
+	}{
+		{"wrong current password", 3, "", `{"current_password": "nope", "new_password": "correct horse 9"}`, http.StatusUnauthorized},
+		{"weak new password", 3, "", `{"current_password": "hunter22", "new_password": "short"}`, http.StatusBadRequest},
+		{"another user", 4, "", `{"current_password": "hunter22", "new_password": "correct horse 9"}`, http.StatusForbidden},
+		{"admin", 1, "admin", `{"current_password": "hunter22", "new_password": "correct horse 9"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := seedPassword(t)
+			if w := changePasswordAs(t, tt.userID, tt.role, tt.body); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if len(fake.Matching(`UPDATE users`)) != 0 {
+				t.Error("password was changed")
+			}
+		})
+	}
+}
+
+func TestBcryptCostFromEnv(t *testing.T) {
+	tests := map[string]int{
+		"":   bcrypt.DefaultCost,
+		"5":  5,
+		"31": 31,
+		"3":  bcrypt.DefaultCost,
+		"32": bcrypt.DefaultCost,
+		"x":  bcrypt.DefaultCost,
+	}
+	for v, want := range tests {
+		t.Setenv("BCRYPT_COST", v)
+		if got := bcryptCostFromEnv(); got != want {
+			t.Errorf("BCRYPT_COST=%q: cost = %d, want %d", v, got, want)
+		}
+	}
+}
+
+func TestRegisterHashesWithConfiguredCost(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "5")
+	useBcryptCost(t, bcryptCostFromEnv())
+	fake := useDB(t)
+	fake.On(`SELECT EXISTS`).Rows([]string{"exists"}, []interface{}{false})
+	fake.On(`INSERT INTO users`).Rows([]string{"id", "role", "created_at"}, []interface{}{3, "customer", time.Now()})
+
+	w := httptest.NewRecorder()
+	register(w, httptest.NewRequest("POST", "/register", strings.NewReader(`{"email": "ann@example.com", "password": "correct horse 9"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	hash, _ := fake.Matching(`INSERT INTO users`)[0].Args[1].(string)
+	if cost, err := bcrypt.Cost([]byte(hash)); err != nil || cost != 5 {
+		t.Errorf("bcrypt.Cost = %d (%v), want 5", cost, err)
+	}
+}
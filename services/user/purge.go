@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+// userDataPurges are the per-service endpoints that remove everything stored
+// for a user. Payments are kept: they are financial records we must retain.
+var userDataPurges = []struct {
+	service string
+	url     func(userID int) string
+}{
+	{"cart", func(id int) string {
+		return fmt.Sprintf("%s/cart/%d", serviceURL("CART_SERVICE_URL", "http://cart-service:8003"), id)
+	}},
+	{"order", func(id int) string {
+		return fmt.Sprintf("%s/orders/user/%d", serviceURL("ORDER_SERVICE_URL", "http://order-service:8004"), id)
+	}},
+	{"notification", func(id int) string {
+		return fmt.Sprintf("%s/notifications/user/%d", serviceURL("NOTIFICATION_SERVICE_URL", "http://notification-service:8006"), id)
+	}},
+}
+
+// purgeUserData asks each service to delete the user's rows, forwarding the
+// caller's token. Failures are logged for follow-up rather than undoing the
+// account deletion.
+func purgeUserData(userID int, authorization string) {
+	for _, purge := range userDataPurges {
+		req, err := http.NewRequest("DELETE", purge.url(userID), nil)
+		if err != nil {
+			log.Printf("User %d purge: %s: %v", userID, purge.service, err)
+			continue
+		}
+		req.Header.Set("Authorization", authorization)
+
+		resp, err := serviceClient.Do(req)
+		if err != nil {
+			log.Printf("User %d purge: %s service unreachable: %v", userID, purge.service, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("User %d purge: %s service returned %d", userID, purge.service, resp.StatusCode)
+		}
+	}
+}
+
+// deleteUser removes an account and then purges the user's data from the
+// other services.
+func deleteUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	if !claims.CanAccessUser(uint(id)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("AUDIT: user %d deleted by user %d", id, claims.UserID)
+	purgeUserData(id, r.Header.Get("Authorization"))
+
+	w.WriteHeader(http.StatusNoContent)
+}
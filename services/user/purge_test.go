@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// stubPurges points the cart, order and notification services at one stub
+// and returns the purge requests it received.
+func stubPurges(t *testing.T) *[]string {
+	t.Helper()
+	var purges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			t.Errorf("%s %s sent without the caller's token", r.Method, r.URL.Path)
+		}
+		purges = append(purges, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	for _, key := range []string{"CART_SERVICE_URL", "ORDER_SERVICE_URL", "NOTIFICATION_SERVICE_URL"} {
+		t.Setenv(key, srv.URL)
+	}
+	return &purges
+}
+
+func deleteUserAs(t *testing.T, target string, userID uint, role string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := authorize(t, httptest.NewRequest("DELETE", target, nil), userID, role)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+func TestDeleteUserPurgesServices(t *testing.T) {
+	purges := stubPurges(t)
+	fake := useDB(t)
+	fake.On(`DELETE FROM users WHERE id = \$1`)
+
+	if w := deleteUserAs(t, "/users/3", 3, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if deletes := fake.Matching(`DELETE FROM users`); len(deletes) != 1 || deletes[0].Args[0] != int64(3) {
+		t.Errorf("deletes = %+v, want user 3", deletes)
+	}
+	want := []string{"DELETE /cart/3", "DELETE /orders/user/3", "DELETE /notifications/user/3"}
+	if !reflect.DeepEqual(*purges, want) {
+		t.Errorf("purges = %v, want %v", *purges, want)
+	}
+}
+
+func TestDeleteUserAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID uint
+		role   string
+		want   int
+	}{
+		{"admin", 1, "admin", http.StatusNoContent},
+		{"other customer", 4, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			purges := stubPurges(t)
+			fake := useDB(t)
+			fake.On(`DELETE FROM users`)
+
+			if w := deleteUserAs(t, "/users/3", tt.userID, tt.role); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusForbidden && (len(fake.Calls()) != 0 || len(*purges) != 0) {
+				t.Error("a forbidden delete touched the database or other services")
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("DELETE", "/users/3", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("no token: status = %d, want 401", w.Code)
+	}
+}
+
+func TestDeleteUserNotFound(t *testing.T) {
+	purges := stubPurges(t)
+	useDB(t).On(`DELETE FROM users`).Affected(0)
+
+	if w := deleteUserAs(t, "/users/3", 3, ""); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+	if len(*purges) != 0 {
+		t.Errorf("purged %v for a missing user", *purges)
+	}
+}
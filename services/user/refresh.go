@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+const (
+	// refreshGrace lets a client that was offline when its token expired
+	// still renew it without logging in again.
+	refreshGrace = time.Hour
+	// maxSessionAge caps how long a login can be kept alive by refreshing.
+	maxSessionAge = 7 * 24 * time.Hour
+)
+
+// refreshToken exchanges a valid, or recently expired, token for a new one
+// with a fresh expiry. The user is reloaded so role changes take effect.
+func refreshToken(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Authorization header required", http.StatusUnauthorized)
+		return
+	}
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+
+	claims := &middleware.Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return middleware.GetJWTSecret(), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(middleware.GetJWTIssuer()),
+		jwt.WithAudience(middleware.GetJWTAudience()),
+		jwt.WithLeeway(refreshGrace),
+	)
+	if err != nil || !token.Valid {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	if revoked, err := isTokenRevoked(claims.ID); err != nil || revoked {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	if claims.ImpersonatedBy != 0 {
+		http.Error(w, "Impersonation tokens cannot be refreshed", http.StatusForbidden)
+		return
+	}
+
+	if claims.IssuedAt == nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	sessionStart := claims.IssuedAt.Time
+	if claims.AuthTime != nil {
+		sessionStart = claims.AuthTime.Time
+	}
+	if time.Since(claims.IssuedAt.Time) > maxSessionAge || time.Since(sessionStart) > maxSessionAge {
+		http.Error(w, "Session expired, please log in again", http.StatusUnauthorized)
+		return
+	}
+
+	var user User
+	err = db.QueryRow(
+		`SELECT id, email, COALESCE(first_name, ''), COALESCE(last_name, ''), COALESCE(phone, ''), COALESCE(address, ''), role, created_at
+		 FROM users WHERE id = $1`,
+		claims.UserID,
+	).Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Phone, &user.Address, &user.Role, &user.CreatedAt)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	newToken, expiresAt, err := issueToken(user.ID, user.Email, user.Role, sessionStart)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newAuthResponse(newToken, expiresAt, user))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+// sessionClaims are user 5's claims for a token issued at issued that
+// expires at expires.
+func sessionClaims(issued, expires time.Time) *middleware.Claims {
+	return &middleware.Claims{
+		UserID: 5,
+		Email:  "ann@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti-5",
+			Issuer:    middleware.GetJWTIssuer(),
+			Audience:  jwt.ClaimStrings{middleware.GetJWTAudience()},
+			IssuedAt:  jwt.NewNumericDate(issued),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}
+}
+
+func seedRefresh(t *testing.T) {
+	t.Helper()
+	fake := useDB(t)
+	fake.On(`FROM revoked_tokens`).Rows([]string{"exists"}, []interface{}{false})
+	fake.On(`FROM users WHERE id = \$1`).Rows(userColumns,
+		[]interface{}{5, "ann@example.com", "Ann", "Lee", "", "", "customer", time.Now()})
+}
+
+func refresh(t *testing.T, r *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+func TestRefreshIssuesNewToken(t *testing.T) {
+	seedRefresh(t)
+	issued := time.Now().Add(-2 * time.Hour)
+	r := withClaims(t, httptest.NewRequest("POST", "/refresh", nil), sessionClaims(issued, time.Now().Add(time.Hour)))
+
+	w := refresh(t, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp AuthResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	claims := parseToken(t, resp.Token)
+	if claims.UserID != 5 || claims.Role != "customer" || resp.User.Email != "ann@example.com" {
+		t.Errorf("new token for %d as %q, user %+v", claims.UserID, claims.Role, resp.User)
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl < 23*time.Hour {
+		t.Errorf("new token lives %v, want a fresh expiry", ttl)
+	}
+	// The session start carries over so refreshing can't extend it forever.
+	if claims.AuthTime == nil || claims.AuthTime.Unix() != issued.Unix() {
+		t.Errorf("auth_time = %v, want %v", claims.AuthTime, issued)
+	}
+}
+
+func TestRefreshAcceptsRecentlyExpiredToken(t *testing.T) {
+	seedRefresh(t)
+	r := withClaims(t, httptest.NewRequest("POST", "/refresh", nil),
+		sessionClaims(time.Now().Add(-25*time.Hour), time.Now().Add(-refreshGrace/2)))
+
+	if w := refresh(t, r); w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200 within the grace window", w.Code)
+	}
+}
+
+func TestRefreshRejects(t *testing.T) {
+	now := time.Now()
+	tampered := withClaims(t, httptest.NewRequest("POST", "/refresh", nil), sessionClaims(now, now.Add(time.Hour)))
+	parts := strings.Split(strings.TrimPrefix(tampered.Header.Get("Authorization"), "Bearer "), ".")
+	forged, _ := json.Marshal(map[string]interface{}{"user_id": 1, "role": "admin", "iss": middleware.GetJWTIssuer(), "aud": middleware.GetJWTAudience(), "exp": now.Add(time.Hour).Unix(), "iat": now.Unix()})
+	parts[1] = base64.RawURLEncoding.EncodeToString(forged)
+	tampered.Header.Set("Authorization", "Bearer "+strings.Join(parts, "."))
+
+	tests := map[string]*http.Request{
+		"tampered": tampered,
+		"expired past the grace window": withClaims(t, httptest.NewRequest("POST", "/refresh", nil),
+			sessionClaims(now.Add(-26*time.Hour), now.Add(-2*refreshGrace))),
+		"issued over 7 days ago": withClaims(t, httptest.NewRequest("POST", "/refresh", nil),
+			sessionClaims(now.Add(-maxSessionAge-time.Hour), now.Add(time.Hour))),
+		"no token": httptest.NewRequest("POST", "/refresh", nil),
+	}
+	for name, r := range tests {
+		seedRefresh(t)
+		if w := refresh(t, r); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", name, w.Code)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const passwordResetTTL = time.Hour
+
+// requestPasswordReset emails a single-use reset link. It answers 200 whether
+// or not the address has an account so it can't be used to enumerate users.
+func requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Email = normalizeEmail(req.Email)
+	if !isValidEmail(req.Email) {
+		writeInvalidEmail(w)
+		return
+	}
+
+	if err := sendPasswordReset(req.Email); err != nil {
+		log.Printf("Password reset request failed: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account exists for that email, a reset link has been sent",
+	})
+}
+
+func sendPasswordReset(email string) error {
+	var userID uint
+	err := db.QueryRow("SELECT id FROM users WHERE LOWER(email) = LOWER($1)", email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, tokenHash, err := newVerificationToken()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		userID, tokenHash, time.Now().Add(passwordResetTTL),
+	)
+	if err != nil {
+		return err
+	}
+
+	link := appBaseURL() + "/reset-password?token=" + url.QueryEscape(token)
+	message := "Reset your password by visiting " + link + ". The link expires in 1 hour. If you didn't ask for this, you can ignore this email."
+	return sendEmail(userID, "password_reset", "Reset your password", message)
+}
+
+// confirmPasswordReset sets a new password using a token from
+// requestPasswordReset. Each token works once, and using one invalidates any
+// other outstanding tokens for the user.
+func confirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := validatePassword(req.NewPassword); err != nil {
+		writePasswordError(w, err)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var resetID, userID int
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	err = tx.QueryRow(
+		"SELECT id, user_id, expires_at, used_at FROM password_resets WHERE token_hash = $1 FOR UPDATE",
+		hashToken(req.Token),
+	).Scan(&resetID, &userID, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid reset token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+	if usedAt.Valid {
+		http.Error(w, "Reset token has already been used", http.StatusBadRequest)
+		return
+	}
+	if time.Now().After(expiresAt) {
+		http.Error(w, "Reset token has expired", http.StatusBadRequest)
+		return
+	}
+
+	newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcryptCost)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE users SET password = $1 WHERE id = $2", string(newHash), userID); err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+	_, err = tx.Exec(
+		"UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND used_at IS NULL",
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset"})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func postReset(t *testing.T, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+	return w
+}
+
+func TestPasswordResetRequestEmailsToken(t *testing.T) {
+	messages := stubNotifications(t)
+	fake := useDB(t)
+	fake.On(`SELECT id FROM users WHERE LOWER\(email\)`).Rows([]string{"id"}, []interface{}{3})
+	fake.On(`INSERT INTO password_resets`)
+
+	if w := postReset(t, "/password-reset/request", `{"email": "Ann@Example.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if len(*messages) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(*messages))
+	}
+	i := strings.Index((*messages)[0], "token=")
+	if i < 0 {
+		t.Fatalf("message %q has no link", (*messages)[0])
+	}
+	token := (*messages)[0][i+len("token=") : i+len("token=")+64]
+
+	inserts := fake.Matching(`INSERT INTO password_resets`)
+	if len(inserts) != 1 || inserts[0].Args[1] != hashToken(token) {
+		t.Fatalf("inserts = %+v, want the emailed token's hash", inserts)
+	}
+	expires, _ := inserts[0].Args[2].(time.Time)
+	if d := time.Until(expires); d < 59*time.Minute || d > passwordResetTTL {
+		t.Errorf("token expires in %v, want an hour", d)
+	}
+}
+
+func TestPasswordResetRequestHidesUnknownEmail(t *testing.T) {
+	messages := stubNotifications(t)
+	fake := useDB(t)
+	fake.On(`SELECT id FROM users WHERE LOWER\(email\)`)
+
+	w := postReset(t, "/password-reset/request", `{"email": "nobody@example.com"}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want the same 200 as a known address", w.Code)
+	}
+	if len(fake.Matching(`INSERT`)) != 0 || len(*messages) != 0 {
+		t.Error("a reset was issued for an unknown address")
+	}
+}
+
+// seedReset scripts a reset token for user 3 that expires at expires and was
+// used at used, if used is not nil.
+func seedReset(t *testing.T, expires time.Time, used interface{}) *dbtest.DB {
+	t.Helper()
+	useBcryptCost(t, bcrypt.MinCost)
+	fake := useDB(t)
+	fake.On(`SELECT id, user_id, expires_at, used_at FROM password_resets WHERE token_hash = \$1`).
+		Rows([]string{"id", "user_id", "expires_at", "used_at"}, []interface{}{9, 3, expires, used})
+	fake.On(`UPDATE users SET password`)
+	fake.On(`UPDATE password_resets SET used_at`)
+	return fake
+}
+
+func TestPasswordResetConfirm(t *testing.T) {
+	fake := seedReset(t, time.Now().Add(time.Hour), nil)
+
+	w := postReset(t, "/password-reset/confirm", `{"token": "abc", "new_password": "Correct-Horse-9"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if lookups := fake.Matching(`FROM password_resets`); lookups[0].Args[0] != hashToken("abc") {
+		t.Errorf("token looked up as %v, want its hash", lookups[0].Args[0])
+	}
+	updates := fake.Matching(`UPDATE users SET password`)
+	if len(updates) != 1 || bcrypt.CompareHashAndPassword([]byte(updates[0].Args[0].(string)), []byte("Correct-Horse-9")) != nil {
+		t.Errorf("password updates = %+v, want the new password's hash", updates)
+	}
+	// Every outstanding token for the user is spent, not just this one.
+	if spent := fake.Matching(`UPDATE password_resets SET used_at`); len(spent) != 1 || spent[0].Args[0] != int64(3) {
+		t.Errorf("used_at updates = %+v, want all of user 3's", spent)
+	}
+	if calls := fake.Calls(); calls[len(calls)-1].Query != "COMMIT" {
+		t.Error("reset not committed")
+	}
+}
+
+func TestPasswordResetConfirmRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		expires time.Time
+		used    interface{}
+		body    string
+	}{
+		{"expired", time.Now().Add(-time.Minute), nil, `{"token": "abc", "new_password": "Correct-Horse-9"}`},
+		{"reused", time.Now().Add(time.Hour), time.Now().Add(-time.Minute), `{"token": "abc", "new_password": "Correct-Horse-9"}`},
+		{"weak password", time.Now().Add(time.Hour), nil, `{"token": "abc", "new_password": "short"}`},
+		{"no token", time.Now().Add(time.Hour), nil, `{"new_password": "Correct-Horse-9"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := seedReset(t, tt.expires, tt.used)
+
+			if w := postReset(t, "/password-reset/confirm", tt.body); w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", w.Code)
+			}
+			if len(fake.Matching(`^UPDATE`)) != 0 {
+				t.Error("the password was changed")
+			}
+		})
+	}
+}
+
+func TestPasswordResetConfirmRejectsUnknownToken(t *testing.T) {
+	fake := useDB(t)
+	fake.On(`FROM password_resets WHERE token_hash`)
+
+	if w := postReset(t, "/password-reset/confirm", `{"token": "nope", "new_password": "Correct-Horse-9"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,293 @@
+// Package dbtest is a scripted database/sql driver for handler tests. A test
+// registers the statements it expects with On, giving each the rows or result
+// it should produce; any other statement fails. Every statement run, along
+// with transaction boundaries, is recorded so a test can assert on what was
+// written.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Call is one statement the code under test ran. Transaction boundaries are
+// recorded as the calls "BEGIN", "COMMIT" and "ROLLBACK".
+type Call struct {
+	Query string
+	Args  []driver.Value
+}
+
+// DB is a scripted database. Use DB.DB wherever the code under test expects
+// a *sql.DB.
+type DB struct {
+	DB *sql.DB
+
+	mu    sync.Mutex
+	rules []*Rule
+	calls []Call
+}
+
+// Rule answers the statements whose whitespace-collapsed text matches its
+// pattern.
+type Rule struct {
+	pattern  *regexp.Regexp
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+	times    int
+	used     int
+}
+
+var (
+	registerOnce sync.Once
+	registryMu   sync.Mutex
+	registry     = map[string]*DB{}
+	nextID       int
+)
+
+// New opens a scripted database that is closed when the test ends.
+func New(t testing.TB) *DB {
+	t.Helper()
+	registerOnce.Do(func() { sql.Register("dbtest", scriptedDriver{}) })
+
+	d := &DB{}
+	registryMu.Lock()
+	nextID++
+	name := fmt.Sprintf("dbtest-%d", nextID)
+	registry[name] = d
+	registryMu.Unlock()
+
+	conn, err := sql.Open("dbtest", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.DB = conn
+	t.Cleanup(func() {
+		conn.Close()
+		registryMu.Lock()
+		delete(registry, name)
+		registryMu.Unlock()
+	})
+	return d
+}
+
+// On registers a rule for statements matching pattern, a regular expression
+// applied to the statement with runs of whitespace collapsed to one space.
+// Rules are tried in the order they were registered. With no rows, result or
+// error set, a matching statement succeeds and affects one row.
+func (d *DB) On(pattern string) *Rule {
+	r := &Rule{pattern: regexp.MustCompile(pattern), affected: 1}
+	d.mu.Lock()
+	d.rules = append(d.rules, r)
+	d.mu.Unlock()
+	return r
+}
+
+// Rows makes the rule return a result set with the given columns.
+func (r *Rule) Rows(columns []string, rows ...[]interface{}) *Rule {
+	r.columns = columns
+	r.rows = make([][]driver.Value, len(rows))
+	for i, row := range rows {
+		r.rows[i] = make([]driver.Value, len(row))
+		for j, v := range row {
+			r.rows[i][j] = toValue(v)
+		}
+	}
+	return r
+}
+
+// Affected sets how many rows an Exec matching the rule reports.
+func (r *Rule) Affected(n int64) *Rule {
+	r.affected = n
+	return r
+}
+
+// Err makes matching statements fail with err.
+func (r *Rule) Err(err error) *Rule {
+	r.err = err
+	return r
+}
+
+// Times limits the rule to its first n matches, after which later rules are
+// tried instead.
+func (r *Rule) Times(n int) *Rule {
+	r.times = n
+	return r
+}
+
+// Calls returns every statement run so far, in order.
+func (d *DB) Calls() []Call {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Call(nil), d.calls...)
+}
+
+// Matching returns the statements run so far that match pattern.
+func (d *DB) Matching(pattern string) []Call {
+	re := regexp.MustCompile(pattern)
+	var out []Call
+	for _, c := range d.Calls() {
+		if re.MatchString(c.Query) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+var spaces = regexp.MustCompile(`\s+`)
+
+func (d *DB) run(query string, args []driver.Value) (*Rule, error) {
+	query = strings.TrimSpace(spaces.ReplaceAllString(query, " "))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls = append(d.calls, Call{Query: query, Args: args})
+	for _, r := range d.rules {
+		if r.times > 0 && r.used >= r.times {
+			continue
+		}
+		if r.pattern.MatchString(query) {
+			r.used++
+			return r, r.err
+		}
+	}
+	return nil, fmt.Errorf("dbtest: unexpected statement: %s", query)
+}
+
+func (d *DB) record(query string) {
+	d.mu.Lock()
+	d.calls = append(d.calls, Call{Query: query})
+	d.mu.Unlock()
+}
+
+// toValue converts the convenient Go types a test writes rows with into
+// driver values.
+func toValue(v interface{}) driver.Value {
+	switch t := v.(type) {
+	case int:
+		return int64(t)
+	case int32:
+		return int64(t)
+	case uint:
+		return int64(t)
+	case float32:
+		return float64(t)
+	default:
+		return v
+	}
+}
+
+type scriptedDriver struct{}
+
+func (scriptedDriver) Open(name string) (driver.Conn, error) {
+	registryMu.Lock()
+	d, ok := registry[name]
+	registryMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("dbtest: unknown database %q", name)
+	}
+	return &conn{db: d}, nil
+}
+
+type conn struct {
+	db *DB
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error { return nil }
+
+func (c *conn) Begin() (driver.Tx, error) {
+	c.db.record("BEGIN")
+	return tx{db: c.db}, nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	r, err := c.db.run(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(r.affected), nil
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r, err := c.db.run(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return &rows{columns: r.columns, rows: r.rows}, nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	out := make([]driver.Value, len(args))
+	for i, a := range args {
+		out[i] = a.Value
+	}
+	return out
+}
+
+type tx struct {
+	db *DB
+}
+
+func (t tx) Commit() error {
+	t.db.record("COMMIT")
+	return nil
+}
+
+func (t tx) Rollback() error {
+	t.db.record("ROLLBACK")
+	return nil
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	r, err := s.conn.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(r.affected), nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	r, err := s.conn.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{columns: r.columns, rows: r.rows}, nil
+}
+
+type rows struct {
+	columns []string
+	rows    [][]driver.Value
+	next    int
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,63 @@
+package dbtest
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestRulesMatchInOrder(t *testing.T) {
+	d := New(t)
+	d.On(`SELECT name FROM products WHERE id`).Rows([]string{"name"}, []interface{}{"Lamp"}).Times(1)
+	d.On(`SELECT name FROM products WHERE id`)
+
+	var name string
+	if err := d.DB.QueryRow("SELECT name FROM products WHERE id = $1", 1).Scan(&name); err != nil || name != "Lamp" {
+		t.Fatalf("first query = %q, %v", name, err)
+	}
+	if err := d.DB.QueryRow("SELECT name FROM products WHERE id = $1", 2).Scan(&name); err != sql.ErrNoRows {
+		t.Fatalf("second query err = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := d.DB.Exec("DELETE FROM products"); err == nil {
+		t.Fatal("unexpected statement succeeded")
+	}
+
+	calls := d.Matching(`^SELECT`)
+	if len(calls) != 2 || calls[1].Args[0] != int64(2) {
+		t.Errorf("calls = %+v", calls)
+	}
+}
+
+func TestTransactionsAreRecorded(t *testing.T) {
+	d := New(t)
+	boom := errors.New("boom")
+	d.On(`^UPDATE products`).Affected(3)
+	d.On(`^INSERT`).Err(boom)
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := tx.Exec("UPDATE products SET stock = 0")
+	if n, _ := res.RowsAffected(); err != nil || n != 3 {
+		t.Fatalf("update affected %d, %v", n, err)
+	}
+	if _, err := tx.Exec("INSERT INTO stock_movements VALUES (1)"); err != boom {
+		t.Fatalf("insert err = %v, want boom", err)
+	}
+	tx.Rollback()
+
+	var got []string
+	for _, c := range d.Calls() {
+		got = append(got, c.Query)
+	}
+	want := []string{"BEGIN", "UPDATE products SET stock = 0", "INSERT INTO stock_movements VALUES (1)", "ROLLBACK"}
+	if len(got) != len(want) {
+		t.Fatalf("calls = %q", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("call %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
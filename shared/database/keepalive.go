@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"time"
+)
+
+const defaultKeepaliveInterval = 30 * time.Second
+
+// KeepaliveInterval reads DB_KEEPALIVE_INTERVAL (a Go duration such as
+// "30s"). Zero disables the pinger.
+func KeepaliveInterval() time.Duration {
+	value := os.Getenv("DB_KEEPALIVE_INTERVAL")
+	if value == "" {
+		return defaultKeepaliveInterval
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Printf("Invalid DB_KEEPALIVE_INTERVAL %q, using %s", value, defaultKeepaliveInterval)
+		return defaultKeepaliveInterval
+	}
+	return d
+}
+
+// StartKeepalive pings the pool every interval so connections silently
+// dropped by the network are discarded and replaced before a request hits
+// them. It complements SetConnMaxLifetime. Call the returned func to stop.
+func StartKeepalive(db *sql.DB, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ticks, stopTicker := newTicker(interval)
+	go func() {
+		defer stopTicker()
+		keepalive(ctx, db, ticks, interval)
+	}()
+	return cancel
+}
+
+// newTicker is the pinger's clock; tests replace it to tick on demand.
+var newTicker = func(interval time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(interval)
+	return t.C, t.Stop
+}
+
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// keepalive pings db on every tick until ctx is done. Ticks are injected so
+// the loop can be driven without real time passing.
+func keepalive(ctx context.Context, db pinger, ticks <-chan time.Time, timeout time.Duration) {
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+			pingCtx, cancel := context.WithTimeout(ctx, timeout)
+			err := db.PingContext(pingCtx)
+			cancel()
+
+			switch {
+			case err != nil && ctx.Err() == nil:
+				log.Printf("Database keepalive ping failed: %v", err)
+				failing = true
+			case err == nil && failing:
+				log.Println("Database keepalive recovered")
+				failing = false
+			}
+		}
+	}
+}
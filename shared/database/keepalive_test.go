@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingPinger struct {
+	mu    sync.Mutex
+	pings int
+	err   error
+	done  chan struct{}
+}
+
+func (p *countingPinger) PingContext(ctx context.Context) error {
+	p.mu.Lock()
+	p.pings++
+	err := p.err
+	p.mu.Unlock()
+	p.done <- struct{}{}
+	return err
+}
+
+func TestKeepalivePingsOnEveryTick(t *testing.T) {
+	p := &countingPinger{err: errors.New("connection reset"), done: make(chan struct{})}
+	ticks := make(chan time.Time)
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		keepalive(ctx, p, ticks, time.Second)
+		close(finished)
+	}()
+
+	for i := 0; i < 3; i++ {
+		ticks <- time.Now()
+		<-p.done
+	}
+	// A failing ping does not stop the loop.
+	p.mu.Lock()
+	p.err = nil
+	p.mu.Unlock()
+	ticks <- time.Now()
+	<-p.done
+
+	cancel()
+	<-finished
+	if p.pings != 4 {
+		t.Errorf("pings = %d, want one per tick", p.pings)
+	}
+}
+
+func TestStartKeepaliveUsesConfiguredInterval(t *testing.T) {
+	var got time.Duration
+	stopped := make(chan struct{})
+	prev := newTicker
+	newTicker = func(interval time.Duration) (<-chan time.Time, func()) {
+		got = interval
+		return make(chan time.Time), func() { close(stopped) }
+	}
+	t.Cleanup(func() { newTicker = prev })
+
+	t.Setenv("DB_KEEPALIVE_INTERVAL", "45s")
+	stop := StartKeepalive(nil, KeepaliveInterval())
+	if got != 45*time.Second {
+		t.Errorf("ticker interval = %s, want 45s", got)
+	}
+	stop()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("ticker was not stopped")
+	}
+}
+
+func TestKeepaliveInterval(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", defaultKeepaliveInterval},
+		{"2m", 2 * time.Minute},
+		{"0", 0},
+		{"soon", defaultKeepaliveInterval},
+		{"-5s", defaultKeepaliveInterval},
+	}
+	for _, tt := range tests {
+		t.Setenv("DB_KEEPALIVE_INTERVAL", tt.value)
+		if got := KeepaliveInterval(); got != tt.want {
+			t.Errorf("KeepaliveInterval(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestStartKeepaliveDisabledAtZero(t *testing.T) {
+	prev := newTicker
+	newTicker = func(time.Duration) (<-chan time.Time, func()) {
+		t.Error("ticker started with the pinger disabled")
+		return nil, func() {}
+	}
+	t.Cleanup(func() { newTicker = prev })
+
+	StartKeepalive(nil, 0)()
+}
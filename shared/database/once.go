@@ -0,0 +1,32 @@
+package database
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// Once initializes a service's package-level *sql.DB exactly once, however
+// many goroutines race to do it. main opens the real connection through it;
+// a test can inject its own connection by calling Set first.
+type Once struct {
+	once sync.Once
+	err  error
+}
+
+// Open assigns the result of open to *target the first time it is called and
+// does nothing afterwards. Every caller gets the first call's error.
+func (o *Once) Open(target **sql.DB, open func() (*sql.DB, error)) error {
+	o.once.Do(func() { *target, o.err = open() })
+	return o.err
+}
+
+// Set assigns conn to *target unless the database was already initialized,
+// reporting whether it did.
+func (o *Once) Set(target **sql.DB, conn *sql.DB) bool {
+	set := false
+	o.once.Do(func() {
+		*target = conn
+		set = true
+	})
+	return set
+}
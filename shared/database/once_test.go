@@ -0,0 +1,66 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Run with -race: the goroutines below all write the same target.
+func TestOnceOpensConcurrentlyOnce(t *testing.T) {
+	var (
+		once   Once
+		target *sql.DB
+		opens  int32
+		conn   = &sql.DB{}
+		failed = errors.New("connection refused")
+	)
+	var wg sync.WaitGroup
+	errs := make([]error, 50)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = once.Open(&target, func() (*sql.DB, error) {
+				atomic.AddInt32(&opens, 1)
+				return conn, failed
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	if opens != 1 {
+		t.Errorf("opened %d times, want 1", opens)
+	}
+	if target != conn {
+		t.Error("target not set to the opened connection")
+	}
+	for i, err := range errs {
+		if err != failed {
+			t.Fatalf("caller %d got %v, want the first open's error", i, err)
+		}
+	}
+}
+
+func TestOnceSetInjectsBeforeOpen(t *testing.T) {
+	var (
+		once     Once
+		target   *sql.DB
+		injected = &sql.DB{}
+	)
+	if !once.Set(&target, injected) {
+		t.Fatal("Set on a fresh Once reported no change")
+	}
+	err := once.Open(&target, func() (*sql.DB, error) {
+		t.Error("Open dialed after a connection was injected")
+		return &sql.DB{}, nil
+	})
+	if err != nil || target != injected {
+		t.Errorf("Open = %v, target replaced: %v", err, target != injected)
+	}
+	if once.Set(&target, &sql.DB{}) || target != injected {
+		t.Error("a second Set replaced the connection")
+	}
+}
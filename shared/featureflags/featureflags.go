@@ -0,0 +1,49 @@
+// Package featureflags toggles endpoints per environment. A flag named
+// "order_returns" is read from FEATURE_ORDER_RETURNS; "true", "1" and "on"
+// enable it, "false", "0" and "off" disable it, and anything else keeps the
+// default given by the caller.
+package featureflags
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// EnvKey returns the environment variable that controls flag name.
+func EnvKey(name string) string {
+	return "FEATURE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Enabled reports whether flag name is on, falling back to def when it is
+// unset or unrecognised. It reads the environment on every call so a flag
+// can be flipped without rebuilding the router.
+func Enabled(name string, def bool) bool {
+	key := EnvKey(name)
+	v := os.Getenv(key)
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "":
+		return def
+	case "true", "1", "on":
+		return true
+	case "false", "0", "off":
+		return false
+	}
+	log.Printf("Invalid %s %q, using %t", key, v, def)
+	return def
+}
+
+// Gate hides a route behind flag name. While the flag is off the route
+// answers 404, as though it were never registered.
+func Gate(name string, def bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !Enabled(name, def) {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package featureflags
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEnvKey(t *testing.T) {
+	if got := EnvKey("order-returns"); got != "FEATURE_ORDER_RETURNS" {
+		t.Errorf("EnvKey = %q", got)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	tests := []struct {
+		value string
+		def   bool
+		want  bool
+	}{
+		{"", true, true},
+		{"", false, false},
+		{"true", false, true},
+		{" ON ", false, true},
+		{"1", false, true},
+		{"off", true, false},
+		{"0", true, false},
+		{"False", true, false},
+		{"maybe", true, true},
+		{"maybe", false, false},
+	}
+	for _, tt := range tests {
+		t.Setenv("FEATURE_REVIEWS", tt.value)
+		if got := Enabled("reviews", tt.def); got != tt.want {
+			t.Errorf("FEATURE_REVIEWS=%q, default %t: Enabled = %t, want %t", tt.value, tt.def, got, tt.want)
+		}
+	}
+}
+
+func TestGate(t *testing.T) {
+	h := Gate("reviews", true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	for value, want := range map[string]int{"off": http.StatusNotFound, "on": http.StatusTeapot, "": http.StatusTeapot} {
+		t.Setenv("FEATURE_REVIEWS", value)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/1/reviews", nil))
+		if w.Code != want {
+			t.Errorf("FEATURE_REVIEWS=%q: status = %d, want %d", value, w.Code, want)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package middleware
 
 import (
+	"context"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -10,6 +13,13 @@ import (
 
 var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
 
+// Tokens are bound to an issuer and audience so a token minted in one
+// environment is rejected by another that shares the secret.
+var (
+	jwtIssuer   = envOrDefault("JWT_ISSUER", "go-ecommerce")
+	jwtAudience = envOrDefault("JWT_AUDIENCE", "go-ecommerce-api")
+)
+
 func init() {
 	if len(jwtSecret) == 0 {
 		jwtSecret = []byte("default-secret-key-change-in-production")
@@ -20,12 +30,54 @@ func GetJWTSecret() []byte {
 	return jwtSecret
 }
 
+func GetJWTIssuer() string {
+	return jwtIssuer
+}
+
+func GetJWTAudience() string {
+	return jwtAudience
+}
+
 type Claims struct {
 	UserID uint   `json:"user_id"`
 	Email  string `json:"email"`
+	Role   string `json:"role,omitempty"`
+	// ImpersonatedBy is the admin user ID when the token was minted for
+	// support impersonation rather than by the user logging in.
+	ImpersonatedBy uint `json:"impersonated_by,omitempty"`
+	// AuthTime is when the user last presented credentials. Refreshed tokens
+	// carry it forward so a session cannot be extended indefinitely.
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// CanAccessUser reports whether the token may act on the given user's data:
+// either it belongs to that user or it carries the admin role. Missing
+// claims can access nothing.
+func (c *Claims) CanAccessUser(userID uint) bool {
+	return c != nil && (c.UserID == userID || c.Role == "admin")
+}
+
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+// ClaimsFromContext returns the claims stored by AuthMiddleware, if any.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*Claims)
+	return claims, ok
+}
+
+// UserIDFromContext returns the ID of the user AuthMiddleware authenticated.
+// ok is false when the request carries no claims.
+func UserIDFromContext(ctx context.Context) (uint, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok || claims == nil {
+		return 0, false
+	}
+	return claims.UserID, true
+}
+
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
@@ -37,7 +89,7 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 			return jwtSecret, nil
-		})
+		}, jwt.WithIssuer(jwtIssuer), jwt.WithAudience(jwtAudience))
 
 		if err != nil || !token.Valid {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
@@ -50,24 +102,48 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		r.Header.Set("X-User-ID", string(rune(claims.UserID)))
+		revoked, err := isRevoked(claims.ID)
+		if err != nil {
+			log.Printf("Token revocation check failed: %v", err)
+			http.Error(w, "Unable to verify token", http.StatusServiceUnavailable)
+			return
+		}
+		if revoked {
+			http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+			return
+		}
+
+		r.Header.Set("X-User-ID", strconv.FormatUint(uint64(claims.UserID), 10))
 		r.Header.Set("X-User-Email", claims.Email)
+		// Only the token may say who is impersonating; a client-sent header
+		// is dropped.
+		r.Header.Del("X-Impersonated-By")
+		if claims.ImpersonatedBy != 0 {
+			r.Header.Set("X-Impersonated-By", strconv.FormatUint(uint64(claims.ImpersonatedBy), 10))
+			log.Printf("Impersonated request: admin %d acting as user %d: %s %s", claims.ImpersonatedBy, claims.UserID, r.Method, r.URL.Path)
+		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
 	})
 }
 
-func CORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+// RequireRole rejects requests whose token carries none of the given roles.
+// It must run after AuthMiddleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Authorization header required", http.StatusUnauthorized)
+				return
+			}
+			for _, role := range roles {
+				if claims.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
 }
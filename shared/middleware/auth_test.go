@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// bearer signs claims with the service's settings and sets them on r.
+func bearer(t *testing.T, r *http.Request, claims *Claims) *http.Request {
+	t.Helper()
+	claims.Issuer = GetJWTIssuer()
+	claims.Audience = jwt.ClaimStrings{GetJWTAudience()}
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(GetJWTSecret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestAuthMiddlewareMarksImpersonation(t *testing.T) {
+	var got *http.Request
+	h := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r }))
+
+	r := bearer(t, httptest.NewRequest(http.MethodGet, "/orders", nil), &Claims{UserID: 5, ImpersonatedBy: 1})
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got == nil {
+		t.Fatal("request was rejected")
+	}
+	if got.Header.Get("X-User-ID") != "5" || got.Header.Get("X-Impersonated-By") != "1" {
+		t.Errorf("X-User-ID = %q, X-Impersonated-By = %q", got.Header.Get("X-User-ID"), got.Header.Get("X-Impersonated-By"))
+	}
+	claims, ok := ClaimsFromContext(got.Context())
+	if !ok || claims.ImpersonatedBy != 1 {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func TestAuthMiddlewareDropsSpoofedImpersonationHeader(t *testing.T) {
+	var got *http.Request
+	h := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r }))
+
+	r := bearer(t, httptest.NewRequest(http.MethodGet, "/orders", nil), &Claims{UserID: 5})
+	r.Header.Set("X-Impersonated-By", "1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got == nil {
+		t.Fatal("request was rejected")
+	}
+	if v := got.Header.Get("X-Impersonated-By"); v != "" {
+		t.Errorf("X-Impersonated-By = %q from the client was passed on", v)
+	}
+}
+
+func TestAuthMiddlewareChecksIssuerAndAudience(t *testing.T) {
+	tests := []struct {
+		name     string
+		issuer   string
+		audience []string
+		want     int
+	}{
+		{"matching", GetJWTIssuer(), []string{GetJWTAudience()}, http.StatusOK},
+		{"one of several audiences", GetJWTIssuer(), []string{"reporting", GetJWTAudience()}, http.StatusOK},
+		{"wrong issuer", "staging-ecommerce", []string{GetJWTAudience()}, http.StatusUnauthorized},
+		{"wrong audience", GetJWTIssuer(), []string{"staging-api"}, http.StatusUnauthorized},
+		{"no issuer", "", []string{GetJWTAudience()}, http.StatusUnauthorized},
+		{"no audience", GetJWTIssuer(), nil, http.StatusUnauthorized},
+	}
+	h := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &Claims{UserID: 5, RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    tt.issuer,
+				Audience:  tt.audience,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			}}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(GetJWTSecret())
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name string
+		role string
+		want int
+	}{
+		{"admin", "admin", http.StatusOK},
+		{"customer", "customer", http.StatusForbidden},
+		{"no role", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := AuthMiddleware(RequireRole("admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, bearer(t, httptest.NewRequest(http.MethodPost, "/products", nil), &Claims{UserID: 5, Role: tt.role}))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+
+	// Without AuthMiddleware in front there are no claims to trust.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/products", nil)
+	r.Header.Set("X-User-Role", "admin")
+	RequireRole("admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("no claims: status = %d, want 401", w.Code)
+	}
+}
+
+type otherKey string
+
+func TestUserIDFromContext(t *testing.T) {
+	var fromToken context.Context
+	h := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fromToken = r.Context() }))
+	h.ServeHTTP(httptest.NewRecorder(), bearer(t, httptest.NewRequest(http.MethodGet, "/cart/7", nil), &Claims{UserID: 7}))
+	if fromToken == nil {
+		t.Fatal("request was rejected")
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		wantID uint
+		wantOK bool
+	}{
+		{"present", fromToken, 7, true},
+		{"absent", context.Background(), 0, false},
+		{"wrong type", context.WithValue(context.Background(), claimsContextKey, uint(7)), 0, false},
+		{"nil claims", context.WithValue(context.Background(), claimsContextKey, (*Claims)(nil)), 0, false},
+		// Another package's "claims" key is a different key.
+		{"colliding key", context.WithValue(context.Background(), otherKey("claims"), &Claims{UserID: 7}), 0, false},
+	}
+	for _, tt := range tests {
+		id, ok := UserIDFromContext(tt.ctx)
+		if id != tt.wantID || ok != tt.wantOK {
+			t.Errorf("%s: UserIDFromContext = %d, %v; want %d, %v", tt.name, id, ok, tt.wantID, tt.wantOK)
+		}
+	}
+}
+
+func TestCanAccessUserWithoutClaims(t *testing.T) {
+	var claims *Claims
+	if claims.CanAccessUser(7) {
+		t.Error("nil claims can access user 7")
+	}
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxLoggedBody caps how much of a request body LogRequestBodies buffers.
+// Larger bodies are passed through untouched and only their size is noted.
+const maxLoggedBody = 64 << 10
+
+// redactedFields are replaced wholesale wherever they appear in a logged
+// body. Any key containing "password" is redacted as well.
+var redactedFields = map[string]bool{
+	"password":  true,
+	"card_info": true,
+	"cvc":       true,
+}
+
+// bodyLoggingEnabled is read once at startup. It is forced off when
+// APP_ENV=production so a stray debug setting can't leak customer data.
+var bodyLoggingEnabled = os.Getenv("LOG_REQUEST_BODIES") == "true" && os.Getenv("APP_ENV") != "production"
+
+// LogRequestBodies logs JSON request bodies with sensitive fields redacted,
+// for debugging integrations. It does nothing unless LOG_REQUEST_BODIES=true.
+// The body is restored so handlers read it as usual.
+func LogRequestBodies(next http.Handler) http.Handler {
+	if !bodyLoggingEnabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf, err := io.ReadAll(io.LimitReader(r.Body, maxLoggedBody+1))
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		switch {
+		case err != nil:
+			log.Printf("Request body %s %s: read failed: %v", r.Method, r.URL.Path, err)
+		case len(buf) > maxLoggedBody:
+			log.Printf("Request body %s %s: more than %d bytes, not logged", r.Method, r.URL.Path, maxLoggedBody)
+		default:
+			log.Printf("Request body %s %s: %s", r.Method, r.URL.Path, redactBody(buf))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// redactBody returns body with sensitive fields masked. Bodies that aren't
+// JSON are summarized by size, since they can't be redacted field by field.
+func redactBody(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "(" + strconv.Itoa(len(body)) + " bytes, not JSON)"
+	}
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return "(unloggable body)"
+	}
+	return string(out)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			lower := strings.ToLower(key)
+			if redactedFields[lower] || strings.Contains(lower, "password") {
+				v[key] = "[REDACTED]"
+				continue
+			}
+			v[key] = redactValue(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	}
+	return v
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureBodyLog turns body logging on and returns the log output.
+func captureBodyLog(t *testing.T, enabled bool) *bytes.Buffer {
+	t.Helper()
+	prev := bodyLoggingEnabled
+	bodyLoggingEnabled = enabled
+	prevOut := log.Writer()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		bodyLoggingEnabled = prev
+		log.SetOutput(prevOut)
+	})
+	return &buf
+}
+
+// echoBody is a handler that reports the body it read.
+func echoBody(got *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		*got = string(b)
+	})
+}
+
+func TestLogRequestBodiesRedactsSensitiveFields(t *testing.T) {
+	logged := captureBodyLog(t, true)
+	body := `{"email":"ann@example.com","password":"hunter22","new_password":"Correct-Horse-9",` +
+		`"card_info":{"number":"4242424242424242","cvc":"123"},"items":[{"CVC":"999","sku":"LAMP"}]}`
+
+	var got string
+	LogRequestBodies(echoBody(&got)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/payments", strings.NewReader(body)))
+
+	if got != body {
+		t.Errorf("handler read %q, want the original body", got)
+	}
+	out := logged.String()
+	for _, secret := range []string{"hunter22", "Correct-Horse-9", "4242424242424242", "123", "999"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log leaks %q: %s", secret, out)
+		}
+	}
+	for _, kept := range []string{"ann@example.com", "LAMP", "[REDACTED]"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("log missing %q: %s", kept, out)
+		}
+	}
+}
+
+func TestLogRequestBodiesHandlerStillDecodes(t *testing.T) {
+	captureBodyLog(t, true)
+
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+	})
+	LogRequestBodies(h).ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"ann@example.com","password":"hunter22"}`)))
+	if req.Email != "ann@example.com" || req.Password != "hunter22" {
+		t.Errorf("handler decoded %+v, want the unredacted body", req)
+	}
+}
+
+func TestLogRequestBodiesUnloggableBodies(t *testing.T) {
+	logged := captureBodyLog(t, true)
+
+	var got string
+	LogRequestBodies(echoBody(&got)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/import", strings.NewReader("name,password\nann,hunter22")))
+	if strings.Contains(logged.String(), "hunter22") || !strings.Contains(logged.String(), "not JSON") {
+		t.Errorf("non-JSON body logged as %s", logged)
+	}
+	if got != "name,password\nann,hunter22" {
+		t.Errorf("handler read %q", got)
+	}
+
+	big := `{"password":"hunter22","pad":"` + strings.Repeat("x", maxLoggedBody) + `"}`
+	LogRequestBodies(echoBody(&got)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/import", strings.NewReader(big)))
+	if strings.Contains(logged.String(), "hunter22") {
+		t.Error("an oversize body was logged")
+	}
+	if got != big {
+		t.Errorf("handler read %d bytes of an oversize body, want %d", len(got), len(big))
+	}
+}
+
+func TestLogRequestBodiesOffByDefault(t *testing.T) {
+	logged := captureBodyLog(t, false)
+
+	var got string
+	LogRequestBodies(echoBody(&got)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"ann@example.com"}`)))
+	if logged.Len() != 0 || got != `{"email":"ann@example.com"}` {
+		t.Errorf("disabled middleware logged %q and passed %q", logged, got)
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// CORS settings, overridable per environment. CORS_MAX_AGE is in seconds and
+// lets browsers cache preflight responses instead of re-sending them.
+var (
+	corsAllowedMethods = envOrDefault("CORS_ALLOWED_METHODS", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+	corsAllowedHeaders = envOrDefault("CORS_ALLOWED_HEADERS", "Content-Type, Authorization, X-Client")
+	corsMaxAge         = envOrDefault("CORS_MAX_AGE", "600")
+)
+
+func init() {
+	if n, err := strconv.Atoi(corsMaxAge); err != nil || n < 0 {
+		corsMaxAge = "600"
+	}
+}
+
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// setCORS overrides the CORS settings for one test.
+func setCORS(t *testing.T, methods, headers, maxAge string) {
+	t.Helper()
+	oldMethods, oldHeaders, oldMaxAge := corsAllowedMethods, corsAllowedHeaders, corsMaxAge
+	corsAllowedMethods, corsAllowedHeaders, corsMaxAge = methods, headers, maxAge
+	t.Cleanup(func() {
+		corsAllowedMethods, corsAllowedHeaders, corsMaxAge = oldMethods, oldHeaders, oldMaxAge
+	})
+}
+
+func TestCORSPreflight(t *testing.T) {
+	setCORS(t, "GET, POST", "Content-Type, X-Request-ID", "3600")
+	called := false
+	h := CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/api/products", nil))
+	if w.Code != http.StatusOK || called {
+		t.Fatalf("status = %d, handler called = %v", w.Code, called)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "Content-Type, X-Request-ID",
+		"Access-Control-Max-Age":       "3600",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCORSSimpleRequestHasNoMaxAge(t *testing.T) {
+	setCORS(t, "GET", "Content-Type", "3600")
+	h := CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) }))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/products", nil))
+	if w.Code != http.StatusTeapot {
+		t.Fatalf("status = %d, request was not passed on", w.Code)
+	}
+	if w.Header().Get("Access-Control-Allow-Methods") != "GET" {
+		t.Errorf("Access-Control-Allow-Methods = %q", w.Header().Get("Access-Control-Allow-Methods"))
+	}
+	if v := w.Header().Get("Access-Control-Max-Age"); v != "" {
+		t.Errorf("Access-Control-Max-Age = %q on a non-preflight request", v)
+	}
+}
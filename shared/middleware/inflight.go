@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// InFlight counts requests that are currently being served so shutdown can
+// wait for them to finish.
+type InFlight struct {
+	wg    sync.WaitGroup
+	count atomic.Int64
+}
+
+// Middleware tracks each request for the lifetime of its handler.
+func (f *InFlight) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.wg.Add(1)
+		f.count.Add(1)
+		defer func() {
+			f.count.Add(-1)
+			f.wg.Done()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Count returns the number of requests currently in flight.
+func (f *InFlight) Count() int64 {
+	return f.count.Load()
+}
+
+// Wait blocks until no requests are in flight or ctx is done, whichever comes
+// first. New requests must already be refused (e.g. by http.Server.Shutdown).
+func (f *InFlight) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig is a token bucket refilled at PerMinute tokens a minute and
+// holding at most Burst tokens.
+type RateLimitConfig struct {
+	PerMinute int
+	Burst     int
+}
+
+// RateLimitFromEnv reads <prefix>_PER_MINUTE and <prefix>_BURST, falling back
+// to the given defaults for missing or invalid values.
+func RateLimitFromEnv(prefix string, fallback RateLimitConfig) RateLimitConfig {
+	cfg := fallback
+	if v := os.Getenv(prefix + "_PER_MINUTE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.PerMinute = n
+		} else {
+			log.Printf("Invalid %s_PER_MINUTE %q, using %d", prefix, v, fallback.PerMinute)
+		}
+	}
+	if v := os.Getenv(prefix + "_BURST"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.Burst = n
+		} else {
+			log.Printf("Invalid %s_BURST %q, using %d", prefix, v, fallback.Burst)
+		}
+	}
+	return cfg
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type bucketLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+// take spends a token from key's bucket. When none is left it returns false
+// and how long until one is available.
+func (l *bucketLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		// Drop idle, refilled buckets occasionally so the map doesn't grow
+		// forever; a full bucket is the same as a missing one.
+		if len(l.buckets) > 10000 {
+			for k, old := range l.buckets {
+				if old.tokens+now.Sub(old.last).Seconds()*l.rate >= l.burst {
+					delete(l.buckets, k)
+				}
+			}
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// RateLimit limits each client to cfg with a token bucket and answers 429
+// with Retry-After once the bucket is empty. Clients are keyed by their
+// remote address, or by the address a trusted proxy forwarded for them.
+func RateLimit(cfg RateLimitConfig) func(http.Handler) http.Handler {
+	l := &bucketLimiter{
+		rate:    float64(cfg.PerMinute) / 60,
+		burst:   float64(cfg.Burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, wait := l.take(clientKey(r), time.Now())
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// trustedProxies are the addresses whose X-Forwarded-For header is believed,
+// from TRUSTED_PROXIES: a comma-separated list of IPs or CIDRs, such as the
+// gateway's. With none set the header is ignored, so a caller can't pick a
+// fresh rate limit bucket by sending a new one.
+var trustedProxies = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+
+func parseTrustedProxies(value string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Invalid TRUSTED_PROXIES entry %q, ignoring it", entry)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientKey is the remote address, unless that is a trusted proxy. Then the
+// X-Forwarded-For hops are walked from the right, past any other trusted
+// proxies, to the first address none of them vouches for.
+func clientKey(r *http.Request) string {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if !isTrustedProxy(addr) {
+		return addr
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		addr = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return addr
+}
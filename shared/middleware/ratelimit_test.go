@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitAnswers429PastBurst(t *testing.T) {
+	h := RateLimit(RateLimitConfig{PerMinute: 1, Burst: 2})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/products/import/url", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := request("10.0.0.1:5000"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200 within the burst", i+1, w.Code)
+		}
+	}
+	w := request("10.0.0.1:5001")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+
+	// Each client has its own bucket.
+	if w := request("10.0.0.2:5000"); w.Code != http.StatusOK {
+		t.Errorf("other client: status = %d, want 200", w.Code)
+	}
+}
+
+func TestRateLimitIgnoresForwardedForFromUntrustedCallers(t *testing.T) {
+	h := RateLimit(RateLimitConfig{PerMinute: 1, Burst: 1})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// A caller that reaches the service directly can't dodge the limit by
+	// inventing a new X-Forwarded-For on each request.
+	for i, fwd := range []string{"1.1.1.1", "2.2.2.2"} {
+		r := httptest.NewRequest(http.MethodPost, "/notifications/bulk", nil)
+		r.RemoteAddr = "203.0.113.5:4000"
+		r.Header.Set("X-Forwarded-For", fwd)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if want := []int{http.StatusOK, http.StatusTooManyRequests}[i]; w.Code != want {
+			t.Errorf("X-Forwarded-For %q: status = %d, want %d", fwd, w.Code, want)
+		}
+	}
+}
+
+// useTrustedProxies sets TRUSTED_PROXIES for the rest of the test.
+func useTrustedProxies(t *testing.T, value string) {
+	t.Helper()
+	prev := trustedProxies
+	trustedProxies = parseTrustedProxies(value)
+	t.Cleanup(func() { trustedProxies = prev })
+}
+
+func TestClientKey(t *testing.T) {
+	useTrustedProxies(t, "10.0.0.9, 172.16.0.0/12, bogus")
+
+	tests := []struct {
+		name   string
+		remote string
+		fwd    string
+		want   string
+	}{
+		{"direct", "203.0.113.5:4000", "", "203.0.113.5"},
+		{"direct with forged header", "203.0.113.5:4000", "1.1.1.1", "203.0.113.5"},
+		{"trusted proxy", "10.0.0.9:4000", "198.51.100.7", "198.51.100.7"},
+		// The client can prepend hops but not change the one the proxy added.
+		{"prepended hops", "10.0.0.9:4000", "1.1.1.1, 198.51.100.7", "198.51.100.7"},
+		{"chained proxies", "10.0.0.9:4000", "1.1.1.1, 198.51.100.7, 172.20.0.3", "198.51.100.7"},
+		{"trusted proxy without header", "10.0.0.9:4000", "", "10.0.0.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/notifications/bulk", nil)
+			r.RemoteAddr = tt.remote
+			if tt.fwd != "" {
+				r.Header.Set("X-Forwarded-For", tt.fwd)
+			}
+			if got := clientKey(r); got != tt.want {
+				t.Errorf("clientKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets := parseTrustedProxies("10.0.0.9, ::1, 192.168.0.0/16, not-an-ip,")
+	var got []string
+	for _, n := range nets {
+		got = append(got, n.String())
+	}
+	want := []string{"10.0.0.9/32", "::1/128", "192.168.0.0/16"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("parsed %v, want %v", got, want)
+	}
+}
+
+func TestBucketRefills(t *testing.T) {
+	l := &bucketLimiter{rate: 1, burst: 1, buckets: map[string]*tokenBucket{}}
+	now := time.Now()
+
+	if ok, _ := l.take("a", now); !ok {
+		t.Fatal("first take refused")
+	}
+	if ok, wait := l.take("a", now.Add(500*time.Millisecond)); ok || wait != 500*time.Millisecond {
+		t.Errorf("take after 0.5s = %v, wait %v; want refused for 0.5s", ok, wait)
+	}
+	if ok, _ := l.take("a", now.Add(1500*time.Millisecond)); !ok {
+		t.Error("take after refill refused")
+	}
+}
+
+func TestRateLimitFromEnv(t *testing.T) {
+	fallback := RateLimitConfig{PerMinute: 5, Burst: 2}
+
+	t.Setenv("TEST_LIMIT_PER_MINUTE", "30")
+	t.Setenv("TEST_LIMIT_BURST", "zero")
+	if got := RateLimitFromEnv("TEST_LIMIT", fallback); got != (RateLimitConfig{PerMinute: 30, Burst: 2}) {
+		t.Errorf("config = %+v", got)
+	}
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// RevocationChecker reports whether the token with the given jti has been
+// revoked (e.g. by logging out).
+type RevocationChecker func(jti string) (bool, error)
+
+var (
+	revocationMu      sync.RWMutex
+	revocationChecker RevocationChecker = remoteRevocationChecker(envOrDefault("USER_SERVICE_URL", "http://user-service:8001"))
+)
+
+// SetRevocationChecker replaces the checker AuthMiddleware consults. The user
+// service, which owns the revocation list, installs a direct database lookup;
+// other services default to asking the user service over HTTP.
+func SetRevocationChecker(c RevocationChecker) {
+	revocationMu.Lock()
+	defer revocationMu.Unlock()
+	revocationChecker = c
+}
+
+func isRevoked(jti string) (bool, error) {
+	revocationMu.RLock()
+	c := revocationChecker
+	revocationMu.RUnlock()
+	if c == nil || jti == "" {
+		return false, nil
+	}
+	return c(jti)
+}
+
+// revocationCacheTTL bounds how long a "not revoked" answer is reused, and so
+// how long a logged-out token may still be accepted by other services.
+const revocationCacheTTL = 30 * time.Second
+
+const maxRevocationCacheEntries = 10000
+
+type revocationEntry struct {
+	revoked bool
+	until   time.Time
+}
+
+func remoteRevocationChecker(baseURL string) RevocationChecker {
+	client := &http.Client{Timeout: 2 * time.Second}
+	var mu sync.Mutex
+	cache := map[string]revocationEntry{}
+
+	return func(jti string) (bool, error) {
+		now := time.Now()
+		mu.Lock()
+		entry, ok := cache[jti]
+		mu.Unlock()
+		// A revoked token stays revoked, so only negative answers expire.
+		if ok && (entry.revoked || now.Before(entry.until)) {
+			return entry.revoked, nil
+		}
+
+		resp, err := client.Get(baseURL + "/tokens/revoked/" + url.PathEscape(jti))
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return false, fmt.Errorf("revocation check returned %d", resp.StatusCode)
+		}
+
+		var body struct {
+			Revoked bool `json:"revoked"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return false, err
+		}
+
+		mu.Lock()
+		if len(cache) >= maxRevocationCacheEntries {
+			for k, e := range cache {
+				if !e.revoked && now.After(e.until) {
+					delete(cache, k)
+				}
+			}
+			if len(cache) >= maxRevocationCacheEntries {
+				cache = map[string]revocationEntry{}
+			}
+		}
+		cache[jti] = revocationEntry{revoked: body.Revoked, until: now.Add(revocationCacheTTL)}
+		mu.Unlock()
+
+		return body.Revoked, nil
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthMiddlewareRejectsRevokedToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		checker RevocationChecker
+		want    int
+	}{
+		{"not revoked", func(string) (bool, error) { return false, nil }, http.StatusOK},
+		{"revoked", func(jti string) (bool, error) { return jti == "jti-5", nil }, http.StatusUnauthorized},
+		{"check failed", func(string) (bool, error) { return false, errors.New("user service down") }, http.StatusServiceUnavailable},
+	}
+	h := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetRevocationChecker(tt.checker)
+			t.Cleanup(func() { SetRevocationChecker(nil) })
+
+			claims := &Claims{UserID: 5}
+			claims.ID = "jti-5"
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, bearer(t, httptest.NewRequest("GET", "/orders", nil), claims))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteRevocationCheckerCaches(t *testing.T) {
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jti := r.URL.Path[len("/tokens/revoked/"):]
+		hits[jti]++
+		fmt.Fprintf(w, `{"revoked": %v}`, jti == "gone")
+	}))
+	defer srv.Close()
+
+	check := remoteRevocationChecker(srv.URL)
+	for i := 0; i < 3; i++ {
+		if revoked, err := check("gone"); err != nil || !revoked {
+			t.Fatalf("check(gone) = %v, %v", revoked, err)
+		}
+		if revoked, err := check("live"); err != nil || revoked {
+			t.Fatalf("check(live) = %v, %v", revoked, err)
+		}
+	}
+	if hits["gone"] != 1 || hits["live"] != 1 {
+		t.Errorf("user service hits = %v, want each answer cached", hits)
+	}
+}
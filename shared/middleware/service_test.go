@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServiceTokenPassesServiceRoutes(t *testing.T) {
+	token, err := ServiceToken("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims *Claims
+	h := AuthMiddleware(RequireRole("admin", RoleService)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ = ClaimsFromContext(r.Context())
+	})))
+	r := httptest.NewRequest(http.MethodPost, "/products/1/reserve", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if claims.Role != RoleService || claims.Subject != "order" {
+		t.Errorf("claims = %+v, want the order service", claims)
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl <= 0 || ttl > serviceTokenTTL {
+		t.Errorf("token expires in %v, want within %v", ttl, serviceTokenTTL)
+	}
+	// A service token acts for no user.
+	if claims.CanAccessUser(7) {
+		t.Error("service token can access a user's data")
+	}
+}
+
+func TestServiceTokenRejectedOnAdminRoutes(t *testing.T) {
+	token, err := ServiceToken("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := AuthMiddleware(RequireRole("admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	r := httptest.NewRequest(http.MethodPost, "/products", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
+
+func TestRequireRoleAcceptsAnyListedRole(t *testing.T) {
+	h := AuthMiddleware(RequireRole("admin", RoleService)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	for role, want := range map[string]int{"admin": http.StatusOK, RoleService: http.StatusOK, "customer": http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, bearer(t, httptest.NewRequest(http.MethodPost, "/products/1/reserve", nil), &Claims{UserID: 5, Role: role}))
+		if w.Code != want {
+			t.Errorf("role %q: status = %d, want %d", role, w.Code, want)
+		}
+	}
+}
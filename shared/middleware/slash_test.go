@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTrimTrailingSlash(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("root")) })
+	r.HandleFunc("/products", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("list")) })
+	r.HandleFunc("/products/{id}", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(mux.Vars(r)["id"])) })
+	h := TrimTrailingSlash(r)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "root"},
+		{"/products", "list"},
+		{"/products/", "list"},
+		{"/products//", "list"},
+		{"/products/42", "42"},
+		{"/products/42/", "42"},
+		{"/products/?page=2", "list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != http.StatusOK || w.Body.String() != tt.want {
+				t.Errorf("GET %s = %d %q, want 200 %q", tt.path, w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package pagination
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2026, 3, 2, 10, 4, 5, 123456789, time.FixedZone("EST", -5*3600))
+
+	gotTime, gotID, err := DecodeCursor(EncodeCursor(createdAt, 42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotTime.Equal(createdAt) || gotID != 42 {
+		t.Errorf("decoded (%v, %d), want (%v, 42)", gotTime, gotID, createdAt)
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, cursor := range []string{
+		"!!!",
+		"bm8tc2VwYXJhdG9y",               // "no-separator"
+		"bm90LWEtdGltZXw0Mg",             // "not-a-time|42"
+		"MjAyNi0wMy0wMlQxMDowMDowMFp8eA", // "2026-03-02T10:00:00Z|x"
+	} {
+		if _, _, err := DecodeCursor(cursor); err != ErrInvalidCursor {
+			t.Errorf("DecodeCursor(%q) err = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Package pricing estimates tax and shipping for a cart or order. Rates are
+// simplified flat figures per region, not a substitute for a tax provider.
+package pricing
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"unicode"
+)
+
+var ErrUnknownRegion = errors.New("unsupported region")
+
+// taxRates are state-level sales tax rates keyed by region code. Regions
+// missing here are not shippable.
+var taxRates = map[string]float64{
+	"CA": 0.0725, "NY": 0.04, "TX": 0.0625, "WA": 0.065, "FL": 0.06,
+	"IL": 0.0625, "MA": 0.0625, "NJ": 0.06625, "PA": 0.06, "CO": 0.029,
+	"OR": 0, "DE": 0, "MT": 0, "NH": 0, "AK": 0,
+	"INTL": 0,
+}
+
+const (
+	domesticShipping      = 5.99
+	internationalShipping = 19.99
+	freeShippingThreshold = 50.00
+
+	// The flat rates cover parcels up to includedGrams of billable weight;
+	// each further started kilogram adds the per-kg surcharge.
+	includedGrams      = 1000
+	domesticPerKg      = 1.50
+	internationalPerKg = 6.00
+)
+
+// DimensionalDivisor is the cm³ per kilogram most carriers use to turn a
+// parcel's volume into a dimensional weight.
+const DimensionalDivisor = 5000
+
+// Parcel is one line of a shipment: a product's outer dimensions and actual
+// weight, times Quantity.
+type Parcel struct {
+	LengthCM    float64
+	WidthCM     float64
+	HeightCM    float64
+	WeightGrams int
+	Quantity    int
+}
+
+// DimensionalGrams is the volumetric weight of one unit, which carriers bill
+// instead of the actual weight for light, bulky items.
+func (p Parcel) DimensionalGrams() int {
+	return int(math.Ceil(p.LengthCM * p.WidthCM * p.HeightCM * 1000 / DimensionalDivisor))
+}
+
+// BillableGrams is the greater of the actual and dimensional weight for all
+// units of the parcel.
+func (p Parcel) BillableGrams() int {
+	grams := p.WeightGrams
+	if dim := p.DimensionalGrams(); dim > grams {
+		grams = dim
+	}
+	return grams * p.Quantity
+}
+
+// BillableWeight sums the billable grams of every parcel.
+func BillableWeight(parcels []Parcel) int {
+	total := 0
+	for _, p := range parcels {
+		total += p.BillableGrams()
+	}
+	return total
+}
+
+// NormalizeRegion upper-cases and validates a region code.
+func NormalizeRegion(region string) (string, error) {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if _, ok := taxRates[region]; !ok {
+		return "", ErrUnknownRegion
+	}
+	return region, nil
+}
+
+// RegionFromAddress finds the region code in a free-form address, such as
+// "TX" in "500 Main St, Austin, TX 78701". Only upper-case words count, so
+// "or" and "de" in a street name are not mistaken for Oregon and Delaware;
+// the last matching word wins. It returns ErrUnknownRegion when none match.
+func RegionFromAddress(address string) (string, error) {
+	words := strings.FieldsFunc(address, func(r rune) bool { return !unicode.IsLetter(r) })
+	for i := len(words) - 1; i >= 0; i-- {
+		if _, ok := taxRates[words[i]]; ok {
+			return words[i], nil
+		}
+	}
+	return "", ErrUnknownRegion
+}
+
+// Tax returns the estimated sales tax on subtotal for region.
+func Tax(region string, subtotal float64) (float64, error) {
+	region, err := NormalizeRegion(region)
+	if err != nil {
+		return 0, err
+	}
+	return Round(subtotal * taxRates[region]), nil
+}
+
+// Shipping returns the estimated shipping cost for an order of subtotal
+// shipped to region. Domestic orders over the threshold ship free.
+func Shipping(region string, subtotal float64) (float64, error) {
+	return ShippingForWeight(region, subtotal, 0)
+}
+
+// ShippingForWeight is Shipping for a shipment of known billable weight,
+// usually from BillableWeight. Weight beyond what the flat rate includes is
+// charged per started kilogram; free domestic shipping still applies.
+func ShippingForWeight(region string, subtotal float64, grams int) (float64, error) {
+	region, err := NormalizeRegion(region)
+	if err != nil {
+		return 0, err
+	}
+	if subtotal <= 0 {
+		return 0, nil
+	}
+	base, perKg := domesticShipping, domesticPerKg
+	if region == "INTL" {
+		base, perKg = internationalShipping, internationalPerKg
+	} else if subtotal >= freeShippingThreshold {
+		return 0, nil
+	}
+	if grams > includedGrams {
+		extraKg := math.Ceil(float64(grams-includedGrams) / 1000)
+		base += extraKg * perKg
+	}
+	return Round(base), nil
+}
+
+// Round rounds an amount to whole cents.
+func Round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
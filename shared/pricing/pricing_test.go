@@ -0,0 +1,82 @@
+package pricing
+
+import "testing"
+
+func TestRegionFromAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"500 Main St, Austin, TX 78701", "TX"},
+		{"1 Infinite Loop\nCupertino, CA 95014", "CA"},
+		{"12 Calle de la Paz, Portland, OR 97201", "OR"},
+		{"7 NYork Rd, Dallas, TX", "TX"},
+		{"Somewhere abroad, INTL", "INTL"},
+	}
+	for _, tt := range tests {
+		got, err := RegionFromAddress(tt.address)
+		if err != nil || got != tt.want {
+			t.Errorf("RegionFromAddress(%q) = %q, %v; want %q", tt.address, got, err, tt.want)
+		}
+	}
+}
+
+func TestRegionFromAddressUnknown(t *testing.T) {
+	for _, address := range []string{"", "10 Downing St, London", "12 main st, austin, tx"} {
+		if got, err := RegionFromAddress(address); err != ErrUnknownRegion {
+			t.Errorf("RegionFromAddress(%q) = %q, %v; want ErrUnknownRegion", address, got, err)
+		}
+	}
+}
+
+func TestTax(t *testing.T) {
+	got, err := Tax("ca", 100)
+	if err != nil || got != 7.25 {
+		t.Errorf("Tax(ca, 100) = %v, %v; want 7.25", got, err)
+	}
+	if _, err := Tax("ZZ", 100); err != ErrUnknownRegion {
+		t.Errorf("Tax(ZZ) error = %v, want ErrUnknownRegion", err)
+	}
+}
+
+func TestBillableGramsUsesDimensionalWeight(t *testing.T) {
+	tests := []struct {
+		name   string
+		parcel Parcel
+		want   int
+	}{
+		// 40x30x20 cm is 24000 cm³, which bills as 4800 g.
+		{"bulky and light", Parcel{LengthCM: 40, WidthCM: 30, HeightCM: 20, WeightGrams: 900, Quantity: 2}, 9600},
+		{"dense", Parcel{LengthCM: 10, WidthCM: 10, HeightCM: 10, WeightGrams: 2500, Quantity: 2}, 5000},
+		{"no dimensions", Parcel{WeightGrams: 300, Quantity: 3}, 900},
+	}
+	for _, tt := range tests {
+		if got := tt.parcel.BillableGrams(); got != tt.want {
+			t.Errorf("%s: BillableGrams = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := BillableWeight([]Parcel{tests[0].parcel, tests[1].parcel}); got != 14600 {
+		t.Errorf("BillableWeight = %d, want 14600", got)
+	}
+}
+
+func TestShippingForWeight(t *testing.T) {
+	tests := []struct {
+		region   string
+		subtotal float64
+		grams    int
+		want     float64
+	}{
+		{"CA", 20, 1000, 5.99},
+		{"CA", 20, 1001, 7.49},
+		{"CA", 20, 3500, 10.49},
+		{"CA", 60, 3500, 0},
+		{"INTL", 60, 2500, 31.99},
+	}
+	for _, tt := range tests {
+		got, err := ShippingForWeight(tt.region, tt.subtotal, tt.grams)
+		if err != nil || got != tt.want {
+			t.Errorf("ShippingForWeight(%s, %v, %d) = %v, %v; want %v", tt.region, tt.subtotal, tt.grams, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Package promo applies coupons and store credit to an order in a fixed,
+// deterministic sequence: percentage coupons first, then fixed-amount
+// coupons, then store credit against whatever is left.
+package promo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const (
+	KindPercent = "percent"
+	KindFixed   = "fixed"
+	KindCredit  = "credit"
+)
+
+var (
+	ErrInvalidPromo     = errors.New("invalid promotion")
+	ErrCouponStacking   = errors.New("only one coupon may be applied")
+	ErrCreditWithCoupon = errors.New("store credit cannot be combined with a coupon")
+	ErrMultipleCredits  = errors.New("only one store credit may be applied")
+)
+
+// Promo is a single discount to apply. Value is a percentage (0-100] for
+// percent coupons and an amount for fixed coupons and credit.
+type Promo struct {
+	Code  string  `json:"code,omitempty"`
+	Kind  string  `json:"kind"`
+	Value float64 `json:"value"`
+}
+
+// Adjustment records what one promo took off and the running total after it.
+// Value is the promo's own value, so the promo can be applied again.
+type Adjustment struct {
+	Code       string  `json:"code,omitempty"`
+	Kind       string  `json:"kind"`
+	Value      float64 `json:"value"`
+	Amount     float64 `json:"amount"`
+	TotalAfter float64 `json:"total_after"`
+}
+
+// Promo returns the promo this adjustment applied.
+func (a Adjustment) Promo() Promo {
+	return Promo{Code: a.Code, Kind: a.Kind, Value: a.Value}
+}
+
+// Rules controls which promos may be combined.
+type Rules struct {
+	AllowCouponStacking   bool
+	AllowCreditWithCoupon bool
+}
+
+// RulesFromEnv reads PROMO_ALLOW_COUPON_STACKING (default false) and
+// PROMO_ALLOW_CREDIT_WITH_COUPON (default true).
+func RulesFromEnv() Rules {
+	return Rules{
+		AllowCouponStacking:   envBool("PROMO_ALLOW_COUPON_STACKING", false),
+		AllowCreditWithCoupon: envBool("PROMO_ALLOW_CREDIT_WITH_COUPON", true),
+	}
+}
+
+func envBool(key string, fallback bool) bool {
+	if b, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return b
+	}
+	return fallback
+}
+
+// Result is the outcome of Apply. Discounted is the total after coupons, which
+// is what the order costs; Due is what remains after store credit.
+type Result struct {
+	Subtotal    float64      `json:"subtotal"`
+	Discounted  float64      `json:"discounted_total"`
+	Credit      float64      `json:"credit_applied"`
+	Due         float64      `json:"amount_due"`
+	Adjustments []Adjustment `json:"adjustments"`
+}
+
+var kindRank = map[string]int{KindPercent: 0, KindFixed: 1, KindCredit: 2}
+
+// Apply runs promos against subtotal. The order in which promos are passed
+// does not matter; within a kind larger values go first, then by code.
+// No step takes the total below zero.
+func Apply(subtotal float64, promos []Promo, rules Rules) (Result, error) {
+	sorted := make([]Promo, len(promos))
+	copy(sorted, promos)
+
+	coupons, credits := 0, 0
+	for _, p := range sorted {
+		switch p.Kind {
+		case KindPercent:
+			if p.Value <= 0 || p.Value > 100 {
+				return Result{}, fmt.Errorf("%w: percent must be in (0, 100]", ErrInvalidPromo)
+			}
+			coupons++
+		case KindFixed, KindCredit:
+			if p.Value <= 0 {
+				return Result{}, fmt.Errorf("%w: %s value must be positive", ErrInvalidPromo, p.Kind)
+			}
+			if p.Kind == KindFixed {
+				coupons++
+			} else {
+				credits++
+			}
+		default:
+			return Result{}, fmt.Errorf("%w: unknown kind %q", ErrInvalidPromo, p.Kind)
+		}
+	}
+	if coupons > 1 && !rules.AllowCouponStacking {
+		return Result{}, ErrCouponStacking
+	}
+	if credits > 1 {
+		return Result{}, ErrMultipleCredits
+	}
+	if coupons > 0 && credits > 0 && !rules.AllowCreditWithCoupon {
+		return Result{}, ErrCreditWithCoupon
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if kindRank[a.Kind] != kindRank[b.Kind] {
+			return kindRank[a.Kind] < kindRank[b.Kind]
+		}
+		if a.Value != b.Value {
+			return a.Value > b.Value
+		}
+		return a.Code < b.Code
+	})
+
+	total := round(subtotal)
+	res := Result{Subtotal: total, Adjustments: []Adjustment{}}
+	for _, p := range sorted {
+		var amount float64
+		switch p.Kind {
+		case KindPercent:
+			amount = round(total * p.Value / 100)
+		default:
+			amount = p.Value
+		}
+		amount = math.Min(round(amount), total)
+		total = round(total - amount)
+
+		if p.Kind == KindCredit {
+			res.Credit = amount
+		} else {
+			res.Discounted = total
+		}
+		res.Adjustments = append(res.Adjustments, Adjustment{Code: p.Code, Kind: p.Kind, Value: p.Value, Amount: amount, TotalAfter: total})
+	}
+	if coupons == 0 {
+		res.Discounted = res.Subtotal
+	}
+	res.Due = total
+	return res, nil
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
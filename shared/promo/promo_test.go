@@ -0,0 +1,74 @@
+package promo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApplyCouponAndCredit(t *testing.T) {
+	promos := []Promo{
+		{Kind: KindCredit, Value: 15},
+		{Code: "SAVE10", Kind: KindPercent, Value: 10},
+	}
+
+	res, err := Apply(100, promos, Rules{AllowCreditWithCoupon: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Discounted != 90 || res.Credit != 15 || res.Due != 75 {
+		t.Errorf("result = %+v, want discounted 90, credit 15, due 75", res)
+	}
+	want := []Adjustment{
+		{Code: "SAVE10", Kind: KindPercent, Value: 10, Amount: 10, TotalAfter: 90},
+		{Kind: KindCredit, Value: 15, Amount: 15, TotalAfter: 75},
+	}
+	if !reflect.DeepEqual(res.Adjustments, want) {
+		t.Errorf("adjustments = %+v, want %+v", res.Adjustments, want)
+	}
+}
+
+func TestApplyPercentBeforeFixed(t *testing.T) {
+	promos := []Promo{
+		{Code: "FIVE", Kind: KindFixed, Value: 5},
+		{Code: "HALF", Kind: KindPercent, Value: 50},
+	}
+
+	res, err := Apply(100, promos, Rules{AllowCouponStacking: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Discounted != 45 {
+		t.Errorf("discounted = %v, want 45 (50%% then 5 off)", res.Discounted)
+	}
+}
+
+func TestApplyNeverGoesBelowZero(t *testing.T) {
+	res, err := Apply(20, []Promo{{Kind: KindFixed, Value: 50}}, Rules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Discounted != 0 || res.Adjustments[0].Amount != 20 {
+		t.Errorf("result = %+v, want the discount capped at the subtotal", res)
+	}
+}
+
+func TestApplyStackingRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		promos []Promo
+		rules  Rules
+		want   error
+	}{
+		{"two coupons", []Promo{{Kind: KindPercent, Value: 10}, {Kind: KindFixed, Value: 5}}, Rules{}, ErrCouponStacking},
+		{"coupon and credit", []Promo{{Kind: KindPercent, Value: 10}, {Kind: KindCredit, Value: 5}}, Rules{}, ErrCreditWithCoupon},
+		{"two credits", []Promo{{Kind: KindCredit, Value: 1}, {Kind: KindCredit, Value: 2}}, Rules{AllowCreditWithCoupon: true}, ErrMultipleCredits},
+		{"percent over 100", []Promo{{Kind: KindPercent, Value: 101}}, Rules{}, ErrInvalidPromo},
+		{"unknown kind", []Promo{{Kind: "bogo", Value: 1}}, Rules{}, ErrInvalidPromo},
+	}
+	for _, tt := range tests {
+		if _, err := Apply(100, tt.promos, tt.rules); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
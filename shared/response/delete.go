@@ -0,0 +1,33 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// WantsDeleteBody reports whether the client asked for a confirmation body
+// on a DELETE, either with ?confirm=true or "Prefer: return=representation".
+func WantsDeleteBody(r *http.Request) bool {
+	if r.URL.Query().Get("confirm") == "true" {
+		return true
+	}
+	for _, pref := range strings.Split(r.Header.Get("Prefer"), ",") {
+		if strings.TrimSpace(pref) == "return=representation" {
+			return true
+		}
+	}
+	return false
+}
+
+// Deleted finishes a DELETE request. By default it sends a bare 204; clients
+// that ask for it get a 200 with {"deleted": bool, "id": n}.
+func Deleted(w http.ResponseWriter, r *http.Request, id int64, deleted bool) {
+	if !WantsDeleteBody(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"deleted": deleted, "id": id})
+}
@@ -0,0 +1,52 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeleted(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		prefer string
+		want   int
+	}{
+		{"default", "/items/7", "", http.StatusNoContent},
+		{"confirm query", "/items/7?confirm=true", "", http.StatusOK},
+		{"prefer header", "/items/7", "respond-async, return=representation", http.StatusOK},
+		{"minimal preference", "/items/7", "return=minimal", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("DELETE", tt.target, nil)
+			if tt.prefer != "" {
+				r.Header.Set("Prefer", tt.prefer)
+			}
+			w := httptest.NewRecorder()
+			Deleted(w, r, 7, true)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusNoContent {
+				if w.Body.Len() != 0 {
+					t.Errorf("204 with body %q", w.Body)
+				}
+				return
+			}
+			var body struct {
+				Deleted bool  `json:"deleted"`
+				ID      int64 `json:"id"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if !body.Deleted || body.ID != 7 {
+				t.Errorf("body = %+v", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package response
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+)
+
+// flushEvery is how many elements StreamJSON writes between flushes.
+const flushEvery = 100
+
+// StreamJSON writes a JSON array to w one row at a time, so large result sets
+// never sit in memory as a slice. scan turns the current row into the value
+// to encode; a scan error skips that row.
+//
+// Once the first byte is written the status can no longer change, so if the
+// cursor fails part way the closing "]" is left off: the client sees invalid
+// JSON rather than a silently truncated array. The returned count is the
+// number of elements written.
+func StreamJSON(w http.ResponseWriter, rows *sql.Rows, scan func(*sql.Rows) (interface{}, error)) (int, error) {
+	w.Header().Set("Content-Type", "application/json")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	if _, err := w.Write([]byte("[")); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			continue
+		}
+		if count > 0 {
+			if _, err := w.Write([]byte(",")); err != nil {
+				return count, err
+			}
+		}
+		if err := enc.Encode(v); err != nil {
+			return count, err
+		}
+
+		count++
+		if flusher != nil && count%flushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	_, err := w.Write([]byte("]\n"))
+	return count, err
+}
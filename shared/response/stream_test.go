@@ -0,0 +1,92 @@
+package response
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/database/dbtest"
+)
+
+type streamed struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func scanStreamed(rows *sql.Rows) (interface{}, error) {
+	var s streamed
+	err := rows.Scan(&s.ID, &s.Name)
+	return s, err
+}
+
+// queryRows returns a cursor over n scripted (id, name) rows.
+func queryRows(t *testing.T, n int) *sql.Rows {
+	t.Helper()
+	fake := dbtest.New(t)
+	rows := make([][]interface{}, n)
+	for i := range rows {
+		rows[i] = []interface{}{i + 1, `item "` + string(rune('a'+i%26)) + `"`}
+	}
+	fake.On(`SELECT`).Rows([]string{"id", "name"}, rows...)
+	cursor, err := fake.DB.Query("SELECT id, name FROM items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cursor.Close() })
+	return cursor
+}
+
+func TestStreamJSONManyRows(t *testing.T) {
+	const n = 2500
+	w := httptest.NewRecorder()
+
+	count, err := StreamJSON(w, queryRows(t, n), scanStreamed)
+	if err != nil || count != n {
+		t.Fatalf("StreamJSON = %d, %v; want %d", count, err, n)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !w.Flushed {
+		t.Error("a large result was never flushed")
+	}
+
+	var got []streamed
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("streamed output is not valid JSON: %v", err)
+	}
+	if len(got) != n || got[0].ID != 1 || got[n-1].ID != n || got[1].Name != `item "b"` {
+		t.Errorf("decoded %d items, first %+v, last %+v", len(got), got[0], got[len(got)-1])
+	}
+}
+
+func TestStreamJSONEmpty(t *testing.T) {
+	w := httptest.NewRecorder()
+	if count, err := StreamJSON(w, queryRows(t, 0), scanStreamed); count != 0 || err != nil {
+		t.Fatalf("StreamJSON = %d, %v", count, err)
+	}
+	var got []streamed
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got == nil || len(got) != 0 {
+		t.Errorf("body = %q, want an empty array", w.Body)
+	}
+}
+
+func TestStreamJSONSkipsUnscannableRows(t *testing.T) {
+	w := httptest.NewRecorder()
+	count, err := StreamJSON(w, queryRows(t, 5), func(rows *sql.Rows) (interface{}, error) {
+		v, err := scanStreamed(rows)
+		if v.(streamed).ID%2 == 0 {
+			return nil, errors.New("bad row")
+		}
+		return v, err
+	})
+	if err != nil || count != 3 {
+		t.Fatalf("StreamJSON = %d, %v; want 3", count, err)
+	}
+	var got []streamed
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || len(got) != 3 || got[1].ID != 3 {
+		t.Errorf("body = %q", w.Body)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/joycezhou/go-ecommerce-microservices/shared/middleware"
+)
+
+const defaultShutdownTimeout = 15 * time.Second
+
+// ShutdownTimeout reads SHUTDOWN_TIMEOUT (a Go duration such as "15s"), the
+// longest a service waits for in-flight requests when asked to stop.
+func ShutdownTimeout() time.Duration {
+	value := os.Getenv("SHUTDOWN_TIMEOUT")
+	if value == "" {
+		return defaultShutdownTimeout
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid SHUTDOWN_TIMEOUT %q, using %s", value, defaultShutdownTimeout)
+		return defaultShutdownTimeout
+	}
+	return d
+}
+
+// Run serves handler on addr until SIGINT or SIGTERM, then stops accepting
+// connections and waits up to ShutdownTimeout for in-flight requests to
+// drain. It returns once the server is stopped, so callers' deferred cleanup
+// (closing the database) runs only after requests have finished.
+func Run(addr string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	return serve(ln, handler, stop)
+}
+
+// serve is Run on an open listener, stopping when stop receives.
+func serve(ln net.Listener, handler http.Handler, stop <-chan os.Signal) error {
+	inFlight := &middleware.InFlight{}
+	srv := &http.Server{Handler: inFlight.Middleware(handler)}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %s, draining %d in-flight requests", sig, inFlight.Count())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout())
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if err := inFlight.Wait(ctx); err != nil {
+		log.Printf("Shutdown timed out with %d requests still in flight", inFlight.Count())
+		return nil
+	}
+
+	log.Println("Shutdown complete")
+	return nil
+}
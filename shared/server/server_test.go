@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startServer serves handler on a local port until the returned channel is
+// signalled. done receives serve's result.
+func startServer(t *testing.T, handler http.Handler) (url string, stop chan os.Signal, done chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop = make(chan os.Signal, 1)
+	done = make(chan error, 1)
+	go func() { done <- serve(ln, handler, stop) }()
+	return "http://" + ln.Addr().String(), stop, done
+}
+
+func TestShutdownWaitsForInFlightRequest(t *testing.T) {
+	t.Setenv("SHUTDOWN_TIMEOUT", "5s")
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	url, stop, done := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		close(finished)
+	}))
+
+	respCh := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			respCh <- 0
+			return
+		}
+		resp.Body.Close()
+		respCh <- resp.StatusCode
+	}()
+
+	<-started
+	stop <- syscall.SIGTERM
+	if err := <-done; err != nil {
+		t.Fatalf("serve = %v", err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Fatal("serve returned before the in-flight request finished")
+	}
+	if code := <-respCh; code != http.StatusOK {
+		t.Errorf("in-flight request got %d, want 200", code)
+	}
+}
+
+func TestShutdownGivesUpAfterTimeout(t *testing.T) {
+	t.Setenv("SHUTDOWN_TIMEOUT", "100ms")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	url, stop, done := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	go func() {
+		if resp, err := http.Get(url); err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	<-started
+	begin := time.Now()
+	stop <- syscall.SIGTERM
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("serve = %v", err)
+		}
+		if elapsed := time.Since(begin); elapsed < 100*time.Millisecond {
+			t.Errorf("serve returned after %s, before the timeout", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve did not give up after the shutdown timeout")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":      defaultShutdownTimeout,
+		"30s":   30 * time.Second,
+		"never": defaultShutdownTimeout,
+		"0s":    defaultShutdownTimeout,
+	} {
+		t.Setenv("SHUTDOWN_TIMEOUT", value)
+		if got := ShutdownTimeout(); got != want {
+			t.Errorf("ShutdownTimeout(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Package validation collects field-level errors for request bodies, with
+// paths into nested objects and arrays such as "items[2].quantity".
+package validation
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validator accumulates errors under a path prefix. Use Field and Index to
+// descend into nested values; children share the parent's error list.
+type Validator struct {
+	path string
+	errs *Errors
+}
+
+func New() *Validator {
+	return &Validator{errs: &Errors{}}
+}
+
+// Field returns a validator for the named child of the current path.
+func (v *Validator) Field(name string) *Validator {
+	path := name
+	if v.path != "" {
+		path = v.path + "." + name
+	}
+	return &Validator{path: path, errs: v.errs}
+}
+
+// Index returns a validator for element i of the current (array) path.
+func (v *Validator) Index(i int) *Validator {
+	return &Validator{path: v.path + "[" + strconv.Itoa(i) + "]", errs: v.errs}
+}
+
+// Check records message against field when ok is false.
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.Field(field).Fail(message)
+	}
+}
+
+// Fail records message against the current path.
+func (v *Validator) Fail(message string) {
+	*v.errs = append(*v.errs, FieldError{Field: v.path, Message: message})
+}
+
+func (v *Validator) Valid() bool {
+	return len(*v.errs) == 0
+}
+
+func (v *Validator) Errors() Errors {
+	return *v.errs
+}
+
+// WriteError responds 400 with {"error":"validation failed","fields":[...]}.
+func WriteError(w http.ResponseWriter, errs Errors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "validation failed",
+		"fields": errs,
+	})
+}
@@ -0,0 +1,49 @@
+package validation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFieldPaths(t *testing.T) {
+	v := New()
+	v.Check(false, "user_id", "is required")
+	items := v.Field("items")
+	items.Index(2).Check(false, "quantity", "must be positive")
+	v.Field("card_info").Field("billing").Check(false, "zip", "is required")
+	items.Index(0).Check(true, "quantity", "must be positive")
+
+	want := []string{"user_id", "items[2].quantity", "card_info.billing.zip"}
+	errs := v.Errors()
+	if v.Valid() || len(errs) != len(want) {
+		t.Fatalf("errors = %v", errs)
+	}
+	for i, field := range want {
+		if errs[i].Field != field {
+			t.Errorf("errors[%d].Field = %q, want %q", i, errs[i].Field, field)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	v := New()
+	v.Field("items").Index(1).Fail("is invalid")
+
+	w := httptest.NewRecorder()
+	WriteError(w, v.Errors())
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+	var body struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != "validation failed" || len(body.Fields) != 1 || body.Fields[0] != (FieldError{"items[1]", "is invalid"}) {
+		t.Errorf("body = %+v", body)
+	}
+}